@@ -137,6 +137,7 @@ build() {
     # shellcheck disable=SC2086
     run env "${GO_BUILD_ENV[@]}" go build $GO_BUILD_FLAGS \
       -installsuffix=cgo \
+      -ldflags="-X ${ROOT_MODULE}/pkg/version.GitCommit=${GIT_SHA}" \
       -o="../../${out}/argon" . || return 2
   ) || return 2
 }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	cli "github.com/urfave/cli/v2"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/client"
+	"github.com/peertechde/argon/pkg/lifecycle"
+)
+
+var (
+	FlagReadOnly = &cli.BoolFlag{
+		Name:  "enable",
+		Value: true,
+		Usage: "Enable (true) or disable (false) read-only mode",
+	}
+	FlagMode = &cli.StringFlag{
+		Name:     "mode",
+		Usage:    "Mode to switch to: read-write, read-only or maintenance",
+		Required: true,
+	}
+	FlagModeReason = &cli.StringFlag{
+		Name:  "reason",
+		Usage: "Reason reported to clients whose requests are rejected",
+	}
+	FlagLevel = &cli.StringFlag{
+		Name:  "level",
+		Usage: "Log level to switch to, the current level is shown if unset",
+	}
+	FlagMemberId = &cli.StringFlag{
+		Name:     "id",
+		Usage:    "ID of the cluster member",
+		Required: true,
+	}
+	FlagMemberRaftAddr = &cli.StringFlag{
+		Name:     "raft-addr",
+		Usage:    "Raft address of the cluster member",
+		Required: true,
+	}
+	FlagMemberAPIAddr = &cli.StringFlag{
+		Name:     "api-addr",
+		Usage:    "API address of the cluster member",
+		Required: true,
+	}
+	FlagLifecycleRule = &cli.StringSliceFlag{
+		Name:  "rule",
+		Usage: "Rule as id=ID,prefix=PREFIX,transition-after=AGE,expire-after=AGE,keep-versions=K with ages like 30d or 12h, can be repeated",
+	}
+	FlagLifecycleDryRun = &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only print the actions the rules would take",
+	}
+)
+
+func AdminCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "admin",
+		Usage: "Inspect and maintain a running server",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "info",
+				Usage:  "Show server ID, build info, uptime, connections and capacity",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminInfoCommand,
+			},
+			{
+				Name:   "jobs",
+				Usage:  "List running and recently finished background jobs",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminJobsCommand,
+			},
+			{
+				Name:   "scrub",
+				Usage:  "Start verifying the integrity of all files",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminScrubCommand,
+			},
+			{
+				Name:   "gc",
+				Usage:  "Start a garbage collection of the storage backend",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminGCCommand,
+			},
+			{
+				Name:   "heal",
+				Usage:  "Start rebuilding lost redundancy, e.g. after replacing a disk",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminHealCommand,
+			},
+			{
+				Name:   "reindex",
+				Usage:  "Start rebuilding the metadata index from the storage",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminReindexCommand,
+			},
+			{
+				Name:   "read-only",
+				Usage:  "Toggle rejecting writes, renames and removes",
+				Flags:  []cli.Flag{FlagTarget, FlagReadOnly},
+				Action: adminReadOnlyCommand,
+			},
+			{
+				Name:   "mode",
+				Usage:  "Switch between read-write, read-only and maintenance mode",
+				Flags:  []cli.Flag{FlagTarget, FlagMode, FlagModeReason},
+				Action: adminModeCommand,
+			},
+			{
+				Name:   "log-level",
+				Usage:  "Show or change the log level of the server",
+				Flags:  []cli.Flag{FlagTarget, FlagLevel},
+				Action: adminLogLevelCommand,
+			},
+			{
+				Name:   "replication",
+				Usage:  "Show the replication role, log position and replica lag",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminReplicationCommand,
+			},
+			{
+				Name:   "promote",
+				Usage:  "Promote a replica to primary",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: adminPromoteCommand,
+			},
+			{
+				Name:  "keys",
+				Usage: "Inspect and rotate the encryption keys",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "Show the active master key and the master key of every file",
+						Flags:  []cli.Flag{FlagTarget},
+						Action: adminKeysListCommand,
+					},
+					{
+						Name:   "rotate",
+						Usage:  "Start re-wrapping the data keys with the active master key",
+						Flags:  []cli.Flag{FlagTarget},
+						Action: adminKeysRotateCommand,
+					},
+				},
+			},
+			{
+				Name:  "lifecycle",
+				Usage: "Inspect, change and apply the lifecycle rules",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "rules",
+						Usage:  "Show the lifecycle rules",
+						Flags:  []cli.Flag{FlagTarget},
+						Action: adminLifecycleRulesCommand,
+					},
+					{
+						Name:   "set",
+						Usage:  "Replace the lifecycle rules until the configuration is reloaded, without --rule they are removed",
+						Flags:  []cli.Flag{FlagTarget, FlagLifecycleRule},
+						Action: adminLifecycleSetCommand,
+					},
+					{
+						Name:   "run",
+						Usage:  "Start applying the lifecycle rules",
+						Flags:  []cli.Flag{FlagTarget, FlagLifecycleDryRun},
+						Action: adminLifecycleRunCommand,
+					},
+				},
+			},
+			{
+				Name:  "cluster",
+				Usage: "Inspect and change the members of a cluster",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "status",
+						Usage:  "Show the Raft state, leader and members",
+						Flags:  []cli.Flag{FlagTarget},
+						Action: adminClusterStatusCommand,
+					},
+					{
+						Name:   "join",
+						Usage:  "Add a server to the cluster, must be sent to the leader",
+						Flags:  []cli.Flag{FlagTarget, FlagMemberId, FlagMemberRaftAddr, FlagMemberAPIAddr},
+						Action: adminClusterJoinCommand,
+					},
+					{
+						Name:   "remove",
+						Usage:  "Remove a server from the cluster, must be sent to the leader",
+						Flags:  []cli.Flag{FlagTarget, FlagMemberId},
+						Action: adminClusterRemoveCommand,
+					},
+				},
+			},
+		},
+	}
+}
+
+func adminInfoCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		info, err := c.Info(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(info)
+	})
+}
+
+func adminJobsCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		jobs, err := c.ListJobs(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(&api.ListJobsResponse{Jobs: jobs})
+	})
+}
+
+func adminScrubCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		job, err := c.Scrub(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(job)
+	})
+}
+
+func adminReindexCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		job, err := c.Reindex(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(job)
+	})
+}
+
+func adminGCCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		job, err := c.GC(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(job)
+	})
+}
+
+func adminHealCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		job, err := c.Heal(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(job)
+	})
+}
+
+func adminReadOnlyCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		readOnly := clictx.Bool("enable")
+		if err := c.SetReadOnly(ctx, readOnly); err != nil {
+			return err
+		}
+		fmt.Printf("Read-only mode enabled: %t\n", readOnly)
+		return nil
+	})
+}
+
+func adminModeCommand(clictx *cli.Context) error {
+	var mode api.Mode
+	switch clictx.String("mode") {
+	case "read-write":
+		mode = api.Mode_MODE_READ_WRITE
+	case "read-only":
+		mode = api.Mode_MODE_READ_ONLY
+	case "maintenance":
+		mode = api.Mode_MODE_MAINTENANCE
+	default:
+		return fmt.Errorf("unknown mode %q", clictx.String("mode"))
+	}
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		if err := c.SetMode(ctx, mode, clictx.String("reason")); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to %s mode\n", clictx.String("mode"))
+		return nil
+	})
+}
+
+func adminLogLevelCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		level, err := c.LogLevel(ctx, clictx.String("level"))
+		if err != nil {
+			return err
+		}
+		fmt.Println(level)
+		return nil
+	})
+}
+
+func adminReplicationCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		status, err := c.ReplicationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(status)
+	})
+}
+
+func adminPromoteCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		seq, err := c.Promote(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Promoted to primary at entry %d\n", seq)
+		return nil
+	})
+}
+
+func adminKeysListCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		keys, err := c.EncryptionKeys(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(keys)
+	})
+}
+
+func adminKeysRotateCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		job, err := c.RotateKeys(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(job)
+	})
+}
+
+func adminLifecycleRulesCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		rules, err := c.LifecycleRules(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(&api.LifecycleRulesResponse{Rules: rules})
+	})
+}
+
+func adminLifecycleSetCommand(clictx *cli.Context) error {
+	var rules []*api.LifecycleRule
+	for _, s := range clictx.StringSlice("rule") {
+		rule, err := lifecycle.ParseRule(s)
+		if err != nil {
+			return err
+		}
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		r := &api.LifecycleRule{Id: rule.ID, Prefix: rule.Prefix, KeepVersions: uint32(rule.KeepVersions)}
+		if rule.TransitionAfter > 0 {
+			r.TransitionAfter = durationpb.New(rule.TransitionAfter)
+		}
+		if rule.ExpireAfter > 0 {
+			r.ExpireAfter = durationpb.New(rule.ExpireAfter)
+		}
+		rules = append(rules, r)
+	}
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		if err := c.SetLifecycleRules(ctx, rules); err != nil {
+			return err
+		}
+		fmt.Printf("Set %d lifecycle rules\n", len(rules))
+		return nil
+	})
+}
+
+func adminLifecycleRunCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		resp, err := c.RunLifecycle(ctx, clictx.Bool("dry-run"))
+		if err != nil {
+			return err
+		}
+		if resp.Job != nil {
+			return printProto(resp.Job)
+		}
+		return printProto(resp)
+	})
+}
+
+func adminClusterStatusCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		status, err := c.ClusterStatus(ctx)
+		if err != nil {
+			return err
+		}
+		return printProto(status)
+	})
+}
+
+func adminClusterJoinCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		id := clictx.String("id")
+		if err := c.ClusterJoin(ctx, id, clictx.String("raft-addr"), clictx.String("api-addr")); err != nil {
+			return err
+		}
+		fmt.Printf("Added %s to the cluster\n", id)
+		return nil
+	})
+}
+
+func adminClusterRemoveCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		id := clictx.String("id")
+		if err := c.ClusterRemove(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s from the cluster\n", id)
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+var (
+	FlagAppendName = &cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the remote file to append to, it is created if it doesn't exist",
+	}
+	FlagExpectedOffset = &cli.Int64Flag{
+		Name:  "expected-offset",
+		Usage: "Size the file must have before the append, e.g. the one printed by the previous append",
+	}
+)
+
+func AppendCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "append",
+		Usage: "Append stdin to a file and print its new size",
+		Flags: []cli.Flag{
+			FlagTarget,
+			FlagAppendName,
+			FlagExpectedOffset,
+			FlagShard,
+			FlagShardReplicas,
+		},
+		Action: appendCommand,
+	}
+}
+
+func appendCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("name") {
+		return requiredFlag(clictx, "name")
+	}
+	offset := int64(-1)
+	if clictx.IsSet("expected-offset") {
+		if offset = clictx.Int64("expected-offset"); offset < 0 {
+			return errors.New("expected offset must not be negative")
+		}
+	}
+
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		}
+	}()
+
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
+	}
+
+	size, err := c.Append(opctx, clictx.String("name"), os.Stdin, offset)
+	if err != nil {
+		return err
+	}
+	fmt.Println(size)
+	return nil
+}
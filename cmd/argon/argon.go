@@ -1,29 +1,81 @@
 package main
 
 import (
+	"context"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 	cli "github.com/urfave/cli/v2"
 
 	"github.com/peertechde/argon/pkg/logging"
+	"github.com/peertechde/argon/pkg/tracing"
 )
 
 var log = logging.Logger.WithField(logging.Sys, "main")
 
+var (
+	FlagLogFormat = &cli.StringFlag{
+		Name:    "log-format",
+		Value:   logging.FormatText,
+		Usage:   "Log format (text or json)",
+		EnvVars: []string{"ARGON_LOGGING_FORMAT"},
+	}
+	FlagLogLevel = &cli.StringFlag{
+		Name:    "log-level",
+		Value:   "info",
+		Usage:   "Log level (trace, debug, info, warn, error, fatal or panic)",
+		EnvVars: []string{"ARGON_LOGGING_LEVEL"},
+	}
+	FlagTraceExporter = &cli.StringFlag{
+		Name:    "trace-exporter",
+		Value:   "none",
+		Usage:   "Trace exporter to use (none, stdout, file or otlp)",
+		EnvVars: []string{"ARGON_TRACE_EXPORTER"},
+	}
+	FlagTraceEndpoint = &cli.StringFlag{
+		Name:    "trace-endpoint",
+		Usage:   "Path of the trace file or URL of the OTLP/HTTP collector",
+		EnvVars: []string{"ARGON_TRACE_ENDPOINT"},
+	}
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "argon"
 	app.Usage = ""
-	app.Flags = []cli.Flag{}
+	app.Flags = []cli.Flag{
+		FlagLogFormat,
+		FlagLogLevel,
+		FlagTraceExporter,
+		FlagTraceEndpoint,
+	}
+	app.Before = func(clictx *cli.Context) error {
+		if err := setupLogging(clictx); err != nil {
+			return err
+		}
+		return setupTracing(clictx)
+	}
+	app.After = shutdownTracing
 	app.Commands = []*cli.Command{
 		WriteCommand(),
+		AppendCommand(),
 		ReadCommand(),
 		ListCommand(),
 		StatCommand(),
+		SetMetadataCommand(),
+		SetExpiryCommand(),
+		FindCommand(),
 		RemoveCommand(),
 		RenameCommand(),
+		CopyCommand(),
+		TrashCommand(),
+		RetentionCommand(),
 		ServerCommand(),
+		AuditCommand(),
+		AdminCommand(),
+		RebalanceCommand(),
+		SyncCommand(),
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -35,3 +87,29 @@ func requiredFlag(clictx *cli.Context, flag string) error {
 	return errors.Errorf("'%s %s' requires the '--%s' flag", clictx.App.HelpName,
 		clictx.Command.Name, flag)
 }
+
+func setupLogging(clictx *cli.Context) error {
+	if err := logging.SetFormat(clictx.String("log-format")); err != nil {
+		return err
+	}
+	return logging.SetLevel(clictx.String("log-level"))
+}
+
+func setupTracing(clictx *cli.Context) error {
+	exporter, err := tracing.NewExporter(clictx.String("trace-exporter"), clictx.String("trace-endpoint"))
+	if err != nil {
+		return errors.Wrap(err, "failed to create trace exporter")
+	}
+	tracing.SetTracer(tracing.NewTracer(exporter))
+	return nil
+}
+
+func shutdownTracing(clictx *cli.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tracing.GetTracer().Shutdown(ctx); err != nil {
+		log.Warnf("Failed to flush traces (%s)", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/server"
+)
+
+var (
+	FlagAuditLog = &cli.StringFlag{
+		Name:  "path",
+		Usage: "Path of the active audit log",
+	}
+)
+
+func AuditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Inspect the audit log",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "verify",
+				Usage:  "Verify the integrity of the audit log hash chain",
+				Flags:  []cli.Flag{FlagAuditLog},
+				Action: auditVerifyCommand,
+			},
+		},
+	}
+}
+
+func auditVerifyCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("path") {
+		return requiredFlag(clictx, "path")
+	}
+
+	result, err := server.VerifyAuditLog(clictx.String("path"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %d entries (%d-%d) in %d files\n", result.Entries, result.FirstSeq,
+		result.LastSeq, len(result.Files))
+	if result.HeadHash != "" {
+		fmt.Printf("Head hash %s\n", result.HeadHash)
+	}
+	return nil
+}
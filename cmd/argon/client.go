@@ -4,14 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	cli "github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/peertechde/argon/pkg/client"
+	"github.com/peertechde/argon/pkg/storage"
 )
 
 var (
@@ -36,6 +42,35 @@ var (
 		Name:  "new",
 		Usage: "TODO",
 	}
+	FlagShard = &cli.StringSliceFlag{
+		Name:  "shard",
+		Usage: "Address of a server to shard the files across, can be repeated, replaces --target",
+	}
+	FlagShardReplicas = &cli.IntFlag{
+		Name:  "shard-replicas",
+		Value: 1,
+		Usage: "Number of servers every sharded file is stored on",
+	}
+	FlagKeyFile = &cli.StringFlag{
+		Name:  "key-file",
+		Usage: "Keyring to encrypt written and decrypt read files with, a line per key as id:base64 key",
+	}
+	FlagEncryptNames = &cli.BoolFlag{
+		Name:  "encrypt-names",
+		Usage: "Encrypt the file names as well, requires --key-file",
+	}
+	FlagMetadata = &cli.StringSliceFlag{
+		Name:  "meta",
+		Usage: "Metadata of the file as key=value, can be repeated",
+	}
+	FlagTTL = &cli.DurationFlag{
+		Name:  "ttl",
+		Usage: "Remove the file after the duration, e.g. 24h",
+	}
+	FlagExpiresAt = &cli.StringFlag{
+		Name:  "expires-at",
+		Usage: "Remove the file at the time, as RFC 3339 or 2006-01-02",
+	}
 )
 
 func WriteCommand() *cli.Command {
@@ -45,6 +80,13 @@ func WriteCommand() *cli.Command {
 		Flags: []cli.Flag{
 			FlagTarget,
 			FlagFileName,
+			FlagMetadata,
+			FlagTTL,
+			FlagExpiresAt,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: writeCommand,
 	}
@@ -57,6 +99,10 @@ func ReadCommand() *cli.Command {
 			FlagTarget,
 			FlagFileName,
 			FlagTo,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: readCommand,
 	}
@@ -68,6 +114,10 @@ func ListCommand() *cli.Command {
 		Usage: "List",
 		Flags: []cli.Flag{
 			FlagTarget,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: listCommand,
 	}
@@ -80,11 +130,50 @@ func StatCommand() *cli.Command {
 		Flags: []cli.Flag{
 			FlagTarget,
 			FlagFileName,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: statCommand,
 	}
 }
 
+func SetMetadataCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "set-meta",
+		Usage: "Replace the metadata of a file, without --meta it is removed",
+		Flags: []cli.Flag{
+			FlagTarget,
+			FlagFileName,
+			FlagMetadata,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
+		},
+		Action: setMetadataCommand,
+	}
+}
+
+func SetExpiryCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "set-expiry",
+		Usage: "Set when a file is removed, without --ttl or --expires-at it doesn't expire",
+		Flags: []cli.Flag{
+			FlagTarget,
+			FlagFileName,
+			FlagTTL,
+			FlagExpiresAt,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
+		},
+		Action: setExpiryCommand,
+	}
+}
+
 func RemoveCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "remove",
@@ -92,6 +181,10 @@ func RemoveCommand() *cli.Command {
 		Flags: []cli.Flag{
 			FlagTarget,
 			FlagFileName,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: removeCommand,
 	}
@@ -105,6 +198,10 @@ func RenameCommand() *cli.Command {
 			FlagTarget,
 			FlagOldFile,
 			FlagNewFile,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
 		},
 		Action: renameCommand,
 	}
@@ -114,6 +211,14 @@ func writeCommand(clictx *cli.Context) error {
 	if !clictx.IsSet("name") {
 		return requiredFlag(clictx, "name")
 	}
+	metadata, err := parseMetadata(clictx.StringSlice("meta"))
+	if err != nil {
+		return err
+	}
+	ttl, expiresAt, err := parseExpiry(clictx)
+	if err != nil {
+		return err
+	}
 
 	// termination handler
 	termc := make(chan os.Signal, 1)
@@ -130,12 +235,16 @@ func writeCommand(clictx *cli.Context) error {
 		}
 	}()
 
-	c := client.New()
-	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
-		return errors.Wrap(err, "failed to dial")
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
 	}
 
-	return c.Write(opctx, clictx.String("name"))
+	return c.Write(opctx, clictx.String("name"), client.WriteOptions{
+		Metadata:  metadata,
+		TTL:       ttl,
+		ExpiresAt: expiresAt,
+	})
 }
 
 func readCommand(clictx *cli.Context) error {
@@ -161,12 +270,16 @@ func readCommand(clictx *cli.Context) error {
 		}
 	}()
 
-	c := client.New()
-	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
-		return errors.Wrap(err, "failed to dial")
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
 	}
 
-	return c.Read(opctx, clictx.String("name"), clictx.String("to"))
+	err = c.Read(opctx, clictx.String("name"), clictx.String("to"))
+	if errors.Is(err, client.ErrEncrypted) {
+		return errors.Errorf("%s is end-to-end encrypted, decrypt it with --key-file", clictx.String("name"))
+	}
+	return err
 }
 
 func listCommand(clictx *cli.Context) error {
@@ -185,9 +298,9 @@ func listCommand(clictx *cli.Context) error {
 		}
 	}()
 
-	c := client.New()
-	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
-		return errors.Wrap(err, "failed to dial")
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
 	}
 
 	files, err := c.List(opctx)
@@ -223,9 +336,9 @@ func statCommand(clictx *cli.Context) error {
 		}
 	}()
 
-	c := client.New()
-	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
-		return errors.Wrap(err, "failed to dial")
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
 	}
 
 	fileInfo, err := c.Stat(opctx, clictx.String("name"))
@@ -241,6 +354,70 @@ func statCommand(clictx *cli.Context) error {
 	return nil
 }
 
+func setMetadataCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("name") {
+		return requiredFlag(clictx, "name")
+	}
+	metadata, err := parseMetadata(clictx.StringSlice("meta"))
+	if err != nil {
+		return err
+	}
+
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		}
+	}()
+
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
+	}
+
+	return c.SetMetadata(opctx, clictx.String("name"), metadata)
+}
+
+func setExpiryCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("name") {
+		return requiredFlag(clictx, "name")
+	}
+	ttl, expiresAt, err := parseExpiry(clictx)
+	if err != nil {
+		return err
+	}
+
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		}
+	}()
+
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
+	}
+
+	return c.SetExpiry(opctx, clictx.String("name"), ttl, expiresAt)
+}
+
 func removeCommand(clictx *cli.Context) error {
 	if !clictx.IsSet("name") {
 		return requiredFlag(clictx, "name")
@@ -261,12 +438,12 @@ func removeCommand(clictx *cli.Context) error {
 		}
 	}()
 
-	c := client.New()
-	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
-		return errors.Wrap(err, "failed to dial")
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
 	}
 
-	err := c.Remove(opctx, clictx.String("name"))
+	err = c.Remove(opctx, clictx.String("name"))
 	if err != nil {
 		return err
 	}
@@ -297,15 +474,130 @@ func renameCommand(clictx *cli.Context) error {
 		}
 	}()
 
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rename(opctx, clictx.String("old"), clictx.String("new"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// runClient dials the target and runs fn with a context which is canceled on
+// SIGTERM.
+func runClient(clictx *cli.Context, fn func(ctx context.Context, c *client.Client) error) error {
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(termc)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		case <-opctx.Done():
+		}
+	}()
+
 	c := client.New()
 	if err := c.DialContext(opctx, clictx.String("target")); err != nil {
 		return errors.Wrap(err, "failed to dial")
 	}
 
-	err := c.Rename(opctx, clictx.String("old"), clictx.String("new"))
-	if err != nil {
-		return err
+	return fn(opctx, c)
+}
+
+// fileClient is implemented by the client of a single server and the sharded
+// client.
+type fileClient interface {
+	Read(ctx context.Context, name, dst string) error
+	Write(ctx context.Context, name string, opts client.WriteOptions) error
+	Append(ctx context.Context, name string, r io.Reader, expectedOffset int64) (int64, error)
+	List(ctx context.Context) ([]string, error)
+	Stat(ctx context.Context, name string) (*storage.FileInfo, error)
+	Remove(ctx context.Context, name string) error
+	Rename(ctx context.Context, old, new string) error
+	Copy(ctx context.Context, src, dst string) error
+	SetMetadata(ctx context.Context, name string, metadata map[string]string) error
+	SetExpiry(ctx context.Context, name string, ttl time.Duration, t time.Time) error
+}
+
+// dialFiles dials the sharded servers if any are given and the target
+// otherwise.
+func dialFiles(ctx context.Context, clictx *cli.Context) (fileClient, error) {
+	var options []client.Option
+	if clictx.IsSet("key-file") {
+		keyring, err := client.LoadKeyring(clictx.String("key-file"))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.WithKeyring(keyring))
+		if clictx.Bool("encrypt-names") {
+			options = append(options, client.WithNameEncryption())
+		}
+	} else if clictx.Bool("encrypt-names") {
+		return nil, errors.New("--encrypt-names requires --key-file")
+	}
+
+	if clictx.IsSet("shard") {
+		if len(options) > 0 {
+			return nil, errors.New("--key-file isn't supported with --shard")
+		}
+		c := client.NewSharded(clictx.StringSlice("shard"), client.WithReplicationFactor(clictx.Int("shard-replicas")))
+		if err := c.DialContext(ctx); err != nil {
+			return nil, errors.Wrap(err, "failed to dial")
+		}
+		return c, nil
+	}
+
+	c := client.New(options...)
+	if err := c.DialContext(ctx, clictx.String("target")); err != nil {
+		return nil, errors.Wrap(err, "failed to dial")
+	}
+	return c, nil
+}
+
+// parseMetadata parses key=value pairs.
+func parseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
 	}
+	metadata := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid metadata %q, expected key=value", pair)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
 
+func printProto(m proto.Message) error {
+	out, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal response")
+	}
+	fmt.Println(string(out))
 	return nil
 }
+
+// parseExpiry returns the ttl or expiry time of the ttl and expires-at flags.
+func parseExpiry(clictx *cli.Context) (time.Duration, time.Time, error) {
+	if clictx.IsSet("ttl") && clictx.IsSet("expires-at") {
+		return 0, time.Time{}, errors.New("either --ttl or --expires-at can be set")
+	}
+	if clictx.IsSet("expires-at") {
+		t, err := parseTime(clictx.String("expires-at"))
+		return 0, t, err
+	}
+	return clictx.Duration("ttl"), time.Time{}, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// remotePrefix marks the arguments of cp naming files on the servers.
+const remotePrefix = "remote:"
+
+func CopyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "cp",
+		Usage:     "Copy a file on the servers without transferring it",
+		ArgsUsage: "remote:<src> remote:<dst>",
+		Flags: []cli.Flag{
+			FlagTarget,
+			FlagShard,
+			FlagShardReplicas,
+			FlagKeyFile,
+			FlagEncryptNames,
+		},
+		Action: copyCommand,
+	}
+}
+
+// parseRemote returns the name of the remote file arg.
+func parseRemote(arg string) (string, error) {
+	if !strings.HasPrefix(arg, remotePrefix) {
+		return "", errors.Errorf("%q isn't a remote file, expected %s<name>", arg, remotePrefix)
+	}
+	name := strings.TrimPrefix(arg, remotePrefix)
+	if name == "" {
+		return "", errors.Errorf("%q is missing the file name", arg)
+	}
+	return name, nil
+}
+
+func copyCommand(clictx *cli.Context) error {
+	if clictx.NArg() != 2 {
+		return errors.Errorf("expected a source and a destination, usage: %s %s", clictx.Command.Name,
+			clictx.Command.ArgsUsage)
+	}
+	src, err := parseRemote(clictx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	dst, err := parseRemote(clictx.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		}
+	}()
+
+	c, err := dialFiles(opctx, clictx)
+	if err != nil {
+		return err
+	}
+
+	return c.Copy(opctx, src, dst)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/client"
+	"github.com/peertechde/argon/pkg/index"
+)
+
+var (
+	FlagFindName = &cli.StringFlag{
+		Name:  "name",
+		Usage: "Glob the file names have to match, e.g. 'build-*.tar'",
+	}
+	FlagFindMinSize = &cli.StringFlag{
+		Name:  "min-size",
+		Usage: "Minimum size of the files, e.g. 1G or 512K",
+	}
+	FlagFindMaxSize = &cli.StringFlag{
+		Name:  "max-size",
+		Usage: "Maximum size of the files, e.g. 1G or 512K",
+	}
+	FlagFindModifiedAfter = &cli.StringFlag{
+		Name:  "modified-after",
+		Usage: "Only files modified after the time, as RFC 3339 or 2006-01-02",
+	}
+	FlagFindModifiedBefore = &cli.StringFlag{
+		Name:  "modified-before",
+		Usage: "Only files modified before the time, as RFC 3339 or 2006-01-02",
+	}
+	FlagFindModifiedWithin = &cli.DurationFlag{
+		Name:  "modified-within",
+		Usage: "Only files modified within the duration, e.g. 168h",
+	}
+	FlagFindTag = &cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Metadata predicate as key=value, key!=value, key or !key, can be repeated",
+	}
+	FlagFindSort = &cli.StringFlag{
+		Name:  "sort",
+		Value: string(index.SortName),
+		Usage: "Order of the files: name, size or mod_time",
+	}
+	FlagFindDescending = &cli.BoolFlag{
+		Name:  "desc",
+		Usage: "Sort in descending order",
+	}
+	FlagFindLimit = &cli.IntFlag{
+		Name:  "limit",
+		Usage: "Maximum number of files to print, 0 prints all",
+	}
+)
+
+func FindCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "find",
+		Usage: "Find files by name, size, modification time and metadata",
+		Flags: []cli.Flag{
+			FlagTarget,
+			FlagFindName,
+			FlagFindMinSize,
+			FlagFindMaxSize,
+			FlagFindModifiedAfter,
+			FlagFindModifiedBefore,
+			FlagFindModifiedWithin,
+			FlagFindTag,
+			FlagFindSort,
+			FlagFindDescending,
+			FlagFindLimit,
+		},
+		Action: findCommand,
+	}
+}
+
+func findCommand(clictx *cli.Context) error {
+	query, err := parseQuery(clictx)
+	if err != nil {
+		return err
+	}
+	limit := clictx.Int("limit")
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		var printed int
+		for {
+			if limit > 0 && limit-printed < index.MaxPageSize {
+				query.PageSize = limit - printed
+			}
+			files, next, err := c.Query(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, fi := range files {
+				out, err := json.Marshal(fi)
+				if err != nil {
+					return errors.Wrap(err, "failed to marshal file info")
+				}
+				fmt.Println(string(out))
+				printed++
+			}
+			if next == "" || (limit > 0 && printed >= limit) {
+				return nil
+			}
+			query.PageToken = next
+		}
+	})
+}
+
+func parseQuery(clictx *cli.Context) (*index.Query, error) {
+	query := &index.Query{
+		NamePattern: clictx.String("name"),
+		Sort:        index.SortField(clictx.String("sort")),
+		Descending:  clictx.Bool("desc"),
+		PageSize:    index.MaxPageSize,
+	}
+	var err error
+	if clictx.IsSet("min-size") {
+		if query.MinSize, err = parseSize(clictx.String("min-size")); err != nil {
+			return nil, err
+		}
+	}
+	if clictx.IsSet("max-size") {
+		if query.MaxSize, err = parseSize(clictx.String("max-size")); err != nil {
+			return nil, err
+		}
+	}
+	if clictx.IsSet("modified-after") {
+		if query.ModifiedAfter, err = parseTime(clictx.String("modified-after")); err != nil {
+			return nil, err
+		}
+	}
+	if clictx.IsSet("modified-before") {
+		if query.ModifiedBefore, err = parseTime(clictx.String("modified-before")); err != nil {
+			return nil, err
+		}
+	}
+	if clictx.IsSet("modified-within") {
+		after := time.Now().Add(-clictx.Duration("modified-within"))
+		if after.After(query.ModifiedAfter) {
+			query.ModifiedAfter = after
+		}
+	}
+	for _, s := range clictx.StringSlice("tag") {
+		tag, err := index.ParseTagPredicate(s)
+		if err != nil {
+			return nil, err
+		}
+		query.Tags = append(query.Tags, tag)
+	}
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// parseSize parses a number of bytes with an optional binary unit suffix, K,
+// M, G or T, optionally followed by B.
+func parseSize(s string) (int64, error) {
+	units := map[string]int64{
+		"":  1,
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	unit := strings.TrimLeft(value, "0123456789")
+	multiplier, ok := units[unit]
+	if !ok {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(value, unit), 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q, expected RFC 3339 or 2006-01-02", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/client"
+)
+
+var (
+	FlagDrain = &cli.StringSliceFlag{
+		Name:  "drain",
+		Usage: "Address of a server being removed whose files are moved to the shards, can be repeated",
+	}
+	FlagDryRun = &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only print the moves",
+	}
+)
+
+func RebalanceCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rebalance",
+		Usage: "Move the files of sharded servers to their owners after adding or removing servers",
+		Flags: []cli.Flag{
+			FlagShard,
+			FlagShardReplicas,
+			FlagDrain,
+			FlagDryRun,
+		},
+		Action: rebalanceCommand,
+	}
+}
+
+func rebalanceCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("shard") {
+		return requiredFlag(clictx, "shard")
+	}
+
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(termc)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		case <-opctx.Done():
+		}
+	}()
+
+	c := client.NewSharded(clictx.StringSlice("shard"), client.WithReplicationFactor(clictx.Int("shard-replicas")))
+	if err := c.DialContext(opctx); err != nil {
+		return err
+	}
+
+	dryRun := clictx.Bool("dry-run")
+	moves, err := c.Rebalance(opctx, clictx.StringSlice("drain"), dryRun)
+	for _, move := range moves {
+		fmt.Printf("%s: from %s, added to %v, removed from %v\n", move.Name, move.Source, move.Added, move.Removed)
+	}
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("Would move %d files\n", len(moves))
+	} else {
+		fmt.Printf("Moved %d files\n", len(moves))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/client"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+var (
+	FlagRetentionName = &cli.StringFlag{
+		Name:     "name",
+		Usage:    "Name of the remote file",
+		Required: true,
+	}
+	FlagRetentionMode = &cli.StringFlag{
+		Name:     "mode",
+		Usage:    "Retention mode: governance or compliance",
+		Required: true,
+	}
+	FlagRetainUntil = &cli.StringFlag{
+		Name:     "until",
+		Usage:    "Time until which the file is locked, as RFC 3339 or 2006-01-02",
+		Required: true,
+	}
+)
+
+func RetentionCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "retention",
+		Usage: "Lock files against changes with retention periods and legal holds",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "show",
+				Usage:  "Print the retention and legal hold of a file",
+				Flags:  []cli.Flag{FlagTarget, FlagRetentionName},
+				Action: retentionShowCommand,
+			},
+			{
+				Name:   "set",
+				Usage:  "Lock a file until a time, compliance locks can only be extended",
+				Flags:  []cli.Flag{FlagTarget, FlagRetentionName, FlagRetentionMode, FlagRetainUntil},
+				Action: retentionSetCommand,
+			},
+			{
+				Name:   "clear",
+				Usage:  "Remove the governance lock of a file",
+				Flags:  []cli.Flag{FlagTarget, FlagRetentionName},
+				Action: retentionClearCommand,
+			},
+			{
+				Name:   "hold",
+				Usage:  "Place a file under legal hold",
+				Flags:  []cli.Flag{FlagTarget, FlagRetentionName},
+				Action: retentionHoldCommand,
+			},
+			{
+				Name:   "release",
+				Usage:  "Release the legal hold of a file",
+				Flags:  []cli.Flag{FlagTarget, FlagRetentionName},
+				Action: retentionReleaseCommand,
+			},
+		},
+	}
+}
+
+func retentionShowCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		retention, err := c.Retention(ctx, clictx.String("name"))
+		if err != nil {
+			return err
+		}
+		out, err := json.Marshal(struct {
+			storage.Retention
+			Locked bool `json:"locked"`
+		}{retention, retention.Locked(time.Now())})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal retention")
+		}
+		fmt.Println(string(out))
+		return nil
+	})
+}
+
+func retentionSetCommand(clictx *cli.Context) error {
+	mode := clictx.String("mode")
+	if mode != storage.RetentionGovernance && mode != storage.RetentionCompliance {
+		return errors.Errorf("unknown retention mode %q", mode)
+	}
+	until, err := parseTime(clictx.String("until"))
+	if err != nil {
+		return err
+	}
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		return c.SetRetention(ctx, clictx.String("name"), mode, until)
+	})
+}
+
+func retentionClearCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		return c.SetRetention(ctx, clictx.String("name"), "", time.Time{})
+	})
+}
+
+func retentionHoldCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		return c.SetLegalHold(ctx, clictx.String("name"), true)
+	})
+}
+
+func retentionReleaseCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		return c.SetLegalHold(ctx, clictx.String("name"), false)
+	})
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -10,40 +13,173 @@ import (
 	"syscall"
 
 	"github.com/oklog/run"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	cli "github.com/urfave/cli/v2"
 
+	"github.com/peertechde/argon/pkg/config"
+	"github.com/peertechde/argon/pkg/lifecycle"
+	"github.com/peertechde/argon/pkg/logging"
 	"github.com/peertechde/argon/pkg/server"
+	"github.com/peertechde/argon/pkg/storage/compressed"
+	"github.com/peertechde/argon/pkg/storage/tiered"
+	"github.com/peertechde/argon/pkg/storage/worm"
 )
 
 var (
+	FlagConfig = &cli.StringFlag{
+		Name:    "config",
+		Usage:   "Path of the YAML or TOML configuration file",
+		EnvVars: []string{"ARGON_CONFIG"},
+	}
 	FlagServerId = &cli.StringFlag{
 		Name:  "id",
-		Usage: "TODO",
+		Usage: "Unique ID of the server",
 	}
 	FlagServerAddr = &cli.StringFlag{
 		Name:  "addr",
 		Value: "0.0.0.0",
-		Usage: "TODO",
+		Usage: "Address the gRPC server listens on",
 	}
 	FlagServerPort = &cli.IntFlag{
 		Name:  "port",
 		Value: 8080,
-		Usage: "TODO",
+		Usage: "Port the gRPC server listens on",
 	}
 	FlagServerStoragePath = &cli.StringFlag{
 		Name:  "path",
-		Usage: "TODO",
+		Usage: "Directory the files are stored in",
+	}
+	FlagStorageBackend = &cli.StringFlag{
+		Name:  "storage-backend",
+		Usage: "Storage backend: local, erasure, mirror or cas",
+	}
+	FlagErasureDir = &cli.StringSliceFlag{
+		Name:  "erasure-dir",
+		Usage: "Directory of an erasure coded shard, repeated once per shard",
+	}
+	FlagErasureDataShards = &cli.IntFlag{
+		Name:  "erasure-data-shards",
+		Usage: "Number of data shards of an erasure coded file",
+	}
+	FlagErasureParityShards = &cli.IntFlag{
+		Name:  "erasure-parity-shards",
+		Usage: "Number of parity shards of an erasure coded file",
+	}
+	FlagMirrorDir = &cli.StringSliceFlag{
+		Name:  "mirror-dir",
+		Usage: "Directory holding a copy of every file, repeated once per mirror",
+	}
+	FlagCompression = &cli.StringFlag{
+		Name:  "compression",
+		Usage: "Compression algorithm of stored files: zstd, gzip or none",
+	}
+	FlagCompressionRule = &cli.StringSliceFlag{
+		Name:  "compression-rule",
+		Usage: "Algorithm of the files matching a pattern as pattern=algorithm, e.g. '*.gz=none', can be repeated",
+	}
+	FlagEncryptionKeyfile = &cli.StringFlag{
+		Name:  "encryption-keyfile",
+		Usage: "Keyfile with the master keys, enables encryption of stored files",
+	}
+	FlagEncryptionKeysPath = &cli.StringFlag{
+		Name:  "encryption-keys-path",
+		Usage: "Directory the wrapped data keys of encrypted files are stored in",
+	}
+	FlagTieringColdPath = &cli.StringFlag{
+		Name:  "tiering-cold-path",
+		Usage: "Directory of the cold tier, enables moving old files there",
+	}
+	FlagTieringPath = &cli.StringFlag{
+		Name:  "tiering-path",
+		Usage: "Directory of the placement of the tiered files",
 	}
 	FlagPrometheusAddr = &cli.StringFlag{
 		Name:  "prometheus_addr",
 		Value: "0.0.0.0",
-		Usage: "TODO",
+		Usage: "Address the metrics and admin HTTP server listens on",
 	}
 	FlagPrometheusPort = &cli.IntFlag{
 		Name:  "prometheus_port",
 		Value: 9090,
-		Usage: "TODO",
+		Usage: "Port the metrics and admin HTTP server listens on",
+	}
+	FlagTLSCert = &cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "Path of the TLS certificate, enables TLS",
+	}
+	FlagTLSKey = &cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "Path of the TLS private key",
+	}
+	FlagTLSClientCA = &cli.StringFlag{
+		Name:  "tls-client-ca",
+		Usage: "Path of the CA bundle verifying client certificates",
+	}
+	FlagAuditPath = &cli.StringFlag{
+		Name:  "audit-path",
+		Usage: "Path of the audit log, auditing is disabled if unset",
+	}
+	FlagAuditMaxSize = &cli.Int64Flag{
+		Name:  "audit-max-size",
+		Value: 100 * 1024 * 1024,
+		Usage: "Size in bytes after which the audit log is rotated",
+	}
+	FlagAuditReads = &cli.BoolFlag{
+		Name:  "audit-reads",
+		Usage: "Audit non-mutating operations as well",
+	}
+	FlagServerMode = &cli.StringFlag{
+		Name:  "mode",
+		Usage: "Mode to start in: read-write, read-only or maintenance",
+	}
+	FlagServerModeReason = &cli.StringFlag{
+		Name:  "mode-reason",
+		Usage: "Reason reported to clients whose requests are rejected by the mode",
+	}
+	FlagReplicationRole = &cli.StringFlag{
+		Name:  "replication-role",
+		Usage: "Replication role of the server: primary or replica",
+	}
+	FlagReplicationPath = &cli.StringFlag{
+		Name:  "replication-path",
+		Usage: "Directory of the replication log and position",
+	}
+	FlagReplica = &cli.StringSliceFlag{
+		Name:  "replica",
+		Usage: "Address of a replica the primary ships its mutations to, can be repeated",
+	}
+	FlagClusterRaftAddr = &cli.StringFlag{
+		Name:  "cluster-raft-addr",
+		Usage: "Address to serve the Raft traffic on, enables the cluster mode",
+	}
+	FlagClusterAPIAddr = &cli.StringFlag{
+		Name:  "cluster-api-addr",
+		Usage: "Address other cluster members reach the API of this server at",
+	}
+	FlagClusterPath = &cli.StringFlag{
+		Name:  "cluster-path",
+		Usage: "Directory of the Raft log and snapshots",
+	}
+	FlagClusterBootstrap = &cli.BoolFlag{
+		Name:  "cluster-bootstrap",
+		Usage: "Bootstrap a new cluster with this server as its first member",
+	}
+	FlagExpiryInterval = &cli.DurationFlag{
+		Name:  "expiry-interval",
+		Usage: "Interval of the worker deleting expired files",
+	}
+	FlagLifecycleInterval = &cli.DurationFlag{
+		Name:  "lifecycle-interval",
+		Usage: "Interval of applying the lifecycle rules",
+	}
+	FlagTrash = &cli.BoolFlag{
+		Name:  "trash",
+		Usage: "Move removed files into the trash",
+	}
+	FlagTrashRetention = &cli.DurationFlag{
+		Name:  "trash-retention",
+		Usage: "Duration after which trashed files are purged, 0 keeps them",
 	}
 )
 
@@ -52,23 +188,348 @@ func ServerCommand() *cli.Command {
 		Name:  "server",
 		Usage: "Start and serve the server",
 		Flags: []cli.Flag{
+			FlagConfig,
 			FlagServerId,
 			FlagServerAddr,
 			FlagServerPort,
 			FlagServerStoragePath,
+			FlagStorageBackend,
+			FlagErasureDir,
+			FlagErasureDataShards,
+			FlagErasureParityShards,
+			FlagMirrorDir,
+			FlagCompression,
+			FlagCompressionRule,
+			FlagEncryptionKeyfile,
+			FlagEncryptionKeysPath,
+			FlagTieringColdPath,
+			FlagTieringPath,
 			FlagPrometheusAddr,
 			FlagPrometheusPort,
+			FlagTLSCert,
+			FlagTLSKey,
+			FlagTLSClientCA,
+			FlagAuditPath,
+			FlagAuditMaxSize,
+			FlagAuditReads,
+			FlagServerMode,
+			FlagServerModeReason,
+			FlagReplicationRole,
+			FlagReplicationPath,
+			FlagReplica,
+			FlagClusterRaftAddr,
+			FlagClusterAPIAddr,
+			FlagClusterPath,
+			FlagClusterBootstrap,
+			FlagExpiryInterval,
+			FlagLifecycleInterval,
+			FlagTrash,
+			FlagTrashRetention,
 		},
 		Action: serverCommand,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "validate-config",
+				Usage:  "Validate the configuration file and exit",
+				Flags:  []cli.Flag{FlagConfig},
+				Action: validateConfigCommand,
+			},
+		},
+	}
+}
+
+func validateConfigCommand(clictx *cli.Context) error {
+	if !clictx.IsSet("config") {
+		return requiredFlag(clictx, "config")
+	}
+
+	cfg, err := config.Load(clictx.String("config"))
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Configuration %s is valid\n", clictx.String("config"))
+	return nil
+}
+
+// loadConfig loads the configuration file, if any, and overrides it with the
+// flags set on the command line.
+func loadConfig(clictx *cli.Context) (*config.Config, error) {
+	cfg, err := config.Load(clictx.String("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	if clictx.IsSet("id") {
+		cfg.Id = clictx.String("id")
+	}
+	if clictx.IsSet("addr") {
+		cfg.Listen.Addr = clictx.String("addr")
+	}
+	if clictx.IsSet("port") {
+		cfg.Listen.Port = clictx.Int("port")
+	}
+	if clictx.IsSet("path") {
+		cfg.Storage.Path = clictx.String("path")
+	}
+	if clictx.IsSet("storage-backend") {
+		cfg.Storage.Backend = clictx.String("storage-backend")
+	}
+	if clictx.IsSet("erasure-dir") {
+		cfg.Storage.Erasure.Dirs = clictx.StringSlice("erasure-dir")
+	}
+	if clictx.IsSet("erasure-data-shards") {
+		cfg.Storage.Erasure.DataShards = clictx.Int("erasure-data-shards")
+	}
+	if clictx.IsSet("erasure-parity-shards") {
+		cfg.Storage.Erasure.ParityShards = clictx.Int("erasure-parity-shards")
+	}
+	if clictx.IsSet("mirror-dir") {
+		cfg.Storage.Mirror.Dirs = clictx.StringSlice("mirror-dir")
+	}
+	if clictx.IsSet("compression") {
+		cfg.Storage.Compression.Algorithm = clictx.String("compression")
+	}
+	if clictx.IsSet("compression-rule") {
+		cfg.Storage.Compression.Rules = clictx.StringSlice("compression-rule")
+	}
+	if clictx.IsSet("encryption-keyfile") {
+		cfg.Storage.Encryption.Keyfile = clictx.String("encryption-keyfile")
+	}
+	if clictx.IsSet("encryption-keys-path") {
+		cfg.Storage.Encryption.KeysPath = clictx.String("encryption-keys-path")
+	}
+	if clictx.IsSet("tiering-cold-path") {
+		cfg.Storage.Tiering.ColdPath = clictx.String("tiering-cold-path")
+	}
+	if clictx.IsSet("tiering-path") {
+		cfg.Storage.Tiering.Path = clictx.String("tiering-path")
+	}
+	if clictx.IsSet("prometheus_addr") {
+		cfg.Metrics.Addr = clictx.String("prometheus_addr")
+	}
+	if clictx.IsSet("prometheus_port") {
+		cfg.Metrics.Port = clictx.Int("prometheus_port")
+	}
+	if clictx.IsSet("tls-cert") {
+		cfg.TLS.CertFile = clictx.String("tls-cert")
+	}
+	if clictx.IsSet("tls-key") {
+		cfg.TLS.KeyFile = clictx.String("tls-key")
+	}
+	if clictx.IsSet("tls-client-ca") {
+		cfg.TLS.ClientCAFile = clictx.String("tls-client-ca")
+		if cfg.TLS.ClientAuth == config.ClientAuthNone {
+			cfg.TLS.ClientAuth = config.ClientAuthRequire
+		}
+	}
+	if clictx.IsSet("mode") {
+		cfg.Mode = clictx.String("mode")
+	}
+	if clictx.IsSet("mode-reason") {
+		cfg.ModeReason = clictx.String("mode-reason")
+	}
+	if clictx.IsSet("replication-role") {
+		cfg.Replication.Role = clictx.String("replication-role")
+	}
+	if clictx.IsSet("replication-path") {
+		cfg.Replication.Path = clictx.String("replication-path")
+	}
+	if clictx.IsSet("replica") {
+		cfg.Replication.Replicas = clictx.StringSlice("replica")
+	}
+	if clictx.IsSet("cluster-raft-addr") {
+		cfg.Cluster.RaftAddr = clictx.String("cluster-raft-addr")
 	}
+	if clictx.IsSet("cluster-api-addr") {
+		cfg.Cluster.APIAddr = clictx.String("cluster-api-addr")
+	}
+	if clictx.IsSet("cluster-path") {
+		cfg.Cluster.Path = clictx.String("cluster-path")
+	}
+	if clictx.IsSet("cluster-bootstrap") {
+		cfg.Cluster.Bootstrap = clictx.Bool("cluster-bootstrap")
+	}
+	if clictx.IsSet("expiry-interval") {
+		cfg.Expiry.Interval = config.Duration(clictx.Duration("expiry-interval"))
+	}
+	if clictx.IsSet("lifecycle-interval") {
+		cfg.Lifecycle.Interval = config.Duration(clictx.Duration("lifecycle-interval"))
+	}
+	if clictx.IsSet("trash") {
+		cfg.Trash.Enabled = clictx.Bool("trash")
+	}
+	if clictx.IsSet("trash-retention") {
+		cfg.Trash.Retention = config.Duration(clictx.Duration("trash-retention"))
+	}
+	if clictx.IsSet("audit-path") {
+		cfg.Audit.Path = clictx.String("audit-path")
+	}
+	if clictx.IsSet("audit-max-size") {
+		cfg.Audit.MaxSize = clictx.Int64("audit-max-size")
+	}
+	if clictx.IsSet("audit-reads") {
+		cfg.Audit.Reads = clictx.Bool("audit-reads")
+	}
+	if clictx.IsSet("log-format") {
+		cfg.Logging.Format = clictx.String("log-format")
+	}
+	if clictx.IsSet("log-level") {
+		cfg.Logging.Level = clictx.String("log-level")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func serverOptions(cfg *config.Config) ([]server.Option, error) {
+	// the mode has been validated by the config already
+	mode, _ := server.ParseMode(cfg.Mode)
+
+	options := []server.Option{
+		server.WithMode(mode, cfg.ModeReason),
+		server.WithId(cfg.Id),
+		server.WithAddr(cfg.Listen.Addr),
+		server.WithPort(cfg.Listen.Port),
+		server.WithStoragePath(cfg.Storage.Path),
+		server.WithPrometheusAddr(cfg.Metrics.Addr),
+		server.WithPrometheusPort(cfg.Metrics.Port),
+		server.WithAuditPath(cfg.Audit.Path),
+		server.WithAuditMaxSize(cfg.Audit.MaxSize),
+		server.WithAuditReads(cfg.Audit.Reads),
+		server.WithMaxMsgSize(cfg.Limits.MaxMsgSize),
+		server.WithMaxFileSize(cfg.Limits.MaxFileSize),
+		server.WithMaxConcurrentStreams(uint32(cfg.Limits.MaxConcurrentStreams)),
+		server.WithAllowedIdentities(cfg.Auth.AllowedIdentities...),
+		server.WithAdminIdentities(cfg.Auth.AdminIdentities...),
+		server.WithRetentionIdentities(cfg.Auth.RetentionIdentities...),
+		server.WithReplication(server.Role(cfg.Replication.Role), cfg.Replication.Path, cfg.Replication.Replicas...),
+		server.WithCluster(cfg.Cluster.RaftAddr, cfg.Cluster.APIAddr, cfg.Cluster.Path, cfg.Cluster.Bootstrap),
+		server.WithExpiryInterval(cfg.Expiry.Interval.Duration()),
+	}
+	var retentionRules []worm.Rule
+	for _, rule := range cfg.Retention.Rules {
+		retentionRules = append(retentionRules, worm.Rule{Prefix: rule.Prefix, Mode: rule.Mode, Period: rule.Period.Duration()})
+	}
+	options = append(options, server.WithRetentionRules(retentionRules...))
+	var rules []lifecycle.Rule
+	for _, rule := range cfg.Lifecycle.Rules {
+		rules = append(rules, lifecycle.Rule{
+			ID:              rule.ID,
+			Prefix:          rule.Prefix,
+			TransitionAfter: rule.TransitionAfter.Duration(),
+			ExpireAfter:     rule.ExpireAfter.Duration(),
+			KeepVersions:    rule.KeepVersions,
+		})
+	}
+	options = append(options, server.WithLifecycle(cfg.Lifecycle.Interval.Duration(), rules...))
+	if cfg.Storage.Backend == config.StorageBackendErasure {
+		erasure := cfg.Storage.Erasure
+		options = append(options, server.WithErasure(erasure.Dirs, erasure.DataShards, erasure.ParityShards))
+	}
+	if cfg.Storage.Backend == config.StorageBackendCAS {
+		options = append(options, server.WithCAS(cfg.Storage.Path))
+	}
+	if cfg.Storage.Backend == config.StorageBackendMirror {
+		options = append(options, server.WithMirror(cfg.Storage.Mirror.Dirs...))
+	}
+	if cfg.Storage.Encryption.Keyfile != "" {
+		options = append(options, server.WithEncryption(cfg.Storage.Encryption.Keyfile, cfg.Storage.Encryption.KeysPath))
+	}
+	if tiering := cfg.Storage.Tiering; tiering.Enabled() {
+		options = append(options, server.WithTiering(tiering.ColdPath, tiering.Path, tiering.Interval.Duration(), tiered.Policy{
+			PromoteReads: tiering.PromoteReads,
+			DemoteAfter:  tiering.DemoteAfter.Duration(),
+			HotMaxBytes:  tiering.HotMaxBytes,
+		}))
+	}
+	if cfg.Trash.Enabled {
+		options = append(options, server.WithTrash(cfg.Trash.Retention.Duration()))
+	}
+	if cfg.Storage.Compression.Enabled() {
+		policy := compressed.Policy{Default: cfg.Storage.Compression.Algorithm}
+		for _, s := range cfg.Storage.Compression.Rules {
+			rule, err := compressed.ParseRule(s)
+			if err != nil {
+				return nil, err
+			}
+			policy.Rules = append(policy.Rules, rule)
+		}
+		options = append(options, server.WithCompression(policy))
+	}
+	if cfg.TLS.Enabled() {
+		clientAuth := tls.NoClientCert
+		switch cfg.TLS.ClientAuth {
+		case config.ClientAuthRequest:
+			clientAuth = tls.VerifyClientCertIfGiven
+		case config.ClientAuthRequire:
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+		options = append(options,
+			server.WithTLSFiles(cfg.TLS.CertFile, cfg.TLS.KeyFile),
+			server.WithTLSClientAuth(cfg.TLS.ClientCAFile, clientAuth),
+		)
+
+		// the primary and cluster members authenticate to their peers with
+		// the server certificate
+		if cfg.Replication.Role == config.ReplicationRolePrimary || cfg.Cluster.RaftAddr != "" {
+			tlsConfig, err := peerTLSConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, server.WithPeerTLSConfig(tlsConfig))
+		}
+	}
+	return options, nil
+}
+
+func peerTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load certificate")
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", cfg.TLS.ClientCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
 }
 
 func serverCommand(clictx *cli.Context) error {
-	if !clictx.IsSet("id") {
-		return requiredFlag(clictx, "id")
+	cfg, err := loadConfig(clictx)
+	if err != nil {
+		return err
 	}
-	if !clictx.IsSet("path") {
-		return requiredFlag(clictx, "path")
+	if err := logging.SetFormat(cfg.Logging.Format); err != nil {
+		return err
+	}
+	if err := logging.SetLevel(cfg.Logging.Level); err != nil {
+		return err
+	}
+
+	options, err := serverOptions(cfg)
+	if err != nil {
+		return err
+	}
+	srv, err := server.New(options...)
+	if err != nil {
+		return err
 	}
 
 	var g run.Group
@@ -94,15 +555,44 @@ func serverCommand(clictx *cli.Context) error {
 		)
 	}
 	{
-		addr := fmt.Sprintf("%s:%d", clictx.String("prometheus_addr"), clictx.Int("prometheus_port"))
+		// reload handler
+		current := cfg
+		hupc := make(chan os.Signal, 1)
+		signal.Notify(hupc, syscall.SIGHUP)
+		cancelc := make(chan struct{})
+
+		g.Add(
+			func() error {
+				for {
+					select {
+					case <-hupc:
+						log.Info("Received SIGHUP, reloading the configuration...")
+						current = reloadServer(clictx, srv, current)
+					case <-cancelc:
+						return nil
+					}
+				}
+			},
+			func(err error) {
+				signal.Stop(hupc)
+				close(cancelc)
+			},
+		)
+	}
+	{
+		addr := fmt.Sprintf("%s:%d", cfg.Metrics.Addr, cfg.Metrics.Port)
 		ln, err := net.Listen("tcp", addr)
 		if err != nil {
 			return err
 		}
 		defer ln.Close()
 
+		mux := http.NewServeMux()
+		mux.Handle("/", promhttp.Handler())
+		mux.Handle("/log/level", logging.LevelHandler())
+
 		httpServer := &http.Server{
-			Handler: promhttp.Handler(),
+			Handler: mux,
 		}
 
 		g.Add(
@@ -116,15 +606,6 @@ func serverCommand(clictx *cli.Context) error {
 		)
 	}
 	{
-		srv, err := server.New(
-			server.WithId(clictx.String("id")),
-			server.WithAddr(clictx.String("addr")),
-			server.WithPort(clictx.Int("port")),
-			server.WithStoragePath(clictx.String("path")),
-		)
-		if err != nil {
-			return err
-		}
 		g.Add(
 			func() error {
 				return srv.Serve()
@@ -139,3 +620,31 @@ func serverCommand(clictx *cli.Context) error {
 	}
 	return nil
 }
+
+// reloadServer applies the reloadable settings of the configuration and
+// returns the configuration in effect. An invalid configuration is rejected as
+// a whole and the server keeps running with the previous one. Like the mode,
+// the log level is only applied if it changed in the configuration, so a level
+// set through the admin service survives unrelated reloads.
+func reloadServer(clictx *cli.Context, srv *server.Server, previous *config.Config) *config.Config {
+	cfg, err := loadConfig(clictx)
+	if err != nil {
+		log.Errorf("Failed to reload the configuration (%s)", err)
+		return previous
+	}
+	options, err := serverOptions(cfg)
+	if err != nil {
+		log.Errorf("Failed to reload the configuration (%s)", err)
+		return previous
+	}
+	if err := srv.Reload(options...); err != nil {
+		log.Errorf("Failed to reload the configuration (%s)", err)
+		return previous
+	}
+	if cfg.Logging.Level != previous.Logging.Level {
+		if err := logging.SetLevel(cfg.Logging.Level); err != nil {
+			log.Errorf("Failed to set the log level (%s)", err)
+		}
+	}
+	return cfg
+}
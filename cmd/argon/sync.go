@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/client"
+)
+
+var (
+	FlagSyncFrom = &cli.StringFlag{
+		Name:     "from",
+		Usage:    "Address of the server whose files are authoritative",
+		Required: true,
+	}
+	FlagSyncTo = &cli.StringFlag{
+		Name:     "to",
+		Usage:    "Address of the server which is repaired",
+		Required: true,
+	}
+	FlagSyncTwoWay = &cli.BoolFlag{
+		Name:  "two-way",
+		Usage: "Copy files missing on the source from the destination as well",
+	}
+	FlagSyncDelete = &cli.BoolFlag{
+		Name:  "delete",
+		Usage: "Remove files from the destination which the source doesn't have",
+	}
+)
+
+func SyncCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "sync",
+		Usage: "Repair the differences between two servers by comparing their Merkle trees",
+		Flags: []cli.Flag{
+			FlagSyncFrom,
+			FlagSyncTo,
+			FlagSyncTwoWay,
+			FlagSyncDelete,
+			FlagDryRun,
+		},
+		Action: syncCommand,
+	}
+}
+
+func syncCommand(clictx *cli.Context) error {
+	// termination handler
+	termc := make(chan os.Signal, 1)
+	signal.Notify(termc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(termc)
+
+	opctx, opcancel := context.WithCancel(context.Background())
+	defer opcancel()
+
+	go func() {
+		select {
+		case <-termc:
+			log.Warnf("Received SIGTERM, exiting gracefully...")
+			opcancel()
+		case <-opctx.Done():
+		}
+	}()
+
+	fromAddr, toAddr := clictx.String("from"), clictx.String("to")
+	from := client.New()
+	if err := from.DialContext(opctx, fromAddr); err != nil {
+		return errors.Wrapf(err, "failed to dial %s", fromAddr)
+	}
+	to := client.New()
+	if err := to.DialContext(opctx, toAddr); err != nil {
+		return errors.Wrapf(err, "failed to dial %s", toAddr)
+	}
+
+	options := client.SyncOptions{
+		TwoWay: clictx.Bool("two-way"),
+		Delete: clictx.Bool("delete"),
+		DryRun: clictx.Bool("dry-run"),
+	}
+	report, err := client.Sync(opctx, from, to, fromAddr, toAddr, options)
+	if report != nil {
+		for _, action := range report.Actions {
+			switch action.Op {
+			case client.SyncRemove:
+				fmt.Printf("%s %s on %s\n", action.Op, action.Name, action.Target)
+			default:
+				fmt.Printf("%s %s from %s to %s\n", action.Op, action.Name, action.Source, action.Target)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if options.DryRun {
+		fmt.Printf("Would repair %d files in %d differing leaves\n", len(report.Actions), report.Leaves)
+	} else {
+		fmt.Printf("Repaired %d files in %d differing leaves\n", len(report.Actions), report.Leaves)
+	}
+	return nil
+}
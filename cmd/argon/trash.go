@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/peertechde/argon/pkg/client"
+)
+
+var (
+	FlagTrashId = &cli.StringSliceFlag{
+		Name:  "id",
+		Usage: "ID of the trashed file, see 'trash list', can be repeated for purge",
+	}
+	FlagTrashRestoreName = &cli.StringFlag{
+		Name:  "name",
+		Usage: "Name to restore the file to instead of its original name",
+	}
+	FlagTrashPurgeAll = &cli.BoolFlag{
+		Name:  "all",
+		Usage: "Purge all trashed files",
+	}
+)
+
+func TrashCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "trash",
+		Usage: "List, restore and purge removed files",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the trashed files, the most recently deleted first",
+				Flags:  []cli.Flag{FlagTarget},
+				Action: trashListCommand,
+			},
+			{
+				Name:   "restore",
+				Usage:  "Restore a trashed file",
+				Flags:  []cli.Flag{FlagTarget, FlagTrashId, FlagTrashRestoreName},
+				Action: trashRestoreCommand,
+			},
+			{
+				Name:   "purge",
+				Usage:  "Remove trashed files permanently",
+				Flags:  []cli.Flag{FlagTarget, FlagTrashId, FlagTrashPurgeAll},
+				Action: trashPurgeCommand,
+			},
+		},
+	}
+}
+
+func trashListCommand(clictx *cli.Context) error {
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		entries, err := c.ListTrash(ctx)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			out, err := json.Marshal(entry)
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal trash entry")
+			}
+			fmt.Println(string(out))
+		}
+		return nil
+	})
+}
+
+func trashRestoreCommand(clictx *cli.Context) error {
+	ids := clictx.StringSlice("id")
+	if len(ids) != 1 {
+		return errors.New("'trash restore' requires a single '--id' flag")
+	}
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		name, err := c.RestoreTrash(ctx, ids[0], clictx.String("name"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s to %s\n", ids[0], name)
+		return nil
+	})
+}
+
+func trashPurgeCommand(clictx *cli.Context) error {
+	ids := clictx.StringSlice("id")
+	all := clictx.Bool("all")
+	if all == (len(ids) > 0) {
+		return errors.New("'trash purge' requires either the '--id' or the '--all' flag")
+	}
+
+	return runClient(clictx, func(ctx context.Context, c *client.Client) error {
+		var purged int64
+		var err error
+		if all {
+			purged, err = c.PurgeAllTrash(ctx)
+		} else {
+			purged, err = c.PurgeTrash(ctx, ids...)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d files\n", purged)
+		return nil
+	})
+}
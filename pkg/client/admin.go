@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+
+	"github.com/peertechde/argon/api"
+)
+
+func (c *Client) Info(ctx context.Context) (*api.InfoResponse, error) {
+	return c.adminClient.Info(ctx, &api.InfoRequest{})
+}
+
+func (c *Client) ListJobs(ctx context.Context) ([]*api.Job, error) {
+	resp, err := c.adminClient.ListJobs(ctx, &api.ListJobsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jobs, nil
+}
+
+func (c *Client) Scrub(ctx context.Context) (*api.Job, error) {
+	resp, err := c.adminClient.Scrub(ctx, &api.ScrubRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Job, nil
+}
+
+func (c *Client) GC(ctx context.Context) (*api.Job, error) {
+	resp, err := c.adminClient.GC(ctx, &api.GCRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Job, nil
+}
+
+func (c *Client) Heal(ctx context.Context) (*api.Job, error) {
+	resp, err := c.adminClient.Heal(ctx, &api.HealRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Job, nil
+}
+
+// SetMode switches the server into the given mode, reason is reported to
+// clients whose requests are rejected.
+func (c *Client) SetMode(ctx context.Context, mode api.Mode, reason string) error {
+	_, err := c.adminClient.SetMode(ctx, &api.SetModeRequest{Mode: mode, Reason: reason})
+	return err
+}
+
+// LogLevel changes the log level of the server to level unless it is empty
+// and returns the current level.
+func (c *Client) LogLevel(ctx context.Context, level string) (string, error) {
+	resp, err := c.adminClient.LogLevel(ctx, &api.LogLevelRequest{Level: level})
+	if err != nil {
+		return "", err
+	}
+	return resp.Level, nil
+}
+
+// Promote turns a replica into a primary and returns the seq of the last
+// entry it applied.
+func (c *Client) Promote(ctx context.Context) (uint64, error) {
+	resp, err := c.adminClient.Promote(ctx, &api.PromoteRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return resp.LastSeq, nil
+}
+
+func (c *Client) ReplicationStatus(ctx context.Context) (*api.ReplicationStatusResponse, error) {
+	return c.adminClient.ReplicationStatus(ctx, &api.ReplicationStatusRequest{})
+}
+
+func (c *Client) ClusterStatus(ctx context.Context) (*api.ClusterStatusResponse, error) {
+	return c.adminClient.ClusterStatus(ctx, &api.ClusterStatusRequest{})
+}
+
+// ClusterJoin adds the server id to the cluster, it has to be sent to the
+// leader.
+func (c *Client) ClusterJoin(ctx context.Context, id, raftAddr, apiAddr string) error {
+	_, err := c.adminClient.ClusterJoin(ctx, &api.ClusterJoinRequest{Id: id, RaftAddr: raftAddr, ApiAddr: apiAddr})
+	return err
+}
+
+// ClusterRemove removes the server id from the cluster, it has to be sent to
+// the leader.
+func (c *Client) ClusterRemove(ctx context.Context, id string) error {
+	_, err := c.adminClient.ClusterRemove(ctx, &api.ClusterRemoveRequest{Id: id})
+	return err
+}
+
+// MerkleTree returns the node at prefix of the server's Merkle tree, refresh
+// rebuilds the tree.
+func (c *Client) MerkleTree(ctx context.Context, prefix string, refresh bool) (*api.MerkleTreeResponse, error) {
+	return c.adminClient.MerkleTree(ctx, &api.MerkleTreeRequest{Prefix: prefix, Refresh: refresh})
+}
+
+func (c *Client) EncryptionKeys(ctx context.Context) (*api.EncryptionKeysResponse, error) {
+	return c.adminClient.EncryptionKeys(ctx, &api.EncryptionKeysRequest{})
+}
+
+// RotateKeys starts re-wrapping the data keys with the active master key.
+func (c *Client) RotateKeys(ctx context.Context) (*api.Job, error) {
+	resp, err := c.adminClient.RotateKeys(ctx, &api.RotateKeysRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Job, nil
+}
+
+// Reindex starts rebuilding the metadata index of the server.
+func (c *Client) Reindex(ctx context.Context) (*api.Job, error) {
+	resp, err := c.adminClient.Reindex(ctx, &api.ReindexRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Job, nil
+}
+
+func (c *Client) SetReadOnly(ctx context.Context, readOnly bool) error {
+	_, err := c.adminClient.SetReadOnly(ctx, &api.SetReadOnlyRequest{ReadOnly: readOnly})
+	return err
+}
+
+func (c *Client) LifecycleRules(ctx context.Context) ([]*api.LifecycleRule, error) {
+	resp, err := c.adminClient.LifecycleRules(ctx, &api.LifecycleRulesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Rules, nil
+}
+
+// SetLifecycleRules replaces the lifecycle rules of the server until its
+// configuration is reloaded, no rules disable the lifecycle actions.
+func (c *Client) SetLifecycleRules(ctx context.Context, rules []*api.LifecycleRule) error {
+	_, err := c.adminClient.SetLifecycleRules(ctx, &api.SetLifecycleRulesRequest{Rules: rules})
+	return err
+}
+
+// RunLifecycle starts applying the lifecycle rules, a dry run returns the
+// actions the rules would take instead of a job.
+func (c *Client) RunLifecycle(ctx context.Context, dryRun bool) (*api.RunLifecycleResponse, error) {
+	return c.adminClient.RunLifecycle(ctx, &api.RunLifecycleRequest{DryRun: dryRun})
+}
@@ -7,15 +7,20 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/peertechde/argon/api"
 	"github.com/peertechde/argon/pkg/logging"
 	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/tracing"
 )
 
 const (
@@ -38,6 +43,7 @@ type Client struct {
 
 	grpcClient    *grpc.ClientConn
 	storageClient api.StorageClient
+	adminClient   api.AdminClient
 }
 
 func (c *Client) DialContext(ctx context.Context, target string) error {
@@ -51,63 +57,169 @@ func (c *Client) DialContext(ctx context.Context, target string) error {
 			grpc.WithInsecure(),
 		)
 	}
+	dialOptions = append(dialOptions,
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	)
 	cc, err := grpc.DialContext(ctx, target, dialOptions...)
 	if err != nil {
 		return err
 	}
 	c.grpcClient = cc
 	c.storageClient = api.NewStorageClient(c.grpcClient)
+	c.adminClient = api.NewAdminClient(c.grpcClient)
 
 	return nil
 }
 
-func (c *Client) Read(ctx context.Context, name, dst string) error {
-	stream, err := c.storageClient.Read(ctx, &api.ReadRequest{Name: name})
+func (c *Client) Read(ctx context.Context, name, dst string) (err error) {
+	ctx, span := tracing.Start(ctx, "client.Read", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	var data []byte
+	err = c.each(name, func(stored string) error {
+		data, err = c.read(ctx, stored)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	if IsEncrypted(data) {
+		if c.options.Keyring == nil {
+			return errors.Wrapf(ErrEncrypted, "failed to read %s", name)
+		}
+		if data, err = c.options.Keyring.decrypt(data); err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", name)
+		}
+	}
+	_, err = save(dst, data)
+	return err
+}
+
+// ReadRange returns length bytes of the remote file name starting at offset,
+// less if the file ends before. A length of 0 reads to the end of the file.
+// Only the requested part is transferred, so end-to-end encrypted files can't
+// be read partially.
+func (c *Client) ReadRange(ctx context.Context, name string, offset, length int64) (data []byte, err error) {
+	ctx, span := tracing.Start(ctx, "client.ReadRange", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if c.options.Keyring != nil {
+		return nil, errors.Errorf("failed to read %s, encrypted files can't be read partially", name)
+	}
+	if offset < 0 || length < 0 {
+		return nil, errors.Errorf("invalid range %d+%d", offset, length)
+	}
+	return c.readRange(ctx, c.storedNames(name)[0], offset, length)
+}
+
+// storedNames returns the names name may be stored as, they differ if names
+// are encrypted.
+func (c *Client) storedNames(name string) []string {
+	if !c.options.EncryptNames || c.options.Keyring == nil {
+		return []string{name}
+	}
+	return c.options.Keyring.encryptedNames(name)
+}
+
+// each calls fn with the names name may be stored as until it succeeds, it
+// returns the error of the first name otherwise.
+func (c *Client) each(name string, fn func(stored string) error) error {
+	var firstErr error
+	for _, stored := range c.storedNames(name) {
+		err := fn(stored)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// read returns the content of the remote file name.
+func (c *Client) read(ctx context.Context, name string) ([]byte, error) {
+	return c.readRange(ctx, name, 0, 0)
+}
+
+func (c *Client) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	stream, err := c.storageClient.Read(ctx, &api.ReadRequest{Name: name, Offset: offset, Length: length})
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
-	var size int
 	for {
 		resp, err := stream.Recv()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return err
-		}
-		_, err = buf.Write(resp.Data)
-		if err != nil {
-			return err
+			return nil, err
 		}
-		size += len(resp.Data)
-	}
-	n, err := save(dst, buf.Bytes())
-	if err != nil {
-		return err
+		buf.Write(resp.Data)
 	}
+	return buf.Bytes(), nil
+}
 
-	// TODO:
-	if n != size {
-	}
+// WriteOptions are applied to a written file. Metadata is attached to the
+// remote file, it isn't end-to-end encrypted. The server removes the file
+// once it expired after TTL or at ExpiresAt, at most one of them can be set.
+type WriteOptions struct {
+	Metadata  map[string]string
+	TTL       time.Duration
+	ExpiresAt time.Time
+}
 
-	return nil
+func (o WriteOptions) request(name string) *api.WriteRequest {
+	req := &api.WriteRequest{Member: &api.WriteRequest_Name{Name: name}, Metadata: o.Metadata}
+	if o.TTL != 0 {
+		req.Ttl = durationpb.New(o.TTL)
+	}
+	if !o.ExpiresAt.IsZero() {
+		req.ExpiresAt = timestamppb.New(o.ExpiresAt)
+	}
+	return req
 }
 
-func (c *Client) Write(ctx context.Context, name string) error {
+// Write stores the local file name under its base name.
+func (c *Client) Write(ctx context.Context, name string, opts WriteOptions) (err error) {
+	ctx, span := tracing.Start(ctx, "client.Write", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	fd, err := os.Open(name)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open file")
 	}
 	defer fd.Close()
 
+	var r io.Reader = fd
+	if c.options.Keyring != nil {
+		if r, err = c.options.Keyring.encryptReader(fd); err != nil {
+			return err
+		}
+	}
+	return c.write(ctx, c.storedNames(filepath.Base(name))[0], r, opts)
+}
+
+// write stores the content of r as the remote file name.
+func (c *Client) write(ctx context.Context, name string, r io.Reader, opts WriteOptions) error {
 	stream, err := c.storageClient.Write(ctx)
 	if err != nil {
 		return err
 	}
 
-	if err := stream.Send(&api.WriteRequest{Member: &api.WriteRequest_Name{Name: filepath.Base(name)}}); err != nil {
+	if err := stream.Send(opts.request(name)); err != nil {
 		s := status.Convert(err)
 		for _, d := range s.Details() {
 			switch info := d.(type) {
@@ -118,7 +230,7 @@ func (c *Client) Write(ctx context.Context, name string) error {
 		return errors.Wrap(err, "failed to send")
 	}
 
-	rd := bufio.NewReader(fd)
+	rd := bufio.NewReader(r)
 	buf := make([]byte, defaultMaxMsgSize)
 	for {
 		n, err := rd.Read(buf)
@@ -140,31 +252,137 @@ func (c *Client) Write(ctx context.Context, name string) error {
 	return nil
 }
 
+// Append appends the content of r to the remote file name, which is created
+// if it doesn't exist, and returns its new size. Unless expectedOffset is
+// negative, nothing is appended if the file doesn't have that many bytes,
+// e.g. because of a concurrent appender. End-to-end encrypted files can't be
+// appended to.
+func (c *Client) Append(ctx context.Context, name string, r io.Reader, expectedOffset int64) (size int64, err error) {
+	ctx, span := tracing.Start(ctx, "client.Append", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if c.options.Keyring != nil {
+		return 0, errors.Errorf("failed to append to %s, encrypted files can't be appended to", name)
+	}
+
+	stream, err := c.storageClient.Append(ctx)
+	if err != nil {
+		return 0, err
+	}
+	req := &api.AppendRequest{Member: &api.AppendRequest_Name{Name: c.storedNames(name)[0]}}
+	if expectedOffset >= 0 {
+		req.ExpectedOffset = wrapperspb.Int64(expectedOffset)
+	}
+	if err := stream.Send(req); err != nil {
+		return 0, errors.Wrap(err, "failed to send")
+	}
+
+	rd := bufio.NewReader(r)
+	buf := make([]byte, defaultMaxMsgSize)
+	for {
+		n, err := rd.Read(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, errors.Wrap(err, "failed to read data")
+		}
+		if err := stream.Send(&api.AppendRequest{Member: &api.AppendRequest_Data{Data: buf[:n]}}); err != nil {
+			return 0, errors.Wrap(err, "failed to send")
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return resp.Size, nil
+}
+
 func (c *Client) List(ctx context.Context) ([]string, error) {
 	resp, err := c.storageClient.List(ctx, &api.ListRequest{})
 	if err != nil {
 		return nil, err
 	}
-	return resp.Files, nil
+	if !c.options.EncryptNames || c.options.Keyring == nil {
+		return resp.Files, nil
+	}
+	files := make([]string, 0, len(resp.Files))
+	for _, stored := range resp.Files {
+		if name, ok := c.options.Keyring.decryptName(stored); ok {
+			stored = name
+		}
+		files = append(files, stored)
+	}
+	return files, nil
 }
 
 func (c *Client) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
-	resp, err := c.storageClient.Stat(ctx, &api.StatRequest{Name: name})
+	var resp *api.StatResponse
+	err := c.each(name, func(stored string) (err error) {
+		resp, err = c.storageClient.Stat(ctx, &api.StatRequest{Name: stored})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	fileInfo := &storage.FileInfo{
-		Name:    resp.FileInfo.Name,
+		Name:    name,
 		Size:    resp.FileInfo.Size,
 		Mode:    resp.FileInfo.Mode,
 		ModTime: resp.FileInfo.ModTime.AsTime(),
 		Dir:     resp.FileInfo.Dir,
+
+		StoredSize:  resp.FileInfo.StoredSize,
+		Compression: resp.FileInfo.Compression,
+		Metadata:    resp.FileInfo.Metadata,
+		Tier:        resp.FileInfo.Tier,
 	}
 	return fileInfo, nil
 }
 
+// metadata returns the metadata of the remote file name.
+func (c *Client) metadata(ctx context.Context, name string) (map[string]string, error) {
+	resp, err := c.storageClient.Stat(ctx, &api.StatRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.FileInfo.Metadata, nil
+}
+
+// SetMetadata replaces the metadata of name, empty metadata removes it.
+func (c *Client) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	return c.each(name, func(stored string) error {
+		_, err := c.storageClient.SetMetadata(ctx, &api.SetMetadataRequest{Name: stored, Metadata: metadata})
+		return err
+	})
+}
+
+// SetExpiry lets the server remove name after ttl or at t, at most one of
+// them can be set. Without either the file doesn't expire anymore.
+func (c *Client) SetExpiry(ctx context.Context, name string, ttl time.Duration, t time.Time) error {
+	req := &api.SetExpiryRequest{}
+	if ttl != 0 {
+		req.Ttl = durationpb.New(ttl)
+	}
+	if !t.IsZero() {
+		req.ExpiresAt = timestamppb.New(t)
+	}
+	return c.each(name, func(stored string) error {
+		req.Name = stored
+		_, err := c.storageClient.SetExpiry(ctx, req)
+		return err
+	})
+}
+
 func (c *Client) Remove(ctx context.Context, name string) error {
-	_, err := c.storageClient.Remove(ctx, &api.RemoveRequest{Name: name})
+	err := c.each(name, func(stored string) error {
+		_, err := c.storageClient.Remove(ctx, &api.RemoveRequest{Name: stored})
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -172,13 +390,25 @@ func (c *Client) Remove(ctx context.Context, name string) error {
 }
 
 func (c *Client) Rename(ctx context.Context, old, new string) error {
-	_, err := c.storageClient.Rename(ctx, &api.RenameRequest{Old: old, New: new})
+	err := c.each(old, func(stored string) error {
+		_, err := c.storageClient.Rename(ctx, &api.RenameRequest{Old: stored, New: c.storedNames(new)[0]})
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Copy copies src with its metadata to dst on the server, the content isn't
+// transferred.
+func (c *Client) Copy(ctx context.Context, src, dst string) error {
+	return c.each(src, func(stored string) error {
+		_, err := c.storageClient.Copy(ctx, &api.CopyRequest{Src: stored, Dst: c.storedNames(dst)[0]})
+		return err
+	})
+}
+
 func save(name string, data []byte) (int, error) {
 	fd, err := os.Create(name)
 	if err != nil {
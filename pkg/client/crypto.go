@@ -0,0 +1,312 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptedChunkSize is the amount of content sealed as one chunk.
+	encryptedChunkSize = 64 << 10
+
+	encryptedVersion = 1
+	keySize          = 32
+	tagSize          = 16
+	prefixSize       = 8
+
+	// encryptedNamePrefix marks encrypted names.
+	encryptedNamePrefix = "e2e."
+)
+
+// encryptedMagic starts every file encrypted by a client.
+var encryptedMagic = []byte("argc")
+
+// ErrEncrypted is returned when reading an encrypted file without a keyring.
+var ErrEncrypted = errors.New("file is end-to-end encrypted")
+
+// Keyring holds the keys files are encrypted with before they leave the
+// client.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// LoadKeyring reads the keyring file at path. Every line holds a key as
+// "id:base64 encoded key" of 32 bytes, e.g. generated with
+// "head -c 32 /dev/urandom | base64", empty lines and lines starting with #
+// are ignored. New files are encrypted with the last key, the others are
+// kept to decrypt existing files.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open keyring")
+	}
+	defer f.Close()
+
+	k := &Keyring{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, ":")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, errors.Errorf("keyring line %d must be id:key", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, errors.Errorf("keyring line %d must hold a base64 encoded %d byte key", line, keySize)
+		}
+		k.keys[id] = key
+		k.active = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read keyring")
+	}
+	if k.active == "" {
+		return nil, errors.New("keyring holds no key")
+	}
+	return k, nil
+}
+
+// derive returns a subkey of the key id for purpose.
+func (k *Keyring) derive(id, purpose string) []byte {
+	mac := hmac.New(sha256.New, k.keys[id])
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// IsEncrypted reports whether data starts with the header of a file
+// encrypted by a client.
+func IsEncrypted(data []byte) bool {
+	return len(data) > len(encryptedMagic) && bytes.Equal(data[:len(encryptedMagic)], encryptedMagic) &&
+		data[len(encryptedMagic)] == encryptedVersion
+}
+
+// encryptedHeader starts an encrypted file. It names the key the random data
+// key is wrapped with and is authenticated along with every chunk.
+//
+//	magic | version | key ID length | key ID | wrapped data key | nonce prefix
+type encryptedHeader struct {
+	raw     []byte
+	dataKey []byte
+	prefix  []byte
+}
+
+func (k *Keyring) newHeader() (*encryptedHeader, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "failed to generate data key")
+	}
+	wrapping := newGCM(k.derive(k.active, "argon data key"))
+	nonce := make([]byte, wrapping.NonceSize()+prefixSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	raw := append([]byte{}, encryptedMagic...)
+	raw = append(raw, encryptedVersion, byte(len(k.active)))
+	raw = append(raw, k.active...)
+	raw = wrapping.Seal(append(raw, nonce[:wrapping.NonceSize()]...), nonce[:wrapping.NonceSize()], dataKey,
+		[]byte(k.active))
+	raw = append(raw, nonce[wrapping.NonceSize():]...)
+	return &encryptedHeader{raw: raw, dataKey: dataKey, prefix: nonce[wrapping.NonceSize():]}, nil
+}
+
+// parseHeader decodes the header at the start of data and unwraps the data
+// key, it returns the header and the rest of data.
+func (k *Keyring) parseHeader(data []byte) (*encryptedHeader, []byte, error) {
+	p := len(encryptedMagic) + 1
+	if len(data) < p+1 || len(data) < p+1+int(data[p]) {
+		return nil, nil, errors.New("encrypted file is truncated")
+	}
+	id := string(data[p+1 : p+1+int(data[p])])
+	p += 1 + len(id)
+	if _, ok := k.keys[id]; !ok {
+		return nil, nil, errors.Errorf("file is encrypted with key %s, which is not in the keyring", id)
+	}
+
+	wrapping := newGCM(k.derive(id, "argon data key"))
+	n := wrapping.NonceSize()
+	if len(data) < p+n+keySize+tagSize+prefixSize {
+		return nil, nil, errors.New("encrypted file is truncated")
+	}
+	dataKey, err := wrapping.Open(nil, data[p:p+n], data[p+n:p+n+keySize+tagSize], []byte(id))
+	if err != nil {
+		return nil, nil, errors.Errorf("failed to unwrap data key with key %s", id)
+	}
+	p += n + keySize + tagSize
+	h := &encryptedHeader{raw: data[:p+prefixSize], dataKey: dataKey, prefix: data[p : p+prefixSize]}
+	return h, data[p+prefixSize:], nil
+}
+
+// chunk returns the nonce and additional data of the i-th chunk, the last
+// chunk is marked so truncated files are detected.
+func (h *encryptedHeader) chunk(i uint32, last bool) ([]byte, []byte) {
+	nonce := make([]byte, prefixSize+4)
+	copy(nonce, h.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], i)
+	ad := append(append([]byte{}, h.raw...), 0)
+	if last {
+		ad[len(ad)-1] = 1
+	}
+	return nonce, ad
+}
+
+// encryptReader encrypts the content of r while it is read.
+type encryptReader struct {
+	r      *bufio.Reader
+	header *encryptedHeader
+	aead   cipher.AEAD
+	buf    []byte
+	out    []byte
+	i      uint32
+	done   bool
+}
+
+func (k *Keyring) encryptReader(r io.Reader) (io.Reader, error) {
+	h, err := k.newHeader()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		r:      bufio.NewReaderSize(r, encryptedChunkSize),
+		header: h,
+		aead:   newGCM(h.dataKey),
+		buf:    make([]byte, encryptedChunkSize),
+		out:    append([]byte{}, h.raw...),
+	}, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.r, e.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := err != nil
+		if !last {
+			if _, err := e.r.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		nonce, ad := e.header.chunk(e.i, last)
+		e.out = e.aead.Seal(e.out[:0], nonce, e.buf[:n], ad)
+		e.i++
+		e.done = last
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// decrypt decrypts a file encrypted by a client.
+func (k *Keyring) decrypt(data []byte) ([]byte, error) {
+	h, body, err := k.parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	aead := newGCM(h.dataKey)
+	var result []byte
+	for i := uint32(0); ; i++ {
+		n := encryptedChunkSize + tagSize
+		last := len(body) <= n
+		if last {
+			n = len(body)
+		}
+		nonce, ad := h.chunk(i, last)
+		result, err = aead.Open(result, nonce, body[:n], ad)
+		if err != nil {
+			return nil, errors.Errorf("chunk %d failed authentication", i)
+		}
+		body = body[n:]
+		if last {
+			return result, nil
+		}
+	}
+}
+
+// encryptName deterministically encrypts name with the active key, so the
+// same name always maps to the same stored name. The IV is a MAC of the
+// name, which authenticates it as well.
+func (k *Keyring) encryptName(name string) string {
+	return k.encryptNameWith(k.active, name)
+}
+
+func (k *Keyring) encryptNameWith(id, name string) string {
+	mac := hmac.New(sha256.New, k.derive(id, "argon name mac"))
+	mac.Write([]byte(name))
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	block, err := aes.NewCipher(k.derive(id, "argon name"))
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, aes.BlockSize+len(name))
+	copy(out, iv)
+	cipher.NewCTR(block, iv).XORKeyStream(out[aes.BlockSize:], []byte(name))
+	return encryptedNamePrefix + base64.RawURLEncoding.EncodeToString(out)
+}
+
+// encryptedNames returns the stored names name may have, one per key.
+func (k *Keyring) encryptedNames(name string) []string {
+	names := []string{k.encryptName(name)}
+	for id := range k.keys {
+		if id != k.active {
+			names = append(names, k.encryptNameWith(id, name))
+		}
+	}
+	return names
+}
+
+// decryptName returns the plain name of an encrypted name, ok is false for
+// names which aren't encrypted with any of the keys.
+func (k *Keyring) decryptName(stored string) (string, bool) {
+	if !strings.HasPrefix(stored, encryptedNamePrefix) {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stored, encryptedNamePrefix))
+	if err != nil || len(raw) < aes.BlockSize {
+		return "", false
+	}
+	for id := range k.keys {
+		block, err := aes.NewCipher(k.derive(id, "argon name"))
+		if err != nil {
+			continue
+		}
+		name := make([]byte, len(raw)-aes.BlockSize)
+		cipher.NewCTR(block, raw[:aes.BlockSize]).XORKeyStream(name, raw[aes.BlockSize:])
+		if k.encryptNameWith(id, string(name)) == stored {
+			return string(name), true
+		}
+	}
+	return "", false
+}
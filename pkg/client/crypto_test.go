@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, k *Keyring, data []byte) []byte {
+	t.Helper()
+	r, err := k.encryptReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k := &Keyring{keys: map[string][]byte{"first": randomKey(t)}, active: "first"}
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3 * encryptedChunkSize} {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		encrypted := encrypt(t, k, data)
+		if !IsEncrypted(encrypted) {
+			t.Fatalf("%d bytes: encrypted file lacks the header", size)
+		}
+		got, err := k.decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("failed to decrypt %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("decrypting %d bytes returned different content", size)
+		}
+	}
+}
+
+func TestDecryptDetectsTruncation(t *testing.T) {
+	k := &Keyring{keys: map[string][]byte{"first": randomKey(t)}, active: "first"}
+	data := make([]byte, 2*encryptedChunkSize+10)
+	encrypted := encrypt(t, k, data)
+	header := len(encrypted) - (2*(encryptedChunkSize+tagSize) + 10 + tagSize)
+
+	// dropping whole chunks leaves a chunk not marked as the last one
+	for _, chunks := range []int{1, 2} {
+		if _, err := k.decrypt(encrypted[:header+chunks*(encryptedChunkSize+tagSize)]); err == nil {
+			t.Fatalf("decrypted a file truncated to %d chunks", chunks)
+		}
+	}
+	if _, err := k.decrypt(encrypted[:len(encrypted)-1]); err == nil {
+		t.Fatal("decrypted a file truncated by a byte")
+	}
+}
+
+func TestNamesAcrossKeyRotation(t *testing.T) {
+	first, second := randomKey(t), randomKey(t)
+	old := &Keyring{keys: map[string][]byte{"first": first}, active: "first"}
+	rotated := &Keyring{keys: map[string][]byte{"first": first, "second": second}, active: "second"}
+
+	stored := old.encryptName("reports/2024.csv")
+	if stored != old.encryptName("reports/2024.csv") {
+		t.Fatal("name encryption isn't deterministic")
+	}
+	if rotated.encryptName("reports/2024.csv") == stored {
+		t.Fatal("the new active key encrypts names like the old one")
+	}
+
+	names := rotated.encryptedNames("reports/2024.csv")
+	if len(names) != 2 || names[0] != rotated.encryptName("reports/2024.csv") || names[1] != stored {
+		t.Fatalf("got stored names %v", names)
+	}
+	for _, name := range names {
+		if got, ok := rotated.decryptName(name); !ok || got != "reports/2024.csv" {
+			t.Fatalf("decrypted %s to %q, %v", name, got, ok)
+		}
+	}
+
+	retired := &Keyring{keys: map[string][]byte{"second": second}, active: "second"}
+	if _, ok := retired.decryptName(stored); ok {
+		t.Fatal("decrypted a name encrypted with a removed key")
+	}
+	if _, ok := retired.decryptName("reports/2024.csv"); ok {
+		t.Fatal("decrypted a plain name")
+	}
+}
@@ -8,6 +8,14 @@ type Option func(*Options)
 
 type Options struct {
 	TLSConfig *tls.Config
+
+	// placement of a sharded client
+	VirtualNodes      int
+	ReplicationFactor int
+
+	// end-to-end encryption
+	Keyring      *Keyring
+	EncryptNames bool
 }
 
 // Apply calls each option on o in turn
@@ -22,3 +30,36 @@ func WithTLSConfig(config *tls.Config) Option {
 		o.TLSConfig = config
 	}
 }
+
+// WithVirtualNodes sets the number of points every target of a sharded client
+// has on the hash ring. All clients of a set of targets have to use the same
+// number, otherwise they place files differently.
+func WithVirtualNodes(n int) Option {
+	return func(o *Options) {
+		o.VirtualNodes = n
+	}
+}
+
+// WithReplicationFactor stores every file of a sharded client on n targets.
+func WithReplicationFactor(n int) Option {
+	return func(o *Options) {
+		o.ReplicationFactor = n
+	}
+}
+
+// WithKeyring encrypts the content of written files with keyring before it
+// leaves the client and decrypts encrypted files on read, so the server
+// never sees the plain content.
+func WithKeyring(keyring *Keyring) Option {
+	return func(o *Options) {
+		o.Keyring = keyring
+	}
+}
+
+// WithNameEncryption encrypts the names of files as well, it requires a
+// keyring.
+func WithNameEncryption() Option {
+	return func(o *Options) {
+		o.EncryptNames = true
+	}
+}
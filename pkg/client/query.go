@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/index"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// Query returns a page of the files matching q from the metadata index of the
+// server and the token of the next page, which is empty on the last page.
+// Name patterns match the stored names, so they don't match encrypted names.
+func (c *Client) Query(ctx context.Context, q *index.Query) ([]*storage.FileInfo, string, error) {
+	req, err := queryToAPI(q)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.storageClient.Query(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+	files := make([]*storage.FileInfo, 0, len(resp.Files))
+	for _, fi := range resp.Files {
+		name := fi.Name
+		if c.options.EncryptNames && c.options.Keyring != nil {
+			if decrypted, ok := c.options.Keyring.decryptName(name); ok {
+				name = decrypted
+			}
+		}
+		files = append(files, &storage.FileInfo{
+			Name:    name,
+			Size:    fi.Size,
+			Mode:    fi.Mode,
+			ModTime: fi.ModTime.AsTime(),
+			Dir:     fi.Dir,
+
+			StoredSize:  fi.StoredSize,
+			Compression: fi.Compression,
+			Metadata:    fi.Metadata,
+			Tier:        fi.Tier,
+		})
+	}
+	return files, resp.NextPageToken, nil
+}
+
+func queryToAPI(q *index.Query) (*api.QueryRequest, error) {
+	req := &api.QueryRequest{
+		NamePattern: q.NamePattern,
+		MinSize:     q.MinSize,
+		MaxSize:     q.MaxSize,
+		Descending:  q.Descending,
+		PageSize:    int32(q.PageSize),
+		PageToken:   q.PageToken,
+	}
+	if !q.ModifiedAfter.IsZero() {
+		req.ModifiedAfter = timestamppb.New(q.ModifiedAfter)
+	}
+	if !q.ModifiedBefore.IsZero() {
+		req.ModifiedBefore = timestamppb.New(q.ModifiedBefore)
+	}
+	switch q.Sort {
+	case "", index.SortName:
+		req.Sort = api.QuerySort_QUERY_SORT_NAME
+	case index.SortSize:
+		req.Sort = api.QuerySort_QUERY_SORT_SIZE
+	case index.SortModTime:
+		req.Sort = api.QuerySort_QUERY_SORT_MOD_TIME
+	default:
+		return nil, errors.Errorf("unknown sort field %q", q.Sort)
+	}
+	for _, tag := range q.Tags {
+		predicate := &api.TagPredicate{Key: tag.Key, Value: tag.Value}
+		switch tag.Op {
+		case index.TagEqual:
+			predicate.Op = api.TagOperator_TAG_OPERATOR_EQUAL
+		case index.TagNotEqual:
+			predicate.Op = api.TagOperator_TAG_OPERATOR_NOT_EQUAL
+		case index.TagExists:
+			predicate.Op = api.TagOperator_TAG_OPERATOR_EXISTS
+		case index.TagNotExists:
+			predicate.Op = api.TagOperator_TAG_OPERATOR_NOT_EXISTS
+		default:
+			return nil, errors.Errorf("unknown tag operator %q", tag.Op)
+		}
+		req.Tags = append(req.Tags, predicate)
+	}
+	return req, nil
+}
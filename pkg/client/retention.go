@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// Retention returns the retention and legal hold of the remote file name.
+func (c *Client) Retention(ctx context.Context, name string) (storage.Retention, error) {
+	fi, err := c.Stat(ctx, name)
+	if err != nil {
+		return storage.Retention{}, err
+	}
+	return storage.RetentionOf(fi.Metadata), nil
+}
+
+// SetRetention locks name until t in mode, governance or compliance. An empty
+// mode removes a governance lock.
+func (c *Client) SetRetention(ctx context.Context, name, mode string, t time.Time) error {
+	req := &api.SetRetentionRequest{}
+	switch mode {
+	case "":
+	case storage.RetentionGovernance:
+		req.Mode = api.RetentionMode_RETENTION_MODE_GOVERNANCE
+	case storage.RetentionCompliance:
+		req.Mode = api.RetentionMode_RETENTION_MODE_COMPLIANCE
+	default:
+		return errors.Errorf("unknown retention mode %q", mode)
+	}
+	if mode != "" {
+		req.RetainUntil = timestamppb.New(t)
+	}
+	return c.each(name, func(stored string) error {
+		req.Name = stored
+		_, err := c.adminClient.SetRetention(ctx, req)
+		return err
+	})
+}
+
+// SetLegalHold places name under legal hold or releases it.
+func (c *Client) SetLegalHold(ctx context.Context, name string, hold bool) error {
+	return c.each(name, func(stored string) error {
+		_, err := c.adminClient.SetLegalHold(ctx, &api.SetLegalHoldRequest{Name: stored, Hold: hold})
+		return err
+	})
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points of a target on the hash ring.
+const DefaultVirtualNodes = 128
+
+// NewRing places every node at vnodes points of a consistent hash ring.
+// Adding or removing a node only moves the names of its neighbouring ranges.
+func NewRing(nodes []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{
+		nodes: make(map[string]struct{}, len(nodes)),
+	}
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, point{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+type Ring struct {
+	nodes  map[string]struct{}
+	points []point
+}
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// Nodes returns the n distinct nodes responsible for name, the first one is
+// its primary owner. Fewer nodes are returned if the ring has less than n.
+func (r *Ring) Nodes(name string, n int) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+
+	h := hash(name)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for len(nodes) < n {
+		p := r.points[i%len(r.points)]
+		if _, ok := seen[p.node]; !ok {
+			seen[p.node] = struct{}{}
+			nodes = append(nodes, p.node)
+		}
+		i++
+	}
+	return nodes
+}
+
+// Has reports whether node is part of the ring.
+func (r *Ring) Has(node string) bool {
+	_, ok := r.nodes[node]
+	return ok
+}
+
+func hash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,460 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/tracing"
+)
+
+// NewSharded returns a client which spreads the files across the independent
+// servers at targets. Every name is placed on the servers following its hash
+// on a consistent hash ring, so adding or removing a server only moves a
+// fraction of the files, see Rebalance.
+func NewSharded(targets []string, options ...Option) *ShardedClient {
+	var opts Options
+	opts.Apply(options...)
+	if opts.ReplicationFactor <= 0 {
+		opts.ReplicationFactor = 1
+	}
+
+	return &ShardedClient{
+		options: opts,
+		targets: targets,
+		ring:    NewRing(targets, opts.VirtualNodes),
+		clients: make(map[string]*Client),
+	}
+}
+
+type ShardedClient struct {
+	options Options
+	targets []string
+	ring    *Ring
+	clients map[string]*Client
+}
+
+// Move is a change of the placement of a file made by Rebalance.
+type Move struct {
+	Name    string
+	Source  string
+	Added   []string
+	Removed []string
+}
+
+func (c *ShardedClient) DialContext(ctx context.Context) error {
+	if len(c.targets) == 0 {
+		return errors.New("no targets to shard across")
+	}
+	if c.options.ReplicationFactor > len(c.ring.nodes) {
+		return errors.Errorf("replication factor %d exceeds the number of targets %d",
+			c.options.ReplicationFactor, len(c.ring.nodes))
+	}
+	for _, target := range c.targets {
+		if _, err := c.client(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// client returns the client of target, dialing it on first use.
+func (c *ShardedClient) client(ctx context.Context, target string) (*Client, error) {
+	if cl, ok := c.clients[target]; ok {
+		return cl, nil
+	}
+	cl := New(WithTLSConfig(c.options.TLSConfig))
+	if err := cl.DialContext(ctx, target); err != nil {
+		return nil, errors.Wrapf(err, "failed to dial %s", target)
+	}
+	c.clients[target] = cl
+	return cl, nil
+}
+
+// owners returns the targets storing name, the primary owner first.
+func (c *ShardedClient) owners(name string) []string {
+	return c.ring.Nodes(name, c.options.ReplicationFactor)
+}
+
+// Read fetches the remote file name from the first of its owners which has
+// it and stores it at dst.
+func (c *ShardedClient) Read(ctx context.Context, name, dst string) (err error) {
+	ctx, span := tracing.Start(ctx, "client.ShardedRead", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	data, _, err := c.read(ctx, name, c.owners(name))
+	if err != nil {
+		return err
+	}
+	_, err = save(dst, data)
+	return err
+}
+
+// read returns the content of name from the first of targets which has it.
+func (c *ShardedClient) read(ctx context.Context, name string, targets []string) ([]byte, string, error) {
+	var lastErr error
+	for _, target := range targets {
+		data, err := c.clients[target].read(ctx, name)
+		if err == nil {
+			return data, target, nil
+		}
+		log.WithField("target", target).Debugf("Failed to read %s (%s)", name, err)
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+// Write stores the local file name on all owners of its base name. A failed
+// write may leave copies on some of the owners, which Rebalance completes.
+func (c *ShardedClient) Write(ctx context.Context, name string, opts WriteOptions) (err error) {
+	ctx, span := tracing.Start(ctx, "client.ShardedWrite", tracing.WithAttributes(tracing.String("argon.name", name)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read file")
+	}
+	base := filepath.Base(name)
+	for _, target := range c.owners(base) {
+		if err := c.clients[target].write(ctx, base, bytes.NewReader(data), opts); err != nil {
+			return errors.Wrapf(err, "failed to write to %s", target)
+		}
+	}
+	return nil
+}
+
+// List merges the files of all targets.
+func (c *ShardedClient) List(ctx context.Context) ([]string, error) {
+	locations, err := c.locate(ctx, c.targets)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// locate lists targets and returns the targets every file is stored on.
+func (c *ShardedClient) locate(ctx context.Context, targets []string) (map[string][]string, error) {
+	locations := make(map[string][]string)
+	for _, target := range targets {
+		files, err := c.clients[target].List(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s", target)
+		}
+		for _, name := range files {
+			locations[name] = append(locations[name], target)
+		}
+	}
+	return locations, nil
+}
+
+// Stat returns the file info of name from the first of its owners which has
+// it.
+func (c *ShardedClient) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	var lastErr error
+	for _, target := range c.owners(name) {
+		fileInfo, err := c.clients[target].Stat(ctx, name)
+		if err == nil {
+			return fileInfo, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Remove removes name from all of its owners. It succeeds if at least one
+// owner had the file.
+func (c *ShardedClient) Remove(ctx context.Context, name string) error {
+	var removed bool
+	var lastErr error
+	for _, target := range c.owners(name) {
+		if err := c.clients[target].Remove(ctx, name); err != nil {
+			lastErr = err
+			continue
+		}
+		removed = true
+	}
+	if removed {
+		return nil
+	}
+	return lastErr
+}
+
+// SetExpiry sets the expiry of name on all of its owners. It succeeds if at
+// least one owner had the file.
+func (c *ShardedClient) SetExpiry(ctx context.Context, name string, ttl time.Duration, t time.Time) error {
+	var updated bool
+	var lastErr error
+	for _, target := range c.owners(name) {
+		if err := c.clients[target].SetExpiry(ctx, name, ttl, t); err != nil {
+			lastErr = err
+			continue
+		}
+		updated = true
+	}
+	if updated {
+		return nil
+	}
+	return lastErr
+}
+
+// SetMetadata replaces the metadata of name on all of its owners. It succeeds
+// if at least one owner had the file.
+func (c *ShardedClient) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	var updated bool
+	var lastErr error
+	for _, target := range c.owners(name) {
+		if err := c.clients[target].SetMetadata(ctx, name, metadata); err != nil {
+			lastErr = err
+			continue
+		}
+		updated = true
+	}
+	if updated {
+		return nil
+	}
+	return lastErr
+}
+
+// Rename renames old to new. Owners of both names rename the file, the
+// content is moved from the owners of old to the other owners of new.
+func (c *ShardedClient) Rename(ctx context.Context, old, new string) error {
+	oldOwners, newOwners := c.owners(old), c.owners(new)
+
+	var data []byte
+	var metadata map[string]string
+	for _, target := range newOwners {
+		if contains(oldOwners, target) {
+			if err := c.clients[target].Rename(ctx, old, new); err != nil {
+				return errors.Wrapf(err, "failed to rename on %s", target)
+			}
+			continue
+		}
+		if data == nil {
+			var source string
+			var err error
+			data, source, err = c.read(ctx, old, oldOwners)
+			if err != nil {
+				return err
+			}
+			if metadata, err = c.clients[source].metadata(ctx, old); err != nil {
+				return err
+			}
+			if metadata, err = movedMetadata(old, metadata); err != nil {
+				return err
+			}
+		}
+		if err := c.clients[target].write(ctx, new, bytes.NewReader(data), WriteOptions{Metadata: metadata}); err != nil {
+			return errors.Wrapf(err, "failed to write to %s", target)
+		}
+	}
+	for _, target := range oldOwners {
+		if contains(newOwners, target) {
+			continue
+		}
+		if err := c.clients[target].Remove(ctx, old); err != nil {
+			return errors.Wrapf(err, "failed to remove from %s", target)
+		}
+	}
+	return nil
+}
+
+// Append appends the content of r to name on all its owners and returns the
+// new size reported by the first one.
+func (c *ShardedClient) Append(ctx context.Context, name string, r io.Reader, expectedOffset int64) (int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read data")
+	}
+	var size int64
+	for i, target := range c.owners(name) {
+		n, err := c.clients[target].Append(ctx, name, bytes.NewReader(data), expectedOffset)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to append on %s", target)
+		}
+		if i == 0 {
+			size = n
+		}
+	}
+	return size, nil
+}
+
+// Copy copies src to dst on the owners of dst. Owners of both names copy the
+// file themselves, it is read from an owner of src and written to the others.
+// The retention of src isn't copied.
+func (c *ShardedClient) Copy(ctx context.Context, src, dst string) error {
+	srcOwners := c.owners(src)
+
+	var data []byte
+	var metadata map[string]string
+	for _, target := range c.owners(dst) {
+		if contains(srcOwners, target) {
+			if err := c.clients[target].Copy(ctx, src, dst); err != nil {
+				return errors.Wrapf(err, "failed to copy on %s", target)
+			}
+			continue
+		}
+		if data == nil {
+			var source string
+			var err error
+			data, source, err = c.read(ctx, src, srcOwners)
+			if err != nil {
+				return err
+			}
+			if metadata, err = c.clients[source].metadata(ctx, src); err != nil {
+				return err
+			}
+			metadata = writableMetadata(metadata)
+		}
+		if err := c.clients[target].write(ctx, dst, bytes.NewReader(data), WriteOptions{Metadata: metadata}); err != nil {
+			return errors.Wrapf(err, "failed to write to %s", target)
+		}
+	}
+	return nil
+}
+
+// Rebalance moves every file of the targets and of the drained servers,
+// which are about to be removed, to its owners. Files are copied to owners
+// missing them before they are removed from servers not owning them anymore,
+// so an interrupted rebalance doesn't lose files. A dry run only returns the
+// moves.
+func (c *ShardedClient) Rebalance(ctx context.Context, drain []string, dryRun bool) ([]Move, error) {
+	nodes := append([]string{}, c.targets...)
+	for _, target := range drain {
+		if c.ring.Has(target) {
+			return nil, errors.Errorf("drained server %s is one of the targets", target)
+		}
+		if _, err := c.client(ctx, target); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, target)
+	}
+
+	locations, err := c.locate(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var moves []Move
+	var failed int
+	for _, name := range names {
+		have, owners := locations[name], c.owners(name)
+		move := Move{Name: name}
+		for _, target := range owners {
+			if !contains(have, target) {
+				move.Added = append(move.Added, target)
+			}
+		}
+		for _, target := range have {
+			if !contains(owners, target) {
+				move.Removed = append(move.Removed, target)
+			}
+		}
+		if len(move.Added) == 0 && len(move.Removed) == 0 {
+			continue
+		}
+		if dryRun {
+			move.Source = have[0]
+			moves = append(moves, move)
+			continue
+		}
+
+		scopedLog := log.WithField("name", name)
+		if err := c.move(ctx, &move, have); err != nil {
+			scopedLog.Errorf("Failed to move file (%s)", err)
+			failed++
+			continue
+		}
+		scopedLog.WithField("added", move.Added).WithField("removed", move.Removed).Debug("Moved file")
+		moves = append(moves, move)
+	}
+	if failed > 0 {
+		return moves, errors.Errorf("failed to move %d of %d files", failed, failed+len(moves))
+	}
+	return moves, nil
+}
+
+// move copies the file from one of the servers having it to the added owners
+// and removes it from the servers which aren't owners anymore.
+func (c *ShardedClient) move(ctx context.Context, move *Move, have []string) error {
+	if len(move.Added) > 0 {
+		data, source, err := c.read(ctx, move.Name, have)
+		if err != nil {
+			return err
+		}
+		move.Source = source
+		metadata, err := c.clients[source].metadata(ctx, move.Name)
+		if err != nil {
+			return err
+		}
+		if metadata, err = movedMetadata(move.Name, metadata); err != nil {
+			return err
+		}
+		for _, target := range move.Added {
+			if err := c.clients[target].write(ctx, move.Name, bytes.NewReader(data), WriteOptions{Metadata: metadata}); err != nil {
+				return errors.Wrapf(err, "failed to write to %s", target)
+			}
+		}
+	}
+	for _, target := range move.Removed {
+		if err := c.clients[target].Remove(ctx, move.Name); err != nil {
+			return errors.Wrapf(err, "failed to remove from %s", target)
+		}
+	}
+	return nil
+}
+
+// writableMetadata returns metadata without the keys argon maintains itself,
+// which can't be written, except the expiry.
+func writableMetadata(metadata map[string]string) map[string]string {
+	writable := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if strings.HasPrefix(key, storage.ReservedMetadataPrefix) && key != storage.MetadataExpires {
+			continue
+		}
+		writable[key] = value
+	}
+	return writable
+}
+
+// movedMetadata returns the metadata of name to write to the servers it is
+// moved to. A locked file can't be removed from the servers it is moved away
+// from, so it isn't moved at all.
+func movedMetadata(name string, metadata map[string]string) (map[string]string, error) {
+	if retention := storage.RetentionOf(metadata); retention.Locked(time.Now()) {
+		return nil, errors.Wrap(&storage.LockedError{Name: name, Retention: retention}, "locked files can't be moved")
+	}
+	return writableMetadata(metadata), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+func TestMovedMetadata(t *testing.T) {
+	metadata := map[string]string{
+		"owner":                 "reports",
+		storage.MetadataExpires: "2030-01-01T00:00:00Z",
+	}
+	past := storage.Retention{Mode: storage.RetentionGovernance, Until: time.Now().Add(-time.Hour)}
+	moved, err := movedMetadata("report", past.Apply(metadata))
+	if err != nil {
+		t.Fatalf("a file with a passed retention isn't movable: %v", err)
+	}
+	if !reflect.DeepEqual(moved, metadata) {
+		t.Fatalf("got metadata %v, want %v", moved, metadata)
+	}
+	if err := storage.ValidateMetadata(moved); err != nil {
+		t.Fatalf("moved metadata can't be written: %v", err)
+	}
+
+	for _, retention := range []storage.Retention{
+		{Mode: storage.RetentionCompliance, Until: time.Now().Add(time.Hour)},
+		{LegalHold: true},
+	} {
+		_, err := movedMetadata("report", retention.Apply(metadata))
+		if !errors.Is(err, &storage.LockedError{}) {
+			t.Fatalf("moving a file locked by %+v: got %v, want a LockedError", retention, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/merkle"
+)
+
+const (
+	SyncCopy   = "copy"
+	SyncUpdate = "update"
+	SyncRemove = "remove"
+)
+
+// SyncOptions configure the direction of a sync. By default files missing
+// or differing on the destination are copied from the source. TwoWay copies
+// files missing on the source from the destination as well, differing files
+// are still resolved in favour of the source. Delete removes files from the
+// destination which the source doesn't have, it can't be combined with
+// TwoWay.
+type SyncOptions struct {
+	TwoWay bool
+	Delete bool
+	DryRun bool
+}
+
+// SyncAction is a repair made by Sync.
+type SyncAction struct {
+	Op     string
+	Name   string
+	Source string
+	Target string
+}
+
+// SyncReport summarizes a sync.
+type SyncReport struct {
+	// Leaves is the number of leaves of the Merkle trees which differed.
+	Leaves  int
+	Actions []SyncAction
+}
+
+// Sync repairs the differences between the servers of from and to, which are
+// found by comparing their Merkle trees. Only the subtrees whose hashes
+// differ are fetched, so the cost depends on the number of differences
+// rather than on the number of files.
+func Sync(ctx context.Context, from, to *Client, fromName, toName string, options SyncOptions) (*SyncReport, error) {
+	if options.TwoWay && options.Delete {
+		return nil, errors.New("a two-way sync can't delete files")
+	}
+
+	s := &syncer{
+		from:     from,
+		to:       to,
+		fromName: fromName,
+		toName:   toName,
+		options:  options,
+		report:   &SyncReport{},
+	}
+	if err := s.walk(ctx, ""); err != nil {
+		return s.report, err
+	}
+	return s.report, nil
+}
+
+type syncer struct {
+	from, to         *Client
+	fromName, toName string
+	options          SyncOptions
+	report           *SyncReport
+}
+
+// walk descends into the subtree at prefix if it differs between the servers.
+func (s *syncer) walk(ctx context.Context, prefix string) error {
+	// rebuild the trees for the root, the subtrees are served from them
+	refresh := prefix == ""
+	a, err := s.from.MerkleTree(ctx, prefix, refresh)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get Merkle tree of %s", s.fromName)
+	}
+	b, err := s.to.MerkleTree(ctx, prefix, refresh)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get Merkle tree of %s", s.toName)
+	}
+	if a.LeafDepth != b.LeafDepth {
+		return errors.Errorf("Merkle trees have different depths (%d and %d)", a.LeafDepth, b.LeafDepth)
+	}
+	if bytes.Equal(a.Hash, b.Hash) {
+		return nil
+	}
+
+	if uint32(len(prefix)) == a.LeafDepth {
+		s.report.Leaves++
+		return s.repair(ctx, a.Entries, b.Entries)
+	}
+	if len(a.Children) != len(b.Children) {
+		return errors.Errorf("Merkle tree nodes at %q have a different number of children", prefix)
+	}
+	for i := range a.Children {
+		if bytes.Equal(a.Children[i], b.Children[i]) {
+			continue
+		}
+		if err := s.walk(ctx, merkle.ChildPrefix(prefix, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repair reconciles the files of a leaf which differs.
+func (s *syncer) repair(ctx context.Context, source, target []*api.MerkleEntry) error {
+	have := make(map[string]*api.MerkleEntry, len(target))
+	for _, entry := range target {
+		have[entry.Name] = entry
+	}
+	want := make(map[string]struct{}, len(source))
+
+	for _, entry := range source {
+		want[entry.Name] = struct{}{}
+		other, ok := have[entry.Name]
+		switch {
+		case !ok:
+			if err := s.apply(ctx, SyncAction{Op: SyncCopy, Name: entry.Name, Source: s.fromName, Target: s.toName}, s.from, s.to); err != nil {
+				return err
+			}
+		case other.Size != entry.Size || other.Checksum != entry.Checksum:
+			if err := s.apply(ctx, SyncAction{Op: SyncUpdate, Name: entry.Name, Source: s.fromName, Target: s.toName}, s.from, s.to); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range target {
+		if _, ok := want[entry.Name]; ok {
+			continue
+		}
+		switch {
+		case s.options.TwoWay:
+			if err := s.apply(ctx, SyncAction{Op: SyncCopy, Name: entry.Name, Source: s.toName, Target: s.fromName}, s.to, s.from); err != nil {
+				return err
+			}
+		case s.options.Delete:
+			if err := s.apply(ctx, SyncAction{Op: SyncRemove, Name: entry.Name, Target: s.toName}, s.from, s.to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apply performs action with the content read from source and written to
+// target.
+func (s *syncer) apply(ctx context.Context, action SyncAction, source, target *Client) error {
+	scopedLog := log.WithField("name", action.Name).WithField("op", action.Op)
+	if s.options.DryRun {
+		s.report.Actions = append(s.report.Actions, action)
+		return nil
+	}
+
+	switch action.Op {
+	case SyncCopy, SyncUpdate:
+		data, err := source.read(ctx, action.Name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s from %s", action.Name, action.Source)
+		}
+		metadata, err := source.metadata(ctx, action.Name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s on %s", action.Name, action.Source)
+		}
+		if action.Op == SyncUpdate {
+			if err := target.Remove(ctx, action.Name); err != nil {
+				return errors.Wrapf(err, "failed to remove %s from %s", action.Name, action.Target)
+			}
+		}
+		if err := target.write(ctx, action.Name, bytes.NewReader(data), WriteOptions{Metadata: metadata}); err != nil {
+			return errors.Wrapf(err, "failed to write %s to %s", action.Name, action.Target)
+		}
+	case SyncRemove:
+		if err := target.Remove(ctx, action.Name); err != nil {
+			return errors.Wrapf(err, "failed to remove %s from %s", action.Name, action.Target)
+		}
+	}
+	scopedLog.Debug("Repaired file")
+	s.report.Actions = append(s.report.Actions, action)
+	return nil
+}
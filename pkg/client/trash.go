@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage/trash"
+)
+
+// ListTrash returns the files in the trash of the server, the most recently
+// deleted first.
+func (c *Client) ListTrash(ctx context.Context) ([]trash.Entry, error) {
+	resp, err := c.storageClient.ListTrash(ctx, &api.ListTrashRequest{})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]trash.Entry, 0, len(resp.Entries))
+	for _, entry := range resp.Entries {
+		entries = append(entries, trash.Entry{
+			ID:      entry.Id,
+			Name:    c.displayName(entry.Name),
+			Deleted: entry.Deleted.AsTime(),
+			Deleter: entry.Deleter,
+			Size:    entry.Size,
+		})
+	}
+	return entries, nil
+}
+
+// RestoreTrash restores the trashed file id to its original name, or to name
+// if it isn't empty, and returns the name it was restored to.
+func (c *Client) RestoreTrash(ctx context.Context, id, name string) (string, error) {
+	req := &api.RestoreTrashRequest{Id: id}
+	if name != "" {
+		req.Name = c.storedNames(name)[0]
+	}
+	resp, err := c.storageClient.RestoreTrash(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return c.displayName(resp.Name), nil
+}
+
+// PurgeTrash removes the trashed files ids permanently and returns their
+// number.
+func (c *Client) PurgeTrash(ctx context.Context, ids ...string) (int64, error) {
+	resp, err := c.storageClient.PurgeTrash(ctx, &api.PurgeTrashRequest{Ids: ids})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Purged, nil
+}
+
+// PurgeAllTrash empties the trash and returns the number of purged files.
+func (c *Client) PurgeAllTrash(ctx context.Context) (int64, error) {
+	resp, err := c.storageClient.PurgeTrash(ctx, &api.PurgeTrashRequest{All: true})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Purged, nil
+}
+
+// displayName decrypts the stored name if names are encrypted.
+func (c *Client) displayName(stored string) string {
+	if c.options.EncryptNames && c.options.Keyring != nil {
+		if name, ok := c.options.Keyring.decryptName(stored); ok {
+			return name
+		}
+	}
+	return stored
+}
@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// blobStore stages file bodies until the write referencing them is
+// committed.
+type blobStore struct {
+	dir string
+}
+
+func newBlobStore(dir string) (*blobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob directory")
+	}
+	return &blobStore{dir: dir}, nil
+}
+
+func newBlobID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func (b *blobStore) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id {
+		return "", errors.Errorf("invalid blob id %q", id)
+	}
+	return filepath.Join(b.dir, id), nil
+}
+
+func (b *blobStore) write(id string, data []byte) error {
+	path, err := b.path(id)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (b *blobStore) read(id string) ([]byte, error) {
+	path, err := b.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (b *blobStore) remove(id string) {
+	if path, err := b.path(id); err == nil {
+		os.Remove(path)
+	}
+}
+
+// cleanup removes blobs of writes which were never committed.
+func (b *blobStore) cleanup(maxAge time.Duration) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		if time.Since(fi.ModTime()) > maxAge {
+			os.Remove(filepath.Join(b.dir, fi.Name()))
+		}
+	}
+}
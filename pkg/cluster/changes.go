@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"sync"
+)
+
+// WithChangeHandler calls handler with the name of every file changed by a
+// committed mutation, on every member, whichever member the mutation was made
+// through. It allows following the namespace, e.g. to keep an index of the
+// files. The handler is called from a single goroutine in the order the
+// changes were applied, but after them and without blocking the Raft log, so
+// it should look up the current state of the file instead of assuming one.
+func WithChangeHandler(handler func(name string)) Option {
+	return func(c *Cluster) {
+		c.fsm.changes = &changes{handler: handler, notifyc: make(chan struct{}, 1)}
+	}
+}
+
+// changes queues the names of changed files for the change handler.
+type changes struct {
+	handler func(name string)
+	notifyc chan struct{}
+
+	mu    sync.Mutex
+	names []string
+}
+
+// add queues names, it is a no-op without a change handler.
+func (c *changes) add(names ...string) {
+	if c == nil || len(names) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.names = append(c.names, names...)
+	c.mu.Unlock()
+
+	select {
+	case c.notifyc <- struct{}{}:
+	default:
+	}
+}
+
+func (c *changes) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := c.names
+	c.names = nil
+	return names
+}
+
+// dispatchChanges calls the change handler with the queued names until the
+// member is stopped.
+func (c *Cluster) dispatchChanges() {
+	defer c.wg.Done()
+
+	changes := c.fsm.changes
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-changes.notifyc:
+		}
+		for _, name := range changes.take() {
+			changes.handler(name)
+		}
+	}
+}
@@ -0,0 +1,568 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	applyTimeout    = 10 * time.Second
+	blobTimeout     = time.Minute
+	repairInterval  = 5 * time.Second
+	maxBlobAge      = 24 * time.Hour
+	retainSnapshots = 2
+)
+
+// ErrNoLeader is returned while the cluster has no leader, e.g. during an
+// election.
+var ErrNoLeader = errors.Wrap(storage.ErrUnavailable, "cluster has no leader")
+
+// NotLeaderError is returned for requests only the leader can handle.
+type NotLeaderError struct {
+	LeaderID   string
+	LeaderAddr string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return "node is not the leader, the cluster has no leader"
+	}
+	return fmt.Sprintf("node is not the leader, the leader is %s (%s)", e.LeaderID, e.LeaderAddr)
+}
+
+type Option func(*Cluster)
+
+// WithTLS carries the Raft traffic over TLS. The server config is used for
+// incoming connections, the client config to dial other members.
+func WithTLS(serverConfig, clientConfig *tls.Config) Option {
+	return func(c *Cluster) {
+		c.serverTLSConfig = serverConfig
+		c.clientTLSConfig = clientConfig
+	}
+}
+
+// WithBootstrap bootstraps a new cluster consisting of this node if it has no
+// Raft state yet. Only the first node of a cluster is bootstrapped, the
+// other nodes are added with AddMember.
+func WithBootstrap(bootstrap bool) Option {
+	return func(c *Cluster) {
+		c.bootstrap = bootstrap
+	}
+}
+
+// New creates the cluster member id. Raft traffic is served on raftAddr,
+// apiAddr is the address of the gRPC API of this node, which other members
+// forward requests and send file bodies to. The Raft state is kept in dir,
+// the files in store.
+//
+// Namespace mutations are ordered by the Raft log. The body of a write is
+// staged on a quorum of the voters before the write is committed, so every
+// committed file survives the loss of a minority of the members.
+func New(id, raftAddr, apiAddr, dir string, store storage.Storage, options ...Option) (*Cluster, error) {
+	if id == "" {
+		return nil, errors.New("missing node id")
+	}
+	if raftAddr == "" || apiAddr == "" {
+		return nil, errors.New("missing raft or api address")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create cluster directory")
+	}
+
+	blobs, err := newBlobStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return nil, err
+	}
+	fsm, err := newFSM(store, blobs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Cluster{
+		id:       id,
+		raftAddr: raftAddr,
+		apiAddr:  apiAddr,
+		dir:      dir,
+		store:    store,
+		blobs:    blobs,
+		fsm:      fsm,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.peers = newPeers(c.clientTLSConfig)
+	return c, nil
+}
+
+// Cluster is a member of a Raft cluster. It implements storage.Storage:
+// mutations, Stat and List are handled by the leader, followers forward
+// them. Reads are served from the local files.
+type Cluster struct {
+	id              string
+	raftAddr        string
+	apiAddr         string
+	dir             string
+	bootstrap       bool
+	serverTLSConfig *tls.Config
+	clientTLSConfig *tls.Config
+
+	store storage.Storage
+	blobs *blobStore
+	fsm   *fsm
+	peers *peers
+
+	raft      *raft.Raft
+	boltStore *raftboltdb.BoltStore
+	transport *raft.NetworkTransport
+
+	// ready is set once a new leader has applied all previous entries
+	ready int32
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start joins the Raft cluster.
+func (c *Cluster) Start() error {
+	advertise, err := net.ResolveTCPAddr("tcp", c.raftAddr)
+	if err != nil {
+		return errors.Wrap(err, "invalid raft address")
+	}
+
+	logWriter := log.WithField("component", "raft").WriterLevel(logrus.InfoLevel)
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:        "raft",
+		Level:       hclog.Info,
+		Output:      logWriter,
+		DisableTime: true,
+	})
+
+	if c.serverTLSConfig != nil {
+		layer, err := newTLSStreamLayer(c.raftAddr, advertise, c.serverTLSConfig, c.clientTLSConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for raft traffic")
+		}
+		c.transport = raft.NewNetworkTransport(layer, 3, 10*time.Second, logWriter)
+	} else {
+		c.transport, err = raft.NewTCPTransport(c.raftAddr, advertise, 3, 10*time.Second, logWriter)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for raft traffic")
+		}
+	}
+
+	c.boltStore, err = raftboltdb.NewBoltStore(filepath.Join(c.dir, "raft.db"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open raft log")
+	}
+	snapshots, err := raft.NewFileSnapshotStore(c.dir, retainSnapshots, logWriter)
+	if err != nil {
+		return errors.Wrap(err, "failed to open snapshot store")
+	}
+
+	notifyc := make(chan bool, 8)
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(c.id)
+	config.Logger = logger
+	config.NotifyCh = notifyc
+
+	c.raft, err = raft.NewRaft(config, c.fsm, c.boltStore, c.boltStore, snapshots, c.transport)
+	if err != nil {
+		return errors.Wrap(err, "failed to start raft")
+	}
+
+	if c.bootstrap {
+		exists, err := raft.HasExistingState(c.boltStore, c.boltStore, snapshots)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.WithField("id", c.id).Info("Bootstrapping a new cluster")
+			configuration := raft.Configuration{
+				Servers: []raft.Server{{
+					ID:      config.LocalID,
+					Address: c.transport.LocalAddr(),
+				}},
+			}
+			if err := c.raft.BootstrapCluster(configuration).Error(); err != nil {
+				return errors.Wrap(err, "failed to bootstrap cluster")
+			}
+		}
+	}
+
+	c.blobs.cleanup(maxBlobAge)
+
+	c.wg.Add(2)
+	go c.observeLeadership(notifyc)
+	go c.repairLoop()
+	if c.fsm.changes != nil {
+		c.wg.Add(1)
+		go c.dispatchChanges()
+	}
+	return nil
+}
+
+// Stop leaves the Raft cluster, the membership is unchanged.
+func (c *Cluster) Stop() error {
+	c.cancel()
+	c.wg.Wait()
+
+	var err error
+	if c.raft != nil {
+		err = c.raft.Shutdown().Error()
+	}
+	if c.transport != nil {
+		c.transport.Close()
+	}
+	if c.boltStore != nil {
+		c.boltStore.Close()
+	}
+	c.peers.close()
+	return err
+}
+
+func (c *Cluster) observeLeadership(notifyc <-chan bool) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case leader := <-notifyc:
+			atomic.StoreInt32(&c.ready, 0)
+			if !leader {
+				isLeader.Set(0)
+				log.Info("Lost cluster leadership")
+				continue
+			}
+			isLeader.Set(1)
+			log.Info("Gained cluster leadership")
+
+			// apply all entries of previous terms before serving reads
+			if err := c.raft.Barrier(applyTimeout).Error(); err != nil {
+				log.Errorf("Failed to apply the log after gaining leadership (%s)", err)
+				continue
+			}
+			atomic.StoreInt32(&c.ready, 1)
+
+			if c.fsm.nodes()[c.id] != c.apiAddr {
+				if err := c.apply(&command{Op: opSetNode, NodeID: c.id, APIAddr: c.apiAddr}); err != nil {
+					log.Errorf("Failed to register the api address (%s)", err)
+				}
+			}
+		}
+	}
+}
+
+// leader returns the ID and API address of the current leader.
+func (c *Cluster) leader() (string, string) {
+	_, id := c.raft.LeaderWithID()
+	if id == "" {
+		return "", ""
+	}
+	return string(id), c.fsm.nodes()[string(id)]
+}
+
+func (c *Cluster) isLeader() bool {
+	return c.raft.State() == raft.Leader
+}
+
+// IsLeader reports whether this member is the leader.
+func (c *Cluster) IsLeader() bool {
+	return c.isLeader()
+}
+
+// leaderAddr returns the API address requests are forwarded to.
+func (c *Cluster) leaderAddr() (string, error) {
+	id, addr := c.leader()
+	if id == "" {
+		return "", ErrNoLeader
+	}
+	if addr == "" {
+		return "", errors.Wrapf(storage.ErrUnavailable, "api address of leader %s is unknown", id)
+	}
+	return addr, nil
+}
+
+func (c *Cluster) notLeader() error {
+	id, addr := c.leader()
+	return &NotLeaderError{LeaderID: id, LeaderAddr: addr}
+}
+
+// linearize waits until the leader has applied every entry committed before
+// the call, so a following read of the state is linearizable.
+func (c *Cluster) linearize(ctx context.Context) error {
+	if atomic.LoadInt32(&c.ready) == 0 {
+		return ErrNoLeader
+	}
+	index := c.raft.CommitIndex()
+	if err := c.raft.VerifyLeader().Error(); err != nil {
+		return errors.Wrap(storage.ErrUnavailable, err.Error())
+	}
+	for c.raft.AppliedIndex() < index {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) apply(cmd *command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	future := c.raft.Apply(b, applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return errors.Wrap(storage.ErrUnavailable, err.Error())
+		}
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// replicateBlob stages data on the other voters and returns once a quorum,
+// including this node, has stored it. The remaining transfers continue in
+// the background.
+func (c *Cluster) replicateBlob(id string, data []byte) error {
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	nodes := c.fsm.nodes()
+
+	voters := 0
+	var targets []string
+	for _, server := range future.Configuration().Servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+		if string(server.ID) == c.id {
+			continue
+		}
+		if addr := nodes[string(server.ID)]; addr != "" && server.Suffrage == raft.Voter {
+			targets = append(targets, addr)
+		}
+	}
+	quorum := voters/2 + 1
+
+	acked := 1
+	if acked >= quorum {
+		return nil
+	}
+	results := make(chan error, len(targets))
+	for _, addr := range targets {
+		go func(addr string) {
+			ctx, cancel := context.WithTimeout(context.Background(), blobTimeout)
+			defer cancel()
+			err := c.peers.putBlob(ctx, addr, id, data)
+			if err != nil {
+				log.WithField("peer", addr).Warnf("Failed to stage file body (%s)", err)
+			}
+			results <- err
+		}(addr)
+	}
+	for range targets {
+		if err := <-results; err == nil {
+			acked++
+		}
+		if acked >= quorum {
+			return nil
+		}
+	}
+	return errors.Wrapf(storage.ErrUnavailable, "file body reached %d of %d required members", acked, quorum)
+}
+
+// PutBlob stages the body of a write coordinated by the leader.
+func (c *Cluster) PutBlob(id string, data []byte) error {
+	return c.blobs.write(id, data)
+}
+
+func (c *Cluster) repairLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(repairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.repair()
+	}
+}
+
+// repair fetches missing files from the leader or any other member.
+func (c *Cluster) repair() {
+	missing := c.fsm.missingFiles()
+	if len(missing) == 0 {
+		return
+	}
+
+	leaderID, _ := c.leader()
+	var addrs []string
+	for id, addr := range c.fsm.nodes() {
+		if id == c.id || addr == "" {
+			continue
+		}
+		if id == leaderID {
+			addrs = append([]string{addr}, addrs...)
+		} else {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	for _, name := range missing {
+		scopedLog := log.WithField("name", name)
+		for _, addr := range addrs {
+			ctx, cancel := context.WithTimeout(c.ctx, blobTimeout)
+			data, err := c.peers.read(ctx, addr, name)
+			cancel()
+			if err != nil {
+				continue
+			}
+			if err := c.fsm.repair(name, data); err != nil {
+				scopedLog.WithField("peer", addr).Warnf("Failed to repair file (%s)", err)
+				continue
+			}
+			scopedLog.WithField("peer", addr).Info("Successfully repaired file")
+			break
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// AddMember adds a voter to the cluster, it has to be called on the leader.
+func (c *Cluster) AddMember(id, raftAddr, apiAddr string) error {
+	if !c.isLeader() {
+		return c.notLeader()
+	}
+
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, server := range future.Configuration().Servers {
+		if string(server.ID) == id && string(server.Address) == raftAddr {
+			// already a member, only update the api address
+			return c.apply(&command{Op: opSetNode, NodeID: id, APIAddr: apiAddr})
+		}
+		if string(server.ID) == id || string(server.Address) == raftAddr {
+			if err := c.raft.RemoveServer(server.ID, 0, applyTimeout).Error(); err != nil {
+				return errors.Wrapf(err, "failed to remove stale member %s", server.ID)
+			}
+		}
+	}
+
+	if err := c.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(raftAddr), 0, applyTimeout).Error(); err != nil {
+		return errors.Wrapf(err, "failed to add member %s", id)
+	}
+	if err := c.apply(&command{Op: opSetNode, NodeID: id, APIAddr: apiAddr}); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		"id":        id,
+		"raft_addr": raftAddr,
+		"api_addr":  apiAddr,
+	}).Info("Added cluster member")
+	return nil
+}
+
+// RemoveMember removes a member from the cluster, it has to be called on the
+// leader.
+func (c *Cluster) RemoveMember(id string) error {
+	if !c.isLeader() {
+		return c.notLeader()
+	}
+
+	if err := c.apply(&command{Op: opDeleteNode, NodeID: id}); err != nil {
+		return err
+	}
+	if err := c.raft.RemoveServer(raft.ServerID(id), 0, applyTimeout).Error(); err != nil {
+		return errors.Wrapf(err, "failed to remove member %s", id)
+	}
+	log.WithField("id", id).Info("Removed cluster member")
+	return nil
+}
+
+// Member is a server of the Raft configuration.
+type Member struct {
+	ID       string
+	RaftAddr string
+	APIAddr  string
+	Suffrage string
+	Leader   bool
+}
+
+// Status describes this node's view of the cluster.
+type Status struct {
+	ID           string
+	State        string
+	LeaderID     string
+	LeaderAddr   string
+	CommitIndex  uint64
+	AppliedIndex uint64
+	MissingFiles int
+	Members      []Member
+}
+
+func (c *Cluster) Status() (*Status, error) {
+	leaderID, leaderAddr := c.leader()
+	status := &Status{
+		ID:           c.id,
+		State:        c.raft.State().String(),
+		LeaderID:     leaderID,
+		LeaderAddr:   leaderAddr,
+		CommitIndex:  c.raft.CommitIndex(),
+		AppliedIndex: c.raft.AppliedIndex(),
+		MissingFiles: len(c.fsm.missingFiles()),
+	}
+
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	nodes := c.fsm.nodes()
+	for _, server := range future.Configuration().Servers {
+		status.Members = append(status.Members, Member{
+			ID:       string(server.ID),
+			RaftAddr: string(server.Address),
+			APIAddr:  nodes[string(server.ID)],
+			Suffrage: server.Suffrage.String(),
+			Leader:   string(server.ID) == leaderID,
+		})
+	}
+	sort.Slice(status.Members, func(i, j int) bool {
+		return status.Members[i].ID < status.Members[j].ID
+	})
+	return status, nil
+}
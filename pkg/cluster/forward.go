@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// forward sends req to the leader, operation labels the forwarded requests.
+func (c *Cluster) forward(ctx context.Context, operation string, req *api.ForwardRequest, data []byte) (*api.ForwardResponse, error) {
+	addr, err := c.leaderAddr()
+	if err != nil {
+		return nil, err
+	}
+	forwardedTotal.WithLabelValues(operation).Inc()
+	return c.peers.forward(ctx, addr, req, data)
+}
+
+// Forwarded applies an operation another member forwarded to the replicated
+// namespace, the errors are returned as part of the response. Only the leader
+// applies forwarded operations, so they don't bounce between the members
+// while the leadership changes.
+func (c *Cluster) Forwarded(ctx context.Context, req *api.ForwardRequest, data []byte) *api.ForwardResponse {
+	resp := &api.ForwardResponse{}
+	if !c.isLeader() {
+		resp.Error = ErrorToAPI(errors.Wrap(storage.ErrUnavailable, c.notLeader().Error()))
+		return resp
+	}
+
+	var err error
+	switch req.Op {
+	case api.ForwardOp_FORWARD_OP_WRITE:
+		if err = checkName(req.Name); err == nil {
+			err = c.write(req.Name, data)
+		}
+	case api.ForwardOp_FORWARD_OP_RENAME:
+		if err = checkName(req.NewName); err == nil {
+			err = c.apply(&command{Op: opRename, Name: req.Name, NewName: req.NewName})
+		}
+	case api.ForwardOp_FORWARD_OP_REMOVE:
+		err = c.apply(&command{Op: opRemove, Name: req.Name})
+	case api.ForwardOp_FORWARD_OP_SET_METADATA:
+		err = c.apply(&command{Op: opSetMetadata, Name: req.Name, Metadata: req.Metadata})
+	case api.ForwardOp_FORWARD_OP_STAT:
+		var fi *storage.FileInfo
+		if fi, err = c.stat(ctx, req.Name); err == nil {
+			resp.FileInfo = &api.FileInfo{
+				Name:     fi.Name,
+				Size:     fi.Size,
+				Mode:     fi.Mode,
+				ModTime:  timestamppb.New(fi.ModTime),
+				Metadata: fi.Metadata,
+			}
+		}
+	case api.ForwardOp_FORWARD_OP_LIST:
+		resp.Files, err = c.list(ctx)
+	default:
+		err = errors.Errorf("unknown operation %s", req.Op)
+	}
+	resp.Error = ErrorToAPI(err)
+	return resp
+}
+
+// ReadForwarded returns the committed file name to another member, it is
+// only served if the body of the file is present on this member.
+func (c *Cluster) ReadForwarded(ctx context.Context, name string) ([]byte, error) {
+	return c.readLocal(ctx, name)
+}
+
+// ErrorToAPI converts err of a forwarded operation, nil if it succeeded.
+func ErrorToAPI(err error) *api.StorageError {
+	if err == nil {
+		return nil
+	}
+	e := &api.StorageError{Message: err.Error()}
+	var notFound *storage.NotFoundError
+	var exists *storage.AlreadyExistsError
+	switch {
+	case errors.As(err, &notFound):
+		e.Code = api.StorageErrorCode_STORAGE_ERROR_CODE_NOT_FOUND
+		e.Name = notFound.Name
+	case errors.As(err, &exists):
+		e.Code = api.StorageErrorCode_STORAGE_ERROR_CODE_ALREADY_EXISTS
+		e.Name = exists.Name
+	case errors.Is(err, storage.ErrInvalidName):
+		e.Code = api.StorageErrorCode_STORAGE_ERROR_CODE_INVALID_NAME
+	case errors.Is(err, storage.ErrUnavailable):
+		e.Code = api.StorageErrorCode_STORAGE_ERROR_CODE_UNAVAILABLE
+	case errors.Is(err, storage.ErrMetadataUnsupported):
+		e.Code = api.StorageErrorCode_STORAGE_ERROR_CODE_METADATA_UNSUPPORTED
+	}
+	return e
+}
+
+// errorFromAPI converts the error of a forwarded operation back into the
+// errors of the storage package.
+func errorFromAPI(e *api.StorageError) error {
+	if e == nil {
+		return nil
+	}
+	switch e.Code {
+	case api.StorageErrorCode_STORAGE_ERROR_CODE_NOT_FOUND:
+		return &storage.NotFoundError{Name: e.Name}
+	case api.StorageErrorCode_STORAGE_ERROR_CODE_ALREADY_EXISTS:
+		return &storage.AlreadyExistsError{Name: e.Name}
+	case api.StorageErrorCode_STORAGE_ERROR_CODE_INVALID_NAME:
+		return storage.ErrInvalidName
+	case api.StorageErrorCode_STORAGE_ERROR_CODE_UNAVAILABLE:
+		return errors.Wrap(storage.ErrUnavailable, e.Message)
+	case api.StorageErrorCode_STORAGE_ERROR_CODE_METADATA_UNSUPPORTED:
+		return storage.ErrMetadataUnsupported
+	}
+	return errors.New(e.Message)
+}
+
+// forward sends req with data to the leader at addr.
+func (p *peers) forward(ctx context.Context, addr string, req *api.ForwardRequest, data []byte) (*api.ForwardResponse, error) {
+	conn, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := api.NewClusterClient(conn).Forward(ctx)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	for {
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		req.Data = data[:n]
+		if err := stream.Send(req); err != nil {
+			if errors.Is(err, io.EOF) {
+				// the leader aborted the stream, its status has the reason
+				_, err = stream.CloseAndRecv()
+			}
+			return nil, fromStatus(err)
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			break
+		}
+		req = &api.ForwardRequest{}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if err := errorFromAPI(resp.Error); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// read returns the file name from the member at addr.
+func (p *peers) read(ctx context.Context, addr, name string) ([]byte, error) {
+	conn, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := api.NewClusterClient(conn).ForwardRead(ctx, &api.ForwardRequest{Name: name})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return data, nil
+			}
+			return nil, fromStatus(err)
+		}
+		if err := errorFromAPI(resp.Error); err != nil {
+			return nil, err
+		}
+		data = append(data, resp.Data...)
+	}
+}
@@ -0,0 +1,441 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	opWrite       = "write"
+	opRename      = "rename"
+	opRemove      = "remove"
+	opSetMetadata = "set-metadata"
+	opSetNode     = "set-node"
+	opDeleteNode  = "delete-node"
+
+	materializedFile = "materialized"
+)
+
+// command is a namespace mutation ordered by the Raft log.
+type command struct {
+	Op       string    `json:"op"`
+	Name     string    `json:"name,omitempty"`
+	NewName  string    `json:"new_name,omitempty"`
+	Blob     string    `json:"blob,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	ModTime  time.Time `json:"mod_time,omitempty"`
+	NodeID   string    `json:"node_id,omitempty"`
+	APIAddr  string    `json:"api_addr,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// fileMeta is the committed metadata of a file.
+type fileMeta struct {
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"`
+	ModTime  time.Time `json:"mod_time"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// fsmState is the replicated state, it is also the content of snapshots.
+type fsmState struct {
+	Index uint64               `json:"index"`
+	Files map[string]*fileMeta `json:"files"`
+	Nodes map[string]string    `json:"nodes"`
+}
+
+func newFSM(store storage.Storage, blobs *blobStore, dir string) (*fsm, error) {
+	materialized, err := readIndex(filepath.Join(dir, materializedFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read materialized index")
+	}
+	return &fsm{
+		store: store,
+		blobs: blobs,
+		dir:   dir,
+		state: fsmState{
+			Files: make(map[string]*fileMeta),
+			Nodes: make(map[string]string),
+		},
+		missing:      make(map[string]struct{}),
+		materialized: materialized,
+	}, nil
+}
+
+// fsm applies committed commands to the metadata and materializes them in
+// the local storage. Entries up to the materialized index have been applied
+// to the local storage before, e.g. before a restart, and only update the
+// metadata when they are replayed.
+//
+// Files whose local content doesn't match the metadata, because their body
+// never reached this node, are tracked as missing until they are repaired
+// from a peer.
+type fsm struct {
+	store storage.Storage
+	blobs *blobStore
+	dir   string
+
+	mu           sync.RWMutex
+	state        fsmState
+	missing      map[string]struct{}
+	materialized uint64
+
+	changes *changes
+}
+
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		log.Errorf("Failed to decode command at index %d (%s)", l.Index, err)
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.state.Index = l.Index
+	materialize := l.Index > f.materialized
+
+	var err error
+	switch cmd.Op {
+	case opWrite:
+		if _, ok := f.state.Files[cmd.Name]; ok {
+			return &storage.AlreadyExistsError{Name: cmd.Name}
+		}
+		meta := &fileMeta{Size: cmd.Size, Checksum: cmd.Checksum, ModTime: cmd.ModTime}
+		f.state.Files[cmd.Name] = meta
+		if materialize {
+			f.materializeWrite(cmd.Name, cmd.Blob, meta)
+		}
+		f.changes.add(cmd.Name)
+	case opRename:
+		meta, ok := f.state.Files[cmd.Name]
+		if !ok {
+			return &storage.NotFoundError{Name: cmd.Name}
+		}
+		if _, ok := f.state.Files[cmd.NewName]; ok {
+			return &storage.AlreadyExistsError{Name: cmd.NewName}
+		}
+		delete(f.state.Files, cmd.Name)
+		f.state.Files[cmd.NewName] = meta
+		if materialize {
+			f.materializeRename(cmd.Name, cmd.NewName, meta)
+		}
+		f.changes.add(cmd.Name, cmd.NewName)
+	case opRemove:
+		if _, ok := f.state.Files[cmd.Name]; !ok {
+			return &storage.NotFoundError{Name: cmd.Name}
+		}
+		delete(f.state.Files, cmd.Name)
+		if materialize {
+			f.materializeRemove(cmd.Name)
+		}
+		f.changes.add(cmd.Name)
+	case opSetMetadata:
+		meta, ok := f.state.Files[cmd.Name]
+		if !ok {
+			return &storage.NotFoundError{Name: cmd.Name}
+		}
+		// metadata only lives in the replicated state, the file is untouched
+		updated := *meta
+		updated.Metadata = cmd.Metadata
+		f.state.Files[cmd.Name] = &updated
+		f.changes.add(cmd.Name)
+	case opSetNode:
+		f.state.Nodes[cmd.NodeID] = cmd.APIAddr
+	case opDeleteNode:
+		delete(f.state.Nodes, cmd.NodeID)
+	default:
+		err = errors.Errorf("unknown command %q", cmd.Op)
+	}
+
+	if materialize {
+		f.setMaterialized(l.Index)
+	}
+	missingFiles.Set(float64(len(f.missing)))
+	appliedIndex.Set(float64(l.Index))
+	return err
+}
+
+func (f *fsm) materializeWrite(name, blob string, meta *fileMeta) {
+	ctx := context.Background()
+	scopedLog := log.WithField("name", name)
+
+	data, err := f.blobs.read(blob)
+	if err != nil {
+		// replayed entry or the body never reached this node
+		if f.localMatches(name, meta) {
+			return
+		}
+		scopedLog.Warn("File body is not available locally, repairing it from a peer")
+		f.missing[name] = struct{}{}
+		return
+	}
+	defer f.blobs.remove(blob)
+
+	f.store.Remove(ctx, name)
+	if err := f.store.Write(ctx, name, data); err != nil {
+		scopedLog.Errorf("Failed to write file (%s)", err)
+		f.missing[name] = struct{}{}
+		return
+	}
+	delete(f.missing, name)
+}
+
+func (f *fsm) materializeRename(old, new string, meta *fileMeta) {
+	ctx := context.Background()
+
+	_, wasMissing := f.missing[old]
+	delete(f.missing, old)
+	f.store.Remove(ctx, new)
+	if wasMissing {
+		f.store.Remove(ctx, old)
+		f.missing[new] = struct{}{}
+		return
+	}
+	if err := f.store.Rename(ctx, old, new); err != nil {
+		if f.localMatches(new, meta) {
+			return
+		}
+		log.WithField("old", old).WithField("new", new).Errorf("Failed to rename file (%s)", err)
+		f.missing[new] = struct{}{}
+	}
+}
+
+func (f *fsm) materializeRemove(name string) {
+	delete(f.missing, name)
+	err := f.store.Remove(context.Background(), name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, &storage.NotFoundError{Name: name}) {
+		log.WithField("name", name).Errorf("Failed to remove file (%s)", err)
+	}
+}
+
+// localMatches reports whether the local file name has the committed content.
+func (f *fsm) localMatches(name string, meta *fileMeta) bool {
+	data, err := f.store.Read(context.Background(), name)
+	if err != nil {
+		return false
+	}
+	return int64(len(data)) == meta.Size && checksum(data) == meta.Checksum
+}
+
+func (f *fsm) setMaterialized(index uint64) {
+	if err := writeIndex(filepath.Join(f.dir, materializedFile), index); err != nil {
+		log.Errorf("Failed to persist materialized index (%s)", err)
+		return
+	}
+	f.materialized = index
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	state := fsmState{
+		Index: f.state.Index,
+		Files: make(map[string]*fileMeta, len(f.state.Files)),
+		Nodes: make(map[string]string, len(f.state.Nodes)),
+	}
+	for name, meta := range f.state.Files {
+		m := *meta
+		state.Files[name] = &m
+	}
+	for id, addr := range f.state.Nodes {
+		state.Nodes[id] = addr
+	}
+	return &fsmSnapshot{state: state}, nil
+}
+
+// Restore replaces the state with a snapshot. A snapshot newer than the local
+// storage, e.g. one installed by the leader, is reconciled with the local
+// files.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var state fsmState
+	if err := json.NewDecoder(rc).Decode(&state); err != nil {
+		return errors.Wrap(err, "failed to decode snapshot")
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*fileMeta)
+	}
+	if state.Nodes == nil {
+		state.Nodes = make(map[string]string)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// any file of the previous or the restored state may have changed
+	for name := range f.state.Files {
+		if _, ok := state.Files[name]; !ok {
+			f.changes.add(name)
+		}
+	}
+	for name := range state.Files {
+		f.changes.add(name)
+	}
+	f.state = state
+	if state.Index > f.materialized {
+		if err := f.reconcile(); err != nil {
+			return err
+		}
+		f.setMaterialized(state.Index)
+	}
+	missingFiles.Set(float64(len(f.missing)))
+	return nil
+}
+
+// reconcile removes local files which aren't part of the state and marks
+// files whose content doesn't match as missing.
+func (f *fsm) reconcile() error {
+	ctx := context.Background()
+
+	files, err := f.store.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list local files")
+	}
+	for _, name := range files {
+		if _, ok := f.state.Files[name]; !ok {
+			f.store.Remove(ctx, name)
+		}
+	}
+	f.missing = make(map[string]struct{})
+	for name, meta := range f.state.Files {
+		if !f.localMatches(name, meta) {
+			f.missing[name] = struct{}{}
+		}
+	}
+	log.WithField("missing", len(f.missing)).Info("Reconciled local files with snapshot")
+	return nil
+}
+
+func (f *fsm) stat(name string) (*fileMeta, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	meta, ok := f.state.Files[name]
+	if !ok {
+		return nil, false
+	}
+	_, missing := f.missing[name]
+	return meta, !missing
+}
+
+func (f *fsm) list() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	names := make([]string, 0, len(f.state.Files))
+	for name := range f.state.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fsm) nodes() map[string]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	nodes := make(map[string]string, len(f.state.Nodes))
+	for id, addr := range f.state.Nodes {
+		nodes[id] = addr
+	}
+	return nodes
+}
+
+func (f *fsm) missingFiles() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	names := make([]string, 0, len(f.missing))
+	for name := range f.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// repair stores data as the content of name if it matches the committed
+// metadata and the file is still missing.
+func (f *fsm) repair(name string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.missing[name]; !ok {
+		return nil
+	}
+	meta, ok := f.state.Files[name]
+	if !ok {
+		delete(f.missing, name)
+		return nil
+	}
+	if int64(len(data)) != meta.Size || checksum(data) != meta.Checksum {
+		return errors.Errorf("content of %s doesn't match the committed checksum", name)
+	}
+
+	ctx := context.Background()
+	f.store.Remove(ctx, name)
+	if err := f.store.Write(ctx, name, data); err != nil {
+		return err
+	}
+	delete(f.missing, name)
+	missingFiles.Set(float64(len(f.missing)))
+	return nil
+}
+
+type fsmSnapshot struct {
+	state fsmState
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(&s.state); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readIndex(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+func writeIndex(path string, index uint64) error {
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatUint(index, 10)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
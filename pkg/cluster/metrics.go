@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "cluster")
+
+var (
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cluster",
+		Name:      "is_leader",
+	})
+	appliedIndex = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cluster",
+		Name:      "applied_index",
+	})
+	missingFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cluster",
+		Name:      "missing_files",
+	})
+	forwardedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "cluster",
+		Name:      "forwarded_requests_total",
+	}, []string{"operation"})
+)
+
+// Collectors returns the cluster metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		isLeader,
+		appliedIndex,
+		missingFiles,
+		forwardedTotal,
+	}
+}
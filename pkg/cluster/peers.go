@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// chunkSize bounds the data of a single message sent to a peer.
+const chunkSize = 1024 * 1024
+
+// peers keeps connections to the API of the other cluster members.
+type peers struct {
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newPeers(tlsConfig *tls.Config) *peers {
+	return &peers{
+		tlsConfig: tlsConfig,
+		conns:     make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (p *peers) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+	var dialOptions []grpc.DialOption
+	if p.tlsConfig != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(p.tlsConfig)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(addr, dialOptions...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial peer %s", addr)
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+func (p *peers) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+}
+
+// putBlob stages data as blob id on the peer.
+func (p *peers) putBlob(ctx context.Context, addr, id string, data []byte) error {
+	conn, err := p.conn(addr)
+	if err != nil {
+		return err
+	}
+	stream, err := api.NewClusterClient(conn).PutBlob(ctx)
+	if err != nil {
+		return err
+	}
+	req := &api.PutBlobRequest{Id: id}
+	for {
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		req.Data = data[:n]
+		if err := stream.Send(req); err != nil {
+			if errors.Is(err, io.EOF) {
+				_, err = stream.CloseAndRecv()
+			}
+			return err
+		}
+		req.Id = ""
+		data = data[n:]
+		if len(data) == 0 {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// fromStatus converts the status of a failed request to a peer, the errors of
+// the forwarded operations themselves are part of the responses.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.Unavailable {
+		return errors.Wrap(storage.ErrUnavailable, status.Convert(err).Message())
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return storage.ErrInvalidName
+	}
+	return nil
+}
+
+// Read serves committed files from the local storage. Files whose body
+// hasn't been repaired yet are read from the leader.
+func (c *Cluster) Read(ctx context.Context, name string) ([]byte, error) {
+	meta, present := c.fsm.stat(name)
+	if meta == nil {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	if present {
+		return c.store.Read(ctx, name)
+	}
+	if c.isLeader() {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+
+	addr, err := c.leaderAddr()
+	if err != nil {
+		return nil, err
+	}
+	forwardedTotal.WithLabelValues("read").Inc()
+	return c.peers.read(ctx, addr, name)
+}
+
+// ReadRange serves a part of a committed file from the local storage, files
+// whose body hasn't been repaired yet are read from the leader as a whole.
+func (c *Cluster) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	meta, present := c.fsm.stat(name)
+	if meta == nil {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	if present {
+		return storage.ReadRange(ctx, c.store, name, offset, length)
+	}
+	data, err := c.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= int64(len(data)) {
+		return []byte{}, nil
+	}
+	data = data[offset:]
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+	return data, nil
+}
+
+// readLocal returns the committed file name if its body is present on this
+// member, it serves the reads of other members.
+func (c *Cluster) readLocal(ctx context.Context, name string) ([]byte, error) {
+	meta, present := c.fsm.stat(name)
+	if meta == nil || !present {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	return c.store.Read(ctx, name)
+}
+
+func (c *Cluster) Write(ctx context.Context, name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if !c.isLeader() {
+		_, err := c.forward(ctx, "write", &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_WRITE, Name: name}, data)
+		return err
+	}
+	return c.write(name, data)
+}
+
+func (c *Cluster) write(name string, data []byte) error {
+	if meta, _ := c.fsm.stat(name); meta != nil {
+		return &storage.AlreadyExistsError{Name: name}
+	}
+
+	id := newBlobID()
+	if err := c.blobs.write(id, data); err != nil {
+		return err
+	}
+	if err := c.replicateBlob(id, data); err != nil {
+		c.blobs.remove(id)
+		return err
+	}
+	err := c.apply(&command{
+		Op:       opWrite,
+		Name:     name,
+		Blob:     id,
+		Size:     int64(len(data)),
+		Checksum: checksum(data),
+		ModTime:  time.Now().UTC(),
+	})
+	if err != nil {
+		c.blobs.remove(id)
+	}
+	return err
+}
+
+// List returns the committed files, the leader answers linearizably.
+func (c *Cluster) List(ctx context.Context) ([]string, error) {
+	if !c.isLeader() {
+		resp, err := c.forward(ctx, "list", &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_LIST}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Files, nil
+	}
+	return c.list(ctx)
+}
+
+func (c *Cluster) list(ctx context.Context) ([]string, error) {
+	if err := c.linearize(ctx); err != nil {
+		return nil, err
+	}
+	return c.fsm.list(), nil
+}
+
+// Stat returns the committed metadata of a file, the leader answers
+// linearizably.
+func (c *Cluster) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	if !c.isLeader() {
+		resp, err := c.forward(ctx, "stat", &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_STAT, Name: name}, nil)
+		if err != nil {
+			return nil, err
+		}
+		fi := resp.FileInfo
+		return &storage.FileInfo{
+			Name:    fi.Name,
+			Size:    fi.Size,
+			Mode:    fi.Mode,
+			ModTime: fi.ModTime.AsTime(),
+			Dir:     fi.Dir,
+
+			Metadata: fi.Metadata,
+		}, nil
+	}
+	return c.stat(ctx, name)
+}
+
+func (c *Cluster) stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	if err := c.linearize(ctx); err != nil {
+		return nil, err
+	}
+	meta, _ := c.fsm.stat(name)
+	if meta == nil {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	return &storage.FileInfo{
+		Name:    name,
+		Size:    meta.Size,
+		Mode:    0600,
+		ModTime: meta.ModTime,
+
+		Metadata: meta.Metadata,
+	}, nil
+}
+
+func (c *Cluster) Rename(ctx context.Context, old, new string) error {
+	if err := checkName(new); err != nil {
+		return err
+	}
+	if !c.isLeader() {
+		_, err := c.forward(ctx, "rename", &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_RENAME, Name: old, NewName: new}, nil)
+		return err
+	}
+	return c.apply(&command{Op: opRename, Name: old, NewName: new})
+}
+
+func (c *Cluster) Remove(ctx context.Context, name string) error {
+	if !c.isLeader() {
+		_, err := c.forward(ctx, "remove", &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_REMOVE, Name: name}, nil)
+		return err
+	}
+	return c.apply(&command{Op: opRemove, Name: name})
+}
+
+// SetMetadata commits the metadata of a file, it is kept in the replicated
+// namespace.
+func (c *Cluster) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	if !c.isLeader() {
+		req := &api.ForwardRequest{Op: api.ForwardOp_FORWARD_OP_SET_METADATA, Name: name, Metadata: metadata}
+		_, err := c.forward(ctx, "set_metadata", req, nil)
+		return err
+	}
+	return c.apply(&command{Op: opSetMetadata, Name: name, Metadata: metadata})
+}
+
+// SetTier moves the local copy of a file, every member places its files on
+// its own tiers.
+func (c *Cluster) SetTier(ctx context.Context, name, tier string) error {
+	return storage.SetTier(ctx, c.store, name, tier)
+}
+
+func (c *Cluster) Close() error {
+	return c.store.Close()
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// tlsStreamLayer carries the Raft traffic over mutually authenticated TLS.
+type tlsStreamLayer struct {
+	net.Listener
+
+	advertise    net.Addr
+	clientConfig *tls.Config
+}
+
+func newTLSStreamLayer(bind string, advertise net.Addr, serverConfig, clientConfig *tls.Config) (*tlsStreamLayer, error) {
+	ln, err := tls.Listen("tcp", bind, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsStreamLayer{
+		Listener:     ln,
+		advertise:    advertise,
+		clientConfig: clientConfig,
+	}, nil
+}
+
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), l.clientConfig)
+}
+
+func (l *tlsStreamLayer) Addr() net.Addr {
+	if l.advertise != nil {
+		return l.advertise
+	}
+	return l.Listener.Addr()
+}
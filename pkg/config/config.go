@@ -0,0 +1,663 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+const (
+	// EnvPrefix prefixes the environment variables overriding config values,
+	// e.g. ARGON_STORAGE_PATH overrides storage.path.
+	EnvPrefix = "ARGON"
+
+	StorageBackendLocal   = "local"
+	StorageBackendErasure = "erasure"
+	StorageBackendMirror  = "mirror"
+	StorageBackendCAS     = "cas"
+
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+
+	ModeReadWrite   = "read-write"
+	ModeReadOnly    = "read-only"
+	ModeMaintenance = "maintenance"
+
+	ReplicationRolePrimary = "primary"
+	ReplicationRoleReplica = "replica"
+
+	RetentionModeGovernance = "governance"
+	RetentionModeCompliance = "compliance"
+
+	// MinMsgSize is the smallest limits.max_msg_size, clients and servers
+	// stream data in chunks of up to 4 MiB.
+	MinMsgSize = 1024 * 1024 * 4
+)
+
+// Config is the configuration of the argon server. Settings marked as
+// reloadable are applied on SIGHUP without restarting the server. The mode
+// is reloadable, the reason is reported to clients whose requests are
+// rejected.
+type Config struct {
+	Id          string            `yaml:"id" toml:"id"`
+	Mode        string            `yaml:"mode" toml:"mode"`
+	ModeReason  string            `yaml:"mode_reason" toml:"mode_reason"`
+	Listen      ListenConfig      `yaml:"listen" toml:"listen"`
+	Metrics     ListenConfig      `yaml:"metrics" toml:"metrics"`
+	TLS         TLSConfig         `yaml:"tls" toml:"tls"`
+	Storage     StorageConfig     `yaml:"storage" toml:"storage"`
+	Limits      LimitsConfig      `yaml:"limits" toml:"limits"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Logging     LoggingConfig     `yaml:"logging" toml:"logging"`
+	Audit       AuditConfig       `yaml:"audit" toml:"audit"`
+	Replication ReplicationConfig `yaml:"replication" toml:"replication"`
+	Cluster     ClusterConfig     `yaml:"cluster" toml:"cluster"`
+	Expiry      ExpiryConfig      `yaml:"expiry" toml:"expiry"`
+	Lifecycle   LifecycleConfig   `yaml:"lifecycle" toml:"lifecycle"`
+	Trash       TrashConfig       `yaml:"trash" toml:"trash"`
+	Retention   RetentionConfig   `yaml:"retention" toml:"retention"`
+}
+
+type ListenConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	Port int    `yaml:"port" toml:"port"`
+}
+
+// TLSConfig enables TLS if a certificate is configured. Certificates are
+// reloadable.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile      string `yaml:"key_file" toml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// StorageConfig selects the storage backend. The local backend stores the
+// files in path, the erasure backend as shards across the erasure dirs and
+// the mirror backend a full copy in each of the mirror dirs. The cas backend
+// stores the files in path as deduplicated chunks. Any backend can compress
+// and encrypt the files it stores, and serve as hot tier of a tiered
+// storage.
+type StorageConfig struct {
+	Backend string        `yaml:"backend" toml:"backend"`
+	Path    string        `yaml:"path" toml:"path"`
+	Erasure ErasureConfig `yaml:"erasure" toml:"erasure"`
+	Mirror  MirrorConfig  `yaml:"mirror" toml:"mirror"`
+
+	Compression CompressionConfig `yaml:"compression" toml:"compression"`
+	Encryption  EncryptionConfig  `yaml:"encryption" toml:"encryption"`
+	Tiering     TieringConfig     `yaml:"tiering" toml:"tiering"`
+}
+
+// ErasureConfig splits every file into data_shards data and parity_shards
+// parity shards, one per directory, so there have to be as many dirs as
+// shards. Files survive the loss of parity_shards dirs.
+type ErasureConfig struct {
+	Dirs         []string `yaml:"dirs" toml:"dirs"`
+	DataShards   int      `yaml:"data_shards" toml:"data_shards"`
+	ParityShards int      `yaml:"parity_shards" toml:"parity_shards"`
+}
+
+// MirrorConfig keeps a copy of every file in each of dirs, which should be on
+// different disks. Files survive the loss of all but one dir.
+type MirrorConfig struct {
+	Dirs []string `yaml:"dirs" toml:"dirs"`
+}
+
+// CompressionConfig compresses the stored files with algorithm, unless one of
+// the rules matches their name first. A rule is "pattern=algorithm" with a
+// filepath.Match pattern, e.g. "*.gz=none". Algorithms are zstd, gzip and
+// none, compression is disabled if neither algorithm nor rules are set.
+type CompressionConfig struct {
+	Algorithm string   `yaml:"algorithm" toml:"algorithm"`
+	Rules     []string `yaml:"rules" toml:"rules"`
+}
+
+// Enabled reports whether files are compressed.
+func (c CompressionConfig) Enabled() bool {
+	return c.Algorithm != "" || len(c.Rules) > 0
+}
+
+// EncryptionConfig encrypts the stored files if keyfile is set. Every file
+// gets its own data key, which is kept in keys_path wrapped with the last
+// master key of the keyfile. A keyfile line holds a key as "id:base64 key",
+// the keyfile is reloadable, so master keys can be rotated without a
+// restart.
+type EncryptionConfig struct {
+	Keyfile  string `yaml:"keyfile" toml:"keyfile"`
+	KeysPath string `yaml:"keys_path" toml:"keys_path"`
+}
+
+// TieringConfig enables tiering if cold_path is set. The configured backend
+// becomes the hot tier, old files are moved to the local cold tier in
+// cold_path. Every interval the files which weren't used for demote_after
+// are demoted, and the least recently used ones while the hot files exceed
+// hot_max_bytes. A cold file is promoted after promote_reads reads. Zero
+// values disable the moves. Path holds the placement of the files.
+type TieringConfig struct {
+	ColdPath     string   `yaml:"cold_path" toml:"cold_path"`
+	Path         string   `yaml:"path" toml:"path"`
+	Interval     Duration `yaml:"interval" toml:"interval"`
+	PromoteReads int      `yaml:"promote_reads" toml:"promote_reads"`
+	DemoteAfter  Duration `yaml:"demote_after" toml:"demote_after"`
+	HotMaxBytes  int64    `yaml:"hot_max_bytes" toml:"hot_max_bytes"`
+}
+
+// Enabled reports whether files are tiered.
+func (c TieringConfig) Enabled() bool {
+	return c.ColdPath != ""
+}
+
+// LimitsConfig bounds the resources used by clients. MaxFileSize is
+// reloadable.
+type LimitsConfig struct {
+	MaxMsgSize           int   `yaml:"max_msg_size" toml:"max_msg_size"`
+	MaxFileSize          int64 `yaml:"max_file_size" toml:"max_file_size"`
+	MaxConcurrentStreams int   `yaml:"max_concurrent_streams" toml:"max_concurrent_streams"`
+}
+
+// AuthConfig holds the access policy, identities are the common names of
+// verified client certificates. An empty list allows every identity. Admin
+// identities may use the admin service, retention identities may manage the
+// retention and legal holds of files, which is left to the admin identities
+// if unset. The policy is reloadable.
+type AuthConfig struct {
+	AllowedIdentities   []string `yaml:"allowed_identities" toml:"allowed_identities"`
+	AdminIdentities     []string `yaml:"admin_identities" toml:"admin_identities"`
+	RetentionIdentities []string `yaml:"retention_identities" toml:"retention_identities"`
+}
+
+// LoggingConfig configures the logger, the level is reloadable.
+type LoggingConfig struct {
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+}
+
+type AuditConfig struct {
+	Path    string `yaml:"path" toml:"path"`
+	MaxSize int64  `yaml:"max_size" toml:"max_size"`
+	Reads   bool   `yaml:"reads" toml:"reads"`
+}
+
+// ReplicationConfig configures asynchronous replication. A primary ships its
+// mutations to the replicas, a replica serves reads only. Path holds the
+// replication log of a primary and the position of a replica. An empty role
+// disables replication.
+type ReplicationConfig struct {
+	Role     string   `yaml:"role" toml:"role"`
+	Path     string   `yaml:"path" toml:"path"`
+	Replicas []string `yaml:"replicas" toml:"replicas"`
+}
+
+// ClusterConfig configures the Raft cluster mode, which is enabled by setting
+// the Raft address. APIAddr is the address peers reach this node's gRPC
+// service at, Path holds the Raft log and snapshots. Bootstrap forms a new
+// cluster with this node as its only voter, other nodes join it with the
+// admin cluster join command.
+type ClusterConfig struct {
+	RaftAddr  string `yaml:"raft_addr" toml:"raft_addr"`
+	APIAddr   string `yaml:"api_addr" toml:"api_addr"`
+	Path      string `yaml:"path" toml:"path"`
+	Bootstrap bool   `yaml:"bootstrap" toml:"bootstrap"`
+}
+
+// ExpiryConfig configures the worker deleting expired files, it looks for
+// them every interval. The default interval is a minute.
+type ExpiryConfig struct {
+	Interval Duration `yaml:"interval" toml:"interval"`
+}
+
+// LifecycleConfig applies the rules every interval, the default interval is
+// an hour. The rules are reloadable.
+type LifecycleConfig struct {
+	Interval Duration              `yaml:"interval" toml:"interval"`
+	Rules    []LifecycleRuleConfig `yaml:"rules" toml:"rules"`
+}
+
+// LifecycleRuleConfig moves the files whose name starts with prefix to the
+// cold tier transition_after and removes them expire_after their last
+// modification. Unset ages disable the action. Only the keep_versions most
+// recently modified matching files are kept if it is set.
+type LifecycleRuleConfig struct {
+	ID              string   `yaml:"id" toml:"id"`
+	Prefix          string   `yaml:"prefix" toml:"prefix"`
+	TransitionAfter Duration `yaml:"transition_after" toml:"transition_after"`
+	ExpireAfter     Duration `yaml:"expire_after" toml:"expire_after"`
+	KeepVersions    int      `yaml:"keep_versions" toml:"keep_versions"`
+}
+
+// TrashConfig moves removed files into the trash if it is enabled. Trashed
+// files are purged after retention, the default is a week and zero keeps them
+// until they are purged explicitly. Replicas should enable the trash as well,
+// otherwise the replicated trashed files are listed.
+type TrashConfig struct {
+	Enabled   bool     `yaml:"enabled" toml:"enabled"`
+	Retention Duration `yaml:"retention" toml:"retention"`
+}
+
+// RetentionConfig locks new files according to the rule with the longest
+// prefix matching their name.
+type RetentionConfig struct {
+	Rules []RetentionRuleConfig `yaml:"rules" toml:"rules"`
+}
+
+// RetentionRuleConfig locks the new files whose name starts with prefix for
+// period. In governance mode the retention identities can shorten or remove
+// the lock, in compliance mode nobody can.
+type RetentionRuleConfig struct {
+	Prefix string   `yaml:"prefix" toml:"prefix"`
+	Mode   string   `yaml:"mode" toml:"mode"`
+	Period Duration `yaml:"period" toml:"period"`
+}
+
+// Default returns the configuration used for unset values.
+func Default() *Config {
+	return &Config{
+		Mode: ModeReadWrite,
+		Listen: ListenConfig{
+			Addr: "0.0.0.0",
+			Port: 8080,
+		},
+		Metrics: ListenConfig{
+			Addr: "0.0.0.0",
+			Port: 9090,
+		},
+		TLS: TLSConfig{
+			ClientAuth: ClientAuthNone,
+		},
+		Storage: StorageConfig{
+			Backend: StorageBackendLocal,
+		},
+		Limits: LimitsConfig{
+			MaxMsgSize: MinMsgSize,
+		},
+		Logging: LoggingConfig{
+			Format: logging.FormatText,
+			Level:  "info",
+		},
+		Audit: AuditConfig{
+			MaxSize: 100 * 1024 * 1024,
+		},
+		Trash: TrashConfig{
+			Retention: Duration(7 * 24 * time.Hour),
+		},
+	}
+}
+
+// Load reads the YAML or TOML file at path, chosen by its extension, on top
+// of the defaults and applies environment variable overrides. An empty path
+// only applies the overrides.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := decodeFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func decodeFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return errors.Wrapf(err, "failed to parse %s", path)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(cfg)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", path)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return errors.Errorf("failed to parse %s: unknown field %s", path, undecoded[0])
+		}
+	default:
+		return errors.Errorf("unsupported config format %q", ext)
+	}
+	return nil
+}
+
+// Validate reports all invalid settings at once.
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Id == "" {
+		fail("id must be set")
+	}
+	switch c.Mode {
+	case "", ModeReadWrite, ModeReadOnly, ModeMaintenance:
+	default:
+		fail("unknown mode %q", c.Mode)
+	}
+	if c.Listen.Port < 0 || c.Listen.Port > 65535 {
+		fail("listen.port %d is out of range", c.Listen.Port)
+	}
+	if c.Metrics.Port < 0 || c.Metrics.Port > 65535 {
+		fail("metrics.port %d is out of range", c.Metrics.Port)
+	}
+
+	if c.TLS.Enabled() && c.TLS.KeyFile == "" {
+		fail("tls.key_file must be set together with tls.cert_file")
+	}
+	if !c.TLS.Enabled() && (c.TLS.KeyFile != "" || c.TLS.ClientCAFile != "") {
+		fail("tls.cert_file must be set to enable tls")
+	}
+	switch c.TLS.ClientAuth {
+	case "", ClientAuthNone:
+	case ClientAuthRequest, ClientAuthRequire:
+		if c.TLS.ClientCAFile == "" {
+			fail("tls.client_auth %q requires tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		fail("unknown tls.client_auth %q", c.TLS.ClientAuth)
+	}
+	for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			fail("tls file %s is not accessible (%s)", file, err)
+		}
+	}
+
+	switch c.Storage.Backend {
+	case StorageBackendLocal, StorageBackendCAS:
+		if c.Storage.Path == "" {
+			fail("storage.path must be set")
+		} else if fi, err := os.Stat(c.Storage.Path); err != nil {
+			fail("storage.path %s is not accessible (%s)", c.Storage.Path, err)
+		} else if !fi.IsDir() {
+			fail("storage.path %s is not a directory", c.Storage.Path)
+		}
+	case StorageBackendErasure:
+		erasure := c.Storage.Erasure
+		if erasure.DataShards <= 0 {
+			fail("storage.erasure.data_shards must be positive")
+		}
+		if erasure.ParityShards < 0 {
+			fail("storage.erasure.parity_shards must not be negative")
+		}
+		if len(erasure.Dirs) != erasure.DataShards+erasure.ParityShards {
+			fail("storage.erasure.dirs must have one directory per shard, got %d for %d shards",
+				len(erasure.Dirs), erasure.DataShards+erasure.ParityShards)
+		}
+	case StorageBackendMirror:
+		if len(c.Storage.Mirror.Dirs) < 2 {
+			fail("storage.mirror.dirs must have at least 2 directories")
+		}
+		for _, dir := range c.Storage.Mirror.Dirs {
+			if fi, err := os.Stat(dir); err != nil {
+				fail("storage.mirror.dirs %s is not accessible (%s)", dir, err)
+			} else if !fi.IsDir() {
+				fail("storage.mirror.dirs %s is not a directory", dir)
+			}
+		}
+	default:
+		fail("unknown storage.backend %q", c.Storage.Backend)
+	}
+	if encryption := c.Storage.Encryption; encryption.Keyfile != "" {
+		if _, err := os.Stat(encryption.Keyfile); err != nil {
+			fail("storage.encryption.keyfile %s is not accessible (%s)", encryption.Keyfile, err)
+		}
+		if encryption.KeysPath == "" {
+			fail("storage.encryption.keys_path must be set")
+		}
+	}
+	if !validCompressionAlgorithm(c.Storage.Compression.Algorithm) {
+		fail("unknown storage.compression.algorithm %q", c.Storage.Compression.Algorithm)
+	}
+	for _, rule := range c.Storage.Compression.Rules {
+		pattern, algorithm, ok := strings.Cut(rule, "=")
+		if !ok {
+			fail("storage.compression.rules %q must be pattern=algorithm", rule)
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fail("storage.compression.rules %q has an invalid pattern", rule)
+		}
+		if !validCompressionAlgorithm(algorithm) {
+			fail("storage.compression.rules %q has an unknown algorithm", rule)
+		}
+	}
+
+	if tiering := c.Storage.Tiering; tiering.Enabled() {
+		if fi, err := os.Stat(tiering.ColdPath); err != nil {
+			fail("storage.tiering.cold_path %s is not accessible (%s)", tiering.ColdPath, err)
+		} else if !fi.IsDir() {
+			fail("storage.tiering.cold_path %s is not a directory", tiering.ColdPath)
+		}
+		if tiering.Path == "" {
+			fail("storage.tiering.path must be set")
+		}
+		if tiering.Interval < 0 || tiering.DemoteAfter < 0 {
+			fail("storage.tiering durations must not be negative")
+		}
+		if tiering.PromoteReads < 0 {
+			fail("storage.tiering.promote_reads must not be negative")
+		}
+		if tiering.HotMaxBytes < 0 {
+			fail("storage.tiering.hot_max_bytes must not be negative")
+		}
+	}
+
+	if c.Limits.MaxMsgSize < MinMsgSize {
+		fail("limits.max_msg_size must be at least %d bytes", MinMsgSize)
+	}
+	if c.Limits.MaxFileSize < 0 {
+		fail("limits.max_file_size must not be negative")
+	}
+	if c.Limits.MaxConcurrentStreams < 0 {
+		fail("limits.max_concurrent_streams must not be negative")
+	}
+
+	switch c.Replication.Role {
+	case "":
+		if len(c.Replication.Replicas) > 0 {
+			fail("replication.replicas requires replication.role %q", ReplicationRolePrimary)
+		}
+	case ReplicationRolePrimary, ReplicationRoleReplica:
+		if c.Replication.Path == "" {
+			fail("replication.path must be set")
+		}
+	default:
+		fail("unknown replication.role %q", c.Replication.Role)
+	}
+
+	if c.Cluster.RaftAddr != "" {
+		if c.Cluster.APIAddr == "" {
+			fail("cluster.api_addr must be set")
+		}
+		if c.Cluster.Path == "" {
+			fail("cluster.path must be set")
+		}
+		if c.Replication.Role != "" {
+			fail("cluster.raft_addr and replication.role are mutually exclusive")
+		}
+	} else if c.Cluster.Bootstrap {
+		fail("cluster.bootstrap requires cluster.raft_addr")
+	}
+
+	switch c.Logging.Format {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		fail("unknown logging.format %q", c.Logging.Format)
+	}
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		fail("invalid logging.level %q", c.Logging.Level)
+	}
+
+	if c.Audit.MaxSize < 0 {
+		fail("audit.max_size must not be negative")
+	}
+
+	if c.Expiry.Interval < 0 {
+		fail("expiry.interval must not be negative")
+	}
+
+	if c.Trash.Retention < 0 {
+		fail("trash.retention must not be negative")
+	}
+
+	prefixes := make(map[string]bool)
+	for _, rule := range c.Retention.Rules {
+		switch {
+		case prefixes[rule.Prefix]:
+			fail("retention.rules prefix %q is not unique", rule.Prefix)
+		case rule.Mode != RetentionModeGovernance && rule.Mode != RetentionModeCompliance:
+			fail("retention.rules prefix %q has an unknown mode %q", rule.Prefix, rule.Mode)
+		case rule.Period <= 0:
+			fail("retention.rules prefix %q must have a positive period", rule.Prefix)
+		}
+		prefixes[rule.Prefix] = true
+	}
+
+	if c.Lifecycle.Interval < 0 {
+		fail("lifecycle.interval must not be negative")
+	}
+	ruleIDs := make(map[string]bool)
+	for i, rule := range c.Lifecycle.Rules {
+		switch {
+		case rule.ID == "":
+			fail("lifecycle.rules[%d] has no id", i)
+		case ruleIDs[rule.ID]:
+			fail("lifecycle.rules id %s is not unique", rule.ID)
+		case rule.TransitionAfter < 0 || rule.ExpireAfter < 0:
+			fail("lifecycle.rules %s has a negative age", rule.ID)
+		case rule.KeepVersions < 0:
+			fail("lifecycle.rules %s keeps a negative number of versions", rule.ID)
+		case rule.TransitionAfter == 0 && rule.ExpireAfter == 0 && rule.KeepVersions == 0:
+			fail("lifecycle.rules %s must set transition_after, expire_after or keep_versions", rule.ID)
+		case rule.ExpireAfter > 0 && rule.TransitionAfter >= rule.ExpireAfter:
+			fail("lifecycle.rules %s expires files before they transition", rule.ID)
+		}
+		ruleIDs[rule.ID] = true
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// applyEnv overrides every field with the environment variable named after
+// its path, e.g. ARGON_LIMITS_MAX_FILE_SIZE. Lists are comma separated.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	return walkEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup)
+}
+
+func walkEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := walkEnv(fv, key, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			return errors.Wrapf(err, "invalid value for %s", key)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func validCompressionAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "", "none", "gzip", "zstd":
+		return true
+	default:
+		return false
+	}
+}
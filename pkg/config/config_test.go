@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testYAML = `id: a
+storage:
+  path: /var/lib/argon
+  tiering:
+    cold_path: /mnt/cold
+    interval: 30m
+    demote_after: 168h
+expiry:
+  interval: 90s
+lifecycle:
+  interval: 1h
+  rules:
+    - id: logs
+      prefix: logs/
+      transition_after: 24h
+      expire_after: 720h
+      keep_versions: 3
+trash:
+  enabled: true
+  retention: 48h
+retention:
+  rules:
+    - prefix: reports/
+      mode: compliance
+      period: 61320h
+`
+
+const testTOML = `id = "a"
+
+[storage]
+path = "/var/lib/argon"
+
+[storage.tiering]
+cold_path = "/mnt/cold"
+interval = "30m"
+demote_after = "168h"
+
+[expiry]
+interval = "90s"
+
+[lifecycle]
+interval = "1h"
+
+[[lifecycle.rules]]
+id = "logs"
+prefix = "logs/"
+transition_after = "24h"
+expire_after = "720h"
+keep_versions = 3
+
+[trash]
+enabled = true
+retention = "48h"
+
+[[retention.rules]]
+prefix = "reports/"
+mode = "compliance"
+period = "61320h"
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAMLAndTOML(t *testing.T) {
+	fromYAML, err := Load(writeConfig(t, "argon.yaml", testYAML))
+	if err != nil {
+		t.Fatalf("failed to load YAML: %v", err)
+	}
+	fromTOML, err := Load(writeConfig(t, "argon.toml", testTOML))
+	if err != nil {
+		t.Fatalf("failed to load TOML: %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromTOML) {
+		t.Fatalf("YAML and TOML differ:\n%+v\n%+v", fromYAML, fromTOML)
+	}
+
+	durations := map[string]Duration{
+		"storage.tiering.interval":            fromYAML.Storage.Tiering.Interval,
+		"storage.tiering.demote_after":        fromYAML.Storage.Tiering.DemoteAfter,
+		"expiry.interval":                     fromYAML.Expiry.Interval,
+		"lifecycle.interval":                  fromYAML.Lifecycle.Interval,
+		"lifecycle.rules[0].transition_after": fromYAML.Lifecycle.Rules[0].TransitionAfter,
+		"lifecycle.rules[0].expire_after":     fromYAML.Lifecycle.Rules[0].ExpireAfter,
+		"trash.retention":                     fromYAML.Trash.Retention,
+		"retention.rules[0].period":           fromYAML.Retention.Rules[0].Period,
+	}
+	want := map[string]time.Duration{
+		"storage.tiering.interval":            30 * time.Minute,
+		"storage.tiering.demote_after":        168 * time.Hour,
+		"expiry.interval":                     90 * time.Second,
+		"lifecycle.interval":                  time.Hour,
+		"lifecycle.rules[0].transition_after": 24 * time.Hour,
+		"lifecycle.rules[0].expire_after":     720 * time.Hour,
+		"trash.retention":                     48 * time.Hour,
+		"retention.rules[0].period":           61320 * time.Hour,
+	}
+	for key, d := range durations {
+		if d.Duration() != want[key] {
+			t.Errorf("%s is %s, want %s", key, d, want[key])
+		}
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	cfg := Default()
+	env := map[string]string{"ARGON_TRASH_RETENTION": "36h"}
+	err := applyEnv(cfg, func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Trash.Retention.Duration() != 36*time.Hour {
+		t.Fatalf("trash.retention is %s, want 36h", cfg.Trash.Retention)
+	}
+}
+
+func TestValidateMaxMsgSize(t *testing.T) {
+	cfg := Default()
+	cfg.Id = "a"
+	cfg.Storage.Path = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+	cfg.Limits.MaxMsgSize = 1024 * 1024
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("max_msg_size below the streamed chunk size passed validation")
+	}
+}
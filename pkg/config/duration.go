@@ -0,0 +1,31 @@
+package config
+
+import (
+	"time"
+)
+
+// Duration is a time.Duration written as a Go duration string, e.g. "90s" or
+// "168h", in YAML, TOML and environment variables.
+type Duration time.Duration
+
+// Duration returns d as time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,189 @@
+package index
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// ErrNotReady is returned by queries before the index has been built.
+var ErrNotReady = errors.New("metadata index is not built yet")
+
+// New returns an empty index of the files in store, it has to be built with
+// Rebuild before it answers queries.
+func New(store storage.Storage) *Index {
+	return &Index{
+		store: store,
+		files: make(map[string]*storage.FileInfo),
+	}
+}
+
+// Index keeps the file info and metadata of all files in memory, so queries
+// don't have to stat every file. It is updated by the callers mutating the
+// store and can be rebuilt from the store at any time.
+type Index struct {
+	store storage.Storage
+
+	mu    sync.RWMutex
+	files map[string]*storage.FileInfo
+	ready bool
+	// names changed while a rebuild scans the store
+	rebuilding bool
+	dirty      map[string]struct{}
+}
+
+// Rebuild replaces the index with the current state of the store and returns
+// the number of indexed files. Updates during the rebuild are applied after
+// the scan.
+func (i *Index) Rebuild(ctx context.Context) (int, error) {
+	i.mu.Lock()
+	if i.rebuilding {
+		i.mu.Unlock()
+		return 0, errors.New("metadata index is already being rebuilt")
+	}
+	i.rebuilding = true
+	i.dirty = make(map[string]struct{})
+	i.mu.Unlock()
+
+	start := time.Now()
+	files, err := i.scan(ctx)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	dirty := i.dirty
+	i.rebuilding = false
+	i.dirty = nil
+	if err != nil {
+		return 0, err
+	}
+	for name := range dirty {
+		delete(files, name)
+		if fi, err := i.store.Stat(ctx, name); err == nil {
+			files[name] = fi
+		}
+	}
+	i.files = files
+	i.ready = true
+
+	indexedFiles.Set(float64(len(files)))
+	rebuildDuration.Set(time.Since(start).Seconds())
+	log.WithField("files", len(files)).WithField("duration", time.Since(start)).Info("Rebuilt metadata index")
+	return len(files), nil
+}
+
+func (i *Index) scan(ctx context.Context) (map[string]*storage.FileInfo, error) {
+	names, err := i.store.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list files")
+	}
+	files := make(map[string]*storage.FileInfo, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fi, err := i.store.Stat(ctx, name)
+		if err != nil {
+			if errors.Is(err, &storage.NotFoundError{Name: name}) {
+				// removed while scanning
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to stat %s", name)
+		}
+		if fi.Dir {
+			continue
+		}
+		fi.Name = name
+		files[name] = fi
+	}
+	return files, nil
+}
+
+// Update re-reads the file info of name from the store after it was written
+// or its metadata changed.
+func (i *Index) Update(ctx context.Context, name string) {
+	fi, err := i.store.Stat(ctx, name)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.markDirty(name)
+	if err != nil {
+		if !errors.Is(err, &storage.NotFoundError{Name: name}) {
+			log.WithField("name", name).Warnf("Failed to update metadata index (%s)", err)
+		}
+		delete(i.files, name)
+	} else {
+		fi.Name = name
+		i.files[name] = fi
+	}
+	indexedFiles.Set(float64(len(i.files)))
+}
+
+// Rename moves the entry of old to new.
+func (i *Index) Rename(old, new string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.markDirty(old)
+	i.markDirty(new)
+	fi, ok := i.files[old]
+	if !ok {
+		return
+	}
+	delete(i.files, old)
+	renamed := *fi
+	renamed.Name = new
+	i.files[new] = &renamed
+}
+
+// Remove drops the entry of name.
+func (i *Index) Remove(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.markDirty(name)
+	delete(i.files, name)
+	indexedFiles.Set(float64(len(i.files)))
+}
+
+// Lookup returns the indexed file info of name.
+func (i *Index) Lookup(name string) (*storage.FileInfo, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.ready {
+		return nil, ErrNotReady
+	}
+	fi, ok := i.files[name]
+	if !ok {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	copied := *fi
+	return &copied, nil
+}
+
+// Expired returns the names of the files which expired before now.
+func (i *Index) Expired(now time.Time) ([]string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.ready {
+		return nil, ErrNotReady
+	}
+	var names []string
+	for name, fi := range i.files {
+		if fi.Expired(now) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (i *Index) markDirty(name string) {
+	if i.rebuilding {
+		i.dirty[name] = struct{}{}
+	}
+}
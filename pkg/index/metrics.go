@@ -0,0 +1,36 @@
+package index
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "index")
+
+var (
+	indexedFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "index",
+		Name:      "files",
+	})
+	rebuildDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "index",
+		Name:      "rebuild_duration_seconds",
+	})
+	queriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "index",
+		Name:      "queries_total",
+	})
+)
+
+// Collectors returns the metadata index metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		indexedFiles,
+		rebuildDuration,
+		queriesTotal,
+	}
+}
@@ -0,0 +1,279 @@
+package index
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	// DefaultPageSize is the page size of queries which don't set one.
+	DefaultPageSize = 100
+	// MaxPageSize bounds the page size of queries.
+	MaxPageSize = 1000
+)
+
+// SortField is the field query results are ordered by, ties are ordered by
+// name.
+type SortField string
+
+const (
+	SortName    SortField = "name"
+	SortSize    SortField = "size"
+	SortModTime SortField = "mod_time"
+)
+
+// TagOp is the comparison of a tag predicate.
+type TagOp string
+
+const (
+	TagEqual     TagOp = "="
+	TagNotEqual  TagOp = "!="
+	TagExists    TagOp = "exists"
+	TagNotExists TagOp = "not-exists"
+)
+
+// TagPredicate matches files by a metadata key.
+type TagPredicate struct {
+	Key   string
+	Op    TagOp
+	Value string
+}
+
+// ParseTagPredicate parses key=value, key!=value, key (the key exists) and
+// !key (the key doesn't exist).
+func ParseTagPredicate(s string) (TagPredicate, error) {
+	if key, value, ok := strings.Cut(s, "!="); ok {
+		if key == "" {
+			return TagPredicate{}, errors.Errorf("invalid tag predicate %q", s)
+		}
+		return TagPredicate{Key: key, Op: TagNotEqual, Value: value}, nil
+	}
+	if key, value, ok := strings.Cut(s, "="); ok {
+		if key == "" {
+			return TagPredicate{}, errors.Errorf("invalid tag predicate %q", s)
+		}
+		return TagPredicate{Key: key, Op: TagEqual, Value: value}, nil
+	}
+	if key := strings.TrimPrefix(s, "!"); key != s {
+		if key == "" {
+			return TagPredicate{}, errors.Errorf("invalid tag predicate %q", s)
+		}
+		return TagPredicate{Key: key, Op: TagNotExists}, nil
+	}
+	if s == "" {
+		return TagPredicate{}, errors.New("empty tag predicate")
+	}
+	return TagPredicate{Key: s, Op: TagExists}, nil
+}
+
+func (p TagPredicate) match(metadata map[string]string) bool {
+	value, ok := metadata[p.Key]
+	switch p.Op {
+	case TagEqual:
+		return ok && value == p.Value
+	case TagNotEqual:
+		return !ok || value != p.Value
+	case TagExists:
+		return ok
+	case TagNotExists:
+		return !ok
+	}
+	return false
+}
+
+// Query selects files, zero fields don't restrict the result.
+type Query struct {
+	// NamePattern is a glob as understood by path.Match.
+	NamePattern    string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Tags           []TagPredicate
+
+	Sort       SortField
+	Descending bool
+	PageSize   int
+	// PageToken continues a previous query, it is returned with each page.
+	PageToken string
+}
+
+// Validate checks the query for invalid patterns and ranges.
+func (q *Query) Validate() error {
+	if _, err := path.Match(q.NamePattern, ""); err != nil {
+		return errors.Errorf("invalid name pattern %q", q.NamePattern)
+	}
+	if q.MinSize < 0 || q.MaxSize < 0 {
+		return errors.New("sizes can't be negative")
+	}
+	if q.MaxSize > 0 && q.MinSize > q.MaxSize {
+		return errors.New("minimum size is larger than the maximum size")
+	}
+	switch q.Sort {
+	case "", SortName, SortSize, SortModTime:
+	default:
+		return errors.Errorf("unknown sort field %q", q.Sort)
+	}
+	if q.PageSize < 0 {
+		return errors.New("page size can't be negative")
+	}
+	for _, tag := range q.Tags {
+		switch tag.Op {
+		case TagEqual, TagNotEqual, TagExists, TagNotExists:
+		default:
+			return errors.Errorf("unknown tag operator %q", tag.Op)
+		}
+		if tag.Key == "" {
+			return errors.New("tag predicate without a key")
+		}
+	}
+	if q.PageToken != "" {
+		if _, err := decodeCursor(q.PageToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Query) match(fi *storage.FileInfo) bool {
+	if q.NamePattern != "" {
+		if ok, _ := path.Match(q.NamePattern, fi.Name); !ok {
+			return false
+		}
+	}
+	if fi.Size < q.MinSize || (q.MaxSize > 0 && fi.Size > q.MaxSize) {
+		return false
+	}
+	if !q.ModifiedAfter.IsZero() && !fi.ModTime.After(q.ModifiedAfter) {
+		return false
+	}
+	if !q.ModifiedBefore.IsZero() && !fi.ModTime.Before(q.ModifiedBefore) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !tag.match(fi.Metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders a before b by the sort field of the query.
+func (q *Query) less(a, b *storage.FileInfo) bool {
+	var c int
+	switch q.Sort {
+	case SortSize:
+		c = compareInt(a.Size, b.Size)
+	case SortModTime:
+		c = compareInt(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if q.Descending {
+		return c > 0
+	}
+	return c < 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// cursor is the last file of a page, the next page starts after it.
+type cursor struct {
+	Name    string    `json:"n"`
+	Size    int64     `json:"s"`
+	ModTime time.Time `json:"t"`
+}
+
+func encodeCursor(fi *storage.FileInfo) string {
+	b, _ := json.Marshal(cursor{Name: fi.Name, Size: fi.Size, ModTime: fi.ModTime})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token string) (*storage.FileInfo, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid page token")
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.New("invalid page token")
+	}
+	return &storage.FileInfo{Name: c.Name, Size: c.Size, ModTime: c.ModTime}, nil
+}
+
+// Result is a page of matching files.
+type Result struct {
+	Files []*storage.FileInfo
+	// NextPageToken continues the query, it is empty on the last page.
+	NextPageToken string
+}
+
+// Query returns a page of the files matching q, expired files are left out.
+// Pages continue after the last file of the previous page, so files changed
+// between pages are neither skipped nor returned twice unless their sort key
+// changed.
+func (i *Index) Query(q *Query) (*Result, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	pageSize := q.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	var after *storage.FileInfo
+	if q.PageToken != "" {
+		after, _ = decodeCursor(q.PageToken)
+	}
+
+	i.mu.RLock()
+	if !i.ready {
+		i.mu.RUnlock()
+		return nil, ErrNotReady
+	}
+	now := time.Now()
+	var matches []*storage.FileInfo
+	for _, fi := range i.files {
+		if after != nil && !q.less(after, fi) {
+			continue
+		}
+		if fi.Expired(now) {
+			continue
+		}
+		if q.match(fi) {
+			copied := *fi
+			matches = append(matches, &copied)
+		}
+	}
+	i.mu.RUnlock()
+	queriesTotal.Inc()
+
+	sort.Slice(matches, func(a, b int) bool {
+		return q.less(matches[a], matches[b])
+	})
+	result := &Result{Files: matches}
+	if len(matches) > pageSize {
+		result.Files = matches[:pageSize]
+		result.NextPageToken = encodeCursor(result.Files[pageSize-1])
+	}
+	return result, nil
+}
@@ -0,0 +1,224 @@
+package lifecycle
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// Op is the action a rule takes on a file.
+type Op string
+
+const (
+	OpTransition Op = "transition"
+	OpExpire     Op = "expire"
+)
+
+// Action is a pending or applied action on a file.
+type Action struct {
+	Rule    string    `json:"rule"`
+	Name    string    `json:"name"`
+	Op      Op        `json:"op"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Report summarizes a run, the actions of a dry run are only evaluated.
+type Report struct {
+	Checked int      `json:"checked"`
+	DryRun  bool     `json:"dry_run"`
+	Actions []Action `json:"actions,omitempty"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// Executor applies the actions, so they take the same path as the requests
+// of clients.
+type Executor interface {
+	// Transition moves name to the cold tier.
+	Transition(ctx context.Context, name string) error
+	// Expire removes name.
+	Expire(ctx context.Context, name string) error
+}
+
+// New returns an engine evaluating rules against the files of store.
+func New(store storage.Storage, executor Executor, rules []Rule) (*Engine, error) {
+	if err := ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return &Engine{
+		store:    store,
+		executor: executor,
+		rules:    rules,
+	}, nil
+}
+
+// Engine evaluates the lifecycle rules against the files of a store and
+// applies the resulting actions.
+type Engine struct {
+	store    storage.Storage
+	executor Executor
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// Rules returns the current rules.
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]Rule(nil), e.rules...)
+}
+
+// SetRules replaces the rules, they apply from the next run on.
+func (e *Engine) SetRules(rules []Rule) error {
+	if err := ValidateRules(rules); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = append([]Rule(nil), rules...)
+	return nil
+}
+
+// Run evaluates the rules against all files and applies the actions unless
+// dryRun is set. A file matched by several rules is expired if any of them
+// expires it, and transitioned otherwise. Files failing an action are
+// reported, the run is aborted if the store is read-only.
+func (e *Engine) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	start := time.Now()
+	rules := e.Rules()
+	report := &Report{DryRun: dryRun}
+	if len(rules) == 0 {
+		return report, nil
+	}
+
+	names, err := e.store.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list files")
+	}
+	var files []file
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fi, err := e.store.Stat(ctx, name)
+		if err != nil {
+			if errors.Is(err, &storage.NotFoundError{Name: name}) {
+				// removed while running
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to stat %s", name)
+		}
+		if fi.Dir {
+			continue
+		}
+		files = append(files, file{name: name, info: fi})
+	}
+	report.Checked = len(files)
+
+	excess := excessVersions(rules, files)
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := f.name
+		action, ok := evaluate(rules, name, f.info, start, excess)
+		if !ok {
+			continue
+		}
+		report.Actions = append(report.Actions, action)
+		if dryRun {
+			continue
+		}
+
+		scopedLog := log.WithField("name", name).WithField("rule", action.Rule).WithField("op", action.Op)
+		switch action.Op {
+		case OpTransition:
+			err = e.executor.Transition(ctx, name)
+		case OpExpire:
+			err = e.executor.Expire(ctx, name)
+		}
+		if err != nil {
+			if errors.Is(err, &storage.ReadOnlyError{}) {
+				return nil, err
+			}
+			failuresTotal.WithLabelValues(string(action.Op)).Inc()
+			report.Failed = append(report.Failed, name)
+			scopedLog.Errorf("Failed to apply lifecycle action (%s)", err)
+			continue
+		}
+		actionsTotal.WithLabelValues(string(action.Op)).Inc()
+		scopedLog.Info("Applied lifecycle action")
+	}
+
+	if !dryRun {
+		lastRun.SetToCurrentTime()
+		runDuration.Set(time.Since(start).Seconds())
+	}
+	return report, nil
+}
+
+type file struct {
+	name string
+	info *storage.FileInfo
+}
+
+// excessVersions returns the names of the files beyond the versions each
+// rule keeps, by rule ID. The most recently modified files are kept.
+func excessVersions(rules []Rule, files []file) map[string]map[string]bool {
+	excess := make(map[string]map[string]bool)
+	for _, rule := range rules {
+		if rule.KeepVersions == 0 {
+			continue
+		}
+		var versions []file
+		for _, f := range files {
+			if rule.match(f.name) {
+				versions = append(versions, f)
+			}
+		}
+		if len(versions) <= rule.KeepVersions {
+			continue
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			if !versions[i].info.ModTime.Equal(versions[j].info.ModTime) {
+				return versions[i].info.ModTime.After(versions[j].info.ModTime)
+			}
+			return versions[i].name > versions[j].name
+		})
+		names := make(map[string]bool, len(versions)-rule.KeepVersions)
+		for _, f := range versions[rule.KeepVersions:] {
+			names[f.name] = true
+		}
+		excess[rule.ID] = names
+	}
+	return excess
+}
+
+// evaluate returns the action the rules take on the file fi at now, excess
+// holds the files beyond the versions of each rule. Locked files aren't
+// expired.
+func evaluate(rules []Rule, name string, fi *storage.FileInfo, now time.Time, excess map[string]map[string]bool) (Action, bool) {
+	age := now.Sub(fi.ModTime)
+	var transition *Rule
+	for i, rule := range rules {
+		if !rule.match(name) {
+			continue
+		}
+		expired := rule.ExpireAfter > 0 && age >= rule.ExpireAfter
+		if (expired || excess[rule.ID][name]) && !fi.Locked(now) {
+			return Action{Rule: rule.ID, Name: name, Op: OpExpire, ModTime: fi.ModTime}, true
+		}
+		if transition == nil && rule.TransitionAfter > 0 && age >= rule.TransitionAfter && fi.Tier != storage.TierCold {
+			transition = &rules[i]
+		}
+	}
+	if transition != nil {
+		return Action{Rule: transition.ID, Name: name, Op: OpTransition, ModTime: fi.ModTime}, true
+	}
+	return Action{}, false
+}
@@ -0,0 +1,57 @@
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+type recorder struct {
+	expired []string
+}
+
+func (r *recorder) Transition(ctx context.Context, name string) error { return nil }
+
+func (r *recorder) Expire(ctx context.Context, name string) error {
+	r.expired = append(r.expired, name)
+	return nil
+}
+
+func TestKeepVersions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := local.New(dir)
+
+	now := time.Now()
+	for i, name := range []string{"db-1", "db-2", "db-3", "db-4", "other"} {
+		if err := store.Write(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executor := &recorder{}
+	engine, err := New(store, executor, []Rule{{ID: "backups", Prefix: "db-", KeepVersions: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := engine.Run(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 {
+		t.Fatalf("checked %d files, want 5", report.Checked)
+	}
+	sort.Strings(executor.expired)
+	if len(executor.expired) != 2 || executor.expired[0] != "db-1" || executor.expired[1] != "db-2" {
+		t.Fatalf("expired %v, want the oldest versions [db-1 db-2]", executor.expired)
+	}
+}
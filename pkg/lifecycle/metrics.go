@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "lifecycle")
+
+var (
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "lifecycle",
+		Name:      "actions_total",
+	}, []string{"op"})
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "lifecycle",
+		Name:      "failures_total",
+	}, []string{"op"})
+	lastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "lifecycle",
+		Name:      "last_run_timestamp_seconds",
+	})
+	runDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "lifecycle",
+		Name:      "run_duration_seconds",
+	})
+)
+
+// Collectors returns the lifecycle metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		actionsTotal,
+		failuresTotal,
+		lastRun,
+		runDuration,
+	}
+}
@@ -0,0 +1,105 @@
+package lifecycle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rule applies to the files whose name starts with Prefix, an empty prefix
+// matches all files. Files are moved to the cold tier TransitionAfter and
+// removed ExpireAfter their last modification, a zero duration disables the
+// action. The matching files are the versions of the rule, e.g. the backups
+// named with the prefix "db-", only the KeepVersions most recently modified
+// of them are kept if it is set.
+type Rule struct {
+	ID              string        `json:"id"`
+	Prefix          string        `json:"prefix,omitempty"`
+	TransitionAfter time.Duration `json:"transition_after,omitempty"`
+	ExpireAfter     time.Duration `json:"expire_after,omitempty"`
+	KeepVersions    int           `json:"keep_versions,omitempty"`
+}
+
+// ParseRule parses a rule given as comma separated key=value pairs, e.g.
+// "id=logs,prefix=logs/,transition-after=7d,expire-after=30d,keep-versions=5".
+// Durations are Go durations or a number of days with the suffix d.
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Rule{}, errors.Errorf("rule %q must be a list of key=value", s)
+		}
+		var err error
+		switch key {
+		case "id":
+			rule.ID = value
+		case "prefix":
+			rule.Prefix = value
+		case "transition-after":
+			rule.TransitionAfter, err = parseAge(value)
+		case "expire-after":
+			rule.ExpireAfter, err = parseAge(value)
+		case "keep-versions":
+			rule.KeepVersions, err = strconv.Atoi(value)
+		default:
+			return Rule{}, errors.Errorf("rule %q has an unknown key %q", s, key)
+		}
+		if err != nil {
+			return Rule{}, errors.Wrapf(err, "invalid rule %q", s)
+		}
+	}
+	return rule, nil
+}
+
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Validate checks that r has an ID and at least one action.
+func (r Rule) Validate() error {
+	if r.ID == "" {
+		return errors.New("rule without an id")
+	}
+	if r.TransitionAfter < 0 || r.ExpireAfter < 0 {
+		return errors.Errorf("rule %s has a negative age", r.ID)
+	}
+	if r.KeepVersions < 0 {
+		return errors.Errorf("rule %s keeps a negative number of versions", r.ID)
+	}
+	if r.TransitionAfter == 0 && r.ExpireAfter == 0 && r.KeepVersions == 0 {
+		return errors.Errorf("rule %s has neither a transition, an expiry nor a number of versions", r.ID)
+	}
+	if r.TransitionAfter > 0 && r.ExpireAfter > 0 && r.TransitionAfter >= r.ExpireAfter {
+		return errors.Errorf("rule %s expires files before they transition", r.ID)
+	}
+	return nil
+}
+
+// ValidateRules validates every rule and checks that the IDs are unique.
+func ValidateRules(rules []Rule) error {
+	ids := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if _, ok := ids[rule.ID]; ok {
+			return errors.Errorf("duplicate rule id %s", rule.ID)
+		}
+		ids[rule.ID] = struct{}{}
+	}
+	return nil
+}
+
+func (r Rule) match(name string) bool {
+	return strings.HasPrefix(name, r.Prefix)
+}
@@ -0,0 +1,26 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type levelResponse struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler returns a handler reporting the current log level on GET. It
+// is served without authentication, so the level is only changed through the
+// LogLevel RPC of the admin service.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&levelResponse{Level: Logger.GetLevel().String()})
+	})
+}
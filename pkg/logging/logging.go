@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -14,18 +15,59 @@ const (
 	Subsys = "subsys"
 )
 
+const (
+	// FormatText selects the human readable key=value format
+	FormatText = "text"
+
+	// FormatJSON selects one JSON object per line
+	FormatJSON = "json"
+)
+
 // Logger is the default logger
 var Logger = defaultLogger()
 
 func defaultLogger() *logrus.Logger {
 	logger := logrus.New()
-	logger.Formatter = &logrus.TextFormatter{
+	logger.Formatter = textFormatter()
+	logger.SetLevel(logrus.InfoLevel)
+	return logger
+}
+
+func textFormatter() logrus.Formatter {
+	return &logrus.TextFormatter{
 		DisableColors:   true,
 		FullTimestamp:   true,
 		TimestampFormat: time.RFC1123,
 	}
-	logger.SetLevel(logrus.InfoLevel)
-	return logger
+}
+
+func jsonFormatter() logrus.Formatter {
+	return &logrus.JSONFormatter{
+		TimestampFormat: time.RFC3339Nano,
+	}
+}
+
+// SetFormat sets the output format of Logger, either FormatText or FormatJSON.
+func SetFormat(format string) error {
+	switch format {
+	case FormatText:
+		Logger.SetFormatter(textFormatter())
+	case FormatJSON:
+		Logger.SetFormatter(jsonFormatter())
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+// SetLevel parses level and sets it on Logger.
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	SetLogLevel(l)
+	return nil
 }
 
 // SetLogLevel sets the log loggel on Logger.
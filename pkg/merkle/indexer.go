@@ -0,0 +1,107 @@
+package merkle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/logging"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "merkle")
+
+// NewIndexer builds trees of the files in store. A tree is reused for maxAge,
+// so a sync walking the tree with many requests sees a consistent state.
+func NewIndexer(store storage.Storage, maxAge time.Duration) *Indexer {
+	return &Indexer{
+		store:     store,
+		maxAge:    maxAge,
+		checksums: make(map[string]cachedChecksum),
+	}
+}
+
+// Indexer caches the checksums of the files by size and modification time,
+// so rebuilding a tree only reads the files which changed.
+type Indexer struct {
+	store  storage.Storage
+	maxAge time.Duration
+
+	mu        sync.Mutex
+	tree      *Tree
+	checksums map[string]cachedChecksum
+}
+
+type cachedChecksum struct {
+	size     int64
+	modTime  time.Time
+	checksum string
+}
+
+// Tree returns the current tree, it is rebuilt if it is older than maxAge or
+// refresh is set.
+func (i *Indexer) Tree(ctx context.Context, refresh bool) (*Tree, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.tree != nil && !refresh && time.Since(i.tree.BuildTime()) < i.maxAge {
+		return i.tree, nil
+	}
+
+	start := time.Now()
+	entries, err := i.scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i.tree = Build(entries)
+	log.WithField("files", len(entries)).WithField("duration", time.Since(start)).Debug("Built Merkle tree")
+	return i.tree, nil
+}
+
+func (i *Indexer) scan(ctx context.Context) ([]Entry, error) {
+	names, err := i.store.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list files")
+	}
+
+	checksums := make(map[string]cachedChecksum, len(names))
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fi, err := i.store.Stat(ctx, name)
+		if err != nil {
+			if errors.Is(err, &storage.NotFoundError{Name: name}) {
+				// removed while scanning
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to stat %s", name)
+		}
+
+		cached, ok := i.checksums[name]
+		if !ok || cached.size != fi.Size || !cached.modTime.Equal(fi.ModTime) {
+			data, err := i.store.Read(ctx, name)
+			if err != nil {
+				if errors.Is(err, &storage.NotFoundError{Name: name}) {
+					continue
+				}
+				return nil, errors.Wrapf(err, "failed to read %s", name)
+			}
+			sum := sha256.Sum256(data)
+			cached = cachedChecksum{
+				size:     int64(len(data)),
+				modTime:  fi.ModTime,
+				checksum: hex.EncodeToString(sum[:]),
+			}
+		}
+		checksums[name] = cached
+		entries = append(entries, Entry{Name: name, Size: cached.size, Checksum: cached.checksum})
+	}
+	i.checksums = checksums
+	return entries, nil
+}
@@ -0,0 +1,155 @@
+// Package merkle summarizes a namespace in a Merkle tree, so two servers can
+// find the files they disagree on by exchanging the hashes of the subtrees
+// which differ only.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Fanout is the number of children of an inner node.
+	Fanout = 16
+	// LeafDepth is the depth of the leaves, every leaf holds the files whose
+	// name hash starts with the leaf's prefix of LeafDepth hex digits.
+	LeafDepth = 3
+)
+
+// Entry is a file as it is compared between servers.
+type Entry struct {
+	Name     string
+	Size     int64
+	Checksum string
+}
+
+// Node is a node of the tree. Inner nodes have the hashes of their children,
+// leaves the entries they cover.
+type Node struct {
+	Prefix   string
+	Hash     []byte
+	Children [][]byte
+	Entries  []Entry
+}
+
+// Leaf reports whether the node is a leaf.
+func (n *Node) Leaf() bool {
+	return len(n.Prefix) == LeafDepth
+}
+
+// Build builds the tree of entries. Files are placed by the hash of their name,
+// so the same files end up in the same leaves on every server.
+func Build(entries []Entry) *Tree {
+	t := &Tree{
+		levels:    make([][][]byte, LeafDepth+1),
+		leaves:    make([][]Entry, pow(Fanout, LeafDepth)),
+		files:     len(entries),
+		buildTime: time.Now(),
+	}
+	for _, entry := range entries {
+		i := bucket(entry.Name)
+		t.leaves[i] = append(t.leaves[i], entry)
+	}
+
+	leafHashes := make([][]byte, len(t.leaves))
+	for i, leaf := range t.leaves {
+		sort.Slice(leaf, func(a, b int) bool { return leaf[a].Name < leaf[b].Name })
+		h := sha256.New()
+		for _, entry := range leaf {
+			fmt.Fprintf(h, "%s\x00%d\x00%s\n", entry.Name, entry.Size, entry.Checksum)
+		}
+		leafHashes[i] = h.Sum(nil)
+	}
+	t.levels[LeafDepth] = leafHashes
+
+	for depth := LeafDepth - 1; depth >= 0; depth-- {
+		children := t.levels[depth+1]
+		hashes := make([][]byte, len(children)/Fanout)
+		for i := range hashes {
+			hashes[i] = hashChildren(children[i*Fanout : (i+1)*Fanout])
+		}
+		t.levels[depth] = hashes
+	}
+	return t
+}
+
+// Tree is an immutable Merkle tree over the files of a namespace.
+type Tree struct {
+	levels    [][][]byte
+	leaves    [][]Entry
+	files     int
+	buildTime time.Time
+}
+
+// Root returns the hash of the whole namespace.
+func (t *Tree) Root() []byte {
+	return t.levels[0][0]
+}
+
+// Files returns the number of files in the tree.
+func (t *Tree) Files() int {
+	return t.files
+}
+
+// BuildTime returns when the tree was built.
+func (t *Tree) BuildTime() time.Time {
+	return t.buildTime
+}
+
+// Node returns the node at prefix, a string of up to LeafDepth hex digits.
+// The empty prefix is the root.
+func (t *Tree) Node(prefix string) (*Node, error) {
+	if len(prefix) > LeafDepth {
+		return nil, fmt.Errorf("prefix %q is deeper than the leaves", prefix)
+	}
+	var index int
+	if prefix != "" {
+		i, err := strconv.ParseUint(prefix, Fanout, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+		index = int(i)
+	}
+
+	node := &Node{
+		Prefix: prefix,
+		Hash:   t.levels[len(prefix)][index],
+	}
+	if node.Leaf() {
+		node.Entries = t.leaves[index]
+	} else {
+		node.Children = t.levels[len(prefix)+1][index*Fanout : (index+1)*Fanout]
+	}
+	return node, nil
+}
+
+// ChildPrefix returns the prefix of the i-th child of the node at prefix.
+func ChildPrefix(prefix string, i int) string {
+	return prefix + strconv.FormatInt(int64(i), Fanout)
+}
+
+// bucket returns the index of the leaf covering name.
+func bucket(name string) int {
+	sum := sha256.Sum256([]byte(name))
+	i, _ := strconv.ParseUint(hex.EncodeToString(sum[:])[:LeafDepth], Fanout, 32)
+	return int(i)
+}
+
+func hashChildren(children [][]byte) []byte {
+	h := sha256.New()
+	h.Write(bytes.Join(children, nil))
+	return h.Sum(nil)
+}
+
+func pow(base, exp int) int {
+	result := 1
+	for i := 0; i < exp; i++ {
+		result *= base
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package replication
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const appliedFile = "applied"
+
+// ErrOutOfOrder is returned for entries which don't directly follow the last
+// applied entry.
+var ErrOutOfOrder = errors.New("entry is out of order")
+
+// NewApplier applies the entries shipped by a primary to store. The seq of
+// the last applied entry is persisted in dir, so the primary can continue
+// where it left off after a restart of the replica.
+func NewApplier(store storage.Storage, dir string) (*Applier, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create replication directory")
+	}
+	applied, err := readSeq(filepath.Join(dir, appliedFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read applied seq")
+	}
+	appliedSeq.Set(float64(applied))
+
+	return &Applier{
+		store:   store,
+		dir:     dir,
+		applied: applied,
+	}, nil
+}
+
+type Applier struct {
+	store storage.Storage
+	dir   string
+
+	mu      sync.Mutex
+	applied uint64
+}
+
+// AppliedSeq returns the seq of the last applied entry.
+func (a *Applier) AppliedSeq() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.applied
+}
+
+// Apply applies entry unless it has been applied before. Entries have to be
+// applied in order.
+//
+// An entry may be applied a second time if the replica stopped before
+// persisting its seq, so applying is idempotent: existing files are
+// overwritten, missing files of renames, copies and removes are ignored and
+// appends are only applied at their offset.
+func (a *Applier) Apply(ctx context.Context, entry *Entry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if entry.Seq <= a.applied {
+		return nil
+	}
+	if entry.Seq != a.applied+1 {
+		return errors.Wrapf(ErrOutOfOrder, "expected entry %d, got %d", a.applied+1, entry.Seq)
+	}
+
+	var err error
+	switch entry.Op {
+	case OpWrite:
+		err = a.write(ctx, entry.Name, entry.Data)
+	case OpRename:
+		err = a.rename(ctx, entry.Name, entry.NewName)
+	case OpRemove:
+		err = a.remove(ctx, entry.Name)
+	case OpSetMetadata:
+		err = storage.SetMetadata(ctx, a.store, entry.Name, entry.Metadata)
+	case OpCopy:
+		err = a.copy(ctx, entry.Name, entry.NewName)
+	case OpAppend:
+		err = a.append(ctx, entry.Name, entry.Data, entry.Offset)
+	default:
+		err = errors.Errorf("unknown operation %q", entry.Op)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to apply entry %d", entry.Seq)
+	}
+
+	if err := writeSeq(filepath.Join(a.dir, appliedFile), entry.Seq); err != nil {
+		return errors.Wrap(err, "failed to persist applied seq")
+	}
+	a.applied = entry.Seq
+	appliedSeq.Set(float64(entry.Seq))
+	appliedTimestamp.Set(float64(entry.Time.UnixNano()) / 1e9)
+	return nil
+}
+
+func (a *Applier) write(ctx context.Context, name string, data []byte) error {
+	err := a.store.Write(ctx, name, data)
+	if errors.Is(err, &storage.AlreadyExistsError{Name: name}) {
+		if err := a.remove(ctx, name); err != nil {
+			return err
+		}
+		err = a.store.Write(ctx, name, data)
+	}
+	return err
+}
+
+func (a *Applier) rename(ctx context.Context, old, new string) error {
+	err := a.store.Rename(ctx, old, new)
+	switch {
+	case errors.Is(err, &storage.NotFoundError{Name: old}):
+		// already renamed
+		if _, err := a.store.Stat(ctx, new); err == nil {
+			return nil
+		}
+		return err
+	case errors.Is(err, &storage.AlreadyExistsError{Name: new}):
+		if err := a.remove(ctx, new); err != nil {
+			return err
+		}
+		return a.store.Rename(ctx, old, new)
+	}
+	return err
+}
+
+func (a *Applier) copy(ctx context.Context, src, dst string) error {
+	err := storage.Copy(ctx, a.store, src, dst)
+	switch {
+	case errors.Is(err, &storage.NotFoundError{Name: src}):
+		// already copied and src was removed meanwhile
+		if _, err := a.store.Stat(ctx, dst); err == nil {
+			return nil
+		}
+		return err
+	case errors.Is(err, &storage.AlreadyExistsError{Name: dst}):
+		if err := a.remove(ctx, dst); err != nil {
+			return err
+		}
+		return storage.Copy(ctx, a.store, src, dst)
+	}
+	return err
+}
+
+func (a *Applier) append(ctx context.Context, name string, data []byte, offset int64) error {
+	_, err := storage.Append(ctx, a.store, name, data, offset)
+	var mismatch *storage.OffsetMismatchError
+	if errors.As(err, &mismatch) && mismatch.Actual == offset+int64(len(data)) {
+		// already appended
+		return nil
+	}
+	return err
+}
+
+func (a *Applier) remove(ctx context.Context, name string) error {
+	err := a.store.Remove(ctx, name)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, &storage.NotFoundError{Name: name}) {
+		return nil
+	}
+	return err
+}
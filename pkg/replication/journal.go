@@ -0,0 +1,139 @@
+package replication
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// NewJournal wraps store so that every successful mutation is appended to the
+// replication log once a log is attached. Without a log the journal passes all
+// calls through.
+func NewJournal(store storage.Storage) *Journal {
+	return &Journal{
+		store: store,
+	}
+}
+
+// Journal records the mutations of a storage in a replication log. Mutations
+// are serialized, so the order of the log matches the order in which they
+// were committed.
+type Journal struct {
+	store storage.Storage
+
+	mu  sync.Mutex
+	log *Log
+}
+
+// Attach starts recording mutations in log.
+func (j *Journal) Attach(log *Log) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.log = log
+}
+
+func (j *Journal) record(entry *Entry) error {
+	if j.log == nil {
+		return nil
+	}
+	if err := j.log.Append(entry); err != nil {
+		// the mutation is committed locally but the replicas won't see it
+		log.WithField("name", entry.Name).Errorf("Failed to append to the replication log (%s)", err)
+		return errors.Wrap(err, "failed to append to the replication log")
+	}
+	return nil
+}
+
+func (j *Journal) Read(ctx context.Context, name string) ([]byte, error) {
+	return j.store.Read(ctx, name)
+}
+
+func (j *Journal) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return storage.ReadRange(ctx, j.store, name, offset, length)
+}
+
+func (j *Journal) Write(ctx context.Context, name string, data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.store.Write(ctx, name, data); err != nil {
+		return err
+	}
+	return j.record(&Entry{Op: OpWrite, Name: name, Data: data})
+}
+
+func (j *Journal) List(ctx context.Context) ([]string, error) {
+	return j.store.List(ctx)
+}
+
+func (j *Journal) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	return j.store.Stat(ctx, name)
+}
+
+func (j *Journal) Rename(ctx context.Context, old, new string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.store.Rename(ctx, old, new); err != nil {
+		return err
+	}
+	return j.record(&Entry{Op: OpRename, Name: old, NewName: new})
+}
+
+// Append is recorded with the offset data was appended at, so a replica can
+// tell whether it has applied it already.
+func (j *Journal) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	size, err := storage.Append(ctx, j.store, name, data, offset)
+	if err != nil {
+		return 0, err
+	}
+	return size, j.record(&Entry{Op: OpAppend, Name: name, Data: data, Offset: size - int64(len(data))})
+}
+
+// Copy is recorded as a copy, so the replicas copy their own file instead of
+// receiving the content again.
+func (j *Journal) Copy(ctx context.Context, src, dst string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := storage.Copy(ctx, j.store, src, dst); err != nil {
+		return err
+	}
+	return j.record(&Entry{Op: OpCopy, Name: src, NewName: dst})
+}
+
+func (j *Journal) Remove(ctx context.Context, name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.store.Remove(ctx, name); err != nil {
+		return err
+	}
+	return j.record(&Entry{Op: OpRemove, Name: name})
+}
+
+func (j *Journal) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := storage.SetMetadata(ctx, j.store, name, metadata); err != nil {
+		return err
+	}
+	return j.record(&Entry{Op: OpSetMetadata, Name: name, Metadata: metadata})
+}
+
+// SetTier isn't recorded, every server places its files on its own tiers.
+func (j *Journal) SetTier(ctx context.Context, name, tier string) error {
+	return storage.SetTier(ctx, j.store, name, tier)
+}
+
+func (j *Journal) Close() error {
+	return j.store.Close()
+}
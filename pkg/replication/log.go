@@ -0,0 +1,269 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	entriesDir    = "entries"
+	truncatedFile = "truncated"
+)
+
+// Op is the kind of mutation recorded by a log entry.
+type Op string
+
+const (
+	OpWrite  Op = "write"
+	OpRename Op = "rename"
+	OpRemove Op = "remove"
+	OpCopy   Op = "copy"
+	OpAppend Op = "append"
+
+	OpSetMetadata Op = "set-metadata"
+)
+
+// Entry is a committed mutation of the primary's storage.
+type Entry struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Op      Op        `json:"op"`
+	Name    string    `json:"name"`
+	NewName string    `json:"new_name,omitempty"`
+	Data    []byte    `json:"-"`
+	// Offset is the size of the file before the append of an OpAppend.
+	Offset int64 `json:"offset,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// OpenLog opens the replication log in dir, creating it if necessary. A new
+// log continues after start, so a promoted replica keeps numbering entries
+// where its primary stopped.
+//
+// Every entry is stored in its own file: a JSON header line followed by the
+// data of writes. Entries acknowledged by all replicas are removed with
+// Truncate.
+func OpenLog(dir string, start uint64) (*Log, error) {
+	if err := os.MkdirAll(filepath.Join(dir, entriesDir), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create replication log")
+	}
+	l := &Log{
+		dir:    dir,
+		notify: make(chan struct{}),
+	}
+
+	truncated, err := l.readTruncated()
+	if err != nil {
+		return nil, err
+	}
+	if truncated < start {
+		truncated = start
+	}
+	l.first = truncated + 1
+	l.last = truncated
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, entriesDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read replication log")
+	}
+	for _, fi := range files {
+		seq, err := strconv.ParseUint(fi.Name(), 10, 64)
+		if err != nil {
+			// leftover temporary file of an interrupted append
+			os.Remove(filepath.Join(dir, entriesDir, fi.Name()))
+			continue
+		}
+		if seq <= truncated {
+			// left behind by an interrupted truncation or an older log
+			os.Remove(filepath.Join(dir, entriesDir, fi.Name()))
+			continue
+		}
+		if seq > l.last {
+			l.last = seq
+		}
+	}
+	return l, nil
+}
+
+type Log struct {
+	dir string
+
+	mu     sync.Mutex
+	first  uint64
+	last   uint64
+	notify chan struct{}
+}
+
+func (l *Log) entryPath(seq uint64) string {
+	return filepath.Join(l.dir, entriesDir, fmt.Sprintf("%020d", seq))
+}
+
+// Append assigns the next seq to entry and persists it.
+func (l *Log) Append(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.last + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	header, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := l.entryPath(entry.Seq)
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create log entry")
+	}
+	w := bufio.NewWriter(fd)
+	w.Write(header)
+	w.WriteByte('\n')
+	w.Write(entry.Data)
+	if err := w.Flush(); err != nil {
+		fd.Close()
+		return errors.Wrap(err, "failed to write log entry")
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return errors.Wrap(err, "failed to sync log entry")
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return errors.Wrap(err, "failed to commit log entry")
+	}
+
+	l.last = entry.Seq
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return nil
+}
+
+// Read returns the entry with the given seq.
+func (l *Log) Read(seq uint64) (*Entry, error) {
+	l.mu.Lock()
+	first, last := l.first, l.last
+	l.mu.Unlock()
+	if seq < first || seq > last {
+		return nil, errors.Errorf("entry %d is not in the log (%d-%d)", seq, first, last)
+	}
+
+	b, err := ioutil.ReadFile(l.entryPath(seq))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read log entry %d", seq)
+	}
+	i := 0
+	for i < len(b) && b[i] != '\n' {
+		i++
+	}
+	if i == len(b) {
+		return nil, errors.Errorf("log entry %d is corrupt", seq)
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(b[:i], entry); err != nil {
+		return nil, errors.Wrapf(err, "log entry %d is corrupt", seq)
+	}
+	entry.Data = b[i+1:]
+	return entry, nil
+}
+
+// FirstSeq returns the seq of the oldest entry still in the log.
+func (l *Log) FirstSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.first
+}
+
+// LastSeq returns the seq of the most recent entry, or of the entry the log
+// started after if it is empty.
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Wait returns a channel which is closed on the next Append.
+func (l *Log) Wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.notify
+}
+
+// Truncate removes all entries up to and including seq.
+func (l *Log) Truncate(seq uint64) error {
+	l.mu.Lock()
+	if seq > l.last {
+		seq = l.last
+	}
+	if seq < l.first {
+		l.mu.Unlock()
+		return nil
+	}
+	first := l.first
+	l.first = seq + 1
+	l.mu.Unlock()
+
+	// record the truncation first, so the seq survives removing all entries
+	if err := writeSeq(filepath.Join(l.dir, truncatedFile), seq); err != nil {
+		return err
+	}
+	for s := first; s <= seq; s++ {
+		if err := os.Remove(l.entryPath(s)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove log entry %d", s)
+		}
+	}
+	return nil
+}
+
+func (l *Log) readTruncated() (uint64, error) {
+	seq, err := readSeq(filepath.Join(l.dir, truncatedFile))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read replication log")
+	}
+	return seq, nil
+}
+
+// readSeq reads a seq persisted by writeSeq, a missing file reads as 0.
+func readSeq(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// writeSeq atomically persists seq at path.
+func writeSeq(path string, seq uint64) error {
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.WriteString(strconv.FormatUint(seq, 10)); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
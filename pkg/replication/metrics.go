@@ -0,0 +1,57 @@
+package replication
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "replication")
+
+var (
+	// primary metrics
+	lastSeq = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "last_seq",
+	})
+	lagEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "lag_entries",
+	}, []string{"replica"})
+	lagSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "lag_seconds",
+	}, []string{"replica"})
+	shipErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "ship_errors_total",
+	}, []string{"replica"})
+
+	// replica metrics
+	appliedSeq = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "applied_seq",
+	})
+	appliedTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "replication",
+		Name:      "applied_timestamp_seconds",
+	})
+)
+
+// Collectors returns the replication metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		lastSeq,
+		lagEntries,
+		lagSeconds,
+		shipErrorsTotal,
+		appliedSeq,
+		appliedTimestamp,
+	}
+}
@@ -0,0 +1,135 @@
+package replication_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/server"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func serve(t *testing.T, options ...server.Option) *server.Server {
+	t.Helper()
+	srv, err := server.New(append([]server.Option{server.WithAddr("127.0.0.1")}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve() }()
+	for srv.Addr() == nil {
+		select {
+		case err := <-errc:
+			t.Fatalf("failed to serve: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return srv
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func write(t *testing.T, client api.StorageClient, name, content string) {
+	t.Helper()
+	stream, err := client.Write(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&api.WriteRequest{Member: &api.WriteRequest_Name{Name: name}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&api.WriteRequest{Member: &api.WriteRequest_Data{Data: []byte(content)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func TestShipAndCatchUp(t *testing.T) {
+	ctx := context.Background()
+	replicaPort := freePort(t)
+	replicaStorage, replicaPath := t.TempDir(), t.TempDir()
+	startReplica := func() *server.Server {
+		return serve(t,
+			server.WithPort(replicaPort),
+			server.WithStoragePath(replicaStorage),
+			server.WithReplication(server.RoleReplica, replicaPath),
+		)
+	}
+	replica := startReplica()
+
+	primary := serve(t,
+		server.WithStoragePath(t.TempDir()),
+		server.WithReplication(server.RolePrimary, t.TempDir(), net.JoinHostPort("127.0.0.1", strconv.Itoa(replicaPort))),
+	)
+	defer primary.Stop()
+
+	conn, err := grpc.Dial(primary.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := api.NewStorageClient(conn)
+
+	write(t, client, "a", "first")
+	write(t, client, "b", "second")
+	if _, err := client.Rename(ctx, &api.RenameRequest{Old: "a", New: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Remove(ctx, &api.RemoveRequest{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the replica to apply the write, rename and remove", func() bool {
+		return exists(filepath.Join(replicaStorage, "c")) &&
+			!exists(filepath.Join(replicaStorage, "a")) &&
+			!exists(filepath.Join(replicaStorage, "b"))
+	})
+	data, err := os.ReadFile(filepath.Join(replicaStorage, "c"))
+	if err != nil || string(data) != "first" {
+		t.Fatalf("replica has %q (%v), want %q", data, err, "first")
+	}
+
+	// mutations while the replica is down are shipped once it is back
+	replica.Stop()
+	write(t, client, "d", "third")
+	if _, err := client.Remove(ctx, &api.RemoveRequest{Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	replica = startReplica()
+	defer replica.Stop()
+	waitFor(t, "the restarted replica to catch up", func() bool {
+		return exists(filepath.Join(replicaStorage, "d")) && !exists(filepath.Join(replicaStorage, "c"))
+	})
+}
@@ -0,0 +1,430 @@
+package replication
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+)
+
+const (
+	replicasFile = "replicas.json"
+
+	// limits of a single Replicate stream
+	maxBatchEntries = 64
+	maxBatchBytes   = 16 * 1024 * 1024
+	chunkSize       = 1024 * 1024
+
+	shipTimeout = 5 * time.Minute
+	minBackoff  = 500 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+)
+
+type ShipperOption func(*Shipper)
+
+// WithTLSConfig dials the replicas with TLS, the shipper dials insecure
+// connections otherwise.
+func WithTLSConfig(config *tls.Config) ShipperOption {
+	return func(s *Shipper) {
+		s.tlsConfig = config
+	}
+}
+
+// NewShipper ships the entries of log to the replicas at targets. The seq
+// acknowledged by each replica is persisted in dir, entries acknowledged by
+// all replicas are truncated from the log.
+func NewShipper(log *Log, dir string, targets []string, options ...ShipperOption) *Shipper {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Shipper{
+		log:    log,
+		dir:    dir,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	for _, target := range targets {
+		s.replicas = append(s.replicas, &replica{target: target})
+	}
+	return s
+}
+
+type Shipper struct {
+	log       *Log
+	dir       string
+	tlsConfig *tls.Config
+	replicas  []*replica
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// serializes persisting acknowledged seqs and truncating the log
+	mu sync.Mutex
+}
+
+type replica struct {
+	target string
+	conn   *grpc.ClientConn
+	client api.ReplicationClient
+
+	mu    sync.Mutex
+	known bool // acked has been confirmed by the replica
+	acked uint64
+	err   error
+}
+
+// ReplicaStatus describes how far a replica is behind the primary.
+type ReplicaStatus struct {
+	Target   string
+	AckedSeq uint64
+	Lag      uint64
+	LagTime  time.Duration
+	Error    string
+}
+
+// Start connects to the replicas and ships entries in the background until
+// Stop is called.
+func (s *Shipper) Start() error {
+	acked, err := s.readAcked()
+	if err != nil {
+		return err
+	}
+
+	var dialOptions []grpc.DialOption
+	if s.tlsConfig != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(s.tlsConfig)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+	for _, r := range s.replicas {
+		conn, err := grpc.DialContext(s.ctx, r.target, dialOptions...)
+		if err != nil {
+			s.Stop()
+			return errors.Wrapf(err, "failed to dial replica %s", r.target)
+		}
+		r.conn = conn
+		r.client = api.NewReplicationClient(conn)
+		r.acked = acked[r.target]
+	}
+
+	lastSeq.Set(float64(s.log.LastSeq()))
+	for _, r := range s.replicas {
+		s.wg.Add(1)
+		go s.run(r)
+	}
+	return nil
+}
+
+// Stop stops shipping and waits for in-flight streams to return.
+func (s *Shipper) Stop() {
+	s.cancel()
+	s.wg.Wait()
+	for _, r := range s.replicas {
+		if r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// Status returns the status of every replica.
+func (s *Shipper) Status() []ReplicaStatus {
+	last := s.log.LastSeq()
+
+	var result []ReplicaStatus
+	for _, r := range s.replicas {
+		r.mu.Lock()
+		status := ReplicaStatus{
+			Target:   r.target,
+			AckedSeq: r.acked,
+		}
+		if r.err != nil {
+			status.Error = r.err.Error()
+		}
+		r.mu.Unlock()
+
+		if status.AckedSeq < last {
+			status.Lag = last - status.AckedSeq
+			status.LagTime = s.lagTime(status.AckedSeq)
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// lagTime returns the age of the first entry after acked.
+func (s *Shipper) lagTime(acked uint64) time.Duration {
+	entry, err := s.log.Read(acked + 1)
+	if err != nil {
+		return 0
+	}
+	return time.Since(entry.Time)
+}
+
+func (s *Shipper) run(r *replica) {
+	defer s.wg.Done()
+
+	scopedLog := log.WithField("replica", r.target)
+	backoff := minBackoff
+	for {
+		// fetch the channel before shipping, so appends while shipping aren't missed
+		wait := s.log.Wait()
+
+		err := s.ship(r)
+		r.mu.Lock()
+		r.err = err
+		if err != nil {
+			// ask the replica for its position again, it may have been reset
+			r.known = false
+		}
+		acked := r.acked
+		r.mu.Unlock()
+
+		last := s.log.LastSeq()
+		lastSeq.Set(float64(last))
+		if acked < last {
+			lagEntries.WithLabelValues(r.target).Set(float64(last - acked))
+			lagSeconds.WithLabelValues(r.target).Set(s.lagTime(acked).Seconds())
+		} else {
+			lagEntries.WithLabelValues(r.target).Set(0)
+			lagSeconds.WithLabelValues(r.target).Set(0)
+		}
+
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			shipErrorsTotal.WithLabelValues(r.target).Inc()
+			scopedLog.Warnf("Failed to ship the replication log, retrying in %s (%s)", backoff, err)
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		if acked < last {
+			continue
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-wait:
+		}
+	}
+}
+
+// ship sends all entries the replica hasn't acknowledged yet.
+func (s *Shipper) ship(r *replica) error {
+	ctx, cancel := context.WithTimeout(s.ctx, shipTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	known, acked := r.known, r.acked
+	r.mu.Unlock()
+
+	if !known {
+		applied, err := s.send(ctx, r, nil)
+		if err != nil {
+			return err
+		}
+		log.WithField("replica", r.target).Infof("Replica is at entry %d", applied)
+		acked = applied
+		s.setAcked(r, acked)
+	}
+
+	for {
+		first, last := s.log.FirstSeq(), s.log.LastSeq()
+		if acked >= last {
+			if acked > last {
+				return errors.Errorf("replica is at entry %d, ahead of the primary at %d", acked, last)
+			}
+			return nil
+		}
+		if acked+1 < first {
+			return errors.Errorf("replica is at entry %d but the log starts at %d, the replica needs a full resync",
+				acked, first)
+		}
+
+		var entries []*Entry
+		var size int
+		for seq := acked + 1; seq <= last && len(entries) < maxBatchEntries && size < maxBatchBytes; seq++ {
+			entry, err := s.log.Read(seq)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			size += len(entry.Data)
+		}
+
+		applied, err := s.send(ctx, r, entries)
+		if err != nil {
+			return err
+		}
+		if applied <= acked {
+			return errors.Errorf("replica made no progress past entry %d", acked)
+		}
+		acked = applied
+		s.setAcked(r, acked)
+	}
+}
+
+// send streams entries to the replica and returns the seq it has applied.
+func (s *Shipper) send(ctx context.Context, r *replica, entries []*Entry) (uint64, error) {
+	stream, err := r.client.Replicate(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		req := &api.ReplicateRequest{
+			Seq:     entry.Seq,
+			Op:      opToAPI(entry.Op),
+			Name:    entry.Name,
+			NewName: entry.NewName,
+			Offset:  entry.Offset,
+			Time:    timestamppb.New(entry.Time),
+
+			Metadata: entry.Metadata,
+		}
+		data := entry.Data
+		for {
+			n := len(data)
+			if n > chunkSize {
+				n = chunkSize
+			}
+			req.Data = data[:n]
+			if err := stream.Send(req); err != nil {
+				if errors.Is(err, io.EOF) {
+					// the replica aborted the stream, its status has the reason
+					_, err = stream.CloseAndRecv()
+				}
+				return 0, err
+			}
+			data = data[n:]
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return resp.AppliedSeq, nil
+}
+
+// setAcked records the seq confirmed by the replica and truncates the entries
+// acknowledged by all replicas.
+func (s *Shipper) setAcked(r *replica, acked uint64) {
+	r.mu.Lock()
+	r.acked = acked
+	r.known = true
+	r.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make(map[string]uint64, len(s.replicas))
+	min := acked
+	for _, r := range s.replicas {
+		r.mu.Lock()
+		positions[r.target] = r.acked
+		if r.acked < min {
+			min = r.acked
+		}
+		r.mu.Unlock()
+	}
+	if err := s.writeAcked(positions); err != nil {
+		log.Errorf("Failed to persist the replica positions (%s)", err)
+		return
+	}
+	if err := s.log.Truncate(min); err != nil {
+		log.Errorf("Failed to truncate the replication log (%s)", err)
+	}
+}
+
+func (s *Shipper) readAcked() (map[string]uint64, error) {
+	positions := make(map[string]uint64)
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, replicasFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return positions, nil
+		}
+		return nil, errors.Wrap(err, "failed to read replica positions")
+	}
+	if err := json.Unmarshal(b, &positions); err != nil {
+		return nil, errors.Wrap(err, "failed to parse replica positions")
+	}
+	return positions, nil
+}
+
+func (s *Shipper) writeAcked(positions map[string]uint64) error {
+	b, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, replicasFile)
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func opToAPI(op Op) api.ReplicationOp {
+	switch op {
+	case OpWrite:
+		return api.ReplicationOp_REPLICATION_OP_WRITE
+	case OpRename:
+		return api.ReplicationOp_REPLICATION_OP_RENAME
+	case OpRemove:
+		return api.ReplicationOp_REPLICATION_OP_REMOVE
+	case OpSetMetadata:
+		return api.ReplicationOp_REPLICATION_OP_SET_METADATA
+	case OpCopy:
+		return api.ReplicationOp_REPLICATION_OP_COPY
+	case OpAppend:
+		return api.ReplicationOp_REPLICATION_OP_APPEND
+	default:
+		return api.ReplicationOp_REPLICATION_OP_UNSPECIFIED
+	}
+}
+
+// OpFromAPI converts the operation of a ReplicateRequest.
+func OpFromAPI(op api.ReplicationOp) (Op, error) {
+	switch op {
+	case api.ReplicationOp_REPLICATION_OP_WRITE:
+		return OpWrite, nil
+	case api.ReplicationOp_REPLICATION_OP_RENAME:
+		return OpRename, nil
+	case api.ReplicationOp_REPLICATION_OP_REMOVE:
+		return OpRemove, nil
+	case api.ReplicationOp_REPLICATION_OP_SET_METADATA:
+		return OpSetMetadata, nil
+	case api.ReplicationOp_REPLICATION_OP_COPY:
+		return OpCopy, nil
+	case api.ReplicationOp_REPLICATION_OP_APPEND:
+		return OpAppend, nil
+	default:
+		return "", errors.Errorf("unknown operation %s", op)
+	}
+}
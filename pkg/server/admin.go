@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/logging"
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/version"
+)
+
+const (
+	jobKindScrub      = "scrub"
+	jobKindGC         = "gc"
+	jobKindHeal       = "heal"
+	jobKindRotateKeys = "rotate-keys"
+	jobKindReindex    = "reindex"
+	jobKindLifecycle  = "lifecycle"
+)
+
+func NewAdminService(srv *Server) *AdminService {
+	return &AdminService{
+		srv: srv,
+	}
+}
+
+// AdminService exposes introspection and maintenance of a running server.
+type AdminService struct {
+	api.UnimplementedAdminServer
+
+	srv *Server
+}
+
+func (s *AdminService) Info(ctx context.Context, req *api.InfoRequest) (*api.InfoResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	info := version.Get()
+	resp := &api.InfoResponse{
+		Id: s.srv.options.Id,
+		BuildInfo: &api.BuildInfo{
+			Version:   info.Version,
+			GitCommit: info.GitCommit,
+			GoVersion: info.GoVersion,
+			Os:        info.OS,
+			Arch:      info.Arch,
+		},
+		StartTime:         timestamppb.New(s.srv.startTime),
+		Uptime:            durationpb.New(time.Since(s.srv.startTime)),
+		ActiveConnections: s.srv.stats.activeConns(),
+		ActiveStreams:     s.srv.stats.activeStreams(),
+	}
+	mode, reason := s.srv.storageService.Mode()
+	resp.Mode = modeToAPI(mode)
+	resp.ModeReason = reason
+	resp.ReadOnly = mode != ModeReadWrite
+
+	if reporter, ok := s.srv.backend.(storage.CapacityReporter); ok {
+		capacity, err := reporter.Capacity(ctx)
+		if err != nil {
+			requestLog(ctx).Errorf("Failed to determine capacity (%s)", err)
+			return nil, status.Errorf(codes.Internal, "failed to determine capacity")
+		}
+		resp.Capacity = &api.Capacity{
+			Total: capacity.Total,
+			Free:  capacity.Free,
+			Used:  capacity.Used,
+		}
+	}
+	return resp, nil
+}
+
+func (s *AdminService) ListJobs(ctx context.Context, req *api.ListJobsRequest) (*api.ListJobsResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	var jobs []*api.Job
+	for _, job := range s.srv.jobs.list() {
+		jobs = append(jobs, jobToAPI(job))
+	}
+	return &api.ListJobsResponse{Jobs: jobs}, nil
+}
+
+func (s *AdminService) Scrub(ctx context.Context, req *api.ScrubRequest) (*api.ScrubResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling scrub request")
+
+	job, err := s.srv.jobs.start(jobKindScrub, s.scrub)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "scrub job %s is already running", job.ID)
+	}
+	return &api.ScrubResponse{Job: jobToAPI(job)}, nil
+}
+
+func (s *AdminService) GC(ctx context.Context, req *api.GCRequest) (*api.GCResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling gc request")
+
+	collector, ok := s.srv.backend.(storage.GarbageCollector)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "storage backend doesn't need garbage collection")
+	}
+	job, err := s.srv.jobs.start(jobKindGC, func(ctx context.Context) (string, error) {
+		report, err := collector.GC(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("removed %d objects, freed %d bytes", report.Removed, report.FreedBytes), nil
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "gc job %s is already running", job.ID)
+	}
+	return &api.GCResponse{Job: jobToAPI(job)}, nil
+}
+
+func (s *AdminService) Heal(ctx context.Context, req *api.HealRequest) (*api.HealResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling heal request")
+
+	healer, ok := s.srv.backend.(storage.Healer)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "storage backend has no redundancy to heal")
+	}
+	job, err := s.srv.jobs.start(jobKindHeal, func(ctx context.Context) (string, error) {
+		report, err := healer.Heal(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, name := range report.Unrecoverable {
+			log.WithField("name", name).Error("Heal found an unrecoverable file")
+		}
+		return fmt.Sprintf("checked %d files, healed %d, rebuilt %d shards, found %d unrecoverable", report.Checked,
+			report.Healed, report.Rebuilt, len(report.Unrecoverable)), nil
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "heal job %s is already running", job.ID)
+	}
+	return &api.HealResponse{Job: jobToAPI(job)}, nil
+}
+
+func (s *AdminService) SetReadOnly(ctx context.Context, req *api.SetReadOnlyRequest) (*api.SetReadOnlyResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	mode := ModeReadWrite
+	if req.ReadOnly {
+		mode = ModeReadOnly
+	}
+	requestLog(ctx).WithField("mode", mode).Info("Handling set read-only request")
+	if err := s.srv.SetMode(mode, ""); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+	}
+	return &api.SetReadOnlyResponse{}, nil
+}
+
+func (s *AdminService) SetMode(ctx context.Context, req *api.SetModeRequest) (*api.SetModeResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	mode, err := modeFromAPI(req.Mode)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	requestLog(ctx).WithField("mode", mode).Info("Handling set mode request")
+	if err := s.srv.SetMode(mode, req.Reason); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+	}
+	return &api.SetModeResponse{}, nil
+}
+
+func (s *AdminService) LogLevel(ctx context.Context, req *api.LogLevelRequest) (*api.LogLevelResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if req.Level != "" {
+		requestLog(ctx).WithField("level", req.Level).Info("Handling log level request")
+		if err := logging.SetLevel(req.Level); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+		}
+		log.Warnf("Changed log level to %s", logging.Logger.GetLevel())
+	}
+	return &api.LogLevelResponse{Level: logging.Logger.GetLevel().String()}, nil
+}
+
+func (s *AdminService) scrub(ctx context.Context) (string, error) {
+	var report *storage.ScrubReport
+	var err error
+	if scrubber, ok := s.srv.backend.(storage.Scrubber); ok {
+		report, err = scrubber.Scrub(ctx)
+	} else {
+		report, err = scrubStorage(ctx, s.srv.store)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, name := range report.Corrupt {
+		log.WithField("name", name).Error("Scrub found a corrupt file")
+	}
+	return fmt.Sprintf("checked %d files, repaired %d, found %d corrupt", report.Checked,
+		report.Repaired, len(report.Corrupt)), nil
+}
+
+// scrubStorage reads back every file of store.
+func scrubStorage(ctx context.Context, store storage.Storage) (*storage.ScrubReport, error) {
+	files, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.ScrubReport{}
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if _, err := store.Read(ctx, name); err != nil {
+			report.Corrupt = append(report.Corrupt, name)
+		}
+		report.Checked++
+	}
+	return report, nil
+}
+
+func jobToAPI(job Job) *api.Job {
+	j := &api.Job{
+		Id:        job.ID,
+		Kind:      job.Kind,
+		State:     job.State,
+		Message:   job.Message,
+		StartTime: timestamppb.New(job.StartTime),
+	}
+	if !job.EndTime.IsZero() {
+		j.EndTime = timestamppb.New(job.EndTime)
+	}
+	return j
+}
@@ -0,0 +1,395 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultAuditMaxSize = 100 * 1024 * 1024
+
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+
+	auditRotationTimeFormat = "20060102T150405.000000000"
+	auditMaxLineSize        = 1024 * 1024
+)
+
+// AuditEntry is a single line of the audit log. Every entry carries the hash
+// of its predecessor, so removing, reordering or modifying entries breaks the
+// chain.
+type AuditEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	Identity  string    `json:"identity"`
+	Peer      string    `json:"peer,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	NewName   string    `json:"new_name,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+func (e *AuditEntry) computeHash() (string, error) {
+	unsealed := *e
+	unsealed.Hash = ""
+	b, err := json.Marshal(&unsealed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type AuditOption func(*Auditor)
+
+// WithAuditorMaxSize sets the size in bytes after which the audit log is rotated.
+func WithAuditorMaxSize(size int64) AuditOption {
+	return func(a *Auditor) {
+		a.maxSize = size
+	}
+}
+
+// WithAuditorReads enables auditing of non-mutating operations.
+func WithAuditorReads(reads bool) AuditOption {
+	return func(a *Auditor) {
+		a.reads = reads
+	}
+}
+
+// NewAuditor opens the audit log at path, continuing the hash chain of an
+// existing log. A partial entry at the end of the log, left by an interrupted
+// write, is removed.
+func NewAuditor(path string, options ...AuditOption) (*Auditor, error) {
+	a := &Auditor{
+		path:    path,
+		maxSize: defaultAuditMaxSize,
+	}
+	for _, option := range options {
+		option(a)
+	}
+
+	if err := truncateTornAuditEntry(path); err != nil {
+		return nil, errors.Wrap(err, "failed to repair audit log")
+	}
+	files, err := auditFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastAuditEntry(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			a.seq = last.Seq
+			a.prevHash = last.Hash
+			break
+		}
+	}
+
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type Auditor struct {
+	path    string
+	maxSize int64
+	reads   bool
+
+	mu       sync.Mutex
+	fd       *os.File
+	size     int64
+	seq      uint64
+	prevHash string
+}
+
+// Record seals entry into the hash chain and appends it to the log.
+func (a *Auditor) Record(entry *AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.Seq = a.seq + 1
+	entry.PrevHash = a.prevHash
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return errors.Wrap(err, "failed to rotate audit log")
+		}
+	}
+
+	n, err := a.fd.Write(line)
+	if err != nil {
+		// don't leave a partial entry for the next one to be appended to
+		if n > 0 && a.fd.Truncate(a.size) != nil {
+			a.size += int64(n)
+		}
+		return err
+	}
+	a.size += int64(n)
+	a.seq = entry.Seq
+	a.prevHash = entry.Hash
+	return a.fd.Sync()
+}
+
+func (a *Auditor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.fd.Close()
+}
+
+func (a *Auditor) open() error {
+	fd, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	a.fd = fd
+	a.size = fi.Size()
+	return nil
+}
+
+func (a *Auditor) rotate() error {
+	if err := a.fd.Close(); err != nil {
+		return err
+	}
+	rotated := a.path + "." + time.Now().UTC().Format(auditRotationTimeFormat)
+	if err := os.Rename(a.path, rotated); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// audit records the outcome of an operation if auditing is enabled for it.
+func (s *StorageService) audit(ctx context.Context, entry *AuditEntry, mutating bool, err error) {
+	if s.auditor == nil || (!mutating && !s.auditor.reads) {
+		return
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Identity = identityFromContext(ctx)
+	entry.Peer = peerFromContext(ctx)
+	entry.RequestID = requestIDFromContext(ctx)
+	entry.Outcome = auditOutcomeSuccess
+	if err != nil {
+		entry.Outcome = auditOutcomeFailure
+		entry.Error = status.Convert(err).Message()
+	}
+
+	if err := s.auditor.Record(entry); err != nil {
+		auditErrorsTotal.Inc()
+		requestLog(ctx).Errorf("Failed to record audit entry (%s)", err)
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditVerifyResult summarizes a successful verification of an audit log.
+// HeadHash is the hash of the last entry, the chain can't detect entries
+// being removed from its end, so it should be compared with a copy kept
+// elsewhere.
+type AuditVerifyResult struct {
+	Files    []string
+	Entries  uint64
+	FirstSeq uint64
+	LastSeq  uint64
+	HeadHash string
+}
+
+// VerifyAuditLog checks the hash chain across the audit log at path and all of
+// its rotated predecessors. The chain has to start with the first entry ever
+// recorded, so removed rotated logs are detected as well.
+func VerifyAuditLog(path string) (*AuditVerifyResult, error) {
+	files, err := auditFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no audit log found at %s", path)
+	}
+
+	result := &AuditVerifyResult{Files: files}
+	var prev *AuditEntry
+	for _, file := range files {
+		err := scanAuditLog(file, func(line int, entry *AuditEntry) error {
+			hash, err := entry.computeHash()
+			if err != nil {
+				return err
+			}
+			if hash != entry.Hash {
+				return errors.Errorf("%s:%d: entry %d has been modified", file, line, entry.Seq)
+			}
+			if prev != nil {
+				if entry.Seq != prev.Seq+1 {
+					return errors.Errorf("%s:%d: expected entry %d, got %d", file, line, prev.Seq+1, entry.Seq)
+				}
+				if entry.PrevHash != prev.Hash {
+					return errors.Errorf("%s:%d: entry %d does not chain to entry %d", file, line, entry.Seq, prev.Seq)
+				}
+			} else {
+				if entry.Seq != 1 || entry.PrevHash != "" {
+					return errors.Errorf("%s:%d: expected entry 1, got %d, the log is incomplete", file, line, entry.Seq)
+				}
+				result.FirstSeq = entry.Seq
+			}
+			prev = entry
+			result.Entries++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if prev != nil {
+		result.LastSeq = prev.Seq
+		result.HeadHash = prev.Hash
+	}
+	return result, nil
+}
+
+// auditFiles returns the rotated audit logs in chronological order followed by
+// the active log, skipping files which don't exist.
+func auditFiles(path string) ([]string, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range rotated {
+		if _, err := time.Parse(auditRotationTimeFormat, file[len(path)+1:]); err == nil {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// truncateTornAuditEntry removes a trailing partial line from the log at path.
+// Entries are written with a single write ending in a newline, so a log not
+// ending in one has been interrupted in the middle of an entry.
+func truncateTornAuditEntry(path string) error {
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil
+	}
+	last := make([]byte, 1)
+	if _, err := fd.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	// find the end of the last complete entry
+	buf := make([]byte, 64*1024)
+	end := size
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := fd.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	log.Warnf("Removing a partial entry of %d bytes from the end of the audit log %s", size-end, path)
+	if err := fd.Truncate(end); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+func lastAuditEntry(path string) (*AuditEntry, error) {
+	var last *AuditEntry
+	err := scanAuditLog(path, func(_ int, entry *AuditEntry) error {
+		last = entry
+		return nil
+	})
+	return last, err
+}
+
+func scanAuditLog(path string, fn func(line int, entry *AuditEntry) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), auditMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: malformed entry (%s)", path, line, err)
+		}
+		if err := fn(line, &entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func recordAuditEntries(t *testing.T, a *Auditor, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := a.Record(&AuditEntry{Operation: "write", Name: "file", Outcome: auditOutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyAuditLogRequiresCompleteChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := NewAuditor(path, WithAuditorMaxSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordAuditEntries(t, a, 10)
+	a.Close()
+
+	result, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FirstSeq != 1 || result.LastSeq != 10 || len(result.Files) < 2 || result.HeadHash == "" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	if err := os.Remove(result.Files[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyAuditLog(path); err == nil {
+		t.Fatal("verified an audit log without its oldest file")
+	}
+}
+
+func TestAuditorTruncatesTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := NewAuditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordAuditEntries(t, a, 3)
+	a.Close()
+
+	fd, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteString(`{"seq":4,"time":`); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	a, err = NewAuditor(path)
+	if err != nil {
+		t.Fatalf("failed to open an audit log with a partial entry: %v", err)
+	}
+	recordAuditEntries(t, a, 1)
+	a.Close()
+
+	result, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 4 || result.LastSeq != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
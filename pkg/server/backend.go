@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/cas"
+	"github.com/peertechde/argon/pkg/storage/erasure"
+	"github.com/peertechde/argon/pkg/storage/local"
+	"github.com/peertechde/argon/pkg/storage/mirror"
+)
+
+const (
+	StorageBackendLocal   = "local"
+	StorageBackendErasure = "erasure"
+	StorageBackendMirror  = "mirror"
+	StorageBackendCAS     = "cas"
+)
+
+// openStorage opens the configured storage backend.
+func (s *Server) openStorage() (storage.Storage, error) {
+	switch s.options.StorageBackend {
+	case "", StorageBackendLocal:
+		return local.New(s.options.StoragePath), nil
+	case StorageBackendErasure:
+		return erasure.New(s.options.ErasureDirs, s.options.ErasureDataShards, s.options.ErasureParityShards)
+	case StorageBackendMirror:
+		var children []storage.Storage
+		for _, dir := range s.options.MirrorDirs {
+			children = append(children, local.New(dir))
+		}
+		return mirror.New(children)
+	case StorageBackendCAS:
+		return cas.New(s.options.StoragePath)
+	default:
+		return nil, errors.Errorf("unknown storage backend %q", s.options.StorageBackend)
+	}
+}
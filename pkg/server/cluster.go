@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/cluster"
+)
+
+// setupCluster starts the Raft member of the server, it replaces the local
+// storage as the backend of the storage service.
+func (s *Server) setupCluster() error {
+	var clusterOptions []cluster.Option
+	clusterOptions = append(clusterOptions, cluster.WithBootstrap(s.options.ClusterBootstrap))
+	// mutations made through other members bypass the local storage service
+	clusterOptions = append(clusterOptions, cluster.WithChangeHandler(s.clusterChanged))
+	if s.tlsReloader != nil {
+		clusterOptions = append(clusterOptions, cluster.WithTLS(s.tlsReloader.TLSConfig(), s.options.PeerTLSConfig))
+	}
+
+	c, err := cluster.New(s.options.Id, s.options.ClusterRaftAddr, s.options.ClusterAPIAddr,
+		s.options.ClusterPath, s.store, clusterOptions...)
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		c.Stop()
+		return err
+	}
+	s.cluster = c
+	return nil
+}
+
+func NewClusterService(srv *Server) *ClusterService {
+	return &ClusterService{
+		srv: srv,
+	}
+}
+
+// ClusterService receives the file bodies staged by the cluster leader. The
+// leader authenticates with an admin identity.
+type ClusterService struct {
+	api.UnimplementedClusterServer
+
+	srv *Server
+}
+
+func (s *ClusterService) PutBlob(stream api.Cluster_PutBlobServer) error {
+	ctx := stream.Context()
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	if s.srv.cluster == nil {
+		return status.Errorf(codes.FailedPrecondition, "server is not a cluster member")
+	}
+
+	var id string
+	var data bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if id == "" {
+			id = req.Id
+		}
+		data.Write(req.Data)
+	}
+	if err := s.srv.cluster.PutBlob(id, data.Bytes()); err != nil {
+		requestLog(ctx).Errorf("Failed to stage blob (%s)", err)
+		return status.Errorf(codes.Internal, "failed to stage blob %s", id)
+	}
+	return stream.SendAndClose(&api.PutBlobResponse{})
+}
+
+// Forward applies an operation another member forwarded to the replicated
+// namespace of this leader. It bypasses the storage service, the forwarding
+// member has applied the trash, retention and validation already.
+func (s *ClusterService) Forward(stream api.Cluster_ForwardServer) error {
+	ctx := stream.Context()
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	if s.srv.cluster == nil {
+		return status.Errorf(codes.FailedPrecondition, "server is not a cluster member")
+	}
+
+	var req *api.ForwardRequest
+	var data bytes.Buffer
+	for {
+		r, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if req == nil {
+			req = r
+		}
+		data.Write(r.Data)
+	}
+	if req == nil {
+		return status.Errorf(codes.InvalidArgument, "missing forwarded operation")
+	}
+	return stream.SendAndClose(s.srv.cluster.Forwarded(ctx, req, data.Bytes()))
+}
+
+// ForwardRead serves the body of a file to another member.
+func (s *ClusterService) ForwardRead(req *api.ForwardRequest, stream api.Cluster_ForwardReadServer) error {
+	ctx := stream.Context()
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	if s.srv.cluster == nil {
+		return status.Errorf(codes.FailedPrecondition, "server is not a cluster member")
+	}
+
+	data, err := s.srv.cluster.ReadForwarded(ctx, req.Name)
+	if err != nil {
+		return stream.Send(&api.ForwardReadResponse{Error: cluster.ErrorToAPI(err)})
+	}
+	for {
+		n := len(data)
+		if n > maxChunkSize {
+			n = maxChunkSize
+		}
+		if err := stream.Send(&api.ForwardReadResponse{Data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *AdminService) clusterMember() (*cluster.Cluster, error) {
+	if s.srv.cluster == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "server is not a cluster member")
+	}
+	return s.srv.cluster, nil
+}
+
+func (s *AdminService) ClusterStatus(ctx context.Context, req *api.ClusterStatusRequest) (*api.ClusterStatusResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := s.clusterMember()
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := c.Status()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get cluster status: %s", err)
+	}
+	resp := &api.ClusterStatusResponse{
+		Id:           st.ID,
+		State:        st.State,
+		LeaderId:     st.LeaderID,
+		LeaderAddr:   st.LeaderAddr,
+		CommitIndex:  st.CommitIndex,
+		AppliedIndex: st.AppliedIndex,
+		MissingFiles: int64(st.MissingFiles),
+	}
+	for _, member := range st.Members {
+		resp.Members = append(resp.Members, &api.ClusterMember{
+			Id:       member.ID,
+			RaftAddr: member.RaftAddr,
+			ApiAddr:  member.APIAddr,
+			Suffrage: member.Suffrage,
+			Leader:   member.Leader,
+		})
+	}
+	return resp, nil
+}
+
+func (s *AdminService) ClusterJoin(ctx context.Context, req *api.ClusterJoinRequest) (*api.ClusterJoinResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := s.clusterMember()
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" || req.RaftAddr == "" || req.ApiAddr == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "id, raft address and api address are required")
+	}
+	requestLog(ctx).WithField("member", req.Id).Info("Handling cluster join request")
+
+	if err := c.AddMember(req.Id, req.RaftAddr, req.ApiAddr); err != nil {
+		return nil, clusterError(err)
+	}
+	return &api.ClusterJoinResponse{}, nil
+}
+
+func (s *AdminService) ClusterRemove(ctx context.Context, req *api.ClusterRemoveRequest) (*api.ClusterRemoveResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := s.clusterMember()
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "id is required")
+	}
+	requestLog(ctx).WithField("member", req.Id).Info("Handling cluster remove request")
+
+	if err := c.RemoveMember(req.Id); err != nil {
+		return nil, clusterError(err)
+	}
+	return &api.ClusterRemoveResponse{}, nil
+}
+
+func clusterError(err error) error {
+	var notLeader *cluster.NotLeaderError
+	if errors.As(err, &notLeader) {
+		return status.Errorf(codes.FailedPrecondition, "%s", err)
+	}
+	return status.Errorf(codes.Internal, "%s", err)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+)
+
+// freeAddr returns a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer serves a server with options until the test ends.
+func startServer(t *testing.T, options ...Option) *Server {
+	t.Helper()
+	path := t.TempDir()
+	options = append([]Option{WithAddr("127.0.0.1"), WithStoragePath(path)}, options...)
+	srv, err := New(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve() }()
+	for srv.Addr() == nil {
+		select {
+		case err := <-errc:
+			t.Fatalf("failed to serve: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+// startMember serves a cluster member on apiAddr.
+func startMember(t *testing.T, id, apiAddr string, bootstrap bool, options ...Option) *Server {
+	t.Helper()
+	_, port, err := net.SplitHostPort(apiAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := net.LookupPort("tcp", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options = append([]Option{
+		WithId(id),
+		WithPort(p),
+		WithCluster(freeAddr(t), apiAddr, filepath.Join(t.TempDir(), "cluster"), bootstrap),
+	}, options...)
+	return startServer(t, options...)
+}
+
+func dial(t *testing.T, addr string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func leaderOf(srv *Server) string {
+	st, err := srv.cluster.Status()
+	if err != nil {
+		return ""
+	}
+	return st.LeaderID
+}
+
+func writeFile(ctx context.Context, client api.StorageClient, name string, data []byte) error {
+	stream, err := client.Write(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&api.WriteRequest{Member: &api.WriteRequest_Name{Name: name}}); err != nil {
+		return err
+	}
+	if err := stream.Send(&api.WriteRequest{Member: &api.WriteRequest_Data{Data: data}}); err != nil {
+		return err
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func TestClusterFollower(t *testing.T) {
+	ctx := context.Background()
+	retention := WithRetentionIdentities(anonymousIdentity)
+
+	leader := startMember(t, "a", freeAddr(t), true, retention, WithTrash(0))
+	waitFor(t, "leader election", func() bool { return leaderOf(leader) == "a" })
+
+	followerAddr := freeAddr(t)
+	follower := startMember(t, "b", followerAddr, false, retention, WithTrash(0))
+	if err := leader.cluster.AddMember("b", follower.options.ClusterRaftAddr, followerAddr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "follower to join", func() bool { return leaderOf(follower) == "a" })
+
+	conn := dial(t, followerAddr)
+	client := api.NewStorageClient(conn)
+	admin := api.NewAdminClient(conn)
+
+	_, err := client.Stat(ctx, &api.StatRequest{Name: "report"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("stat of a missing file through the follower: got %v, want NotFound", err)
+	}
+	if err := writeFile(ctx, client, "report", []byte("content")); err != nil {
+		t.Fatalf("failed to write a new file through the follower: %v", err)
+	}
+	resp, err := client.Stat(ctx, &api.StatRequest{Name: "report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FileInfo.Size != int64(len("content")) {
+		t.Fatalf("got size %d, want %d", resp.FileInfo.Size, len("content"))
+	}
+	// the write bypassed the leader's storage service, both indexes follow
+	// the committed mutations
+	for _, srv := range []*Server{leader, follower} {
+		waitFor(t, "the write to be indexed on "+srv.options.Id, func() bool {
+			_, err := srv.index.Lookup("report")
+			return err == nil
+		})
+	}
+
+	if _, err := admin.SetLegalHold(ctx, &api.SetLegalHoldRequest{Name: "report", Hold: true}); err != nil {
+		t.Fatalf("failed to set a legal hold through the follower: %v", err)
+	}
+	_, err = client.Remove(ctx, &api.RemoveRequest{Name: "report"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("remove of a held file: got %v, want PermissionDenied", err)
+	}
+	if _, err := admin.SetLegalHold(ctx, &api.SetLegalHoldRequest{Name: "report"}); err != nil {
+		t.Fatalf("failed to release the legal hold through the follower: %v", err)
+	}
+	if _, err := client.Remove(ctx, &api.RemoveRequest{Name: "report"}); err != nil {
+		t.Fatalf("failed to remove the released file through the follower: %v", err)
+	}
+	waitFor(t, "the removal to be indexed on the leader", func() bool {
+		_, err := leader.index.Lookup("report")
+		return err != nil
+	})
+
+	trashed, err := client.ListTrash(ctx, &api.ListTrashRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed.Entries) != 1 || trashed.Entries[0].Name != "report" || trashed.Entries[0].Deleter != anonymousIdentity {
+		t.Fatalf("unexpected trash entries %v", trashed.Entries)
+	}
+	if _, err := client.RestoreTrash(ctx, &api.RestoreTrashRequest{Id: trashed.Entries[0].Id}); err != nil {
+		t.Fatalf("failed to restore the file through the follower: %v", err)
+	}
+	if _, err := client.Stat(ctx, &api.StatRequest{Name: "report"}); err != nil {
+		t.Fatalf("failed to stat the restored file: %v", err)
+	}
+}
+
+func TestRetentionWithoutIdentities(t *testing.T) {
+	p := newPolicy(nil, nil, nil)
+	if err := p.authorizeRetention(context.Background()); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got %v, want PermissionDenied", err)
+	}
+	p = newPolicy(nil, []string{anonymousIdentity}, nil)
+	if err := p.authorizeRetention(context.Background()); err != nil {
+		t.Fatalf("admins may manage the retention: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+)
+
+func (s *AdminService) EncryptionKeys(ctx context.Context, req *api.EncryptionKeysRequest) (*api.EncryptionKeysResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.srv.encrypted == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "encryption is disabled")
+	}
+
+	keys, err := s.srv.encrypted.Keys(ctx)
+	if err != nil {
+		requestLog(ctx).Errorf("Failed to list keys (%s)", err)
+		return nil, status.Errorf(codes.Internal, "failed to list keys")
+	}
+	resp := &api.EncryptionKeysResponse{ActiveKey: s.srv.encrypted.ActiveKey()}
+	for _, key := range keys {
+		resp.Files = append(resp.Files, &api.FileKey{Name: key.Name, KeyId: key.KeyID})
+	}
+	return resp, nil
+}
+
+func (s *AdminService) RotateKeys(ctx context.Context, req *api.RotateKeysRequest) (*api.RotateKeysResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling rotate keys request")
+
+	if s.srv.encrypted == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "encryption is disabled")
+	}
+	job, err := s.srv.jobs.start(jobKindRotateKeys, func(ctx context.Context) (string, error) {
+		report, err := s.srv.encrypted.Rotate(ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(report.Failed) > 0 {
+			return "", fmt.Errorf("failed to re-wrap the data keys of %d of %d files", len(report.Failed), report.Checked)
+		}
+		return fmt.Sprintf("checked %d files, re-wrapped %d data keys, removed %d orphaned keys", report.Checked,
+			report.Rewrapped, report.Removed), nil
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "rotate keys job %s is already running", job.ID)
+	}
+	return &api.RotateKeysResponse{Job: jobToAPI(job)}, nil
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/pkg/index"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const defaultExpiryInterval = time.Minute
+
+var (
+	expiredFilesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "expiry",
+		Name:      "expired_files_total",
+	})
+	expiryFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "expiry",
+		Name:      "failures_total",
+	})
+	expiryPendingFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "expiry",
+		Name:      "pending_files",
+	})
+	expiryLastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "expiry",
+		Name:      "last_run_timestamp_seconds",
+	})
+)
+
+// expiryFromAPI returns the expiry time set by a ttl relative to now or an
+// absolute time, ok is false if neither is set.
+func expiryFromAPI(ttl *durationpb.Duration, expiresAt *timestamppb.Timestamp) (t time.Time, ok bool, err error) {
+	switch {
+	case ttl != nil && expiresAt != nil:
+		return time.Time{}, false, errors.New("either a ttl or an expiry time can be set")
+	case ttl != nil:
+		if ttl.AsDuration() <= 0 {
+			return time.Time{}, false, errors.New("ttl has to be positive")
+		}
+		return time.Now().Add(ttl.AsDuration()), true, nil
+	case expiresAt != nil:
+		return expiresAt.AsTime(), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// withExpiry returns a copy of metadata recording the expiry time t.
+func withExpiry(metadata map[string]string, t time.Time) map[string]string {
+	metadata = copyMetadata(metadata)
+	metadata[storage.MetadataExpires] = t.UTC().Format(time.RFC3339Nano)
+	return metadata
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// expired reports whether name has expired, expired files are hidden until
+// the expiry worker removes them.
+func (s *StorageService) expired(ctx context.Context, name string) bool {
+	now := time.Now()
+	if s.index != nil {
+		fi, err := s.index.Lookup(name)
+		if err == nil {
+			return fi.Expired(now)
+		}
+		if !errors.Is(err, index.ErrNotReady) {
+			return false
+		}
+	}
+	fi, err := s.store.Stat(ctx, name)
+	return err == nil && fi.Expired(now)
+}
+
+// withoutExpired filters the expired files from names, it relies on the
+// metadata index and returns names unchanged until it is built.
+func (s *StorageService) withoutExpired(names []string) []string {
+	if s.index == nil {
+		return names
+	}
+	expired, err := s.index.Expired(time.Now())
+	if err != nil || len(expired) == 0 {
+		return names
+	}
+	skip := make(map[string]struct{}, len(expired))
+	for _, name := range expired {
+		skip[name] = struct{}{}
+	}
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := skip[name]; !ok {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// expire removes the expired file name.
+func (s *StorageService) expire(ctx context.Context, name string) error {
+	if err := s.removeFile(ctx, name, "expire"); err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			return nil
+		}
+		return err
+	}
+	expiredFilesTotal.Inc()
+	return nil
+}
+
+// expiryLoop removes expired files every interval until ctx is done.
+func (s *Server) expiryLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireFiles(ctx)
+		}
+	}
+}
+
+func (s *Server) expireFiles(ctx context.Context) {
+	s.mu.Lock()
+	role := s.role
+	s.mu.Unlock()
+	if role == RoleReplica {
+		// the primary's removals are replicated
+		return
+	}
+	if s.cluster != nil && !s.cluster.IsLeader() {
+		// the leader's removals are replicated
+		return
+	}
+
+	names, err := s.index.Expired(time.Now())
+	if err != nil {
+		log.Debugf("Skipping expiry (%s)", err)
+		return
+	}
+	expiryLastRun.SetToCurrentTime()
+	expiryPendingFiles.Set(float64(len(names)))
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		scopedLog := log.WithField("name", name)
+		if err := s.storageService.expire(ctx, name); err != nil {
+			if errors.Is(err, &storage.ReadOnlyError{}) {
+				scopedLog.Debugf("Postponing expiry (%s)", err)
+				return
+			}
+			expiryFailuresTotal.Inc()
+			scopedLog.Errorf("Failed to remove expired file (%s)", err)
+			continue
+		}
+		expiryPendingFiles.Dec()
+		scopedLog.Info("Removed expired file")
+	}
+}
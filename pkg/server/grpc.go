@@ -10,6 +10,10 @@ import (
 
 const (
 	defaultMaxMsgSize = 1024 * 1024 * 4
+	// maxChunkSize bounds the data of a single streamed message, leaving
+	// room for its framing. Clients send chunks of the same size, so the
+	// message size limit must not be below defaultMaxMsgSize.
+	maxChunkSize = defaultMaxMsgSize - 1024
 )
 
 type grpcOption func(*grpcOptions)
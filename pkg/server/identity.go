@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	anonymousIdentity = "anonymous"
+)
+
+// identityFromContext returns the common name of the verified client
+// certificate of the caller or anonymousIdentity.
+func identityFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return anonymousIdentity
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return anonymousIdentity
+	}
+	for _, chain := range tlsInfo.State.VerifiedChains {
+		if len(chain) > 0 && chain[0].Subject.CommonName != "" {
+			return chain[0].Subject.CommonName
+		}
+	}
+	return anonymousIdentity
+}
+
+// peerFromContext returns the address of the caller or an empty string.
+func peerFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/replication"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// reindexRetryInterval is the delay between attempts to rebuild the index
+// while the storage is unavailable.
+const reindexRetryInterval = time.Second
+
+// reindex rebuilds the metadata index from the storage. The rebuild is
+// retried while the storage is unavailable, e.g. while a cluster member
+// doesn't know the leader yet after a start.
+func (s *Server) reindex(ctx context.Context) (string, error) {
+	for {
+		n, err := s.index.Rebuild(ctx)
+		if err == nil {
+			return fmt.Sprintf("indexed %d files", n), nil
+		}
+		if !errors.Is(err, storage.ErrUnavailable) {
+			return "", err
+		}
+		log.Debugf("Retrying to rebuild the metadata index (%s)", err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(reindexRetryInterval):
+		}
+	}
+}
+
+// updateIndex applies a replicated mutation to the metadata index, the
+// applier bypasses the storage service which maintains it otherwise.
+func (s *Server) updateIndex(ctx context.Context, entry *replication.Entry) {
+	switch entry.Op {
+	case replication.OpWrite, replication.OpSetMetadata, replication.OpAppend:
+		s.index.Update(ctx, entry.Name)
+	case replication.OpRename:
+		s.index.Rename(entry.Name, entry.NewName)
+	case replication.OpRemove:
+		s.index.Remove(entry.Name)
+	case replication.OpCopy:
+		s.index.Update(ctx, entry.NewName)
+	}
+}
+
+// clusterChanged updates the metadata index after a committed cluster
+// mutation changed name. Changes applied before the index exists are part of
+// its initial build.
+func (s *Server) clusterChanged(name string) {
+	s.mu.Lock()
+	index := s.index
+	s.mu.Unlock()
+	if index != nil {
+		index.Update(context.Background(), name)
+	}
+}
+
+func (s *AdminService) Reindex(ctx context.Context, req *api.ReindexRequest) (*api.ReindexResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling reindex request")
+
+	job, err := s.srv.jobs.start(jobKindReindex, s.srv.reindex)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "reindex job %s is already running", job.ID)
+	}
+	return &api.ReindexResponse{Job: jobToAPI(job)}, nil
+}
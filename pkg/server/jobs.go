@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	jobStateRunning   = "running"
+	jobStateSucceeded = "succeeded"
+	jobStateFailed    = "failed"
+
+	// finished jobs are kept around for inspection
+	maxFinishedJobs = 32
+)
+
+var errJobRunning = errors.New("job is already running")
+
+// Job is a background task such as a scrub or garbage collection run.
+type Job struct {
+	ID        string
+	Kind      string
+	State     string
+	Message   string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+type jobFunc func(ctx context.Context) (string, error)
+
+func newJobManager() *jobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobManager{
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*Job),
+	}
+}
+
+type jobManager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// start runs fn in the background unless a job of the same kind is running.
+// The message returned by fn is recorded on the job.
+func (m *jobManager) start(kind string, fn jobFunc) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.Kind == kind && job.State == jobStateRunning {
+			return *job, errJobRunning
+		}
+	}
+
+	var id [8]byte
+	rand.Read(id[:])
+	job := &Job{
+		ID:        hex.EncodeToString(id[:]),
+		Kind:      kind,
+		State:     jobStateRunning,
+		StartTime: time.Now(),
+	}
+	m.jobs[job.ID] = job
+	m.prune()
+
+	scopedLog := log.WithField("job", job.ID).WithField("kind", kind)
+	scopedLog.Info("Starting background job")
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		message, err := fn(m.ctx)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		job.EndTime = time.Now()
+		job.Message = message
+		if err != nil {
+			job.State = jobStateFailed
+			job.Message = err.Error()
+			scopedLog.Errorf("Background job failed (%s)", err)
+			return
+		}
+		job.State = jobStateSucceeded
+		scopedLog.Info("Successfully finished background job")
+	}()
+
+	return *job, nil
+}
+
+// list returns a snapshot of all known jobs, most recent first.
+func (m *jobManager) list() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.After(jobs[j].StartTime)
+	})
+	return jobs
+}
+
+// run calls fn in the background with a context which is canceled on stop.
+// Unlike jobs it isn't listed, it suits loops running for the lifetime of the
+// server.
+func (m *jobManager) run(fn func(ctx context.Context)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn(m.ctx)
+	}()
+}
+
+// stop cancels all running jobs and waits for them to return.
+func (m *jobManager) stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *jobManager) prune() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.State != jobStateRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].StartTime.Before(finished[j].StartTime)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, job.ID)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/lifecycle"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const defaultLifecycleInterval = time.Hour
+
+// lifecycleExecutor applies the lifecycle actions through the storage
+// service, so they are audited and reflected in the metadata index.
+type lifecycleExecutor struct {
+	s *StorageService
+}
+
+func (e lifecycleExecutor) Expire(ctx context.Context, name string) error {
+	return e.s.removeFile(ctx, name, "lifecycle-expire")
+}
+
+func (e lifecycleExecutor) Transition(ctx context.Context, name string) (err error) {
+	defer func() { e.s.audit(ctx, &AuditEntry{Operation: "lifecycle-transition", Name: name}, true, err) }()
+
+	if err := storage.SetTier(ctx, e.s.store, name, storage.TierCold); err != nil {
+		return err
+	}
+	if e.s.index != nil {
+		e.s.index.Update(ctx, name)
+	}
+	return nil
+}
+
+// checkLifecycleRules rejects transitions if the backend has no cold tier.
+func (s *Server) checkLifecycleRules(rules []lifecycle.Rule) error {
+	if err := lifecycle.ValidateRules(rules); err != nil {
+		return err
+	}
+	if _, ok := s.backend.(storage.Tierer); ok {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.TransitionAfter > 0 {
+			return errors.Errorf("rule %s transitions files, but the storage has no cold tier", rule.ID)
+		}
+	}
+	return nil
+}
+
+// runLifecycle applies the lifecycle rules.
+func (s *Server) runLifecycle(ctx context.Context) (string, error) {
+	report, err := s.lifecycle.Run(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	var transitioned, expired int
+	for _, action := range report.Actions {
+		switch action.Op {
+		case lifecycle.OpTransition:
+			transitioned++
+		case lifecycle.OpExpire:
+			expired++
+		}
+	}
+	if len(report.Failed) > 0 {
+		return "", fmt.Errorf("failed to apply the lifecycle actions of %d of %d files", len(report.Failed),
+			len(report.Actions))
+	}
+	return fmt.Sprintf("checked %d files, transitioned %d, expired %d", report.Checked, transitioned, expired), nil
+}
+
+// lifecycleLoop starts a lifecycle job every interval until ctx is done.
+func (s *Server) lifecycleLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.Role() == RoleReplica || len(s.lifecycle.Rules()) == 0 {
+			// the primary's actions are replicated
+			continue
+		}
+		if job, err := s.jobs.start(jobKindLifecycle, s.runLifecycle); err != nil {
+			log.WithField("job", job.ID).Debugf("Skipping lifecycle run (%s)", err)
+		}
+	}
+}
+
+func (s *AdminService) LifecycleRules(ctx context.Context, req *api.LifecycleRulesRequest) (*api.LifecycleRulesResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &api.LifecycleRulesResponse{}
+	for _, rule := range s.srv.lifecycle.Rules() {
+		resp.Rules = append(resp.Rules, lifecycleRuleToAPI(rule))
+	}
+	return resp, nil
+}
+
+func (s *AdminService) SetLifecycleRules(ctx context.Context, req *api.SetLifecycleRulesRequest) (*api.SetLifecycleRulesResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling set lifecycle rules request")
+
+	rules := make([]lifecycle.Rule, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		rules = append(rules, lifecycleRuleFromAPI(rule))
+	}
+	if err := s.srv.checkLifecycleRules(rules); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err := s.srv.lifecycle.SetRules(rules); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	requestLog(ctx).WithField("rules", len(rules)).Info("Replaced the lifecycle rules")
+	return &api.SetLifecycleRulesResponse{}, nil
+}
+
+func (s *AdminService) RunLifecycle(ctx context.Context, req *api.RunLifecycleRequest) (*api.RunLifecycleResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).WithField("dry_run", req.DryRun).Info("Handling run lifecycle request")
+
+	if req.DryRun {
+		report, err := s.srv.lifecycle.Run(ctx, true)
+		if err != nil {
+			requestLog(ctx).Errorf("Failed to evaluate the lifecycle rules (%s)", err)
+			return nil, status.Errorf(codes.Internal, "failed to evaluate the lifecycle rules")
+		}
+		resp := &api.RunLifecycleResponse{Checked: int64(report.Checked)}
+		for _, action := range report.Actions {
+			resp.Actions = append(resp.Actions, lifecycleActionToAPI(action))
+		}
+		return resp, nil
+	}
+
+	if s.srv.Role() == RoleReplica {
+		return nil, status.Errorf(codes.FailedPrecondition, "replicas apply the lifecycle actions of the primary")
+	}
+	job, err := s.srv.jobs.start(jobKindLifecycle, s.srv.runLifecycle)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "lifecycle job %s is already running", job.ID)
+	}
+	return &api.RunLifecycleResponse{Job: jobToAPI(job)}, nil
+}
+
+func lifecycleRuleToAPI(rule lifecycle.Rule) *api.LifecycleRule {
+	r := &api.LifecycleRule{Id: rule.ID, Prefix: rule.Prefix, KeepVersions: uint32(rule.KeepVersions)}
+	if rule.TransitionAfter > 0 {
+		r.TransitionAfter = durationpb.New(rule.TransitionAfter)
+	}
+	if rule.ExpireAfter > 0 {
+		r.ExpireAfter = durationpb.New(rule.ExpireAfter)
+	}
+	return r
+}
+
+func lifecycleRuleFromAPI(rule *api.LifecycleRule) lifecycle.Rule {
+	r := lifecycle.Rule{ID: rule.Id, Prefix: rule.Prefix, KeepVersions: int(rule.KeepVersions)}
+	if rule.TransitionAfter != nil {
+		r.TransitionAfter = rule.TransitionAfter.AsDuration()
+	}
+	if rule.ExpireAfter != nil {
+		r.ExpireAfter = rule.ExpireAfter.AsDuration()
+	}
+	return r
+}
+
+func lifecycleActionToAPI(action lifecycle.Action) *api.LifecycleAction {
+	a := &api.LifecycleAction{
+		Rule:    action.Rule,
+		Name:    action.Name,
+		ModTime: timestamppb.New(action.ModTime),
+	}
+	switch action.Op {
+	case lifecycle.OpTransition:
+		a.Op = api.LifecycleOp_LIFECYCLE_OP_TRANSITION
+	case lifecycle.OpExpire:
+		a.Op = api.LifecycleOp_LIFECYCLE_OP_EXPIRE
+	}
+	return a
+}
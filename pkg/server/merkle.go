@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/merkle"
+)
+
+// merkleTreeMaxAge bounds how long a Merkle tree is reused, a sync walks the
+// tree with many requests which should see the same state.
+const merkleTreeMaxAge = time.Minute
+
+func (s *AdminService) MerkleTree(ctx context.Context, req *api.MerkleTreeRequest) (*api.MerkleTreeResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	tree, err := s.srv.merkle.Tree(ctx, req.Refresh)
+	if err != nil {
+		requestLog(ctx).Errorf("Failed to build Merkle tree (%s)", err)
+		return nil, status.Errorf(codes.Internal, "failed to build Merkle tree")
+	}
+	node, err := tree.Node(req.Prefix)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	resp := &api.MerkleTreeResponse{
+		Prefix:    node.Prefix,
+		Hash:      node.Hash,
+		Children:  node.Children,
+		LeafDepth: merkle.LeafDepth,
+		Files:     int64(tree.Files()),
+		BuildTime: timestamppb.New(tree.BuildTime()),
+	}
+	for _, entry := range node.Entries {
+		resp.Entries = append(resp.Entries, &api.MerkleEntry{
+			Name:     entry.Name,
+			Size:     entry.Size,
+			Checksum: entry.Checksum,
+		})
+	}
+	return resp, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc/stats"
@@ -30,19 +31,40 @@ var (
 		Namespace: "grpc",
 		Name:      "requests_total",
 	})
+
+	// audit related metrics
+	auditErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "audit",
+		Name:      "errors_total",
+	})
 )
 
-type grpcStatsHandler struct{}
+// grpcStatsHandler tracks connections and in-flight RPCs, i.e. HTTP/2 streams.
+type grpcStatsHandler struct {
+	conns   int64
+	streams int64
+}
+
+func (h *grpcStatsHandler) activeConns() int64 {
+	return atomic.LoadInt64(&h.conns)
+}
+
+func (h *grpcStatsHandler) activeStreams() int64 {
+	return atomic.LoadInt64(&h.streams)
+}
 
 func (*grpcStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
 	return ctx
 }
 
-func (*grpcStatsHandler) HandleRPC(ctx context.Context, stat stats.RPCStats) {
+func (h *grpcStatsHandler) HandleRPC(ctx context.Context, stat stats.RPCStats) {
 	switch stat.(type) {
 	case *stats.Begin:
+		atomic.AddInt64(&h.streams, 1)
 		grpcRequestsPending.Inc()
 	case *stats.End:
+		atomic.AddInt64(&h.streams, -1)
 		grpcRequestsPending.Dec()
 		grpcRequestsTotal.Inc()
 	case *stats.OutHeader, *stats.InHeader, *stats.InTrailer, *stats.OutTrailer:
@@ -60,12 +82,14 @@ func (*grpcStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) cont
 	return ctx
 }
 
-func (*grpcStatsHandler) HandleConn(ctx context.Context, stat stats.ConnStats) {
+func (h *grpcStatsHandler) HandleConn(ctx context.Context, stat stats.ConnStats) {
 	switch stat.(type) {
 	case *stats.ConnBegin:
+		atomic.AddInt64(&h.conns, 1)
 		grpcConnsOpen.Inc()
 		grpcConnsTotal.Inc()
 	case *stats.ConnEnd:
+		atomic.AddInt64(&h.conns, -1)
 		grpcConnsOpen.Dec()
 	}
 }
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+)
+
+// Mode controls which requests the server accepts. Reads are served in every
+// mode.
+type Mode int
+
+const (
+	// ModeReadWrite accepts all requests.
+	ModeReadWrite Mode = iota
+
+	// ModeReadOnly rejects writes, renames and removes with
+	// codes.FailedPrecondition, clients should not retry.
+	ModeReadOnly
+
+	// ModeMaintenance rejects writes, renames and removes with
+	// codes.Unavailable, clients may retry later.
+	ModeMaintenance
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeReadWrite:
+		return "read-write"
+	case ModeReadOnly:
+		return "read-only"
+	case ModeMaintenance:
+		return "maintenance"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "read-write":
+		return ModeReadWrite, nil
+	case "read-only":
+		return ModeReadOnly, nil
+	case "maintenance":
+		return ModeMaintenance, nil
+	default:
+		return ModeReadWrite, fmt.Errorf("unknown mode %q", s)
+	}
+}
+
+func modeFromAPI(mode api.Mode) (Mode, error) {
+	switch mode {
+	case api.Mode_MODE_READ_WRITE:
+		return ModeReadWrite, nil
+	case api.Mode_MODE_READ_ONLY:
+		return ModeReadOnly, nil
+	case api.Mode_MODE_MAINTENANCE:
+		return ModeMaintenance, nil
+	default:
+		return ModeReadWrite, fmt.Errorf("unknown mode %s", mode)
+	}
+}
+
+func modeToAPI(mode Mode) api.Mode {
+	switch mode {
+	case ModeReadWrite:
+		return api.Mode_MODE_READ_WRITE
+	case ModeReadOnly:
+		return api.Mode_MODE_READ_ONLY
+	case ModeMaintenance:
+		return api.Mode_MODE_MAINTENANCE
+	default:
+		return api.Mode_MODE_UNSPECIFIED
+	}
+}
+
+type modeState struct {
+	mu     sync.RWMutex
+	mode   Mode
+	reason string
+}
+
+func (m *modeState) set(mode Mode, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mode = mode
+	m.reason = reason
+}
+
+func (m *modeState) get() (Mode, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.mode, m.reason
+}
+
+// checkWritable returns the status rejecting a mutating request in the
+// current mode, if any.
+func (m *modeState) checkWritable() error {
+	mode, reason := m.get()
+	if reason == "" {
+		reason = "no reason given"
+	}
+	switch mode {
+	case ModeReadOnly:
+		return status.Errorf(codes.FailedPrecondition, "server is read-only: %s", reason)
+	case ModeMaintenance:
+		return status.Errorf(codes.Unavailable, "server is in maintenance: %s", reason)
+	default:
+		return nil
+	}
+}
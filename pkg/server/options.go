@@ -2,18 +2,65 @@ package server
 
 import (
 	"crypto/tls"
+	"time"
+
+	"github.com/peertechde/argon/pkg/lifecycle"
+	"github.com/peertechde/argon/pkg/storage/compressed"
+	"github.com/peertechde/argon/pkg/storage/tiered"
+	"github.com/peertechde/argon/pkg/storage/worm"
 )
 
 type Option func(*Options)
 
 type Options struct {
-	Id             string
-	Addr           string
-	Port           int
-	TLSConfig      *tls.Config
-	StoragePath    string
-	PrometheusAddr string
-	PrometheusPort int
+	Id                   string
+	Addr                 string
+	Port                 int
+	TLSConfig            *tls.Config
+	TLSCertFile          string
+	TLSKeyFile           string
+	TLSClientCAFile      string
+	TLSClientAuth        tls.ClientAuthType
+	StoragePath          string
+	StorageBackend       string
+	ErasureDirs          []string
+	ErasureDataShards    int
+	ErasureParityShards  int
+	MirrorDirs           []string
+	Compression          *compressed.Policy
+	EncryptionKeyFile    string
+	EncryptionKeysPath   string
+	PrometheusAddr       string
+	PrometheusPort       int
+	AuditPath            string
+	AuditMaxSize         int64
+	AuditReads           bool
+	MaxMsgSize           int
+	MaxFileSize          int64
+	MaxConcurrentStreams uint32
+	AllowedIdentities    []string
+	AdminIdentities      []string
+	RetentionIdentities  []string
+	RetentionRules       []worm.Rule
+	Mode                 Mode
+	ModeReason           string
+	ReplicationRole      Role
+	ReplicationPath      string
+	Replicas             []string
+	PeerTLSConfig        *tls.Config
+	ClusterRaftAddr      string
+	ClusterAPIAddr       string
+	ClusterPath          string
+	ClusterBootstrap     bool
+	ExpiryInterval       time.Duration
+	LifecycleInterval    time.Duration
+	LifecycleRules       []lifecycle.Rule
+	TieringColdPath      string
+	TieringPath          string
+	TieringInterval      time.Duration
+	TieringPolicy        tiered.Policy
+	Trash                bool
+	TrashRetention       time.Duration
 }
 
 // Apply calls each option on o in turn
@@ -47,12 +94,72 @@ func WithTLSConfig(config *tls.Config) Option {
 	}
 }
 
+// WithTLSFiles enables TLS using the certificate and key files. Unlike
+// WithTLSConfig, the files are read again on Reload.
+func WithTLSFiles(certFile, keyFile string) Option {
+	return func(o *Options) {
+		o.TLSCertFile = certFile
+		o.TLSKeyFile = keyFile
+	}
+}
+
+// WithTLSClientAuth verifies client certificates against the CAs in caFile.
+func WithTLSClientAuth(caFile string, clientAuth tls.ClientAuthType) Option {
+	return func(o *Options) {
+		o.TLSClientCAFile = caFile
+		o.TLSClientAuth = clientAuth
+	}
+}
+
 func WithStoragePath(path string) Option {
 	return func(o *Options) {
 		o.StoragePath = path
 	}
 }
 
+// WithErasure stores the files erasure coded across dirs, every file is
+// split into dataShards data and parityShards parity shards.
+func WithErasure(dirs []string, dataShards, parityShards int) Option {
+	return func(o *Options) {
+		o.StorageBackend = StorageBackendErasure
+		o.ErasureDirs = dirs
+		o.ErasureDataShards = dataShards
+		o.ErasureParityShards = parityShards
+	}
+}
+
+// WithMirror stores a full copy of every file in each of dirs.
+func WithMirror(dirs ...string) Option {
+	return func(o *Options) {
+		o.StorageBackend = StorageBackendMirror
+		o.MirrorDirs = dirs
+	}
+}
+
+// WithCAS stores the files deduplicated by chunks in path.
+func WithCAS(path string) Option {
+	return func(o *Options) {
+		o.StorageBackend = StorageBackendCAS
+		o.StoragePath = path
+	}
+}
+
+// WithEncryption encrypts the stored files with data keys kept in keysPath,
+// which are wrapped with the active master key of keyFile.
+func WithEncryption(keyFile, keysPath string) Option {
+	return func(o *Options) {
+		o.EncryptionKeyFile = keyFile
+		o.EncryptionKeysPath = keysPath
+	}
+}
+
+// WithCompression compresses the stored files following policy.
+func WithCompression(policy compressed.Policy) Option {
+	return func(o *Options) {
+		o.Compression = &policy
+	}
+}
+
 func WithPrometheusAddr(addr string) Option {
 	return func(o *Options) {
 		o.PrometheusAddr = addr
@@ -64,3 +171,154 @@ func WithPrometheusPort(port int) Option {
 		o.PrometheusPort = port
 	}
 }
+
+// WithAuditPath enables the audit log at path.
+func WithAuditPath(path string) Option {
+	return func(o *Options) {
+		o.AuditPath = path
+	}
+}
+
+func WithAuditMaxSize(size int64) Option {
+	return func(o *Options) {
+		o.AuditMaxSize = size
+	}
+}
+
+func WithAuditReads(reads bool) Option {
+	return func(o *Options) {
+		o.AuditReads = reads
+	}
+}
+
+// WithMaxMsgSize limits the size of received and sent messages. Data is
+// streamed in chunks of up to 4 MiB, so smaller limits are rejected.
+func WithMaxMsgSize(size int) Option {
+	return func(o *Options) {
+		o.MaxMsgSize = size
+	}
+}
+
+// WithMaxFileSize limits the size of written files, 0 disables the limit.
+func WithMaxFileSize(size int64) Option {
+	return func(o *Options) {
+		o.MaxFileSize = size
+	}
+}
+
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(o *Options) {
+		o.MaxConcurrentStreams = n
+	}
+}
+
+// WithAllowedIdentities restricts access to the given client certificate
+// common names. No identities allow everyone.
+func WithAllowedIdentities(identities ...string) Option {
+	return func(o *Options) {
+		o.AllowedIdentities = identities
+	}
+}
+
+// WithAdminIdentities restricts the admin service to the given client
+// certificate common names. No identities allow everyone.
+func WithAdminIdentities(identities ...string) Option {
+	return func(o *Options) {
+		o.AdminIdentities = identities
+	}
+}
+
+// WithRetentionIdentities restricts managing the retention and legal holds
+// of files to the given client certificate common names. No identities leave
+// it to the admin identities.
+func WithRetentionIdentities(identities ...string) Option {
+	return func(o *Options) {
+		o.RetentionIdentities = identities
+	}
+}
+
+// WithRetentionRules locks new files according to the rule matching their
+// name.
+func WithRetentionRules(rules ...worm.Rule) Option {
+	return func(o *Options) {
+		o.RetentionRules = rules
+	}
+}
+
+// WithMode starts the server in the given mode, reason is reported to clients
+// whose requests are rejected.
+func WithMode(mode Mode, reason string) Option {
+	return func(o *Options) {
+		o.Mode = mode
+		o.ModeReason = reason
+	}
+}
+
+// WithReplication sets the replication role of the server and the directory
+// of its replication state. A primary ships its mutations to the replicas at
+// targets, a replica serves reads only and applies the mutations of its
+// primary.
+func WithReplication(role Role, path string, targets ...string) Option {
+	return func(o *Options) {
+		o.ReplicationRole = role
+		o.ReplicationPath = path
+		o.Replicas = targets
+	}
+}
+
+// WithPeerTLSConfig dials other argon servers, i.e. replicas and cluster
+// members, with config.
+func WithPeerTLSConfig(config *tls.Config) Option {
+	return func(o *Options) {
+		o.PeerTLSConfig = config
+	}
+}
+
+// WithCluster runs the server as member of a Raft cluster with the server ID
+// as node ID. Raft traffic is served on raftAddr, apiAddr is the address
+// other members reach the gRPC API of this server at. The Raft state is kept
+// in path. Bootstrap creates a new cluster if the node has no state yet.
+func WithCluster(raftAddr, apiAddr, path string, bootstrap bool) Option {
+	return func(o *Options) {
+		o.ClusterRaftAddr = raftAddr
+		o.ClusterAPIAddr = apiAddr
+		o.ClusterPath = path
+		o.ClusterBootstrap = bootstrap
+	}
+}
+
+// WithExpiryInterval sets how often expired files are removed.
+func WithExpiryInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.ExpiryInterval = interval
+	}
+}
+
+// WithLifecycle applies the lifecycle rules every interval.
+func WithLifecycle(interval time.Duration, rules ...lifecycle.Rule) Option {
+	return func(o *Options) {
+		o.LifecycleInterval = interval
+		o.LifecycleRules = rules
+	}
+}
+
+// WithTiering moves the files between the configured backend as hot tier
+// and a local cold tier in coldPath according to policy. The placement of
+// the files is kept in path, files are demoted every interval.
+func WithTiering(coldPath, path string, interval time.Duration, policy tiered.Policy) Option {
+	return func(o *Options) {
+		o.TieringColdPath = coldPath
+		o.TieringPath = path
+		o.TieringInterval = interval
+		o.TieringPolicy = policy
+	}
+}
+
+// WithTrash moves removed files into the trash instead of removing them, they
+// are purged after retention.
+func WithTrash(retention time.Duration) Option {
+	return func(o *Options) {
+		o.Trash = true
+		o.TrashRetention = retention
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policy decides which identities may access the server, which of them may
+// use the admin service and which may manage the retention of files. An empty
+// list allows every identity, except for the retention, which is managed by
+// the admins then. Without either list nobody may manage the retention.
+type policy struct {
+	mu        sync.RWMutex
+	allowed   map[string]struct{}
+	admins    map[string]struct{}
+	retention map[string]struct{}
+}
+
+func newPolicy(identities, admins, retention []string) *policy {
+	p := &policy{}
+	p.setAllowedIdentities(identities)
+	p.setAdminIdentities(admins)
+	p.setRetentionIdentities(retention)
+	return p
+}
+
+func (p *policy) setAllowedIdentities(identities []string) {
+	allowed := identitySet(identities)
+
+	p.mu.Lock()
+	p.allowed = allowed
+	p.mu.Unlock()
+}
+
+func (p *policy) setAdminIdentities(identities []string) {
+	admins := identitySet(identities)
+
+	p.mu.Lock()
+	p.admins = admins
+	p.mu.Unlock()
+}
+
+func (p *policy) setRetentionIdentities(identities []string) {
+	retention := identitySet(identities)
+
+	p.mu.Lock()
+	p.retention = retention
+	p.mu.Unlock()
+}
+
+func (p *policy) authorizeAdmin(ctx context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.admins) == 0 {
+		return nil
+	}
+	identity := identityFromContext(ctx)
+	if _, ok := p.admins[identity]; !ok {
+		return status.Errorf(codes.PermissionDenied, "identity %s is not an admin", identity)
+	}
+	return nil
+}
+
+func (p *policy) authorizeRetention(ctx context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	identities := p.retention
+	if len(identities) == 0 {
+		identities = p.admins
+	}
+	if len(identities) == 0 {
+		return status.Errorf(codes.PermissionDenied,
+			"retention can't be managed, neither retention nor admin identities are configured")
+	}
+	identity := identityFromContext(ctx)
+	if _, ok := identities[identity]; !ok {
+		return status.Errorf(codes.PermissionDenied, "identity %s may not manage the retention of files", identity)
+	}
+	return nil
+}
+
+func (p *policy) authorize(ctx context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.allowed) == 0 {
+		return nil
+	}
+	identity := identityFromContext(ctx)
+	if _, ok := p.allowed[identity]; !ok {
+		return status.Errorf(codes.PermissionDenied, "identity %s is not allowed", identity)
+	}
+	return nil
+}
+
+func (p *policy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := p.authorize(ctx); err != nil {
+			requestLog(ctx).Warnf("Rejected %s (%s)", info.FullMethod, err)
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (p *policy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := p.authorize(stream.Context()); err != nil {
+			requestLog(stream.Context()).Warnf("Rejected %s (%s)", info.FullMethod, err)
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func identitySet(identities []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(identities))
+	for _, identity := range identities {
+		set[identity] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peertechde/argon/pkg/lifecycle"
+)
+
+func TestReloadRejectedAsAWhole(t *testing.T) {
+	srv := startServer(t)
+	base := []Option{WithAddr("127.0.0.1"), WithStoragePath(srv.options.StoragePath)}
+
+	// the storage has no cold tier, so the transition rule is invalid
+	invalid := append(base,
+		WithAdminIdentities("admin"),
+		WithMaxFileSize(1024),
+		WithLifecycle(0, lifecycle.Rule{ID: "archive", Prefix: "logs/", TransitionAfter: time.Hour}),
+	)
+	if err := srv.Reload(invalid...); err == nil {
+		t.Fatal("reload with an invalid lifecycle rule succeeded")
+	}
+	if len(srv.policy.admins) != 0 || srv.options.MaxFileSize != 0 {
+		t.Fatalf("a rejected reload applied settings: admins %v, max file size %d", srv.policy.admins, srv.options.MaxFileSize)
+	}
+
+	valid := append(base, WithAdminIdentities("admin"), WithMaxFileSize(1024))
+	if err := srv.Reload(valid...); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.policy.admins["admin"]; !ok || srv.options.MaxFileSize != 1024 {
+		t.Fatalf("reload didn't apply settings: admins %v, max file size %d", srv.policy.admins, srv.options.MaxFileSize)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/replication"
+)
+
+// Role is the part a server plays in replication.
+type Role string
+
+const (
+	RoleStandalone Role = ""
+	RolePrimary    Role = "primary"
+	RoleReplica    Role = "replica"
+)
+
+func (r Role) String() string {
+	if r == RoleStandalone {
+		return "standalone"
+	}
+	return string(r)
+}
+
+// replicaModeReason is reported to clients writing to a replica.
+const replicaModeReason = "server is a replica"
+
+// setupReplication starts shipping the replication log on a primary and
+// prepares applying the primary's log on a replica.
+func (s *Server) setupReplication() error {
+	s.role = s.options.ReplicationRole
+
+	switch s.role {
+	case RolePrimary:
+		return s.startPrimary(0)
+	case RoleReplica:
+		applier, err := replication.NewApplier(s.store, s.options.ReplicationPath)
+		if err != nil {
+			return err
+		}
+		s.applier = applier
+		if mode, _ := s.storageService.Mode(); mode == ModeReadWrite {
+			s.setMode(ModeReadOnly, replicaModeReason)
+		}
+		log.WithField("applied_seq", applier.AppliedSeq()).Info("Serving as replica")
+	}
+	return nil
+}
+
+// startPrimary starts recording mutations in the replication log, which
+// continues after start, and shipping them to the replicas.
+func (s *Server) startPrimary(start uint64) error {
+	replLog, err := replication.OpenLog(filepath.Join(s.options.ReplicationPath, "log"), start)
+	if err != nil {
+		return err
+	}
+	if last := replLog.LastSeq(); last != start && start > 0 {
+		return errors.Errorf("replication log has entries up to %d past the applied entry %d", last, start)
+	}
+
+	var shipperOptions []replication.ShipperOption
+	if s.options.PeerTLSConfig != nil {
+		shipperOptions = append(shipperOptions, replication.WithTLSConfig(s.options.PeerTLSConfig))
+	}
+	shipper := replication.NewShipper(replLog, s.options.ReplicationPath, s.options.Replicas, shipperOptions...)
+	if err := shipper.Start(); err != nil {
+		return err
+	}
+
+	s.journal.Attach(replLog)
+	s.replLog = replLog
+	s.shipper = shipper
+	log.WithField("last_seq", replLog.LastSeq()).WithField("replicas", s.options.Replicas).Info("Serving as primary")
+	return nil
+}
+
+// Promote turns a replica into a primary which accepts writes and ships
+// them to its configured replicas. The former primary has to be stopped or
+// reconfigured as a replica beforehand, the new role isn't persisted.
+func (s *Server) Promote() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role != RoleReplica {
+		return 0, errors.Errorf("server is not a replica but %s", s.role)
+	}
+	applied := s.applier.AppliedSeq()
+	if err := s.startPrimary(applied); err != nil {
+		return 0, err
+	}
+	s.role = RolePrimary
+	s.applier = nil
+	s.storageService.SetMode(ModeReadWrite, "")
+	s.readOnly.Disable()
+
+	log.WithField("applied_seq", applied).Warn("Promoted replica to primary, update the configuration accordingly")
+	return applied, nil
+}
+
+// Role returns the current replication role of the server.
+func (s *Server) Role() Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.role
+}
+
+func NewReplicationService(srv *Server) *ReplicationService {
+	return &ReplicationService{
+		srv: srv,
+	}
+}
+
+// ReplicationService receives the replication log of a primary. The primary
+// authenticates with an admin identity.
+type ReplicationService struct {
+	api.UnimplementedReplicationServer
+
+	srv *Server
+}
+
+func (s *ReplicationService) Replicate(stream api.Replication_ReplicateServer) error {
+	ctx := stream.Context()
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+
+	s.srv.mu.Lock()
+	applier := s.srv.applier
+	s.srv.mu.Unlock()
+	if applier == nil {
+		return status.Errorf(codes.FailedPrecondition, "server is not a replica")
+	}
+
+	var entry *replication.Entry
+	var data bytes.Buffer
+	apply := func() error {
+		if entry == nil {
+			return nil
+		}
+		entry.Data = data.Bytes()
+		if err := applier.Apply(ctx, entry); err != nil {
+			requestLog(ctx).Errorf("Failed to apply replication log entry (%s)", err)
+			if errors.Is(err, replication.ErrOutOfOrder) {
+				return status.Errorf(codes.FailedPrecondition, "%s", err)
+			}
+			return status.Errorf(codes.Internal, "failed to apply entry %d", entry.Seq)
+		}
+		s.srv.updateIndex(ctx, entry)
+		entry = nil
+		data = bytes.Buffer{}
+		return nil
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if entry != nil && entry.Seq != req.Seq {
+			if err := apply(); err != nil {
+				return err
+			}
+		}
+		if entry == nil {
+			op, err := replication.OpFromAPI(req.Op)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "%s", err)
+			}
+			entry = &replication.Entry{
+				Seq:     req.Seq,
+				Time:    req.Time.AsTime(),
+				Op:      op,
+				Name:    req.Name,
+				NewName: req.NewName,
+				Offset:  req.Offset,
+
+				Metadata: req.Metadata,
+			}
+		}
+		data.Write(req.Data)
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&api.ReplicateResponse{AppliedSeq: applier.AppliedSeq()})
+}
+
+func (s *AdminService) Promote(ctx context.Context, req *api.PromoteRequest) (*api.PromoteResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	requestLog(ctx).Info("Handling promote request")
+
+	applied, err := s.srv.Promote()
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to promote: %s", err)
+	}
+	return &api.PromoteResponse{LastSeq: applied}, nil
+}
+
+func (s *AdminService) ReplicationStatus(ctx context.Context, req *api.ReplicationStatusRequest) (*api.ReplicationStatusResponse, error) {
+	if err := s.srv.policy.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	s.srv.mu.Lock()
+	role, replLog, shipper, applier := s.srv.role, s.srv.replLog, s.srv.shipper, s.srv.applier
+	s.srv.mu.Unlock()
+
+	resp := &api.ReplicationStatusResponse{
+		Role: role.String(),
+	}
+	if replLog != nil {
+		resp.LastSeq = replLog.LastSeq()
+	}
+	if applier != nil {
+		resp.AppliedSeq = applier.AppliedSeq()
+	}
+	if shipper != nil {
+		for _, replica := range shipper.Status() {
+			resp.Replicas = append(resp.Replicas, &api.ReplicaStatus{
+				Target:   replica.Target,
+				AckedSeq: replica.AckedSeq,
+				Lag:      replica.Lag,
+				LagTime:  durationpb.New(replica.LagTime),
+				Error:    replica.Error,
+			})
+		}
+	}
+	return resp, nil
+}
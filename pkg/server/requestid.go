@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RequestIDHeader is the metadata key carrying the request ID. A request
+	// ID sent by the client is reused, otherwise a new one is generated. The
+	// request ID is always echoed back in the response header.
+	RequestIDHeader = "x-request-id"
+
+	maxRequestIDLen = 128
+)
+
+type requestIDKey struct{}
+
+func RequestIDUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := incomingRequestID(ctx)
+		ctx = context.WithValue(ctx, requestIDKey{}, id)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id)); err != nil {
+			log.Warnf("Failed to set request ID header (%s)", err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func RequestIDStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := incomingRequestID(stream.Context())
+		if err := stream.SetHeader(metadata.Pairs(RequestIDHeader, id)); err != nil {
+			log.Warnf("Failed to set request ID header (%s)", err)
+		}
+
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = context.WithValue(stream.Context(), requestIDKey{}, id)
+		return handler(srv, wrapped)
+	}
+}
+
+// requestIDFromContext returns the request ID assigned by the interceptors.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns a logger scoped to the request in ctx.
+func requestLog(ctx context.Context) *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"request_id": requestIDFromContext(ctx),
+		"peer":       peerFromContext(ctx),
+		"identity":   identityFromContext(ctx),
+	})
+}
+
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+			return values[0]
+		}
+	}
+	return newRequestID()
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// setRetention replaces the retention of name with the one update returns for
+// the current one. Compliance locks are enforced by the storage.
+func (s *StorageService) setRetention(ctx context.Context, name string, update func(storage.Retention) storage.Retention) error {
+	return s.updateMetadata(ctx, name, func(current map[string]string) map[string]string {
+		return update(storage.RetentionOf(current)).Apply(current)
+	})
+}
+
+func (s *AdminService) SetRetention(ctx context.Context, req *api.SetRetentionRequest) (_ *api.SetRetentionResponse, err error) {
+	defer func() {
+		s.srv.storageService.audit(ctx, &AuditEntry{Operation: "set-retention", Name: req.Name}, true, err)
+	}()
+
+	if err := s.srv.policy.authorizeRetention(ctx); err != nil {
+		return nil, err
+	}
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"name": req.Name,
+		"mode": req.Mode,
+	})
+	scopedLog.Info("Handling set retention request")
+
+	if err := s.srv.storageService.checkWritable(); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
+	}
+	var retention storage.Retention
+	switch req.Mode {
+	case api.RetentionMode_RETENTION_MODE_NONE:
+		if req.RetainUntil != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "retain until requires a retention mode")
+		}
+	case api.RetentionMode_RETENTION_MODE_GOVERNANCE, api.RetentionMode_RETENTION_MODE_COMPLIANCE:
+		if req.RetainUntil == nil || !req.RetainUntil.AsTime().After(time.Now()) {
+			return nil, status.Errorf(codes.InvalidArgument, "retain until has to be in the future")
+		}
+		retention.Mode = storage.RetentionGovernance
+		if req.Mode == api.RetentionMode_RETENTION_MODE_COMPLIANCE {
+			retention.Mode = storage.RetentionCompliance
+		}
+		retention.Until = req.RetainUntil.AsTime()
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown retention mode %s", req.Mode)
+	}
+
+	err = s.srv.storageService.setRetention(ctx, req.Name, func(current storage.Retention) storage.Retention {
+		retention.LegalHold = current.LegalHold
+		return retention
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scopedLog.Info("Successfully handled set retention request")
+	return &api.SetRetentionResponse{}, nil
+}
+
+func (s *AdminService) SetLegalHold(ctx context.Context, req *api.SetLegalHoldRequest) (_ *api.SetLegalHoldResponse, err error) {
+	defer func() {
+		s.srv.storageService.audit(ctx, &AuditEntry{Operation: "set-legal-hold", Name: req.Name}, true, err)
+	}()
+
+	if err := s.srv.policy.authorizeRetention(ctx); err != nil {
+		return nil, err
+	}
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"name": req.Name,
+		"hold": req.Hold,
+	})
+	scopedLog.Info("Handling set legal hold request")
+
+	if err := s.srv.storageService.checkWritable(); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
+	}
+
+	err = s.srv.storageService.setRetention(ctx, req.Name, func(current storage.Retention) storage.Retention {
+		current.LegalHold = req.Hold
+		return current
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scopedLog.Info("Successfully handled set legal hold request")
+	return &api.SetLegalHoldResponse{}, nil
+}
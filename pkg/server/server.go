@@ -1,11 +1,16 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"runtime"
 	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,9 +18,25 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/cluster"
+	"github.com/peertechde/argon/pkg/index"
+	"github.com/peertechde/argon/pkg/lifecycle"
 	"github.com/peertechde/argon/pkg/logging"
+	"github.com/peertechde/argon/pkg/merkle"
+	"github.com/peertechde/argon/pkg/replication"
 	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/cas"
+	"github.com/peertechde/argon/pkg/storage/compressed"
+	"github.com/peertechde/argon/pkg/storage/encrypted"
+	"github.com/peertechde/argon/pkg/storage/erasure"
 	"github.com/peertechde/argon/pkg/storage/local"
+	"github.com/peertechde/argon/pkg/storage/mirror"
+	"github.com/peertechde/argon/pkg/storage/readonly"
+	"github.com/peertechde/argon/pkg/storage/tiered"
+	"github.com/peertechde/argon/pkg/storage/traced"
+	"github.com/peertechde/argon/pkg/storage/trash"
+	"github.com/peertechde/argon/pkg/storage/worm"
+	"github.com/peertechde/argon/pkg/tracing"
 )
 
 var log = logging.Logger.WithField(logging.Subsys, "server")
@@ -24,34 +45,82 @@ func New(options ...Option) (*Server, error) {
 	var opts Options
 	opts.Apply(options...)
 
-	if opts.StoragePath == "" {
-		return nil, fmt.Errorf("missing storage path")
-	}
+	switch opts.StorageBackend {
+	case "", StorageBackendLocal, StorageBackendCAS:
+		if opts.StoragePath == "" {
+			return nil, fmt.Errorf("missing storage path")
+		}
 
-	fi, err := os.Stat(opts.StoragePath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, errors.Errorf("path '%s' doesn't exist", opts.StoragePath)
+		fi, err := os.Stat(opts.StoragePath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Errorf("path '%s' doesn't exist", opts.StoragePath)
+			}
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, errors.Errorf("path '%s' is not a directory", opts.StoragePath)
 		}
-		return nil, err
 	}
-	if !fi.IsDir() {
-		return nil, errors.Errorf("path '%s' is not a directory", opts.StoragePath)
+	if opts.ClusterRaftAddr != "" && opts.ReplicationRole != RoleStandalone {
+		return nil, errors.New("cluster mode and replication are mutually exclusive")
+	}
+	if opts.MaxMsgSize > 0 && opts.MaxMsgSize < defaultMaxMsgSize {
+		return nil, errors.Errorf("max message size must be at least %d bytes", defaultMaxMsgSize)
+	}
+	for _, rule := range opts.RetentionRules {
+		if err := rule.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid retention rule")
+		}
 	}
 
 	srv := &Server{
 		options: opts,
+		policy:  newPolicy(opts.AllowedIdentities, opts.AdminIdentities, opts.RetentionIdentities),
+		stats:   &grpcStatsHandler{},
+		jobs:    newJobManager(),
+	}
+
+	if opts.TLSCertFile != "" {
+		tlsReloader, err := newTLSReloader(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSClientCAFile, opts.TLSClientAuth)
+		if err != nil {
+			return nil, err
+		}
+		srv.tlsReloader = tlsReloader
 	}
 
 	return srv, nil
 }
 
 type Server struct {
+	mu      sync.Mutex
 	options Options
 
+	startTime      time.Time
 	grpcServer     *grpc.Server
 	storageService *StorageService
+	adminService   *AdminService
 	store          storage.Storage
+	backend        storage.Storage
+	encrypted      *encrypted.Encrypted
+	tiered         *tiered.Tiered
+	trash          *trash.Trash
+	readOnly       *readonly.ReadOnly
+	journal        *replication.Journal
+	role           Role
+	replLog        *replication.Log
+	shipper        *replication.Shipper
+	applier        *replication.Applier
+	cluster        *cluster.Cluster
+	merkle         *merkle.Indexer
+	index          *index.Index
+	lifecycle      *lifecycle.Engine
+	listener       net.Listener
+	auditor        *Auditor
+	policy         *policy
+	tlsReloader    *tlsReloader
+	stats          *grpcStatsHandler
+	jobs           *jobManager
 }
 
 func (s *Server) Serve() error {
@@ -62,18 +131,135 @@ func (s *Server) Serve() error {
 		"path": s.options.StoragePath,
 	}).Info("Starting the server")
 
+	s.startTime = time.Now()
 	s.registerMetrics()
-	s.storageService = NewStorageService(local.New(s.options.StoragePath))
+
+	var serviceOptions []StorageServiceOption
+	if s.options.AuditPath != "" {
+		auditOptions := []AuditOption{WithAuditorReads(s.options.AuditReads)}
+		if s.options.AuditMaxSize > 0 {
+			auditOptions = append(auditOptions, WithAuditorMaxSize(s.options.AuditMaxSize))
+		}
+		auditor, err := NewAuditor(s.options.AuditPath, auditOptions...)
+		if err != nil {
+			return errors.Wrap(err, "failed to create auditor")
+		}
+		s.auditor = auditor
+		serviceOptions = append(serviceOptions, WithAuditor(auditor))
+	}
+	serviceOptions = append(serviceOptions, WithFileSizeLimit(s.options.MaxFileSize))
+	serviceOptions = append(serviceOptions, WithDefaultRetention(s.options.RetentionRules...))
+	store, err := s.openStorage()
+	if err != nil {
+		return errors.Wrap(err, "failed to open storage")
+	}
+	if s.options.TieringColdPath != "" {
+		s.tiered, err = tiered.New(store, local.New(s.options.TieringColdPath), s.options.TieringPath,
+			s.options.TieringPolicy)
+		if err != nil {
+			return errors.Wrap(err, "failed to set up tiering")
+		}
+		store = s.tiered
+	}
+	// the admin service maintains the backend below wrappers like compression
+	s.backend = store
+	if s.options.EncryptionKeyFile != "" {
+		s.encrypted, err = encrypted.New(store, s.options.EncryptionKeyFile, s.options.EncryptionKeysPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to set up encryption")
+		}
+		store = s.encrypted
+	}
+	// files are compressed before they are encrypted
+	if s.options.Compression != nil {
+		store, err = compressed.New(store, *s.options.Compression)
+		if err != nil {
+			return errors.Wrap(err, "failed to set up compression")
+		}
+	}
+	s.store = store
+	s.journal = replication.NewJournal(s.store)
+	var backend storage.Storage = s.journal
+	if s.options.ClusterRaftAddr != "" {
+		if err := s.setupCluster(); err != nil {
+			return errors.Wrap(err, "failed to set up cluster")
+		}
+		backend = s.cluster
+	}
+	// the trashed files are part of the replicated state, but hidden from the
+	// clients, the index and the lifecycle rules
+	s.merkle = merkle.NewIndexer(backend, merkleTreeMaxAge)
+	s.readOnly = readonly.New(backend)
+	var files storage.Storage = worm.New(s.readOnly)
+	if s.options.Trash {
+		s.trash, err = trash.New(files, s.options.TrashRetention)
+		if err != nil {
+			return errors.Wrap(err, "failed to set up the trash")
+		}
+		files = s.trash
+		serviceOptions = append(serviceOptions, WithTrashStore(s.trash))
+	}
+	s.mu.Lock()
+	s.index = index.New(files)
+	s.mu.Unlock()
+	serviceOptions = append(serviceOptions, WithIndex(s.index))
+	s.storageService = NewStorageService(traced.New(files), serviceOptions...)
+	if s.options.Mode != ModeReadWrite {
+		s.setMode(s.options.Mode, s.options.ModeReason)
+	}
+	s.adminService = NewAdminService(s)
+	if err := s.setupReplication(); err != nil {
+		return errors.Wrap(err, "failed to set up replication")
+	}
+	if _, err := s.jobs.start(jobKindReindex, s.reindex); err != nil {
+		return errors.Wrap(err, "failed to start building the metadata index")
+	}
+	expiryInterval := s.options.ExpiryInterval
+	if expiryInterval <= 0 {
+		expiryInterval = defaultExpiryInterval
+	}
+	s.jobs.run(func(ctx context.Context) { s.expiryLoop(ctx, expiryInterval) })
+	if err := s.checkLifecycleRules(s.options.LifecycleRules); err != nil {
+		return errors.Wrap(err, "invalid lifecycle rules")
+	}
+	s.lifecycle, err = lifecycle.New(files, lifecycleExecutor{s.storageService}, s.options.LifecycleRules)
+	if err != nil {
+		return errors.Wrap(err, "invalid lifecycle rules")
+	}
+	lifecycleInterval := s.options.LifecycleInterval
+	if lifecycleInterval <= 0 {
+		lifecycleInterval = defaultLifecycleInterval
+	}
+	s.jobs.run(func(ctx context.Context) { s.lifecycleLoop(ctx, lifecycleInterval) })
+	if s.tiered != nil {
+		tieringInterval := s.options.TieringInterval
+		if tieringInterval <= 0 {
+			tieringInterval = defaultTieringInterval
+		}
+		s.jobs.run(func(ctx context.Context) { s.tieringLoop(ctx, tieringInterval) })
+	}
+	if s.trash != nil {
+		trashInterval := trashPurgeInterval
+		if retention := s.trash.Retention(); retention > 0 && retention < trashInterval {
+			trashInterval = retention
+		}
+		s.jobs.run(func(ctx context.Context) { s.trashLoop(ctx, trashInterval) })
+	}
 
 	addr := fmt.Sprintf("%s:%d", s.options.Addr, s.options.Port)
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return errors.Wrap(err, "failed to listen")
 	}
-	s.grpcServer = NewGRPCServer(
-		WithGRPCServerOptions(grpc.StatsHandler(&grpcStatsHandler{})),
-	)
+	s.grpcServer = NewGRPCServer(s.grpcOptions()...)
 	api.RegisterStorageServer(s.grpcServer, s.storageService)
+	api.RegisterAdminServer(s.grpcServer, s.adminService)
+	api.RegisterReplicationServer(s.grpcServer, NewReplicationService(s))
+	api.RegisterClusterServer(s.grpcServer, NewClusterService(s))
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
 
 	err = s.grpcServer.Serve(ln)
 	if err != nil {
@@ -85,15 +271,225 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+func (s *Server) grpcOptions() []grpcOption {
+	tlsConfig := s.options.TLSConfig
+	if s.tlsReloader != nil {
+		tlsConfig = s.tlsReloader.TLSConfig()
+	}
+
+	options := []grpcOption{
+		WithGRPCServerOptions(grpc.StatsHandler(s.stats)),
+		WithGRPCTLSConfig(tlsConfig),
+		WithUnaryInterceptor(tracing.UnaryServerInterceptor()),
+		WithUnaryInterceptor(RequestIDUnaryInterceptor()),
+		WithUnaryInterceptor(s.policy.UnaryInterceptor()),
+		WithStreamInterceptor(tracing.StreamServerInterceptor()),
+		WithStreamInterceptor(RequestIDStreamInterceptor()),
+		WithStreamInterceptor(s.policy.StreamInterceptor()),
+	}
+	if s.options.MaxMsgSize > 0 {
+		options = append(options, WithGRPCServerOptions(
+			grpc.MaxRecvMsgSize(s.options.MaxMsgSize),
+			grpc.MaxSendMsgSize(s.options.MaxMsgSize),
+		))
+	}
+	if s.options.MaxConcurrentStreams > 0 {
+		options = append(options, WithGRPCServerOptions(grpc.MaxConcurrentStreams(s.options.MaxConcurrentStreams)))
+	}
+	return options
+}
+
+// Addr returns the address the server listens on, or nil if it isn't
+// serving yet. It allows listening on port 0, e.g. to run several servers in
+// one process.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// SetMode changes which requests the server accepts. Outside of read-write
+// mode the storage backend itself rejects mutations as well, so background
+// jobs can't modify it either. A replica can't be switched to read-write
+// mode, it has to be promoted instead.
+func (s *Server) SetMode(mode Mode, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setMode(mode, reason)
+}
+
+// checkMode reports whether the server can switch to mode.
+func (s *Server) checkMode(mode Mode) error {
+	if s.role == RoleReplica && mode == ModeReadWrite {
+		return errors.New("a replica can't be read-write, promote it instead")
+	}
+	return nil
+}
+
+func (s *Server) setMode(mode Mode, reason string) error {
+	if err := s.checkMode(mode); err != nil {
+		return err
+	}
+
+	s.storageService.SetMode(mode, reason)
+	if mode == ModeReadWrite {
+		s.readOnly.Disable()
+	} else {
+		s.readOnly.Enable(reason)
+	}
+
+	log.WithFields(logrus.Fields{
+		"mode":   mode,
+		"reason": reason,
+	}).Warn("Changed the server mode")
+	return nil
+}
+
+// Reload applies the settings which can change without dropping connections:
+// TLS certificates, the access policy, the file size limit, the mode, the
+// master keys of the encryption keyfile and the lifecycle rules.
+// Changes of other settings are ignored until the next restart. The mode and
+// the lifecycle rules are only applied if they changed in the configuration,
+// so values set through the admin service survive unrelated reloads. An
+// invalid configuration is rejected before any setting is applied.
+func (s *Server) Reload(options ...Option) error {
+	var opts Options
+	opts.Apply(options...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opts.Id != s.options.Id || opts.Addr != s.options.Addr || opts.Port != s.options.Port ||
+		opts.StoragePath != s.options.StoragePath || opts.StorageBackend != s.options.StorageBackend ||
+		opts.MaxMsgSize != s.options.MaxMsgSize ||
+		opts.MaxConcurrentStreams != s.options.MaxConcurrentStreams || opts.AuditPath != s.options.AuditPath ||
+		opts.ReplicationRole != s.options.ReplicationRole || opts.ReplicationPath != s.options.ReplicationPath ||
+		opts.ClusterRaftAddr != s.options.ClusterRaftAddr || opts.ClusterPath != s.options.ClusterPath {
+		log.Warn("Some changed settings require a restart and are ignored")
+	}
+
+	// Everything which can fail is checked before anything is applied, so an
+	// invalid configuration leaves the server unchanged.
+	var tlsConfig *tls.Config
+	if s.tlsReloader != nil && opts.TLSCertFile != "" {
+		config, err := loadTLSConfig(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSClientCAFile, opts.TLSClientAuth)
+		if err != nil {
+			return errors.Wrap(err, "failed to reload certificates")
+		}
+		tlsConfig = config
+	}
+	rulesChanged := !reflect.DeepEqual(opts.LifecycleRules, s.options.LifecycleRules)
+	if rulesChanged && s.lifecycle != nil {
+		if err := s.checkLifecycleRules(opts.LifecycleRules); err != nil {
+			return errors.Wrap(err, "invalid lifecycle rules")
+		}
+	}
+	modeChanged := opts.Mode != s.options.Mode || opts.ModeReason != s.options.ModeReason
+	if modeChanged && s.storageService != nil {
+		if err := s.checkMode(opts.Mode); err != nil {
+			return err
+		}
+	}
+	// the keyfile is loaded last, it is only swapped in if it is valid
+	if s.encrypted != nil {
+		if opts.EncryptionKeyFile != s.options.EncryptionKeyFile {
+			log.Warn("Changing the encryption keyfile requires a restart, reloading the current one")
+		}
+		if err := s.encrypted.LoadKeyfile(); err != nil {
+			return errors.Wrap(err, "failed to reload the encryption keyfile")
+		}
+	}
+
+	if s.tlsReloader != nil {
+		if tlsConfig == nil {
+			log.Warn("Disabling TLS requires a restart, keeping the current certificates")
+		} else {
+			s.tlsReloader.set(tlsConfig)
+			s.options.TLSCertFile = opts.TLSCertFile
+			s.options.TLSKeyFile = opts.TLSKeyFile
+			s.options.TLSClientCAFile = opts.TLSClientCAFile
+			s.options.TLSClientAuth = opts.TLSClientAuth
+		}
+	} else if opts.TLSCertFile != "" {
+		log.Warn("Enabling TLS requires a restart")
+	}
+
+	s.policy.setAllowedIdentities(opts.AllowedIdentities)
+	s.policy.setAdminIdentities(opts.AdminIdentities)
+	s.policy.setRetentionIdentities(opts.RetentionIdentities)
+	s.options.AllowedIdentities = opts.AllowedIdentities
+	s.options.AdminIdentities = opts.AdminIdentities
+	s.options.RetentionIdentities = opts.RetentionIdentities
+
+	if s.storageService != nil {
+		s.storageService.SetMaxFileSize(opts.MaxFileSize)
+	}
+	s.options.MaxFileSize = opts.MaxFileSize
+
+	if rulesChanged {
+		if s.lifecycle != nil {
+			if err := s.lifecycle.SetRules(opts.LifecycleRules); err != nil {
+				return errors.Wrap(err, "invalid lifecycle rules")
+			}
+		}
+		s.options.LifecycleRules = opts.LifecycleRules
+	}
+
+	if modeChanged {
+		if s.storageService != nil {
+			if err := s.setMode(opts.Mode, opts.ModeReason); err != nil {
+				return err
+			}
+		}
+		s.options.Mode = opts.Mode
+		s.options.ModeReason = opts.ModeReason
+	}
+
+	log.Info("Successfully reloaded the configuration")
+	return nil
+}
+
 func (s *Server) Stop() error {
 	log.Info("Trying to gracefully stop the server...")
 	s.grpcServer.GracefulStop()
+	s.jobs.stop()
+
+	if s.shipper != nil {
+		s.shipper.Stop()
+	}
+	if s.cluster != nil {
+		if err := s.cluster.Stop(); err != nil {
+			log.Errorf("Failed to stop the cluster member (%s)", err)
+		}
+	}
+	if err := s.store.Close(); err != nil {
+		log.Errorf("Failed to close the storage (%s)", err)
+	}
+
+	if s.auditor != nil {
+		if err := s.auditor.Close(); err != nil {
+			log.Errorf("Failed to close the audit log (%s)", err)
+		}
+	}
 
 	log.Info("Successfully stopped the server")
 	return nil
 }
 
+// registerMetricsOnce guards the global registry against several servers in
+// one process.
+var registerMetricsOnce sync.Once
+
 func (s *Server) registerMetrics() {
+	registerMetricsOnce.Do(registerMetrics)
+}
+
+func registerMetrics() {
 	// general metrics
 	prometheus.MustRegister(modInfo)
 
@@ -103,6 +499,37 @@ func (s *Server) registerMetrics() {
 	prometheus.MustRegister(grpcRequestsPending)
 	prometheus.MustRegister(grpcRequestsTotal)
 
+	// audit metrics
+	prometheus.MustRegister(auditErrorsTotal)
+
+	// replication metrics
+	prometheus.MustRegister(replication.Collectors()...)
+
+	// cluster metrics
+	prometheus.MustRegister(cluster.Collectors()...)
+
+	// storage metrics
+	prometheus.MustRegister(erasure.Collectors()...)
+	prometheus.MustRegister(mirror.Collectors()...)
+	prometheus.MustRegister(cas.Collectors()...)
+	prometheus.MustRegister(compressed.Collectors()...)
+	prometheus.MustRegister(encrypted.Collectors()...)
+	prometheus.MustRegister(tiered.Collectors()...)
+	prometheus.MustRegister(trash.Collectors()...)
+	prometheus.MustRegister(worm.Collectors()...)
+
+	// metadata index metrics
+	prometheus.MustRegister(index.Collectors()...)
+
+	// expiry metrics
+	prometheus.MustRegister(expiredFilesTotal)
+	prometheus.MustRegister(expiryFailuresTotal)
+	prometheus.MustRegister(expiryPendingFiles)
+	prometheus.MustRegister(expiryLastRun)
+
+	// lifecycle metrics
+	prometheus.MustRegister(lifecycle.Collectors()...)
+
 	// go_mod_info; name and version of used modules
 	buildInfo, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -12,37 +16,132 @@ import (
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/index"
 	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/trash"
+	"github.com/peertechde/argon/pkg/storage/worm"
 )
 
-func NewStorageService(store storage.Storage) *StorageService {
-	return &StorageService{
+type StorageServiceOption func(*StorageService)
+
+// WithAuditor records the operations handled by the service in auditor.
+func WithAuditor(auditor *Auditor) StorageServiceOption {
+	return func(s *StorageService) {
+		s.auditor = auditor
+	}
+}
+
+// WithIndex keeps idx up to date with the mutations handled by the service
+// and answers queries from it.
+func WithIndex(idx *index.Index) StorageServiceOption {
+	return func(s *StorageService) {
+		s.index = idx
+	}
+}
+
+// WithTrashStore serves the trash requests from t, which has to be part of the
+// storage of the service.
+func WithTrashStore(t *trash.Trash) StorageServiceOption {
+	return func(s *StorageService) {
+		s.trash = t
+	}
+}
+
+// WithDefaultRetention locks new files according to the rule matching their
+// name.
+func WithDefaultRetention(rules ...worm.Rule) StorageServiceOption {
+	return func(s *StorageService) {
+		s.retentionRules = rules
+	}
+}
+
+// WithFileSizeLimit rejects writes of files larger than size bytes.
+func WithFileSizeLimit(size int64) StorageServiceOption {
+	return func(s *StorageService) {
+		s.SetMaxFileSize(size)
+	}
+}
+
+func NewStorageService(store storage.Storage, options ...StorageServiceOption) *StorageService {
+	s := &StorageService{
 		store: store,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 type StorageService struct {
 	api.UnimplementedStorageServer
 
-	store storage.Storage
+	store       storage.Storage
+	auditor     *Auditor
+	index       *index.Index
+	trash       *trash.Trash
+	maxFileSize int64
+	mode        modeState
+
+	retentionRules []worm.Rule
+}
+
+// SetMode changes which requests are accepted, reason is reported to clients
+// whose requests are rejected.
+func (s *StorageService) SetMode(mode Mode, reason string) {
+	s.mode.set(mode, reason)
+}
+
+func (s *StorageService) Mode() (Mode, string) {
+	return s.mode.get()
+}
+
+func (s *StorageService) checkWritable() error {
+	return s.mode.checkWritable()
 }
 
-func (s *StorageService) Read(req *api.ReadRequest, stream api.Storage_ReadServer) error {
-	scopedLog := log.WithFields(logrus.Fields{
+// SetMaxFileSize changes the file size limit, 0 disables the limit.
+func (s *StorageService) SetMaxFileSize(size int64) {
+	atomic.StoreInt64(&s.maxFileSize, size)
+}
+
+func (s *StorageService) Read(req *api.ReadRequest, stream api.Storage_ReadServer) (err error) {
+	entry := &AuditEntry{Operation: "read", Name: req.Name}
+	defer func() { s.audit(stream.Context(), entry, false, err) }()
+
+	scopedLog := requestLog(stream.Context()).WithFields(logrus.Fields{
 		"name": req.Name,
 	})
 	scopedLog.Info("Handling read request")
 
-	data, err := s.store.Read(stream.Context(), req.Name)
+	if req.Offset < 0 || req.Length < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid range %d+%d", req.Offset, req.Length)
+	}
+	if s.expired(stream.Context(), req.Name) {
+		return status.Errorf(codes.InvalidArgument, "file (%s) does not exist", req.Name)
+	}
+	var data []byte
+	if req.Offset > 0 || req.Length > 0 {
+		length := req.Length
+		if length == 0 {
+			length = math.MaxInt64
+		}
+		data, err = storage.ReadRange(stream.Context(), s.store, req.Name, req.Offset, length)
+	} else {
+		data, err = s.store.Read(stream.Context(), req.Name)
+	}
 	if err != nil {
+		if errors.Is(err, storage.ErrUnavailable) {
+			return status.Errorf(codes.Unavailable, "%s", err)
+		}
 		if errors.Is(err, &storage.NotFoundError{Name: req.Name}) {
 			return status.Errorf(codes.InvalidArgument, "file (%s) does not exist", req.Name)
 		}
 		return status.Errorf(codes.Internal, "failed to read file")
 	}
+	entry.Size = int64(len(data))
 
 	rd := bytes.NewReader(data)
-	buf := make([]byte, defaultMaxMsgSize-1024)
+	buf := make([]byte, maxChunkSize)
 	for {
 		n, err := rd.Read(buf)
 		if err != nil {
@@ -62,20 +161,53 @@ func (s *StorageService) Read(req *api.ReadRequest, stream api.Storage_ReadServe
 	return nil
 }
 
-func (s *StorageService) Write(stream api.Storage_WriteServer) error {
+func (s *StorageService) Write(stream api.Storage_WriteServer) (err error) {
+	entry := &AuditEntry{Operation: "write"}
+	defer func() { s.audit(stream.Context(), entry, true, err) }()
+
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
 	req, err := stream.Recv()
 	if err != nil {
 		return status.Errorf(codes.InvalidArgument, "invalid argument")
 	}
 
 	name := req.GetName()
-	scopedLog := log.WithFields(logrus.Fields{
+	entry.Name = name
+	scopedLog := requestLog(stream.Context()).WithFields(logrus.Fields{
 		"name": name,
 	})
 	scopedLog.Info("Handling write request")
 
-	if _, err := s.store.Stat(stream.Context(), name); err == nil {
-		return status.Errorf(codes.AlreadyExists, "file %s already exists")
+	metadata := req.GetMetadata()
+	if expiresAt, ok, err := expiryFromAPI(req.GetTtl(), req.GetExpiresAt()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	} else if ok {
+		metadata = withExpiry(metadata, expiresAt)
+	}
+	if err := storage.ValidateMetadata(metadata); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if retention, ok := worm.DefaultRetention(s.retentionRules, name, time.Now()); ok {
+		metadata = retention.Apply(metadata)
+	}
+
+	if fi, err := s.store.Stat(stream.Context(), name); err == nil {
+		if fi.Locked(time.Now()) {
+			return status.Errorf(codes.PermissionDenied, "%s", &storage.LockedError{Name: name,
+				Retention: storage.RetentionOf(fi.Metadata)})
+		}
+		if !fi.Expired(time.Now()) {
+			return status.Errorf(codes.AlreadyExists, "file %s already exists", name)
+		}
+		// replace the expired file right away instead of waiting for the
+		// expiry worker
+		if err := s.expire(stream.Context(), name); err != nil {
+			scopedLog.Errorf("Failed to remove expired file (%s)", err)
+			return status.Errorf(codes.Internal, "failed to write file")
+		}
 	}
 
 	var buf bytes.Buffer
@@ -91,21 +223,56 @@ func (s *StorageService) Write(stream api.Storage_WriteServer) error {
 		}
 		scopedLog.Debugf("Received %d bytes of data", len(req.GetData()))
 
+		if limit := atomic.LoadInt64(&s.maxFileSize); limit > 0 && int64(size+len(req.GetData())) > limit {
+			return status.Errorf(codes.ResourceExhausted, "file exceeds the limit of %d bytes", limit)
+		}
+
 		_, err = buf.Write(req.GetData())
 		if err != nil {
-			log.Errorf("Failed to copy data (%s)", err)
+			scopedLog.Errorf("Failed to copy data (%s)", err)
 			return err
 		}
 		size += len(req.GetData())
 	}
 
+	entry.Size = int64(buf.Len())
+	entry.Checksum = checksum(buf.Bytes())
+
 	if err := s.store.Write(stream.Context(), name, buf.Bytes()); err != nil {
+		if errors.Is(err, &storage.ReadOnlyError{}) {
+			return status.Errorf(codes.FailedPrecondition, "%s", err)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return status.Errorf(codes.Unavailable, "%s", err)
+		}
+		if errors.Is(err, storage.ErrInvalidName) {
+			return status.Errorf(codes.InvalidArgument, "invalid file name")
+		}
+		if errors.Is(err, &storage.LockedError{}) {
+			return status.Errorf(codes.PermissionDenied, "%s", err)
+		}
 		scopedLog.Errorf("Failed to write file (%s)", err)
 		return status.Errorf(codes.Internal, "failed to write file")
 	}
+	if len(metadata) > 0 {
+		if err := storage.SetMetadata(stream.Context(), s.store, name, metadata); err != nil {
+			// don't leave the file behind without its metadata
+			if err := s.store.Remove(stream.Context(), name); err != nil {
+				scopedLog.Errorf("Failed to remove file without metadata (%s)", err)
+			}
+			if errors.Is(err, storage.ErrMetadataUnsupported) {
+				return status.Errorf(codes.Unimplemented, "%s", err)
+			}
+			scopedLog.Errorf("Failed to set metadata (%s)", err)
+			return status.Errorf(codes.Internal, "failed to set metadata")
+		}
+	}
+	if s.index != nil {
+		s.index.Update(stream.Context(), name)
+	}
 
 	if err := stream.SendAndClose(&api.WriteResponse{}); err != nil {
-		log.Errorf("Failed to close the connection (%s)", err)
+		scopedLog.Errorf("Failed to close the connection (%s)", err)
 		return err
 	}
 
@@ -113,20 +280,140 @@ func (s *StorageService) Write(stream api.Storage_WriteServer) error {
 	return nil
 }
 
-func (s *StorageService) List(ctx context.Context, req *api.ListRequest) (*api.ListResponse, error) {
-	log.Info("Handling list request")
+func (s *StorageService) Append(stream api.Storage_AppendServer) (err error) {
+	entry := &AuditEntry{Operation: "append"}
+	defer func() { s.audit(stream.Context(), entry, true, err) }()
+
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid argument")
+	}
+
+	name := req.GetName()
+	entry.Name = name
+	scopedLog := requestLog(stream.Context()).WithFields(logrus.Fields{
+		"name": name,
+	})
+	scopedLog.Info("Handling append request")
+
+	if name == "" {
+		return status.Errorf(codes.InvalidArgument, "invalid file name")
+	}
+	offset := int64(-1)
+	if expected := req.GetExpectedOffset(); expected != nil {
+		if expected.Value < 0 {
+			return status.Errorf(codes.InvalidArgument, "expected offset must not be negative")
+		}
+		offset = expected.Value
+	}
+
+	var current int64
+	if fi, err := s.store.Stat(stream.Context(), name); err == nil {
+		if fi.Locked(time.Now()) {
+			return status.Errorf(codes.PermissionDenied, "%s", &storage.LockedError{Name: name,
+				Retention: storage.RetentionOf(fi.Metadata)})
+		}
+		if fi.Expired(time.Now()) {
+			// start over instead of appending to the expired file
+			if err := s.expire(stream.Context(), name); err != nil {
+				scopedLog.Errorf("Failed to remove expired file (%s)", err)
+				return status.Errorf(codes.Internal, "failed to append to file")
+			}
+		} else {
+			current = fi.Size
+		}
+	}
+
+	var buf bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			scopedLog.Errorf("Failed to receive data (%s)", err)
+			return err
+		}
+
+		if limit := atomic.LoadInt64(&s.maxFileSize); limit > 0 && current+int64(buf.Len()+len(req.GetData())) > limit {
+			return status.Errorf(codes.ResourceExhausted, "file exceeds the limit of %d bytes", limit)
+		}
+		buf.Write(req.GetData())
+	}
+
+	entry.Size = int64(buf.Len())
+	entry.Checksum = checksum(buf.Bytes())
+
+	size, err := storage.Append(stream.Context(), s.store, name, buf.Bytes(), offset)
+	if err != nil {
+		switch {
+		case errors.Is(err, &storage.OffsetMismatchError{}):
+			return status.Errorf(codes.FailedPrecondition, "%s", err)
+		case errors.Is(err, &storage.ReadOnlyError{}):
+			return status.Errorf(codes.FailedPrecondition, "%s", err)
+		case errors.Is(err, storage.ErrAppendUnsupported):
+			return status.Errorf(codes.Unimplemented, "%s", err)
+		case errors.Is(err, storage.ErrUnavailable):
+			return status.Errorf(codes.Unavailable, "%s", err)
+		case errors.Is(err, storage.ErrInvalidName):
+			return status.Errorf(codes.InvalidArgument, "invalid file name")
+		case errors.Is(err, &storage.LockedError{}):
+			return status.Errorf(codes.PermissionDenied, "%s", err)
+		}
+		scopedLog.Errorf("Failed to append to file (%s)", err)
+		return status.Errorf(codes.Internal, "failed to append to file")
+	}
+	// the file was created by this append if it only holds the appended data,
+	// the stat above may be outdated by concurrent appends
+	created := size == int64(buf.Len())
+	if retention, ok := worm.DefaultRetention(s.retentionRules, name, time.Now()); ok && created {
+		err := s.updateMetadata(stream.Context(), name, func(current map[string]string) map[string]string {
+			return retention.Apply(current)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if s.index != nil {
+		s.index.Update(stream.Context(), name)
+	}
+
+	if err := stream.SendAndClose(&api.AppendResponse{Size: size}); err != nil {
+		scopedLog.Errorf("Failed to close the connection (%s)", err)
+		return err
+	}
+
+	scopedLog.WithField("size", size).Info("Successfully handled append request")
+	return nil
+}
+
+func (s *StorageService) List(ctx context.Context, req *api.ListRequest) (_ *api.ListResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "list"}, false, err) }()
+
+	scopedLog := requestLog(ctx)
+	scopedLog.Info("Handling list request")
 
 	files, err := s.store.List(ctx)
 	if err != nil {
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to list files")
 	}
+	files = s.withoutExpired(files)
 
-	log.Info("Successfully handled list request")
+	scopedLog.Info("Successfully handled list request")
 	return &api.ListResponse{Files: files}, nil
 }
 
-func (s *StorageService) Stat(ctx context.Context, req *api.StatRequest) (*api.StatResponse, error) {
-	scopedLog := log.WithFields(logrus.Fields{
+func (s *StorageService) Stat(ctx context.Context, req *api.StatRequest) (_ *api.StatResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "stat", Name: req.Name}, false, err) }()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
 		"name": req.Name,
 	})
 	scopedLog.Info("Handling stat request")
@@ -137,45 +424,103 @@ func (s *StorageService) Stat(ctx context.Context, req *api.StatRequest) (*api.S
 
 	fi, err := s.store.Stat(ctx, req.Name)
 	if err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: req.Name}) {
+			return nil, status.Errorf(codes.NotFound, "file (%s) does not exist", req.Name)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to stat file %s", req.Name)
 	}
-	fileInfo := &api.FileInfo{
+	if fi.Expired(time.Now()) {
+		return nil, status.Errorf(codes.NotFound, "file (%s) does not exist", req.Name)
+	}
+
+	scopedLog.Info("Successfully handled stat request")
+	return &api.StatResponse{FileInfo: fileInfoToAPI(fi)}, nil
+}
+
+func fileInfoToAPI(fi *storage.FileInfo) *api.FileInfo {
+	return &api.FileInfo{
 		Name:    fi.Name,
 		Size:    fi.Size,
 		Mode:    fi.Mode,
 		ModTime: timestamppb.New(fi.ModTime),
 		Dir:     fi.Dir,
-	}
 
-	scopedLog.Info("Successfully handled stat request")
-	return &api.StatResponse{FileInfo: fileInfo}, nil
+		StoredSize:  fi.StoredSize,
+		Compression: fi.Compression,
+		Metadata:    fi.Metadata,
+		Tier:        fi.Tier,
+	}
 }
 
-func (s *StorageService) Remove(ctx context.Context, req *api.RemoveRequest) (*api.RemoveResponse, error) {
-	scopedLog := log.WithFields(logrus.Fields{
+func (s *StorageService) Remove(ctx context.Context, req *api.RemoveRequest) (_ *api.RemoveResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "remove", Name: req.Name}, true, err) }()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
 		"name": req.Name,
 	})
 	scopedLog.Info("Handling remove request")
 
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
 	if req.Name == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
 	}
 
-	if err := s.store.Remove(ctx, req.Name); err != nil {
+	if err := s.store.Remove(trash.WithDeleter(ctx, identityFromContext(ctx)), req.Name); err != nil {
+		if errors.Is(err, &storage.ReadOnlyError{}) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+		}
+		if errors.Is(err, &storage.LockedError{}) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s", err)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to remove file %s", req.Name)
 	}
+	if s.index != nil {
+		s.index.Remove(req.Name)
+	}
 
 	scopedLog.Info("Successfully handled remove request")
 	return &api.RemoveResponse{}, nil
 }
 
-func (s *StorageService) Rename(ctx context.Context, req *api.RenameRequest) (*api.RenameResponse, error) {
-	scopedLog := log.WithFields(logrus.Fields{
+// removeFile removes name on behalf of the server rather than a client, e.g.
+// because it expired. The removal is audited as operation, which is recorded
+// as the deleter if the file is moved into the trash.
+func (s *StorageService) removeFile(ctx context.Context, name, operation string) (err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: operation, Name: name}, true, err) }()
+
+	if err := s.store.Remove(trash.WithDeleter(ctx, operation), name); err != nil {
+		return err
+	}
+	if s.index != nil {
+		s.index.Remove(name)
+	}
+	return nil
+}
+
+func (s *StorageService) Rename(ctx context.Context, req *api.RenameRequest) (_ *api.RenameResponse, err error) {
+	defer func() {
+		s.audit(ctx, &AuditEntry{Operation: "rename", Name: req.Old, NewName: req.New}, true, err)
+	}()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
 		"old": req.Old,
 		"new": req.New,
 	})
 	scopedLog.Info("Handling rename request")
 
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
 	if req.Old == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid old file name")
 	}
@@ -184,9 +529,291 @@ func (s *StorageService) Rename(ctx context.Context, req *api.RenameRequest) (*a
 	}
 
 	if err := s.store.Rename(ctx, req.Old, req.New); err != nil {
+		if errors.Is(err, &storage.ReadOnlyError{}) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+		}
+		if errors.Is(err, &storage.LockedError{}) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s", err)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to rename file %s to %s", req.Old, req.New)
 	}
+	if s.index != nil {
+		s.index.Rename(req.Old, req.New)
+	}
 
 	scopedLog.Info("Successfully handled rename request")
 	return &api.RenameResponse{}, nil
 }
+
+func (s *StorageService) Copy(ctx context.Context, req *api.CopyRequest) (_ *api.CopyResponse, err error) {
+	defer func() {
+		s.audit(ctx, &AuditEntry{Operation: "copy", Name: req.Src, NewName: req.Dst}, true, err)
+	}()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"src": req.Src,
+		"dst": req.Dst,
+	})
+	scopedLog.Info("Handling copy request")
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if req.Src == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid source file name")
+	}
+	if req.Dst == "" || req.Dst == req.Src {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid destination file name")
+	}
+
+	if fi, err := s.store.Stat(ctx, req.Src); err != nil || fi.Expired(time.Now()) {
+		if err == nil || errors.Is(err, &storage.NotFoundError{Name: req.Src}) {
+			return nil, status.Errorf(codes.NotFound, "file %s not found", req.Src)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat file %s", req.Src)
+	}
+	if fi, err := s.store.Stat(ctx, req.Dst); err == nil {
+		if fi.Locked(time.Now()) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s", &storage.LockedError{Name: req.Dst,
+				Retention: storage.RetentionOf(fi.Metadata)})
+		}
+		if !fi.Expired(time.Now()) {
+			return nil, status.Errorf(codes.AlreadyExists, "file %s already exists", req.Dst)
+		}
+		if err := s.expire(ctx, req.Dst); err != nil {
+			scopedLog.Errorf("Failed to remove expired file (%s)", err)
+			return nil, status.Errorf(codes.Internal, "failed to copy file")
+		}
+	}
+
+	if err := storage.Copy(ctx, s.store, req.Src, req.Dst); err != nil {
+		var exists *storage.AlreadyExistsError
+		switch {
+		case errors.Is(err, &storage.ReadOnlyError{}):
+			return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+		case errors.Is(err, &storage.NotFoundError{Name: req.Src}):
+			return nil, status.Errorf(codes.NotFound, "file %s not found", req.Src)
+		case errors.As(err, &exists):
+			return nil, status.Errorf(codes.AlreadyExists, "file %s already exists", exists.Name)
+		case errors.Is(err, storage.ErrInvalidName):
+			return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
+		case errors.Is(err, &storage.LockedError{}):
+			return nil, status.Errorf(codes.PermissionDenied, "%s", err)
+		case errors.Is(err, storage.ErrUnavailable):
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
+		scopedLog.Errorf("Failed to copy file (%s)", err)
+		return nil, status.Errorf(codes.Internal, "failed to copy file %s to %s", req.Src, req.Dst)
+	}
+	if retention, ok := worm.DefaultRetention(s.retentionRules, req.Dst, time.Now()); ok {
+		err := s.updateMetadata(ctx, req.Dst, func(current map[string]string) map[string]string {
+			return retention.Apply(current)
+		})
+		if err != nil {
+			// don't leave the copy behind without its retention
+			if err := s.store.Remove(ctx, req.Dst); err != nil {
+				scopedLog.Errorf("Failed to remove copy without retention (%s)", err)
+			}
+			return nil, err
+		}
+	}
+	if s.index != nil {
+		s.index.Update(ctx, req.Dst)
+	}
+
+	scopedLog.Info("Successfully handled copy request")
+	return &api.CopyResponse{}, nil
+}
+
+func (s *StorageService) SetMetadata(ctx context.Context, req *api.SetMetadataRequest) (_ *api.SetMetadataResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "set-metadata", Name: req.Name}, true, err) }()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"name": req.Name,
+	})
+	scopedLog.Info("Handling set metadata request")
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
+	}
+	if err := storage.ValidateMetadata(req.Metadata); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	err = s.updateMetadata(ctx, req.Name, func(current map[string]string) map[string]string {
+		metadata := copyMetadata(req.Metadata)
+		// the expiry and the retention are kept unless they are replaced
+		for key, value := range current {
+			if _, ok := metadata[key]; !ok && strings.HasPrefix(key, storage.ReservedMetadataPrefix) {
+				metadata[key] = value
+			}
+		}
+		return metadata
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scopedLog.Info("Successfully handled set metadata request")
+	return &api.SetMetadataResponse{}, nil
+}
+
+func (s *StorageService) SetExpiry(ctx context.Context, req *api.SetExpiryRequest) (_ *api.SetExpiryResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "set-expiry", Name: req.Name}, true, err) }()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"name": req.Name,
+	})
+	scopedLog.Info("Handling set expiry request")
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid file name")
+	}
+	expiresAt, ok, err := expiryFromAPI(req.Ttl, req.ExpiresAt)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	err = s.updateMetadata(ctx, req.Name, func(current map[string]string) map[string]string {
+		if ok {
+			return withExpiry(current, expiresAt)
+		}
+		metadata := copyMetadata(current)
+		delete(metadata, storage.MetadataExpires)
+		return metadata
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scopedLog.Info("Successfully handled set expiry request")
+	return &api.SetExpiryResponse{}, nil
+}
+
+// updateMetadata replaces the metadata of name with the result of update,
+// which is called with the current metadata. It returns status errors.
+func (s *StorageService) updateMetadata(ctx context.Context, name string, update func(map[string]string) map[string]string) error {
+	scopedLog := requestLog(ctx).WithField("name", name)
+
+	fi, err := s.store.Stat(ctx, name)
+	if err == nil && fi.Expired(time.Now()) {
+		err = &storage.NotFoundError{Name: name}
+	}
+	if err == nil {
+		err = storage.SetMetadata(ctx, s.store, name, update(fi.Metadata))
+	}
+	if err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			return status.Errorf(codes.NotFound, "file (%s) does not exist", name)
+		}
+		if errors.Is(err, storage.ErrMetadataUnsupported) {
+			return status.Errorf(codes.Unimplemented, "%s", err)
+		}
+		if errors.Is(err, &storage.ReadOnlyError{}) {
+			return status.Errorf(codes.FailedPrecondition, "%s", err)
+		}
+		if errors.Is(err, &storage.LockedError{}) {
+			return status.Errorf(codes.PermissionDenied, "%s", err)
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return status.Errorf(codes.Unavailable, "%s", err)
+		}
+		scopedLog.Errorf("Failed to set metadata (%s)", err)
+		return status.Errorf(codes.Internal, "failed to set metadata of file %s", name)
+	}
+	if s.index != nil {
+		s.index.Update(ctx, name)
+	}
+	return nil
+}
+
+func (s *StorageService) Query(ctx context.Context, req *api.QueryRequest) (_ *api.QueryResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "query", Name: req.NamePattern}, false, err) }()
+
+	scopedLog := requestLog(ctx)
+	scopedLog.Info("Handling query request")
+
+	if s.index == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "metadata index is disabled")
+	}
+	query, err := queryFromAPI(req)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	result, err := s.index.Query(query)
+	if err != nil {
+		if errors.Is(err, index.ErrNotReady) {
+			return nil, status.Errorf(codes.Unavailable, "%s", err)
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	resp := &api.QueryResponse{NextPageToken: result.NextPageToken}
+	for _, fi := range result.Files {
+		resp.Files = append(resp.Files, fileInfoToAPI(fi))
+	}
+	scopedLog.WithField("files", len(resp.Files)).Info("Successfully handled query request")
+	return resp, nil
+}
+
+func queryFromAPI(req *api.QueryRequest) (*index.Query, error) {
+	query := &index.Query{
+		NamePattern: req.NamePattern,
+		MinSize:     req.MinSize,
+		MaxSize:     req.MaxSize,
+		Descending:  req.Descending,
+		PageSize:    int(req.PageSize),
+		PageToken:   req.PageToken,
+	}
+	if req.ModifiedAfter != nil {
+		query.ModifiedAfter = req.ModifiedAfter.AsTime()
+	}
+	if req.ModifiedBefore != nil {
+		query.ModifiedBefore = req.ModifiedBefore.AsTime()
+	}
+	switch req.Sort {
+	case api.QuerySort_QUERY_SORT_NAME:
+		query.Sort = index.SortName
+	case api.QuerySort_QUERY_SORT_SIZE:
+		query.Sort = index.SortSize
+	case api.QuerySort_QUERY_SORT_MOD_TIME:
+		query.Sort = index.SortModTime
+	default:
+		return nil, errors.Errorf("unknown sort %s", req.Sort)
+	}
+	for _, tag := range req.Tags {
+		predicate := index.TagPredicate{Key: tag.Key, Value: tag.Value}
+		switch tag.Op {
+		case api.TagOperator_TAG_OPERATOR_EQUAL:
+			predicate.Op = index.TagEqual
+		case api.TagOperator_TAG_OPERATOR_NOT_EQUAL:
+			predicate.Op = index.TagNotEqual
+		case api.TagOperator_TAG_OPERATOR_EXISTS:
+			predicate.Op = index.TagExists
+		case api.TagOperator_TAG_OPERATOR_NOT_EXISTS:
+			predicate.Op = index.TagNotExists
+		default:
+			return nil, errors.Errorf("unknown tag operator %s", tag.Op)
+		}
+		query.Tags = append(query.Tags, predicate)
+	}
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
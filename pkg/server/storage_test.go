@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage/compressed"
+)
+
+func readRange(ctx context.Context, client api.StorageClient, name string, offset, length int64) ([]byte, error) {
+	stream, err := client.Read(ctx, &api.ReadRequest{Name: name, Offset: offset, Length: length})
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, resp.Data...)
+	}
+}
+
+func TestRangedRead(t *testing.T) {
+	ctx := context.Background()
+	srv := startServer(t, WithCompression(compressed.Policy{Default: compressed.AlgorithmZstd}))
+	client := api.NewStorageClient(dial(t, srv.Addr().String()))
+
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := writeFile(ctx, client, "log", data); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, 0, data},
+		{300000, 400000, data[300000:700000]},
+		{999990, 0, data[999990:]},
+		{999990, 100, data[999990:]},
+		{2000000, 10, nil},
+	} {
+		got, err := readRange(ctx, client, "log", tc.offset, tc.length)
+		if err != nil {
+			t.Fatalf("read of %d+%d: %v", tc.offset, tc.length, err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("read of %d+%d returned %d bytes, want %d", tc.offset, tc.length, len(got), len(tc.want))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+const defaultTieringInterval = 10 * time.Minute
+
+// tieringLoop demotes the files which are due every interval until ctx is
+// done. Replicas demote as well, every server places its files on its own
+// tiers.
+func (s *Server) tieringLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := s.tiered.Demote(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Failed to demote files (%s)", err)
+			}
+			continue
+		}
+		if report.Demoted > 0 || len(report.Failed) > 0 {
+			log.WithField("demoted", report.Demoted).WithField("bytes", report.Bytes).
+				WithField("failed", len(report.Failed)).Info("Demoted files to the cold tier")
+		}
+	}
+}
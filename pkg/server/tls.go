@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// tlsReloader serves the most recently loaded certificates, so certificates
+// can be replaced without restarting the listener.
+type tlsReloader struct {
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+func newTLSReloader(certFile, keyFile, clientCAFile string, clientAuth tls.ClientAuthType) (*tlsReloader, error) {
+	config, err := loadTLSConfig(certFile, keyFile, clientCAFile, clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	r := &tlsReloader{}
+	r.set(config)
+	return r, nil
+}
+
+// loadTLSConfig loads the certificates, it doesn't touch the reloader so a
+// reload can be validated before anything is applied.
+func loadTLSConfig(certFile, keyFile, clientCAFile string, clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load certificate")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+func (r *tlsReloader) set(config *tls.Config) {
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+}
+
+// TLSConfig returns a config resolving to the current certificates on every
+// handshake.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/peertechde/argon/api"
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/trash"
+)
+
+const trashPurgeInterval = 10 * time.Minute
+
+// trashLoop purges the files trashed longer than the retention every interval
+// until ctx is done.
+func (s *Server) trashLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.Role() == RoleReplica {
+			// the primary's purges are replicated
+			continue
+		}
+		purged, err := s.trash.PurgeExpired(ctx, time.Now())
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, &storage.ReadOnlyError{}) {
+				log.Errorf("Failed to purge the trash (%s)", err)
+			}
+			continue
+		}
+		if purged > 0 {
+			log.WithField("purged", purged).Info("Purged files from the trash")
+		}
+	}
+}
+
+// trashError converts errors of the trash into gRPC errors.
+func trashError(err error, action string) error {
+	var notFound *trash.NotFoundError
+	var exists *storage.AlreadyExistsError
+	switch {
+	case errors.As(err, &notFound):
+		return status.Errorf(codes.NotFound, "%s", err)
+	case errors.As(err, &exists):
+		return status.Errorf(codes.AlreadyExists, "file (%s) already exists", exists.Name)
+	case errors.Is(err, storage.ErrInvalidName):
+		return status.Errorf(codes.InvalidArgument, "invalid file name")
+	case errors.Is(err, &storage.ReadOnlyError{}):
+		return status.Errorf(codes.FailedPrecondition, "%s", err)
+	case errors.Is(err, storage.ErrUnavailable):
+		return status.Errorf(codes.Unavailable, "%s", err)
+	}
+	return status.Errorf(codes.Internal, "failed to %s", action)
+}
+
+func (s *StorageService) checkTrash() error {
+	if s.trash == nil {
+		return status.Errorf(codes.FailedPrecondition, "trash is disabled")
+	}
+	return nil
+}
+
+func (s *StorageService) ListTrash(ctx context.Context, req *api.ListTrashRequest) (_ *api.ListTrashResponse, err error) {
+	defer func() { s.audit(ctx, &AuditEntry{Operation: "trash-list"}, false, err) }()
+
+	requestLog(ctx).Info("Handling list trash request")
+
+	if err := s.checkTrash(); err != nil {
+		return nil, err
+	}
+	entries, err := s.trash.Entries(ctx)
+	if err != nil {
+		requestLog(ctx).Errorf("Failed to list the trash (%s)", err)
+		return nil, trashError(err, "list the trash")
+	}
+	resp := &api.ListTrashResponse{}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &api.TrashEntry{
+			Id:      entry.ID,
+			Name:    entry.Name,
+			Deleted: timestamppb.New(entry.Deleted),
+			Deleter: entry.Deleter,
+			Size:    entry.Size,
+		})
+	}
+	return resp, nil
+}
+
+func (s *StorageService) RestoreTrash(ctx context.Context, req *api.RestoreTrashRequest) (_ *api.RestoreTrashResponse, err error) {
+	var restored string
+	defer func() {
+		s.audit(ctx, &AuditEntry{Operation: "trash-restore", Name: req.Id, NewName: restored}, true, err)
+	}()
+
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"id":   req.Id,
+		"name": req.Name,
+	})
+	scopedLog.Info("Handling restore trash request")
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.checkTrash(); err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "missing trash id")
+	}
+
+	restored, err = s.trash.Restore(ctx, req.Id, req.Name)
+	if err != nil {
+		scopedLog.Errorf("Failed to restore file (%s)", err)
+		return nil, trashError(err, "restore file "+req.Id)
+	}
+	if s.index != nil {
+		s.index.Update(ctx, restored)
+	}
+
+	scopedLog.WithField("restored", restored).Info("Successfully handled restore trash request")
+	return &api.RestoreTrashResponse{Name: restored}, nil
+}
+
+func (s *StorageService) PurgeTrash(ctx context.Context, req *api.PurgeTrashRequest) (_ *api.PurgeTrashResponse, err error) {
+	scopedLog := requestLog(ctx).WithFields(logrus.Fields{
+		"ids": len(req.Ids),
+		"all": req.All,
+	})
+	scopedLog.Info("Handling purge trash request")
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.checkTrash(); err != nil {
+		return nil, err
+	}
+	if req.All == (len(req.Ids) > 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "either ids or all have to be set")
+	}
+
+	resp := &api.PurgeTrashResponse{}
+	if req.All {
+		purged, err := s.trash.PurgeAll(ctx)
+		resp.Purged = int64(purged)
+		s.audit(ctx, &AuditEntry{Operation: "trash-purge-all"}, true, err)
+		if err != nil {
+			scopedLog.Errorf("Failed to purge the trash (%s)", err)
+			return nil, trashError(err, "purge the trash")
+		}
+	}
+	for _, id := range req.Ids {
+		err := s.trash.Purge(ctx, id)
+		s.audit(ctx, &AuditEntry{Operation: "trash-purge", Name: id}, true, err)
+		if err != nil {
+			scopedLog.WithField("id", id).Errorf("Failed to purge file (%s)", err)
+			return nil, trashError(err, "purge file "+id)
+		}
+		resp.Purged++
+	}
+
+	scopedLog.WithField("purged", resp.Purged).Info("Successfully handled purge trash request")
+	return resp, nil
+}
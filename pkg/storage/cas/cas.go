@@ -0,0 +1,462 @@
+// Package cas implements a content-addressable storage which deduplicates
+// the chunks of the stored files.
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+const (
+	chunksDir    = "chunks"
+	manifestsDir = "manifests"
+	tmpDir       = "tmp"
+
+	defaultPermissions = os.FileMode(0600)
+)
+
+// manifest lists the chunks a file consists of in order.
+type manifest struct {
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"mod_time"`
+	Chunks  []chunkRef `json:"chunks"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type chunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// chunk is the state of a stored chunk.
+type chunk struct {
+	refs int
+	size int64
+}
+
+// New returns a storage which splits every file into content-defined chunks
+// and stores every distinct chunk once in dir, named by its SHA-256 hash.
+// Every file is described by a manifest listing its chunks. Chunks are
+// reference-counted, chunks no file refers to anymore are removed by GC.
+//
+// The reference counts are rebuilt from the manifests when the storage is
+// opened, chunks left behind by an interrupted write are collected by the
+// next GC.
+func New(dir string) (*CAS, error) {
+	for _, sub := range []string{chunksDir, manifestsDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, errors.Wrap(err, "failed to prepare directory")
+		}
+	}
+	c := &CAS{
+		dir:    dir,
+		chunks: make(map[string]*chunk),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type CAS struct {
+	dir string
+
+	// reads share the lock, mutations and garbage collections take it
+	// exclusively
+	mu       sync.RWMutex
+	chunks   map[string]*chunk
+	logical  int64
+	physical int64
+}
+
+// load rebuilds the reference counts from the stored chunks and manifests.
+func (c *CAS) load() error {
+	tmps, err := os.ReadDir(filepath.Join(c.dir, tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range tmps {
+		os.Remove(filepath.Join(c.dir, tmpDir, tmp.Name()))
+	}
+
+	err = filepath.Walk(filepath.Join(c.dir, chunksDir), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		c.chunks[fi.Name()] = &chunk{size: fi.Size()}
+		c.physical += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list chunks")
+	}
+
+	names, err := c.list()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		m, err := c.readManifest(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read manifest of %s", name)
+		}
+		for _, ref := range m.Chunks {
+			ch, ok := c.chunks[ref.Hash]
+			if !ok {
+				log.WithField("name", name).Errorf("Chunk %s of file is missing", ref.Hash)
+				continue
+			}
+			ch.refs++
+		}
+		c.logical += m.Size
+	}
+	c.updateMetrics()
+	return nil
+}
+
+func (c *CAS) updateMetrics() {
+	logicalBytes.Set(float64(c.logical))
+	physicalBytes.Set(float64(c.physical))
+	chunksStored.Set(float64(len(c.chunks)))
+	if c.physical > 0 {
+		dedupRatio.Set(float64(c.logical) / float64(c.physical))
+	} else {
+		dedupRatio.Set(1)
+	}
+}
+
+func (c *CAS) chunkPath(hash string) string {
+	return filepath.Join(c.dir, chunksDir, hash[:2], hash)
+}
+
+func (c *CAS) manifestPath(name string) string {
+	return filepath.Join(c.dir, manifestsDir, name)
+}
+
+// writeFile atomically creates the file at path. The temporary file gets a
+// random name with the default permissions, the name of path may already be
+// as long as the filesystem allows.
+func (c *CAS) writeFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Join(c.dir, tmpDir), "")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *CAS) readManifest(name string) (*manifest, error) {
+	b, err := local.ReadFile(c.manifestPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &storage.NotFoundError{Name: name}
+		}
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	return &m, nil
+}
+
+// readChunk reads the chunk hash and verifies its content.
+func (c *CAS) readChunk(hash string) ([]byte, error) {
+	data, err := local.ReadFile(c.chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	if checksum(data) != hash {
+		return nil, errors.Errorf("chunk %s doesn't match its hash", hash)
+	}
+	return data, nil
+}
+
+func (c *CAS) Read(_ context.Context, name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, err := c.readManifest(name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, m.Size)
+	for _, ref := range m.Chunks {
+		b, err := c.readChunk(ref.Hash)
+		if err != nil {
+			log.WithField("name", name).Errorf("Failed to read chunk (%s)", err)
+			return nil, storage.ErrInternal
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+func (c *CAS) Write(_ context.Context, name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(c.manifestPath(name)); err == nil {
+		return &storage.AlreadyExistsError{Name: name}
+	}
+
+	m := &manifest{Size: int64(len(data)), ModTime: time.Now()}
+	for _, b := range split(data) {
+		hash := checksum(b)
+		m.Chunks = append(m.Chunks, chunkRef{Hash: hash, Size: int64(len(b))})
+		if _, ok := c.chunks[hash]; ok {
+			chunksDeduplicatedTotal.Inc()
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(c.chunkPath(hash)), 0700); err != nil {
+			return errors.Wrap(err, "failed to create chunk directory")
+		}
+		if err := c.writeFile(c.chunkPath(hash), b); err != nil {
+			return errors.Wrap(err, "failed to write chunk")
+		}
+		// unreferenced until the manifest has been written, a failed write
+		// leaves it to GC
+		c.chunks[hash] = &chunk{size: int64(len(b))}
+		c.physical += int64(len(b))
+		chunksWrittenTotal.Inc()
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	if err := c.writeFile(c.manifestPath(name), b); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	for _, ref := range m.Chunks {
+		c.chunks[ref.Hash].refs++
+	}
+	c.logical += m.Size
+	c.updateMetrics()
+	return nil
+}
+
+func (c *CAS) List(_ context.Context) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list()
+}
+
+func (c *CAS) list() ([]string, error) {
+	files, err := os.ReadDir(filepath.Join(c.dir, manifestsDir))
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		result = append(result, file.Name())
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (c *CAS) Stat(_ context.Context, name string) (*storage.FileInfo, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, err := c.readManifest(name)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.FileInfo{
+		Name:    name,
+		Size:    m.Size,
+		Mode:    uint32(defaultPermissions),
+		ModTime: m.ModTime,
+
+		Metadata: m.Metadata,
+	}, nil
+}
+
+// SetMetadata stores metadata in the manifest of name, the chunks are
+// unaffected.
+func (c *CAS) SetMetadata(_ context.Context, name string, metadata map[string]string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, err := c.readManifest(name)
+	if err != nil {
+		return err
+	}
+	m.Metadata = metadata
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	if err := c.writeFile(c.manifestPath(name), b); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	return nil
+}
+
+func (c *CAS) Rename(_ context.Context, old, new string) error {
+	if err := checkName(old); err != nil {
+		return err
+	}
+	if err := checkName(new); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(c.manifestPath(old)); os.IsNotExist(err) {
+		return &storage.NotFoundError{Name: old}
+	}
+	if _, err := os.Stat(c.manifestPath(new)); err == nil {
+		return &storage.AlreadyExistsError{Name: new}
+	}
+	return os.Rename(c.manifestPath(old), c.manifestPath(new))
+}
+
+// Remove removes the manifest of name, its chunks stay until the next GC if
+// no other file refers to them.
+func (c *CAS) Remove(_ context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, err := c.readManifest(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(c.manifestPath(name)); err != nil {
+		return err
+	}
+	for _, ref := range m.Chunks {
+		if ch, ok := c.chunks[ref.Hash]; ok && ch.refs > 0 {
+			ch.refs--
+		}
+	}
+	c.logical -= m.Size
+	c.updateMetrics()
+	return nil
+}
+
+// GC removes the chunks no file refers to.
+func (c *CAS) GC(ctx context.Context) (*storage.GCReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &storage.GCReport{}
+	defer c.updateMetrics()
+	for hash, ch := range c.chunks {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if ch.refs > 0 {
+			continue
+		}
+		if err := os.Remove(c.chunkPath(hash)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove chunk %s (%s)", hash, err)
+			continue
+		}
+		delete(c.chunks, hash)
+		c.physical -= ch.size
+		report.Removed++
+		report.FreedBytes += ch.size
+	}
+	return report, nil
+}
+
+// Scrub verifies every chunk and reports the files referring to missing or
+// corrupt chunks.
+func (c *CAS) Scrub(ctx context.Context) (*storage.ScrubReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	bad := make(map[string]struct{})
+	for hash := range c.chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := c.readChunk(hash); err != nil {
+			log.Errorf("Scrub found a bad chunk (%s)", err)
+			bad[hash] = struct{}{}
+		}
+	}
+
+	names, err := c.list()
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.ScrubReport{}
+	for _, name := range names {
+		report.Checked++
+		m, err := c.readManifest(name)
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, name)
+			continue
+		}
+		for _, ref := range m.Chunks {
+			_, isBad := bad[ref.Hash]
+			if _, ok := c.chunks[ref.Hash]; !ok || isBad {
+				report.Corrupt = append(report.Corrupt, name)
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+func (c *CAS) Capacity(_ context.Context) (*storage.Capacity, error) {
+	return local.Statfs(c.dir)
+}
+
+func (c *CAS) Close() error {
+	return nil
+}
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return storage.ErrInvalidName
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// insert returns data with extra inserted at offset.
+func insert(data []byte, offset int, extra []byte) []byte {
+	result := append([]byte{}, data[:offset]...)
+	result = append(result, extra...)
+	return append(result, data[offset:]...)
+}
+
+// refs returns the reference count of every stored chunk.
+func refs(c *CAS) map[string]int {
+	result := make(map[string]int, len(c.chunks))
+	for hash, ch := range c.chunks {
+		result[hash] = ch.refs
+	}
+	return result
+}
+
+func TestSplitIsStableAfterInsertion(t *testing.T) {
+	data := randomData(2 << 20)
+	chunks := split(data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks don't add up to the data")
+	}
+	hashes := make(map[string]struct{}, len(chunks))
+	for i, b := range chunks {
+		if len(b) > MaxChunkSize || (len(b) < MinChunkSize && i != len(chunks)-1) {
+			t.Fatalf("chunk %d has %d bytes", i, len(b))
+		}
+		hashes[checksum(b)] = struct{}{}
+	}
+
+	var changed int
+	for _, b := range split(insert(data, 1<<20, []byte("inserted"))) {
+		if _, ok := hashes[checksum(b)]; !ok {
+			changed++
+		}
+	}
+	// only the chunk around the insertion and possibly its successor change
+	if changed == 0 || changed > 2 {
+		t.Fatalf("%d of %d chunks changed after an insertion", changed, len(chunks))
+	}
+}
+
+func TestRefcounts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := randomData(1 << 20)
+	second := insert(first, 1<<19, []byte("inserted"))
+	if err := c.Write(ctx, "first", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(ctx, "second", second); err != nil {
+		t.Fatal(err)
+	}
+
+	unique := make(map[string]int64)
+	for _, b := range split(first) {
+		unique[checksum(b)] = int64(len(b))
+	}
+	for _, b := range split(second) {
+		delete(unique, checksum(b))
+	}
+	shared := refs(c)
+	for hash, n := range shared {
+		_, onlyFirst := unique[hash]
+		if (onlyFirst && n != 1) || (!onlyFirst && n < 1) {
+			t.Fatalf("chunk %s has %d references", hash, n)
+		}
+	}
+
+	// the counts are rebuilt from the manifests
+	reopened, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(refs(reopened), shared) {
+		t.Fatalf("reopening changed the references from %v to %v", shared, refs(reopened))
+	}
+	if reopened.logical != c.logical || reopened.physical != c.physical {
+		t.Fatalf("reopening changed the sizes from %d/%d to %d/%d", c.logical, c.physical,
+			reopened.logical, reopened.physical)
+	}
+
+	if err := c.Remove(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	for hash := range unique {
+		if n := c.chunks[hash].refs; n != 0 {
+			t.Fatalf("chunk %s of the removed file has %d references", hash, n)
+		}
+	}
+	report, err := c.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var freed int64
+	for _, size := range unique {
+		freed += size
+	}
+	if report.Removed != len(unique) || report.FreedBytes != freed {
+		t.Fatalf("GC removed %d chunks of %d bytes, expected %d of %d", report.Removed, report.FreedBytes,
+			len(unique), freed)
+	}
+	got, err := c.Read(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, second) {
+		t.Fatal("GC damaged the remaining file")
+	}
+	if report, err := c.GC(ctx); err != nil || report.Removed != 0 {
+		t.Fatalf("second GC removed %+v, %v", report, err)
+	}
+}
+
+func TestLongNames(t *testing.T) {
+	ctx := context.Background()
+	c, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.Repeat("n", 255)
+	if err := c.Write(ctx, name, []byte("content")); err != nil {
+		t.Fatalf("failed to write a file with a 255 byte name: %v", err)
+	}
+	if err := c.SetMetadata(ctx, name, map[string]string{"owner": "ops"}); err != nil {
+		t.Fatalf("failed to set the metadata of a file with a 255 byte name: %v", err)
+	}
+	fi, err := c.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size != int64(len("content")) || fi.Metadata["owner"] != "ops" {
+		t.Fatalf("got size %d and metadata %v", fi.Size, fi.Metadata)
+	}
+}
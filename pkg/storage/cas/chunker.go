@@ -0,0 +1,63 @@
+package cas
+
+const (
+	// MinChunkSize, AvgChunkSize and MaxChunkSize bound the chunks files are
+	// split into.
+	MinChunkSize = 16 << 10
+	AvgChunkSize = 64 << 10
+	MaxChunkSize = 256 << 10
+
+	// chunkMask has log2(AvgChunkSize) bits set, a boundary is declared when
+	// all of them are zero. The top bits of the gear hash depend on the last
+	// 64 bytes.
+	chunkMask = uint64(1<<16-1) << (64 - 16)
+)
+
+// gear maps every byte to a random value, it has to stay stable as it
+// determines the chunk boundaries of stored files.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	seed := uint64(0x6172676f6e636173)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// split cuts data into content-defined chunks using a gear rolling hash.
+// Boundaries only depend on the surrounding bytes, so an insertion or
+// removal only changes the chunks around it and the remaining chunks are
+// deduplicated.
+func split(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := boundary(data)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// boundary returns the length of the first chunk of data.
+func boundary(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+	end := len(data)
+	if end > MaxChunkSize {
+		end = MaxChunkSize
+	}
+	var h uint64
+	for i := MinChunkSize; i < end; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
@@ -0,0 +1,55 @@
+package cas
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "cas")
+
+var (
+	logicalBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "logical_bytes",
+	})
+	physicalBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "physical_bytes",
+	})
+	dedupRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "dedup_ratio",
+	})
+	chunksStored = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "chunks",
+	})
+	chunksWrittenTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "chunks_written_total",
+	})
+	chunksDeduplicatedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "cas",
+		Name:      "chunks_deduplicated_total",
+	})
+)
+
+// Collectors returns the content-addressable storage metrics for
+// registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		logicalBytes,
+		physicalBytes,
+		dedupRatio,
+		chunksStored,
+		chunksWrittenTotal,
+		chunksDeduplicatedTotal,
+	}
+}
@@ -0,0 +1,487 @@
+// Package compressed implements a storage wrapper which compresses the files
+// at rest.
+package compressed
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	// DefaultFrameSize is the amount of content compressed into one frame, a
+	// ranged read decompresses the frames it overlaps.
+	DefaultFrameSize = 256 << 10
+
+	// sampleSize bytes at the start, middle and end of a file are compressed
+	// to decide whether compressing it pays off.
+	sampleSize = 16 << 10
+	// maxSampleRatio is the compressed to original size ratio of the samples
+	// above which a file is stored uncompressed.
+	maxSampleRatio = 0.9
+
+	version = 1
+	// headerSize is the size of the fixed part of the header, which is
+	// followed by the compressed length of every frame.
+	headerSize = 24
+)
+
+// The algorithm and the content size of a file are recorded in its metadata,
+// so clients see the encoding and Stat doesn't have to read the header. The
+// header stays authoritative, the keys are recorded after the file is written
+// and only change when it is written again.
+const (
+	// MetadataAlgorithm holds the algorithm a file is compressed with.
+	MetadataAlgorithm = storage.ReservedMetadataPrefix + "compression"
+	// MetadataSize holds the size of the content before compression.
+	MetadataSize = storage.ReservedMetadataPrefix + "compression-size"
+)
+
+// encodingKeys are the metadata keys maintained by the wrapper.
+var encodingKeys = []string{MetadataAlgorithm, MetadataSize}
+
+// magic starts every stored file.
+var magic = []byte("argz")
+
+var algorithmIDs = map[string]byte{
+	AlgorithmNone: 0,
+	AlgorithmGzip: 1,
+	AlgorithmZstd: 2,
+}
+
+type Option func(*Compressed)
+
+// WithFrameSize sets the amount of content compressed into one frame.
+func WithFrameSize(size int) Option {
+	return func(c *Compressed) {
+		c.frameSize = size
+	}
+}
+
+// New returns a storage which compresses the files stored in store with the
+// algorithm policy selects for their name. Files whose sampled content
+// doesn't compress are stored uncompressed.
+//
+// Every file is split into frames which are compressed independently, a
+// header records the algorithm, the content size and the length of every
+// frame, so ranged reads only decompress the frames they need. The algorithm
+// and the content size are recorded in the metadata of the file as well if
+// store supports metadata.
+// Files stored before compression was enabled lack the header and are read as
+// they are.
+func New(store storage.Storage, policy Policy, options ...Option) (*Compressed, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd encoder")
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd decoder")
+	}
+	c := &Compressed{
+		store:     store,
+		policy:    policy,
+		frameSize: DefaultFrameSize,
+		encoder:   encoder,
+		decoder:   decoder,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.frameSize <= 0 {
+		return nil, errors.Errorf("invalid frame size %d", c.frameSize)
+	}
+	return c, nil
+}
+
+type Compressed struct {
+	store     storage.Storage
+	policy    Policy
+	frameSize int
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// header describes a stored file.
+type header struct {
+	algorithm string
+	frameSize int64
+	size      int64
+	frames    []int64
+}
+
+// length returns the size of the encoded header.
+func (h *header) length() int64 {
+	return headerSize + 4*int64(len(h.frames))
+}
+
+func (h *header) encode() []byte {
+	b := make([]byte, h.length())
+	copy(b, magic)
+	b[4] = version
+	b[5] = algorithmIDs[h.algorithm]
+	binary.BigEndian.PutUint32(b[8:], uint32(h.frameSize))
+	binary.BigEndian.PutUint64(b[12:], uint64(h.size))
+	binary.BigEndian.PutUint32(b[20:], uint32(len(h.frames)))
+	for i, n := range h.frames {
+		binary.BigEndian.PutUint32(b[headerSize+4*i:], uint32(n))
+	}
+	return b
+}
+
+// decodeHeader decodes the fixed part of the header at the start of b, it
+// returns nil if b doesn't start with a header.
+func decodeHeader(b []byte) *header {
+	if len(b) < headerSize || !bytes.Equal(b[:len(magic)], magic) || b[4] != version {
+		return nil
+	}
+	h := &header{
+		frameSize: int64(binary.BigEndian.Uint32(b[8:])),
+		size:      int64(binary.BigEndian.Uint64(b[12:])),
+		frames:    make([]int64, binary.BigEndian.Uint32(b[20:])),
+	}
+	for algorithm, id := range algorithmIDs {
+		if b[5] == id {
+			h.algorithm = algorithm
+		}
+	}
+	if h.algorithm == "" || h.frameSize <= 0 {
+		return nil
+	}
+	return h
+}
+
+// decodeFrames decodes the frame lengths following the fixed part of the
+// header and checks them against the stored size of the file.
+func (h *header) decodeFrames(b []byte, storedSize int64) bool {
+	if int64(len(b)) < 4*int64(len(h.frames)) {
+		return false
+	}
+	total := h.length()
+	for i := range h.frames {
+		h.frames[i] = int64(binary.BigEndian.Uint32(b[4*i:]))
+		total += h.frames[i]
+	}
+	return total == storedSize
+}
+
+// parse splits a stored file into its header and frames, it returns a nil
+// header for files stored without compression.
+func parse(raw []byte) (*header, []byte) {
+	h := decodeHeader(raw)
+	if h == nil || !h.decodeFrames(raw[headerSize:], int64(len(raw))) {
+		return nil, raw
+	}
+	return h, raw[h.length():]
+}
+
+func (c *Compressed) compress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmZstd:
+		return c.encoder.EncodeAll(data, nil), nil
+	case AlgorithmGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return data, nil
+	}
+}
+
+func (c *Compressed) decompress(algorithm string, frame []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmZstd:
+		return c.decoder.DecodeAll(frame, nil)
+	case AlgorithmGzip:
+		r, err := gzip.NewReader(bytes.NewReader(frame))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	default:
+		return frame, nil
+	}
+}
+
+// compressible compresses samples of data with algorithm and reports
+// whether they shrink enough.
+func (c *Compressed) compressible(algorithm string, data []byte) bool {
+	if len(data) <= 3*sampleSize {
+		sample, err := c.compress(algorithm, data)
+		return err == nil && float64(len(sample)) <= maxSampleRatio*float64(len(data))
+	}
+	var original, compressed int
+	for _, offset := range []int{0, len(data)/2 - sampleSize/2, len(data) - sampleSize} {
+		sample, err := c.compress(algorithm, data[offset:offset+sampleSize])
+		if err != nil {
+			return false
+		}
+		original += sampleSize
+		compressed += len(sample)
+	}
+	return float64(compressed) <= maxSampleRatio*float64(original)
+}
+
+// encode compresses data with the algorithm selected for name.
+func (c *Compressed) encode(name string, data []byte) ([]byte, string, error) {
+	algorithm := c.policy.Algorithm(name)
+	if algorithm != AlgorithmNone && len(data) > 0 && !c.compressible(algorithm, data) {
+		incompressibleFilesTotal.Inc()
+		algorithm = AlgorithmNone
+	}
+
+	h := &header{algorithm: algorithm, frameSize: int64(c.frameSize), size: int64(len(data))}
+	var frames [][]byte
+	for offset := 0; offset < len(data); offset += c.frameSize {
+		end := offset + c.frameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frame, err := c.compress(algorithm, data[offset:end])
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "failed to compress with %s", algorithm)
+		}
+		h.frames = append(h.frames, int64(len(frame)))
+		frames = append(frames, frame)
+	}
+	return append(h.encode(), bytes.Join(frames, nil)...), algorithm, nil
+}
+
+// decodeFrames decompresses the frames of h in [first, last) from body,
+// which starts at the first of them.
+func (c *Compressed) decodeFrames(h *header, body []byte, first, last int) ([]byte, error) {
+	data := make([]byte, 0, int64(last-first)*h.frameSize)
+	for i := first; i < last; i++ {
+		if int64(len(body)) < h.frames[i] {
+			return nil, errors.New("file is truncated")
+		}
+		frame, err := c.decompress(h.algorithm, body[:h.frames[i]])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decompress frame %d", i)
+		}
+		data = append(data, frame...)
+		body = body[h.frames[i]:]
+	}
+	return data, nil
+}
+
+func (c *Compressed) Read(ctx context.Context, name string) ([]byte, error) {
+	raw, err := c.store.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	h, body := parse(raw)
+	if h == nil {
+		return raw, nil
+	}
+	data, err := c.decodeFrames(h, body, 0, len(h.frames))
+	if err != nil {
+		log.WithField("name", name).Errorf("Failed to decompress file (%s)", err)
+		return nil, storage.ErrInternal
+	}
+	return data, nil
+}
+
+// ReadRange only reads and decompresses the frames overlapping the range if
+// the wrapped storage supports ranged reads.
+func (c *Compressed) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.Errorf("invalid range %d+%d", offset, length)
+	}
+	h, storedSize, err := c.readHeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return storage.ReadRange(ctx, c.store, name, offset, length)
+	}
+	if offset >= h.size || length == 0 {
+		return []byte{}, nil
+	}
+	if end := offset + length; end > h.size || end < 0 {
+		length = h.size - offset
+	}
+
+	first, last := int(offset/h.frameSize), int((offset+length-1)/h.frameSize)+1
+	start := h.length()
+	for i := 0; i < first; i++ {
+		start += h.frames[i]
+	}
+	var span int64
+	for i := first; i < last; i++ {
+		span += h.frames[i]
+	}
+	if start+span > storedSize {
+		log.WithField("name", name).Error("Compressed file is truncated")
+		return nil, storage.ErrInternal
+	}
+	body, err := storage.ReadRange(ctx, c.store, name, start, span)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.decodeFrames(h, body, first, last)
+	if err != nil {
+		log.WithField("name", name).Errorf("Failed to decompress file (%s)", err)
+		return nil, storage.ErrInternal
+	}
+	skip := offset - int64(first)*h.frameSize
+	return data[skip : skip+length], nil
+}
+
+// readHeader reads the header of name, it returns a nil header for files
+// stored without compression.
+func (c *Compressed) readHeader(ctx context.Context, name string) (*header, int64, error) {
+	fi, err := c.store.Stat(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := storage.ReadRange(ctx, c.store, name, 0, headerSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	h := decodeHeader(b)
+	if h == nil {
+		return nil, fi.Size, nil
+	}
+	b, err = storage.ReadRange(ctx, c.store, name, headerSize, 4*int64(len(h.frames)))
+	if err != nil {
+		return nil, 0, err
+	}
+	if !h.decodeFrames(b, fi.Size) {
+		return nil, fi.Size, nil
+	}
+	return h, fi.Size, nil
+}
+
+func (c *Compressed) Write(ctx context.Context, name string, data []byte) error {
+	raw, algorithm, err := c.encode(name, data)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Write(ctx, name, raw); err != nil {
+		return err
+	}
+	c.record(ctx, name, map[string]string{
+		MetadataAlgorithm: algorithm,
+		MetadataSize:      strconv.Itoa(len(data)),
+	})
+	logicalBytesTotal.WithLabelValues(algorithm).Add(float64(len(data)))
+	physicalBytesTotal.WithLabelValues(algorithm).Add(float64(len(raw)))
+	return nil
+}
+
+func (c *Compressed) List(ctx context.Context) ([]string, error) {
+	return c.store.List(ctx)
+}
+
+// Stat reports the size of the content and the stored size. The header is
+// only read for files without the encoding in their metadata.
+func (c *Compressed) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	fi, err := c.store.Stat(ctx, name)
+	if err != nil || fi.Dir {
+		return fi, err
+	}
+	algorithm := fi.Metadata[MetadataAlgorithm]
+	size, err := strconv.ParseInt(fi.Metadata[MetadataSize], 10, 64)
+	if _, ok := algorithmIDs[algorithm]; ok && err == nil && size >= 0 {
+		if fi.StoredSize == 0 {
+			fi.StoredSize = fi.Size
+		}
+		fi.Size = size
+		fi.Compression = algorithm
+		return fi, nil
+	}
+	h, _, err := c.readHeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		if fi.StoredSize == 0 {
+			fi.StoredSize = fi.Size
+		}
+		fi.Size = h.size
+		fi.Compression = h.algorithm
+	}
+	return fi, nil
+}
+
+func (c *Compressed) Rename(ctx context.Context, old, new string) error {
+	return c.store.Rename(ctx, old, new)
+}
+
+func (c *Compressed) Remove(ctx context.Context, name string) error {
+	return c.store.Remove(ctx, name)
+}
+
+// Copy copies the compressed content, which describes its own encoding.
+func (c *Compressed) Copy(ctx context.Context, src, dst string) error {
+	return storage.Copy(ctx, c.store, src, dst)
+}
+
+// record merges the keys describing the encoding of a written file into its
+// metadata. Failures are only logged, the header describes the encoding as
+// well.
+func (c *Compressed) record(ctx context.Context, name string, keys map[string]string) {
+	fi, err := c.store.Stat(ctx, name)
+	if err == nil {
+		metadata := make(map[string]string, len(fi.Metadata)+len(keys))
+		for key, value := range fi.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range keys {
+			metadata[key] = value
+		}
+		err = storage.SetMetadata(ctx, c.store, name, metadata)
+	}
+	if err != nil && !errors.Is(err, storage.ErrMetadataUnsupported) {
+		log.WithField("name", name).Warnf("Failed to record the encoding in the metadata (%s)", err)
+	}
+}
+
+// SetMetadata replaces the metadata of name but keeps the keys describing its
+// encoding, they only change when the file is written.
+func (c *Compressed) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	fi, err := c.store.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]string, len(metadata)+len(encodingKeys))
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	for _, key := range encodingKeys {
+		delete(merged, key)
+		if value, ok := fi.Metadata[key]; ok {
+			merged[key] = value
+		}
+	}
+	return storage.SetMetadata(ctx, c.store, name, merged)
+}
+
+func (c *Compressed) SetTier(ctx context.Context, name, tier string) error {
+	return storage.SetTier(ctx, c.store, name, tier)
+}
+
+func (c *Compressed) Close() error {
+	c.encoder.Close()
+	c.decoder.Close()
+	return c.store.Close()
+}
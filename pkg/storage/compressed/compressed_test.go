@@ -0,0 +1,51 @@
+package compressed
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+func TestAlgorithmInMetadata(t *testing.T) {
+	ctx := context.Background()
+	c, err := New(local.New(t.TempDir()), Policy{Default: AlgorithmZstd}, WithFrameSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := c.Write(ctx, "log", data); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := c.Stat(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size != int64(len(data)) || fi.Metadata[MetadataAlgorithm] != AlgorithmZstd {
+		t.Fatalf("got size %d and metadata %v, want %d and %s", fi.Size, fi.Metadata, len(data), AlgorithmZstd)
+	}
+
+	// replacing the metadata keeps the recorded algorithm
+	if err := c.SetMetadata(ctx, "log", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = c.Stat(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Metadata["owner"] != "ops" || fi.Metadata[MetadataAlgorithm] != AlgorithmZstd {
+		t.Fatalf("got metadata %v after replacing it", fi.Metadata)
+	}
+
+	// the range spans three frames
+	part, err := storage.ReadRange(ctx, c, "log", 1500, 2100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, data[1500:3600]) {
+		t.Fatalf("ranged read returned %d wrong bytes", len(part))
+	}
+}
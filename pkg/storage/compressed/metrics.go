@@ -0,0 +1,36 @@
+package compressed
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "compressed")
+
+var (
+	logicalBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "compression",
+		Name:      "logical_bytes_total",
+	}, []string{"algorithm"})
+	physicalBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "compression",
+		Name:      "physical_bytes_total",
+	}, []string{"algorithm"})
+	incompressibleFilesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "compression",
+		Name:      "incompressible_files_total",
+	})
+)
+
+// Collectors returns the compression metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		logicalBytesTotal,
+		physicalBytesTotal,
+		incompressibleFilesTotal,
+	}
+}
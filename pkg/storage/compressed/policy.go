@@ -0,0 +1,75 @@
+package compressed
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AlgorithmNone = "none"
+	AlgorithmGzip = "gzip"
+	AlgorithmZstd = "zstd"
+)
+
+// Rule selects the algorithm of the files whose name matches Pattern, see
+// filepath.Match.
+type Rule struct {
+	Pattern   string
+	Algorithm string
+}
+
+// Policy selects the algorithm of a file by the first matching rule, files
+// matching no rule are compressed with Default.
+type Policy struct {
+	Default string
+	Rules   []Rule
+}
+
+// ParseRule parses a rule given as "pattern=algorithm".
+func ParseRule(s string) (Rule, error) {
+	pattern, algorithm, ok := strings.Cut(s, "=")
+	if !ok {
+		return Rule{}, errors.Errorf("rule %q must be pattern=algorithm", s)
+	}
+	return Rule{Pattern: pattern, Algorithm: algorithm}, nil
+}
+
+// Validate checks the algorithms and patterns of p.
+func (p Policy) Validate() error {
+	if err := checkAlgorithm(p.Default); err != nil {
+		return err
+	}
+	for _, rule := range p.Rules {
+		if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+			return errors.Errorf("invalid pattern %q", rule.Pattern)
+		}
+		if err := checkAlgorithm(rule.Algorithm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Algorithm returns the algorithm name is compressed with.
+func (p Policy) Algorithm(name string) string {
+	for _, rule := range p.Rules {
+		if ok, _ := filepath.Match(rule.Pattern, name); ok {
+			return rule.Algorithm
+		}
+	}
+	if p.Default == "" {
+		return AlgorithmNone
+	}
+	return p.Default
+}
+
+func checkAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", AlgorithmNone, AlgorithmGzip, AlgorithmZstd:
+		return nil
+	default:
+		return errors.Errorf("unknown compression algorithm %q", algorithm)
+	}
+}
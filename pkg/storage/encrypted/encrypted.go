@@ -0,0 +1,583 @@
+// Package encrypted implements a storage wrapper which encrypts the files at
+// rest.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	// DefaultChunkSize is the amount of content encrypted as one chunk, a
+	// ranged read decrypts the chunks it overlaps.
+	DefaultChunkSize = 64 << 10
+
+	version    = 1
+	headerSize = 44
+	tagSize    = 16
+	idSize     = 16
+	prefixSize = 8
+
+	// orphanAge is the age after which a key record without a file is
+	// removed, younger records may belong to a write in progress.
+	orphanAge = time.Hour
+
+	defaultPermissions = os.FileMode(0600)
+)
+
+// MetadataSize is the metadata key recording the size of the content of a
+// file before encryption, so Stat doesn't have to read the header. The header
+// stays authoritative, the key is recorded after the file is written and only
+// changes when it is written again.
+const MetadataSize = storage.ReservedMetadataPrefix + "encryption-size"
+
+// magic starts every stored file.
+var magic = []byte("arge")
+
+// header describes a stored file. It is authenticated as additional data of
+// every chunk.
+type header struct {
+	chunkSize int64
+	size      int64
+	id        []byte
+	prefix    []byte
+	raw       []byte
+}
+
+func newHeader(chunkSize, size int64) *header {
+	h := &header{chunkSize: chunkSize, size: size, raw: make([]byte, headerSize)}
+	copy(h.raw, magic)
+	h.raw[4] = version
+	binary.BigEndian.PutUint32(h.raw[8:], uint32(chunkSize))
+	binary.BigEndian.PutUint64(h.raw[12:], uint64(size))
+	h.id = h.raw[20 : 20+idSize]
+	h.prefix = h.raw[20+idSize:]
+	if _, err := rand.Read(h.raw[20:]); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// decodeHeader decodes the header at the start of b, it returns nil if b
+// doesn't start with a header.
+func decodeHeader(b []byte) *header {
+	if len(b) < headerSize || !bytes.Equal(b[:len(magic)], magic) || b[4] != version {
+		return nil
+	}
+	raw := append([]byte{}, b[:headerSize]...)
+	h := &header{
+		chunkSize: int64(binary.BigEndian.Uint32(raw[8:])),
+		size:      int64(binary.BigEndian.Uint64(raw[12:])),
+		id:        raw[20 : 20+idSize],
+		prefix:    raw[20+idSize:],
+		raw:       raw,
+	}
+	if h.chunkSize <= 0 || h.size < 0 {
+		return nil
+	}
+	return h
+}
+
+func (h *header) chunks() int64 {
+	return (h.size + h.chunkSize - 1) / h.chunkSize
+}
+
+// storedSize returns the size of the stored file.
+func (h *header) storedSize() int64 {
+	return headerSize + h.size + h.chunks()*tagSize
+}
+
+// nonce returns the nonce of the i-th chunk.
+func (h *header) nonce(i int64) []byte {
+	nonce := make([]byte, prefixSize+4)
+	copy(nonce, h.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], uint32(i))
+	return nonce
+}
+
+// keyRecord holds the wrapped data key of a file.
+type keyRecord struct {
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+type Option func(*Encrypted)
+
+// WithChunkSize sets the amount of content encrypted as one chunk.
+func WithChunkSize(size int) Option {
+	return func(e *Encrypted) {
+		e.chunkSize = size
+	}
+}
+
+// New returns a storage which encrypts the files stored in store with
+// AES-256-GCM. Every file is encrypted with its own random data key, which
+// is wrapped with the active master key of the keyfile and kept in keysDir.
+// Rotate re-wraps the data keys with a new master key without touching the
+// files.
+//
+// Files are encrypted in chunks, each authenticated along with the header,
+// so ranged reads only decrypt the chunks they need. Files stored before
+// encryption was enabled lack the header and are read as they are.
+func New(store storage.Storage, keyfile, keysDir string, options ...Option) (*Encrypted, error) {
+	keys, err := loadKeyring(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(keysDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create keys directory")
+	}
+	e := &Encrypted{
+		store:     store,
+		keyfile:   keyfile,
+		keysDir:   keysDir,
+		keys:      keys,
+		chunkSize: DefaultChunkSize,
+	}
+	for _, option := range options {
+		option(e)
+	}
+	if e.chunkSize <= 0 {
+		return nil, errors.Errorf("invalid chunk size %d", e.chunkSize)
+	}
+	return e, nil
+}
+
+type Encrypted struct {
+	store     storage.Storage
+	keyfile   string
+	keysDir   string
+	chunkSize int
+
+	mu   sync.RWMutex
+	keys *keyring
+}
+
+// LoadKeyfile reads the keyfile again, e.g. after a new master key has been
+// added.
+func (e *Encrypted) LoadKeyfile() error {
+	keys, err := loadKeyring(e.keyfile)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if keys.active != e.keys.active {
+		log.WithField("key", keys.active).Info("Switched the active master key")
+	}
+	e.keys = keys
+	return nil
+}
+
+// ActiveKey returns the ID of the master key new data keys are wrapped with.
+func (e *Encrypted) ActiveKey() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.keys.active
+}
+
+func (e *Encrypted) keyring() *keyring {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.keys
+}
+
+func (e *Encrypted) recordPath(id []byte) string {
+	return filepath.Join(e.keysDir, hex.EncodeToString(id))
+}
+
+func (e *Encrypted) readRecord(id []byte) (*keyRecord, error) {
+	b, err := os.ReadFile(e.recordPath(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read data key")
+	}
+	var record keyRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, errors.Wrap(err, "failed to decode data key")
+	}
+	return &record, nil
+}
+
+// writeRecord atomically replaces the key record of the file id.
+func (e *Encrypted) writeRecord(id []byte, record *keyRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	path := e.recordPath(id)
+	if err := os.WriteFile(path+".tmp", b, defaultPermissions); err != nil {
+		return errors.Wrap(err, "failed to write data key")
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// aead returns the cipher of the file described by h.
+func (e *Encrypted) aead(h *header) (cipher.AEAD, error) {
+	record, err := e.readRecord(h.id)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.keyring().unwrap(h.id, record)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dataKey)
+}
+
+// decrypt decrypts the chunks of h in [first, last) from body, which starts
+// at the first of them.
+func (e *Encrypted) decrypt(h *header, body []byte, first, last int64) ([]byte, error) {
+	aead, err := e.aead(h)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, (last-first)*h.chunkSize)
+	for i := first; i < last; i++ {
+		n := h.chunkSize + tagSize
+		if i == h.chunks()-1 {
+			n = h.size - i*h.chunkSize + tagSize
+		}
+		if int64(len(body)) < n {
+			return nil, errors.New("file is truncated")
+		}
+		data, err = aead.Open(data, h.nonce(i), body[:n], h.raw)
+		if err != nil {
+			return nil, errors.Errorf("chunk %d failed authentication", i)
+		}
+		body = body[n:]
+	}
+	return data, nil
+}
+
+func (e *Encrypted) Read(ctx context.Context, name string) ([]byte, error) {
+	raw, err := e.store.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	h := decodeHeader(raw)
+	if h == nil {
+		return raw, nil
+	}
+	if h.storedSize() != int64(len(raw)) {
+		decryptFailuresTotal.Inc()
+		log.WithField("name", name).Error("Encrypted file has an unexpected size")
+		return nil, storage.ErrInternal
+	}
+	data, err := e.decrypt(h, raw[headerSize:], 0, h.chunks())
+	if err != nil {
+		decryptFailuresTotal.Inc()
+		log.WithField("name", name).Errorf("Failed to decrypt file (%s)", err)
+		return nil, storage.ErrInternal
+	}
+	return data, nil
+}
+
+// ReadRange only reads and decrypts the chunks overlapping the range if the
+// wrapped storage supports ranged reads.
+func (e *Encrypted) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.Errorf("invalid range %d+%d", offset, length)
+	}
+	h, err := e.readHeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return storage.ReadRange(ctx, e.store, name, offset, length)
+	}
+	if offset >= h.size || length == 0 {
+		return []byte{}, nil
+	}
+	if end := offset + length; end > h.size || end < 0 {
+		length = h.size - offset
+	}
+
+	first, last := offset/h.chunkSize, (offset+length-1)/h.chunkSize+1
+	start := headerSize + first*(h.chunkSize+tagSize)
+	end := headerSize + last*(h.chunkSize+tagSize)
+	if end > h.storedSize() {
+		end = h.storedSize()
+	}
+	body, err := storage.ReadRange(ctx, e.store, name, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.decrypt(h, body, first, last)
+	if err != nil {
+		decryptFailuresTotal.Inc()
+		log.WithField("name", name).Errorf("Failed to decrypt file (%s)", err)
+		return nil, storage.ErrInternal
+	}
+	skip := offset - first*h.chunkSize
+	return data[skip : skip+length], nil
+}
+
+// readHeader reads the header of name, it returns nil for files stored
+// without encryption.
+func (e *Encrypted) readHeader(ctx context.Context, name string) (*header, error) {
+	b, err := storage.ReadRange(ctx, e.store, name, 0, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeader(b), nil
+}
+
+func (e *Encrypted) Write(ctx context.Context, name string, data []byte) error {
+	h := newHeader(int64(e.chunkSize), int64(len(data)))
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "failed to generate data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 0, h.storedSize())
+	raw = append(raw, h.raw...)
+	for i := int64(0); i < h.chunks(); i++ {
+		chunk := data[i*h.chunkSize:]
+		if int64(len(chunk)) > h.chunkSize {
+			chunk = chunk[:h.chunkSize]
+		}
+		raw = aead.Seal(raw, h.nonce(i), chunk, h.raw)
+	}
+
+	// the key is written first, a failed write leaves an orphaned key which
+	// Rotate removes
+	if err := e.writeRecord(h.id, e.keyring().wrap(h.id, dataKey)); err != nil {
+		return err
+	}
+	if err := e.store.Write(ctx, name, raw); err != nil {
+		os.Remove(e.recordPath(h.id))
+		return err
+	}
+	e.recordSize(ctx, name, h.size)
+	return nil
+}
+
+// recordSize records the content size of a written file in its metadata.
+// Failures are only logged, Stat falls back to reading the header.
+func (e *Encrypted) recordSize(ctx context.Context, name string, size int64) {
+	fi, err := e.store.Stat(ctx, name)
+	if err == nil {
+		metadata := make(map[string]string, len(fi.Metadata)+1)
+		for key, value := range fi.Metadata {
+			metadata[key] = value
+		}
+		metadata[MetadataSize] = strconv.FormatInt(size, 10)
+		err = storage.SetMetadata(ctx, e.store, name, metadata)
+	}
+	if err != nil && !errors.Is(err, storage.ErrMetadataUnsupported) {
+		log.WithField("name", name).Warnf("Failed to record the content size in the metadata (%s)", err)
+	}
+}
+
+func (e *Encrypted) List(ctx context.Context) ([]string, error) {
+	return e.store.List(ctx)
+}
+
+// Stat reports the size of the content and the stored size. The header is
+// only read for files without the content size in their metadata.
+func (e *Encrypted) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	fi, err := e.store.Stat(ctx, name)
+	if err != nil || fi.Dir {
+		return fi, err
+	}
+	if size, err := strconv.ParseInt(fi.Metadata[MetadataSize], 10, 64); err == nil && size >= 0 {
+		fi.StoredSize = fi.Size
+		fi.Size = size
+		return fi, nil
+	}
+	h, err := e.readHeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		fi.StoredSize = fi.Size
+		fi.Size = h.size
+	}
+	return fi, nil
+}
+
+func (e *Encrypted) Rename(ctx context.Context, old, new string) error {
+	return e.store.Rename(ctx, old, new)
+}
+
+// Remove removes name and its data key.
+func (e *Encrypted) Remove(ctx context.Context, name string) error {
+	h, err := e.readHeader(ctx, name)
+	if err != nil && !errors.Is(err, &storage.NotFoundError{Name: name}) {
+		return err
+	}
+	if err := e.store.Remove(ctx, name); err != nil {
+		return err
+	}
+	if h != nil {
+		if err := os.Remove(e.recordPath(h.id)); err != nil && !os.IsNotExist(err) {
+			log.WithField("name", name).Warnf("Failed to remove data key (%s)", err)
+		}
+	}
+	return nil
+}
+
+// SetMetadata passes metadata through, it is stored unencrypted. The recorded
+// content size is kept, it only changes when the file is written.
+func (e *Encrypted) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	fi, err := e.store.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	delete(merged, MetadataSize)
+	if value, ok := fi.Metadata[MetadataSize]; ok {
+		merged[MetadataSize] = value
+	}
+	return storage.SetMetadata(ctx, e.store, name, merged)
+}
+
+func (e *Encrypted) SetTier(ctx context.Context, name, tier string) error {
+	return storage.SetTier(ctx, e.store, name, tier)
+}
+
+func (e *Encrypted) Close() error {
+	return e.store.Close()
+}
+
+// FileKey names the master key the data key of a file is wrapped with, it
+// is empty for files stored without encryption.
+type FileKey struct {
+	Name  string
+	KeyID string
+}
+
+// Keys returns the master key of every file.
+func (e *Encrypted) Keys(ctx context.Context) ([]FileKey, error) {
+	names, err := e.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []FileKey
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h, err := e.readHeader(ctx, name)
+		if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := FileKey{Name: name}
+		if h != nil {
+			record, err := e.readRecord(h.id)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read data key of %s", name)
+			}
+			key.KeyID = record.KeyID
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+// RotateReport summarizes a key rotation.
+type RotateReport struct {
+	Checked   int
+	Rewrapped int
+	Removed   int
+	Failed    []string
+}
+
+// Rotate re-wraps the data keys wrapped with other than the active master
+// key, after which the other keys can be removed from the keyfile. Data keys
+// of files which don't exist anymore are removed.
+func (e *Encrypted) Rotate(ctx context.Context) (*RotateReport, error) {
+	keys := e.keyring()
+	names, err := e.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RotateReport{}
+	used := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		h, err := e.readHeader(ctx, name)
+		if err != nil || h == nil {
+			continue
+		}
+		used[hex.EncodeToString(h.id)] = struct{}{}
+		report.Checked++
+
+		rewrapped, err := e.rewrap(keys, h.id)
+		if err != nil {
+			log.WithField("name", name).Errorf("Failed to re-wrap data key (%s)", err)
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		if rewrapped {
+			report.Rewrapped++
+		}
+	}
+
+	records, err := os.ReadDir(e.keysDir)
+	if err != nil {
+		return report, err
+	}
+	for _, record := range records {
+		if _, ok := used[record.Name()]; ok {
+			continue
+		}
+		info, err := record.Info()
+		if err != nil || time.Since(info.ModTime()) < orphanAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(e.keysDir, record.Name())); err == nil {
+			report.Removed++
+		}
+	}
+	return report, nil
+}
+
+// rewrap wraps the data key of the file id with the active master key, it
+// reports whether the key had been wrapped with another one.
+func (e *Encrypted) rewrap(keys *keyring, id []byte) (bool, error) {
+	record, err := e.readRecord(id)
+	if err != nil {
+		return false, err
+	}
+	if record.KeyID == keys.active {
+		return false, nil
+	}
+	dataKey, err := keys.unwrap(id, record)
+	if err != nil {
+		return false, err
+	}
+	if err := e.writeRecord(id, keys.wrap(id, dataKey)); err != nil {
+		return false, err
+	}
+	rewrappedKeysTotal.Inc()
+	return true, nil
+}
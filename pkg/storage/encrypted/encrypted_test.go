@@ -0,0 +1,250 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/compressed"
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+// writeKeyfile writes a keyfile with a random key for every id, the last one
+// is active.
+func writeKeyfile(t *testing.T, path string, ids ...string) {
+	t.Helper()
+	var lines []string
+	for _, id := range ids {
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, id+":"+base64.StdEncoding.EncodeToString(key))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// counting counts the reads of the content of files.
+type counting struct {
+	storage.Storage
+	reads int
+}
+
+func (c *counting) Read(ctx context.Context, name string) ([]byte, error) {
+	c.reads++
+	return c.Storage.Read(ctx, name)
+}
+
+func (c *counting) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	c.reads++
+	return storage.ReadRange(ctx, c.Storage, name, offset, length)
+}
+
+func (c *counting) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	return storage.SetMetadata(ctx, c.Storage, name, metadata)
+}
+
+func newEncrypted(t *testing.T, store storage.Storage, options ...Option) (*Encrypted, string) {
+	t.Helper()
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "keyfile")
+	writeKeyfile(t, keyfile, "first")
+	e, err := New(store, keyfile, filepath.Join(dir, "keys"), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, keyfile
+}
+
+func TestStatDoesNotReadContent(t *testing.T) {
+	ctx := context.Background()
+	backend := &counting{Storage: local.New(t.TempDir())}
+	e, _ := newEncrypted(t, backend)
+	c, err := compressed.New(e, compressed.Policy{Default: compressed.AlgorithmZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("content"), 10000)
+	if err := c.Write(ctx, "log", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetMetadata(ctx, "log", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.reads = 0
+	fi, err := c.Stat(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.reads != 0 {
+		t.Fatalf("stat read the content %d times", backend.reads)
+	}
+	if fi.Size != int64(len(data)) || fi.Compression != compressed.AlgorithmZstd || fi.Metadata["owner"] != "ops" {
+		t.Fatalf("got size %d, compression %q and metadata %v", fi.Size, fi.Compression, fi.Metadata)
+	}
+	stored, err := e.Stat(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.StoredSize <= stored.Size {
+		t.Fatalf("stored size %d doesn't include the encryption overhead of %d bytes", stored.StoredSize, stored.Size)
+	}
+}
+
+const testChunkSize = 16
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newEncrypted(t, local.New(t.TempDir()), WithChunkSize(testChunkSize))
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 3 * testChunkSize, 100} {
+		name := "file-" + strconv.Itoa(size)
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Write(ctx, name, data); err != nil {
+			t.Fatal(err)
+		}
+		got, err := e.Read(ctx, name)
+		if err != nil {
+			t.Fatalf("failed to read %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("read of %d bytes returned different content", size)
+		}
+	}
+}
+
+func TestReadRangeAtChunkEdges(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newEncrypted(t, local.New(t.TempDir()), WithChunkSize(testChunkSize))
+	data := make([]byte, 3*testChunkSize+5)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ offset, length int64 }{
+		{0, testChunkSize},
+		{testChunkSize - 1, 2},
+		{testChunkSize, testChunkSize},
+		{testChunkSize + 1, testChunkSize},
+		{2*testChunkSize - 1, testChunkSize + 2},
+		{3 * testChunkSize, 5},
+		{3*testChunkSize + 4, 100},
+		{int64(len(data)), 1},
+	} {
+		got, err := e.ReadRange(ctx, "file", r.offset, r.length)
+		if err != nil {
+			t.Fatalf("failed to read %d+%d: %v", r.offset, r.length, err)
+		}
+		end := r.offset + r.length
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if !bytes.Equal(got, data[r.offset:end]) {
+			t.Fatalf("read of %d+%d returned different content", r.offset, r.length)
+		}
+	}
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	e, _ := newEncrypted(t, local.New(dir), WithChunkSize(testChunkSize))
+	data := bytes.Repeat([]byte("content"), 10)
+	if err := e.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		stored []byte
+	}{
+		{"modified chunk", func() []byte {
+			b := append([]byte{}, stored...)
+			b[headerSize+2*(testChunkSize+tagSize)+1] ^= 0xff
+			return b
+		}()},
+		{"modified size", func() []byte {
+			b := append([]byte{}, stored...)
+			b[19]--
+			return b[:len(b)-1]
+		}()},
+		{"truncated", stored[:len(stored)-testChunkSize]},
+		{"dropped last chunk", stored[:headerSize+4*(testChunkSize+tagSize)]},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "file"), tc.stored, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Read(ctx, "file"); err == nil {
+			t.Fatalf("%s: read succeeded", tc.name)
+		}
+		if _, err := e.ReadRange(ctx, "file", 2*testChunkSize, int64(len(data))); err == nil {
+			t.Fatalf("%s: ranged read succeeded", tc.name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	e, keyfile := newEncrypted(t, local.New(t.TempDir()))
+	data := []byte("content")
+	if err := e.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+
+	// add a new active key, then drop the old one once rotated
+	keys, err := os.ReadFile(keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKeyfile(t, keyfile, "second")
+	second, err := os.ReadFile(keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyfile, append(keys, second...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadKeyfile(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := e.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || report.Rewrapped != 1 || len(report.Failed) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if err := os.WriteFile(keyfile, second, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadKeyfile(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Read(ctx, "file")
+	if err != nil {
+		t.Fatalf("failed to read with the new master key: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read returned different content")
+	}
+}
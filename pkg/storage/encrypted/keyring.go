@@ -0,0 +1,106 @@
+package encrypted
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keySize is the size of master and data keys, keys are used for AES-256.
+const keySize = 32
+
+// keyring holds the master keys of a keyfile.
+type keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// loadKeyring reads the keyfile at path. Every line holds a key as
+// "id:base64 encoded key", empty lines and lines starting with # are
+// ignored. The last key is the active one, new data keys are wrapped with
+// it, the others are kept to unwrap the data keys of existing files until
+// they have been rotated.
+func loadKeyring(path string) (*keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open keyfile")
+	}
+	defer f.Close()
+
+	k := &keyring{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, ":")
+		if !ok || id == "" {
+			return nil, errors.Errorf("keyfile line %d must be id:key", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, errors.Errorf("keyfile line %d must hold a base64 encoded %d byte key", line, keySize)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, errors.Errorf("keyfile line %d repeats key %s", line, id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		k.active = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read keyfile")
+	}
+	if k.active == "" {
+		return nil, errors.New("keyfile holds no key")
+	}
+	return k, nil
+}
+
+// wrap encrypts the data key of the file id with the active master key.
+func (k *keyring) wrap(id, dataKey []byte) *keyRecord {
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return &keyRecord{
+		KeyID:      k.active,
+		WrappedKey: aead.Seal(nonce, nonce, dataKey, id),
+	}
+}
+
+// unwrap decrypts the data key of the file id.
+func (k *keyring) unwrap(id []byte, record *keyRecord) ([]byte, error) {
+	aead, ok := k.keys[record.KeyID]
+	if !ok {
+		return nil, errors.Errorf("master key %s is not in the keyfile", record.KeyID)
+	}
+	if len(record.WrappedKey) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	nonce, sealed := record.WrappedKey[:aead.NonceSize()], record.WrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, id)
+	if err != nil {
+		return nil, errors.Errorf("failed to unwrap data key with master key %s", record.KeyID)
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
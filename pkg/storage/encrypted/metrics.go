@@ -0,0 +1,30 @@
+package encrypted
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "encrypted")
+
+var (
+	decryptFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "encryption",
+		Name:      "decrypt_failures_total",
+	})
+	rewrappedKeysTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "encryption",
+		Name:      "rewrapped_keys_total",
+	})
+)
+
+// Collectors returns the encryption metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		decryptFailuresTotal,
+		rewrappedKeysTotal,
+	}
+}
@@ -0,0 +1,498 @@
+// Package erasure implements a storage which spreads every file across
+// several directories, usually on different disks, with Reed-Solomon coding.
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+const (
+	shardsDir = "shards"
+	metaDir   = "meta"
+	tmpDir    = "tmp"
+
+	defaultPermissions = os.FileMode(0600)
+)
+
+// fileMeta describes how a file has been encoded. A copy is stored next to
+// the shard in every directory, so it survives the loss of as many
+// directories as the file itself.
+type fileMeta struct {
+	Size         int64     `json:"size"`
+	DataShards   int       `json:"data_shards"`
+	ParityShards int       `json:"parity_shards"`
+	ModTime      time.Time `json:"mod_time"`
+	Checksums    []string  `json:"checksums"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// New returns a storage which splits every file into dataShards data and
+// parityShards parity shards and stores shard i in dirs[i]. Files can be
+// read as long as any dataShards of their shards are intact, so up to
+// parityShards directories may be missing or corrupt. Shards are verified
+// against the checksums recorded at write time.
+//
+// Directories which aren't accessible are treated as failed disks, Heal
+// rebuilds their shards once they have been replaced.
+func New(dirs []string, dataShards, parityShards int) (*Erasure, error) {
+	if dataShards <= 0 || parityShards < 0 {
+		return nil, errors.Errorf("invalid number of shards %d+%d", dataShards, parityShards)
+	}
+	if len(dirs) != dataShards+parityShards {
+		return nil, errors.Errorf("%d+%d shards require %d directories, got %d", dataShards, parityShards,
+			dataShards+parityShards, len(dirs))
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create encoder")
+	}
+
+	e := &Erasure{
+		dirs:         dirs,
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		enc:          enc,
+	}
+	if available := e.prepareDirs(); available < dataShards {
+		return nil, errors.Errorf("only %d of %d directories are available, at least %d are required",
+			available, len(dirs), dataShards)
+	}
+	return e, nil
+}
+
+type Erasure struct {
+	dirs         []string
+	dataShards   int
+	parityShards int
+	enc          reedsolomon.Encoder
+
+	// reads share the lock, mutations and heals of a file take it exclusively
+	mu sync.RWMutex
+}
+
+// prepareDirs creates the layout in every accessible directory and returns
+// their number.
+func (e *Erasure) prepareDirs() int {
+	var available int
+	for _, dir := range e.dirs {
+		if _, err := os.Stat(dir); err != nil {
+			log.WithField("dir", dir).Warnf("Directory is unavailable (%s)", err)
+			continue
+		}
+		var err error
+		for _, sub := range []string{shardsDir, metaDir, tmpDir} {
+			if err = os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.WithField("dir", dir).Warnf("Failed to prepare directory (%s)", err)
+			continue
+		}
+		available++
+	}
+	return available
+}
+
+func (e *Erasure) shardPath(i int, name string) string {
+	return filepath.Join(e.dirs[i], shardsDir, name)
+}
+
+func (e *Erasure) metaPath(i int, name string) string {
+	return filepath.Join(e.dirs[i], metaDir, name)
+}
+
+// writeFile atomically replaces the file at path in the i-th directory.
+func (e *Erasure) writeFile(i int, path string, data []byte) error {
+	tmp := filepath.Join(e.dirs[i], tmpDir, filepath.Base(filepath.Dir(path))+"-"+filepath.Base(path))
+	if err := os.WriteFile(tmp, data, defaultPermissions); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (e *Erasure) Read(_ context.Context, name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	meta, _, err := e.readMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Size == 0 {
+		return []byte{}, nil
+	}
+	enc, err := e.encoder(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, bad := e.readShards(name, meta)
+	if len(bad) > 0 {
+		degradedReadsTotal.Inc()
+		log.WithField("name", name).WithField("shards", bad).Warn("Reconstructing file from the intact shards")
+		if err := enc.ReconstructData(shards); err != nil {
+			unrecoverableReadsTotal.Inc()
+			return nil, errors.Wrapf(err, "too few intact shards of %s", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Join(&buf, shards, int(meta.Size)); err != nil {
+		return nil, errors.Wrapf(err, "failed to join shards of %s", name)
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Erasure) Write(_ context.Context, name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, _, err := e.readMeta(name); err == nil {
+		return &storage.AlreadyExistsError{Name: name}
+	}
+
+	shards, err := e.encode(data)
+	if err != nil {
+		return err
+	}
+	meta := &fileMeta{
+		Size:         int64(len(data)),
+		DataShards:   e.dataShards,
+		ParityShards: e.parityShards,
+		ModTime:      time.Now(),
+		Checksums:    make([]string, len(shards)),
+	}
+	for i, shard := range shards {
+		meta.Checksums[i] = checksum(shard)
+	}
+
+	var written []int
+	for i := range e.dirs {
+		if err := e.writeShard(i, name, shards[i], meta); err != nil {
+			log.WithField("dir", e.dirs[i]).WithField("name", name).Warnf("Failed to write shard (%s)", err)
+			continue
+		}
+		written = append(written, i)
+	}
+	if len(written) < e.dataShards {
+		for _, i := range written {
+			e.removeShard(i, name)
+		}
+		return errors.Errorf("wrote only %d of %d shards of %s, at least %d are required", len(written),
+			len(shards), name, e.dataShards)
+	}
+	if len(written) < len(shards) {
+		log.WithField("name", name).Warnf("Wrote %d of %d shards, run heal after replacing the failed disks",
+			len(written), len(shards))
+	}
+	return nil
+}
+
+// encode splits data into data shards and computes the parity shards.
+func (e *Erasure) encode(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return make([][]byte, e.dataShards+e.parityShards), nil
+	}
+	shards, err := e.enc.Split(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to split data")
+	}
+	if err := e.enc.Encode(shards); err != nil {
+		return nil, errors.Wrap(err, "failed to encode data")
+	}
+	return shards, nil
+}
+
+// encoder returns the encoder matching the layout of a file.
+func (e *Erasure) encoder(meta *fileMeta) (reedsolomon.Encoder, error) {
+	if meta.DataShards != e.dataShards || meta.ParityShards != e.parityShards {
+		return nil, errors.Errorf("file was written with %d+%d shards, the storage uses %d+%d",
+			meta.DataShards, meta.ParityShards, e.dataShards, e.parityShards)
+	}
+	return e.enc, nil
+}
+
+// writeShard atomically writes shard i and its metadata. The metadata is
+// written last, a shard without metadata is ignored.
+func (e *Erasure) writeShard(i int, name string, shard []byte, meta *fileMeta) error {
+	if err := e.writeFile(i, e.shardPath(i, name), shard); err != nil {
+		return err
+	}
+	return e.writeMeta(i, name, meta)
+}
+
+func (e *Erasure) writeMeta(i int, name string, meta *fileMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(i, e.metaPath(i, name), b)
+}
+
+func (e *Erasure) removeShard(i int, name string) error {
+	errMeta := os.Remove(e.metaPath(i, name))
+	errShard := os.Remove(e.shardPath(i, name))
+	if errMeta != nil && !os.IsNotExist(errMeta) {
+		return errMeta
+	}
+	if errShard != nil && !os.IsNotExist(errShard) {
+		return errShard
+	}
+	return nil
+}
+
+// readMeta returns the most recent metadata of name and the directories
+// whose copy is missing or outdated.
+func (e *Erasure) readMeta(name string) (*fileMeta, []int, error) {
+	metas := make([]*fileMeta, len(e.dirs))
+	var newest *fileMeta
+	for i := range e.dirs {
+		b, err := local.ReadFile(e.metaPath(i, name))
+		if err != nil {
+			continue
+		}
+		var meta fileMeta
+		if err := json.Unmarshal(b, &meta); err != nil {
+			log.WithField("dir", e.dirs[i]).WithField("name", name).Warnf("Ignoring corrupt metadata (%s)", err)
+			continue
+		}
+		if len(meta.Checksums) != meta.DataShards+meta.ParityShards {
+			continue
+		}
+		metas[i] = &meta
+		if newest == nil || meta.ModTime.After(newest.ModTime) {
+			newest = &meta
+		}
+	}
+	if newest == nil {
+		return nil, nil, &storage.NotFoundError{Name: name}
+	}
+
+	var stale []int
+	for i, meta := range metas {
+		if meta == nil || !meta.ModTime.Equal(newest.ModTime) {
+			stale = append(stale, i)
+		}
+	}
+	return newest, stale, nil
+}
+
+// readShards reads all shards of name, missing shards and shards which don't
+// match their checksum are nil and returned as bad.
+func (e *Erasure) readShards(name string, meta *fileMeta) ([][]byte, []int) {
+	shards := make([][]byte, len(e.dirs))
+	var bad []int
+	for i := range e.dirs {
+		shard, err := local.ReadFile(e.shardPath(i, name))
+		if err != nil || checksum(shard) != meta.Checksums[i] {
+			bad = append(bad, i)
+			continue
+		}
+		shards[i] = shard
+	}
+	return shards, bad
+}
+
+func (e *Erasure) List(_ context.Context) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	names := make(map[string]struct{})
+	var available int
+	for i := range e.dirs {
+		files, err := os.ReadDir(filepath.Join(e.dirs[i], metaDir))
+		if err != nil {
+			continue
+		}
+		available++
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			names[file.Name()] = struct{}{}
+		}
+	}
+	if available < e.dataShards {
+		return nil, errors.Errorf("only %d of %d directories are available", available, len(e.dirs))
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (e *Erasure) Stat(_ context.Context, name string) (*storage.FileInfo, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	meta, _, err := e.readMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.FileInfo{
+		Name:    name,
+		Size:    meta.Size,
+		Mode:    uint32(defaultPermissions),
+		ModTime: meta.ModTime,
+
+		Metadata: meta.Metadata,
+	}, nil
+}
+
+// SetMetadata stores metadata next to the checksums of name in every
+// directory.
+func (e *Erasure) SetMetadata(_ context.Context, name string, metadata map[string]string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, _, err := e.readMeta(name)
+	if err != nil {
+		return err
+	}
+	meta.Metadata = metadata
+
+	// stale shards don't match the checksums of meta and are rebuilt by heal
+	var updated int
+	for i := range e.dirs {
+		if err := e.writeMeta(i, name, meta); err != nil {
+			log.WithField("dir", e.dirs[i]).Warnf("Failed to write metadata of %s (%s)", name, err)
+			continue
+		}
+		updated++
+	}
+	if updated < e.dataShards {
+		return errors.Errorf("updated only %d shards of %s, at least %d are required", updated, name, e.dataShards)
+	}
+	return nil
+}
+
+func (e *Erasure) Rename(_ context.Context, old, new string) error {
+	if err := checkName(old); err != nil {
+		return err
+	}
+	if err := checkName(new); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, _, err := e.readMeta(old); err != nil {
+		return err
+	}
+	if _, _, err := e.readMeta(new); err == nil {
+		return &storage.AlreadyExistsError{Name: new}
+	}
+
+	var renamed int
+	for i := range e.dirs {
+		// rename the shard first, metadata without a shard reads as a bad shard
+		err := os.Rename(e.shardPath(i, old), e.shardPath(i, new))
+		if err != nil && !os.IsNotExist(err) {
+			log.WithField("dir", e.dirs[i]).Warnf("Failed to rename shard of %s (%s)", old, err)
+			continue
+		}
+		if err := os.Rename(e.metaPath(i, old), e.metaPath(i, new)); err != nil {
+			if !os.IsNotExist(err) {
+				log.WithField("dir", e.dirs[i]).Warnf("Failed to rename metadata of %s (%s)", old, err)
+			}
+			continue
+		}
+		renamed++
+	}
+	if renamed < e.dataShards {
+		return errors.Errorf("renamed only %d shards of %s, at least %d are required", renamed, old, e.dataShards)
+	}
+	return nil
+}
+
+func (e *Erasure) Remove(_ context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, _, err := e.readMeta(name); err != nil {
+		return err
+	}
+	var failed int
+	for i := range e.dirs {
+		if err := e.removeShard(i, name); err != nil {
+			log.WithField("dir", e.dirs[i]).Warnf("Failed to remove shard of %s (%s)", name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to remove %d shards of %s", failed, name)
+	}
+	return nil
+}
+
+// Capacity reports the space available for file contents, which is the raw
+// space of the directories reduced by the parity overhead.
+func (e *Erasure) Capacity(_ context.Context) (*storage.Capacity, error) {
+	var raw storage.Capacity
+	for _, dir := range e.dirs {
+		capacity, err := local.Statfs(dir)
+		if err != nil {
+			continue
+		}
+		raw.Total += capacity.Total
+		raw.Free += capacity.Free
+		raw.Used += capacity.Used
+	}
+	n, k := uint64(len(e.dirs)), uint64(e.dataShards)
+	return &storage.Capacity{
+		Total: raw.Total / n * k,
+		Free:  raw.Free / n * k,
+		Used:  raw.Used / n * k,
+	}, nil
+}
+
+func (e *Erasure) Close() error {
+	return nil
+}
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return storage.ErrInvalidName
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,147 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testDataShards   = 4
+	testParityShards = 2
+)
+
+func newErasure(t *testing.T) (*Erasure, []string) {
+	t.Helper()
+	root := t.TempDir()
+	dirs := make([]string, testDataShards+testParityShards)
+	for i := range dirs {
+		dirs[i] = filepath.Join(root, string(rune('a'+i)))
+		if err := os.Mkdir(dirs[i], 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := New(dirs, testDataShards, testParityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, dirs
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func corruptShard(t *testing.T, e *Erasure, i int, name string) {
+	t.Helper()
+	path := e.shardPath(i, name)
+	shard, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard[0] ^= 0xff
+	if err := os.WriteFile(path, shard, defaultPermissions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWithFailedShards(t *testing.T) {
+	ctx := context.Background()
+	e, dirs := newErasure(t)
+	data := randomData(t, 10000)
+	if err := e.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed disk and a corrupt shard are within the parity
+	if err := os.RemoveAll(dirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	corruptShard(t, e, 3, "file")
+	got, err := e.Read(ctx, "file")
+	if err != nil {
+		t.Fatalf("failed to read with %d bad shards: %v", testParityShards, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read returned different content")
+	}
+
+	corruptShard(t, e, 5, "file")
+	if _, err := e.Read(ctx, "file"); err == nil {
+		t.Fatalf("read succeeded with %d bad shards", testParityShards+1)
+	}
+}
+
+func TestHeal(t *testing.T) {
+	ctx := context.Background()
+	e, dirs := newErasure(t)
+	if err := e.Write(ctx, "file", randomData(t, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep the shard of the first version in one directory
+	oldShard, err := os.ReadFile(e.shardPath(2, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldMeta, err := os.ReadFile(e.metaPath(2, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Remove(ctx, "file"); err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(t, 10000)
+	if err := e.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.shardPath(2, "file"), oldShard, defaultPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.metaPath(2, "file"), oldMeta, defaultPermissions); err != nil {
+		t.Fatal(err)
+	}
+	// replace a failed disk with an empty one
+	if err := os.RemoveAll(dirs[4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dirs[4], 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := e.Heal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || report.Healed != 1 || report.Rebuilt != 2 || len(report.Unrecoverable) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// the rebuilt shards have to be usable in place of the others
+	for _, i := range []int{0, 1} {
+		if err := os.Remove(e.shardPath(i, "file")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := e.Read(ctx, "file")
+	if err != nil {
+		t.Fatalf("failed to read from the rebuilt shards: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read returned different content")
+	}
+
+	report, err = e.Heal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rebuilt != 2 {
+		t.Fatalf("expected the removed shards to be rebuilt, got %+v", report)
+	}
+}
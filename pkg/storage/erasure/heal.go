@@ -0,0 +1,106 @@
+package erasure
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// Heal verifies every file and rebuilds its missing and corrupt shards and
+// metadata from the intact shards. Rebuilt counts the repaired shards, files
+// with too few intact shards are reported as unrecoverable.
+func (e *Erasure) Heal(ctx context.Context) (*storage.HealReport, error) {
+	e.prepareDirs()
+
+	names, err := e.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.HealReport{}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		rebuilt, err := e.heal(name)
+		report.Checked++
+		if err != nil {
+			log.WithField("name", name).Errorf("Failed to heal file (%s)", err)
+			report.Unrecoverable = append(report.Unrecoverable, name)
+			continue
+		}
+		if rebuilt > 0 {
+			log.WithField("name", name).WithField("shards", rebuilt).Info("Healed file")
+			report.Healed++
+			report.Rebuilt += rebuilt
+			healedShardsTotal.Add(float64(rebuilt))
+		}
+	}
+	return report, nil
+}
+
+// heal repairs a single file and returns the number of rebuilt shards.
+func (e *Erasure) heal(name string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, stale, err := e.readMeta(name)
+	if err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			// removed since it was listed
+			return 0, nil
+		}
+		return 0, err
+	}
+	enc, err := e.encoder(meta)
+	if err != nil {
+		return 0, err
+	}
+
+	shards, bad := e.readShards(name, meta)
+	if len(bad) == 0 && len(stale) == 0 {
+		return 0, nil
+	}
+	if len(bad) > 0 && meta.Size > 0 {
+		if err := enc.Reconstruct(shards); err != nil {
+			return 0, errors.Wrap(err, "too few intact shards")
+		}
+		for _, i := range bad {
+			if checksum(shards[i]) != meta.Checksums[i] {
+				return 0, errors.Errorf("rebuilt shard %d doesn't match its checksum", i)
+			}
+		}
+	}
+
+	repair := make(map[int]struct{}, len(bad)+len(stale))
+	for _, i := range bad {
+		repair[i] = struct{}{}
+	}
+	for _, i := range stale {
+		repair[i] = struct{}{}
+	}
+	var rebuilt int
+	for i := range repair {
+		if err := e.writeShard(i, name, shards[i], meta); err != nil {
+			log.WithField("dir", e.dirs[i]).WithField("name", name).Warnf("Failed to rebuild shard (%s)", err)
+			continue
+		}
+		rebuilt++
+	}
+	return rebuilt, nil
+}
+
+// Scrub heals all files, corrupt files are the ones which couldn't be
+// recovered.
+func (e *Erasure) Scrub(ctx context.Context) (*storage.ScrubReport, error) {
+	report, err := e.Heal(ctx)
+	if report == nil {
+		return nil, err
+	}
+	return &storage.ScrubReport{
+		Checked:  report.Checked,
+		Repaired: report.Healed,
+		Corrupt:  report.Unrecoverable,
+	}, err
+}
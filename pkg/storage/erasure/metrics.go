@@ -0,0 +1,36 @@
+package erasure
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "erasure")
+
+var (
+	degradedReadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "erasure",
+		Name:      "degraded_reads_total",
+	})
+	unrecoverableReadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "erasure",
+		Name:      "unrecoverable_reads_total",
+	})
+	healedShardsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "erasure",
+		Name:      "healed_shards_total",
+	})
+)
+
+// Collectors returns the erasure coding metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		degradedReadsTotal,
+		unrecoverableReadsTotal,
+		healedShardsTotal,
+	}
+}
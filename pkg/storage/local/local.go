@@ -3,6 +3,7 @@ package local
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -38,6 +39,38 @@ func (l *Local) Read(_ context.Context, name string) ([]byte, error) {
 	return b, nil
 }
 
+func (l *Local) ReadRange(_ context.Context, name string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", offset, length)
+	}
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &storage.NotFoundError{Name: name}
+		}
+		return nil, storage.ErrInternal
+	}
+	defer f.Close()
+
+	// the length is given by clients, only allocate what the file holds
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, storage.ErrInternal
+	}
+	if offset >= fi.Size() {
+		return []byte{}, nil
+	}
+	if end := offset + length; end > fi.Size() || end < 0 {
+		length = fi.Size() - offset
+	}
+	b := make([]byte, length)
+	n, err := f.ReadAt(b, offset)
+	if err != nil && err != io.EOF {
+		return nil, storage.ErrInternal
+	}
+	return b[:n], nil
+}
+
 func (l *Local) Write(_ context.Context, name string, data []byte) error {
 	if _, err := os.Stat(l.path(name)); err == nil {
 		return &storage.AlreadyExistsError{Name: name}
@@ -72,9 +105,27 @@ func (l *Local) Stat(_ context.Context, name string) (*storage.FileInfo, error)
 		}
 		return nil, storage.ErrInternal
 	}
+	if fi.Metadata, err = GetMetadata(l.path(name)); err != nil {
+		return nil, storage.ErrInternal
+	}
 	return fi, nil
 }
 
+// SetMetadata stores the metadata of name in an extended attribute, so it
+// follows the file through renames and is removed with it.
+func (l *Local) SetMetadata(_ context.Context, name string, metadata map[string]string) error {
+	if !isFileName(name) {
+		return storage.ErrInvalidName
+	}
+	if _, err := Stat(l.path(name)); err != nil {
+		if os.IsNotExist(err) {
+			return &storage.NotFoundError{Name: name}
+		}
+		return err
+	}
+	return SetMetadata(l.path(name), metadata)
+}
+
 func (l *Local) Rename(_ context.Context, old, new string) error {
 	if _, err := Stat(l.path(old)); os.IsNotExist(err) {
 		return &storage.NotFoundError{Name: old}
@@ -86,10 +137,82 @@ func (l *Local) Rename(_ context.Context, old, new string) error {
 
 }
 
+// Append appends data to name in place, see AppendFile.
+func (l *Local) Append(_ context.Context, name string, data []byte, offset int64) (int64, error) {
+	if dir := filepath.Dir(name); dir != "" && dir != "." {
+		return 0, storage.ErrInvalidName
+	}
+	return AppendFile(l.path(name), data, offset, defaultPermissions)
+}
+
+// Copy copies src with its metadata to dst without reading it into memory. The
+// copy shares the blocks of src if the filesystem supports reflinks.
+func (l *Local) Copy(_ context.Context, src, dst string) error {
+	if !isFileName(src) || !isFileName(dst) {
+		return storage.ErrInvalidName
+	}
+	fi, err := Stat(l.path(src))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &storage.NotFoundError{Name: src}
+		}
+		return storage.ErrInternal
+	}
+	if _, err := os.Stat(l.path(dst)); err == nil {
+		return &storage.AlreadyExistsError{Name: dst}
+	}
+	metadata, err := GetMetadata(l.path(src))
+	if err != nil {
+		return storage.ErrInternal
+	}
+	if err := CopyFile(l.path(src), l.path(dst), os.FileMode(fi.Mode).Perm()); err != nil {
+		if os.IsExist(err) {
+			return &storage.AlreadyExistsError{Name: dst}
+		}
+		return err
+	}
+	if len(metadata) > 0 {
+		if err := SetMetadata(l.path(dst), metadata); err != nil {
+			_ = os.Remove(l.path(dst))
+			return err
+		}
+	}
+	return nil
+}
+
+// isFileName reports whether name refers to a file directly in the storage
+// directory.
+func isFileName(name string) bool {
+	return filepath.Dir(name) == "." && name != "" && name != "." && name != ".."
+}
+
 func (l *Local) Remove(_ context.Context, name string) error {
 	return Remove(l.path(name))
 }
 
+func (l *Local) Capacity(_ context.Context) (*storage.Capacity, error) {
+	return Statfs(l.dir)
+}
+
+// Scrub reads every file to detect files which can't be read back anymore.
+func (l *Local) Scrub(ctx context.Context) (*storage.ScrubReport, error) {
+	files, err := l.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.ScrubReport{}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if _, err := ReadFile(l.path(file)); err != nil {
+			report.Corrupt = append(report.Corrupt, file)
+		}
+		report.Checked++
+	}
+	return report, nil
+}
+
 func (l *Local) Close() error {
 	return nil
 }
@@ -4,7 +4,14 @@
 package local
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 
 	"github.com/peertechde/argon/pkg/storage"
 )
@@ -38,6 +45,155 @@ func Stat(name string) (*storage.FileInfo, error) {
 	return fileInfo, nil
 }
 
+// metadataAttr is the extended attribute holding the JSON encoded metadata of
+// a file.
+const metadataAttr = "user.argon.metadata"
+
+// GetMetadata returns the metadata stored in the extended attributes of name,
+// nil if it has none or the filesystem doesn't support extended attributes.
+func GetMetadata(name string) (map[string]string, error) {
+	buf := make([]byte, storage.MaxMetadataSize*2)
+	for {
+		n, err := syscall.Getxattr(name, metadataAttr, buf)
+		switch err {
+		case nil:
+			var metadata map[string]string
+			if err := json.Unmarshal(buf[:n], &metadata); err != nil {
+				return nil, err
+			}
+			return metadata, nil
+		case syscall.ERANGE:
+			buf = make([]byte, len(buf)*2)
+		case syscall.ENODATA, syscall.ENOTSUP:
+			return nil, nil
+		default:
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+	}
+}
+
+// SetMetadata replaces the metadata stored in the extended attributes of name.
+func SetMetadata(name string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		err := syscall.Removexattr(name, metadataAttr)
+		if err != nil && err != syscall.ENODATA && err != syscall.ENOTSUP {
+			return &os.PathError{Op: "removexattr", Path: name, Err: err}
+		}
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Setxattr(name, metadataAttr, data, 0); err != nil {
+		if err == syscall.ENOTSUP {
+			return storage.ErrMetadataUnsupported
+		}
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+// AppendFile appends data to the file name, which is created with perm if it
+// doesn't exist, and returns its new size. The file is opened with O_APPEND
+// and locked exclusively, so concurrent appends neither interleave nor race
+// with the offset check. Unless offset is negative, nothing is appended if
+// the file doesn't have offset bytes.
+func AppendFile(name string, data []byte, offset int64, perm os.FileMode) (int64, error) {
+	if err := checkName(filepath.Base(name)); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		if errors.Is(err, syscall.EISDIR) {
+			return 0, storage.ErrInvalidName
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return 0, &os.PathError{Op: "flock", Path: name, Err: err}
+	}
+	// released by closing the file
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	if offset >= 0 && offset != size {
+		return 0, &storage.OffsetMismatchError{Name: filepath.Base(name), Expected: offset, Actual: size}
+	}
+	if _, err := f.Write(data); err != nil {
+		// don't leave a partial append behind
+		_ = f.Truncate(size)
+		return 0, err
+	}
+	return size + int64(len(data)), nil
+}
+
+// CopyFile copies the content of src to the new file dst. It clones src with
+// FICLONE if the filesystem supports reflinks and falls back to
+// copy_file_range, and to a plain copy if that isn't supported either, e.g.
+// across filesystems.
+func CopyFile(src, dst string, perm os.FileMode) error {
+	if err := checkName(dst); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func copyFile(out, in *os.File) error {
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err == nil {
+		return nil
+	}
+	var copied int64
+	for {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, 1<<30, 0)
+		if err != nil {
+			if copied == 0 && copyFallback(err) {
+				_, err = io.Copy(out, in)
+				return err
+			}
+			return &os.PathError{Op: "copy_file_range", Path: in.Name(), Err: err}
+		}
+		if n == 0 {
+			return nil
+		}
+		copied += int64(n)
+	}
+}
+
+// copyFallback reports whether copy_file_range failed because it isn't
+// supported between the files.
+func copyFallback(err error) bool {
+	switch err {
+	case unix.EXDEV, unix.ENOSYS, unix.EOPNOTSUPP, unix.EINVAL:
+		return true
+	}
+	return false
+}
+
 func Rename(old, new string) error {
 	if err := checkName(new); err != nil {
 		return err
@@ -72,3 +228,17 @@ func checkName(name string) error {
 
 	return nil
 }
+
+func Statfs(path string) (*storage.Capacity, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	total := st.Blocks * uint64(st.Bsize)
+	free := st.Bavail * uint64(st.Bsize)
+	return &storage.Capacity{
+		Total: total,
+		Free:  free,
+		Used:  total - st.Bfree*uint64(st.Bsize),
+	}, nil
+}
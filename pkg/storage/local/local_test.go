@@ -0,0 +1,63 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+func TestReadRangeClampsLength(t *testing.T) {
+	ctx := context.Background()
+	l := New(t.TempDir())
+	if err := l.Write(ctx, "file", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	reader := l.(*Local)
+
+	data, err := reader.ReadRange(ctx, "file", 3, math.MaxInt64)
+	if err != nil {
+		t.Fatalf("failed to read a huge range: %v", err)
+	}
+	if string(data) != "tent" {
+		t.Fatalf("got %q, want %q", data, "tent")
+	}
+	data, err = reader.ReadRange(ctx, "file", 100, math.MaxInt64)
+	if err != nil || len(data) != 0 {
+		t.Fatalf("got %q (%v) past the end, want nothing", data, err)
+	}
+}
+
+func TestNamesOutsideTheDirectory(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "storage")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "outside"), []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l := New(dir)
+	if err := l.Write(ctx, "file", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	setter := l.(storage.MetadataSetter)
+	copier := l.(*Local)
+
+	for _, name := range []string{"../outside", "..", ".", ""} {
+		if err := setter.SetMetadata(ctx, name, map[string]string{"key": "value"}); !errors.Is(err, storage.ErrInvalidName) {
+			t.Errorf("SetMetadata(%q): got %v, want ErrInvalidName", name, err)
+		}
+		if err := copier.Copy(ctx, name, "copy"); !errors.Is(err, storage.ErrInvalidName) {
+			t.Errorf("Copy(%q, copy): got %v, want ErrInvalidName", name, err)
+		}
+	}
+	if metadata, err := GetMetadata(filepath.Join(root, "outside")); err != nil || len(metadata) != 0 {
+		t.Fatalf("file outside the directory has metadata %v (%v)", metadata, err)
+	}
+}
@@ -0,0 +1,36 @@
+package mirror
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "mirror")
+
+var (
+	readFailoversTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "mirror",
+		Name:      "read_failovers_total",
+	})
+	resyncedFilesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "mirror",
+		Name:      "resynced_files_total",
+	})
+	degraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "mirror",
+		Name:      "degraded",
+	}, []string{"child"})
+)
+
+// Collectors returns the mirror metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		readFailoversTotal,
+		resyncedFilesTotal,
+		degraded,
+	}
+}
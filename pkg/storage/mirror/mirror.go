@@ -0,0 +1,464 @@
+// Package mirror implements a storage which keeps a full copy of every file
+// on each of several child storages.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	defaultResyncInterval = time.Minute
+)
+
+// magic starts every copy stored in a child, it is followed by the SHA-256
+// checksum of the content.
+var magic = []byte("argm")
+
+const headerSize = 4 + sha256.Size
+
+type Option func(*Mirror)
+
+// WithResyncInterval sets how often degraded children are resynchronized in
+// the background.
+func WithResyncInterval(interval time.Duration) Option {
+	return func(m *Mirror) {
+		m.resyncInterval = interval
+	}
+}
+
+// New returns a storage which mirrors every mutation to all children. Every
+// copy carries the checksum of its content, reads are served by a healthy
+// child and fail over to the next one on errors or checksum mismatches,
+// repairing the bad copy.
+//
+// A child which misses a mutation, or which lacks files of the others when
+// the mirror is started, e.g. because its disk has been replaced, is
+// degraded. Degraded children still receive all mutations but don't serve
+// reads until they have been resynchronized from the healthy children in the
+// background.
+func New(children []storage.Storage, options ...Option) (*Mirror, error) {
+	if len(children) < 2 {
+		return nil, errors.Errorf("a mirror requires at least 2 children, got %d", len(children))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Mirror{
+		resyncInterval: defaultResyncInterval,
+		resyncc:        make(chan struct{}, 1),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	for i, store := range children {
+		m.children = append(m.children, &child{id: strconv.Itoa(i), store: store})
+		degraded.WithLabelValues(strconv.Itoa(i)).Set(0)
+	}
+	if err := m.check(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.wg.Add(1)
+	go m.resyncLoop()
+	return m, nil
+}
+
+type Mirror struct {
+	children       []*child
+	resyncInterval time.Duration
+	resyncc        chan struct{}
+
+	// reads share the lock, mutations and repairs of a file take it
+	// exclusively
+	mu sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type child struct {
+	id    string
+	store storage.Storage
+
+	mu       sync.Mutex
+	degraded bool
+	reason   string
+}
+
+func (c *child) isDegraded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.degraded
+}
+
+func (c *child) setDegraded(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.degraded {
+		log.WithField("child", c.id).Warnf("Mirror child is degraded: %s", reason)
+	}
+	c.degraded = true
+	c.reason = reason
+	degraded.WithLabelValues(c.id).Set(1)
+}
+
+func (c *child) setHealthy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.degraded {
+		log.WithField("child", c.id).Info("Mirror child is healthy again")
+	}
+	c.degraded = false
+	c.reason = ""
+	degraded.WithLabelValues(c.id).Set(0)
+}
+
+// ChildStatus describes the state of a child.
+type ChildStatus struct {
+	ID       string
+	Degraded bool
+	Reason   string
+}
+
+// Status returns the state of every child.
+func (m *Mirror) Status() []ChildStatus {
+	var result []ChildStatus
+	for _, c := range m.children {
+		c.mu.Lock()
+		result = append(result, ChildStatus{ID: c.id, Degraded: c.degraded, Reason: c.reason})
+		c.mu.Unlock()
+	}
+	return result
+}
+
+// healthy returns the children which serve reads.
+func (m *Mirror) healthy() []*child {
+	var result []*child
+	for _, c := range m.children {
+		if !c.isDegraded() {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// check marks the children degraded which lack files of the other ones.
+func (m *Mirror) check(ctx context.Context) error {
+	lists := make([]map[string]struct{}, len(m.children))
+	all := make(map[string]struct{})
+	for i, c := range m.children {
+		names, err := c.store.List(ctx)
+		if err != nil {
+			c.setDegraded(err.Error())
+			continue
+		}
+		lists[i] = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			lists[i][name] = struct{}{}
+			all[name] = struct{}{}
+		}
+	}
+	for i, c := range m.children {
+		if lists[i] == nil {
+			continue
+		}
+		if missing := len(all) - len(lists[i]); missing > 0 {
+			c.setDegraded(strconv.Itoa(missing) + " files are missing")
+		}
+	}
+	if len(m.healthy()) == 0 {
+		return errors.New("no child of the mirror has all files, resolve the differences manually")
+	}
+	return nil
+}
+
+func (m *Mirror) Read(ctx context.Context, name string) ([]byte, error) {
+	m.mu.RLock()
+	data, bad, err := m.read(ctx, name, m.healthy())
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(bad) > 0 {
+		readFailoversTotal.Add(float64(len(bad)))
+		m.repair(ctx, name, data, bad)
+	}
+	return data, nil
+}
+
+// read returns the content of name from the first of children with an
+// intact copy and the children whose copy is missing or corrupt.
+func (m *Mirror) read(ctx context.Context, name string, children []*child) ([]byte, []*child, error) {
+	if len(children) == 0 {
+		return nil, nil, errors.Wrap(storage.ErrUnavailable, "no healthy mirror child")
+	}
+
+	var bad []*child
+	var notFound int
+	var lastErr error
+	for _, c := range children {
+		raw, err := c.store.Read(ctx, name)
+		if err == nil {
+			var data []byte
+			data, err = decode(raw)
+			if err == nil {
+				return data, bad, nil
+			}
+		} else if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			notFound++
+		}
+		log.WithField("child", c.id).WithField("name", name).Warnf("Failing over to the next mirror child (%s)", err)
+		bad = append(bad, c)
+		lastErr = err
+	}
+	if notFound == len(children) {
+		return nil, nil, &storage.NotFoundError{Name: name}
+	}
+	return nil, nil, lastErr
+}
+
+// repair replaces the copies of name on children with data, unless the file
+// changed in the meantime.
+func (m *Mirror) repair(ctx context.Context, name string, data []byte, children []*child) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, _, err := m.read(ctx, name, m.healthy())
+	if err != nil || !bytes.Equal(current, data) {
+		return
+	}
+	var metadata map[string]string
+	if fi, err := m.stat(ctx, name); err == nil {
+		metadata = fi.Metadata
+	}
+	raw := encode(data)
+	for _, c := range children {
+		c.store.Remove(ctx, name)
+		if err := c.store.Write(ctx, name, raw); err != nil {
+			c.setDegraded("failed to repair " + name + ": " + err.Error())
+			continue
+		}
+		if len(metadata) > 0 {
+			if err := storage.SetMetadata(ctx, c.store, name, metadata); err != nil {
+				c.setDegraded("failed to repair the metadata of " + name + ": " + err.Error())
+				continue
+			}
+		}
+		log.WithField("child", c.id).WithField("name", name).Info("Repaired mirror copy")
+	}
+}
+
+func (m *Mirror) Write(ctx context.Context, name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(ctx, name); err == nil {
+		return &storage.AlreadyExistsError{Name: name}
+	}
+
+	raw := encode(data)
+	return m.apply("write "+name, func(c *child) error {
+		err := c.store.Write(ctx, name, raw)
+		if errors.Is(err, &storage.AlreadyExistsError{Name: name}) {
+			// left behind on a degraded child
+			if err := c.store.Remove(ctx, name); err != nil {
+				return err
+			}
+			err = c.store.Write(ctx, name, raw)
+		}
+		return err
+	})
+}
+
+// apply runs fn on every child. Children on which it fails are degraded, it
+// only fails if it failed on all healthy children.
+func (m *Mirror) apply(op string, fn func(c *child) error) error {
+	var succeeded int
+	var firstErr error
+	for _, c := range m.children {
+		wasDegraded := c.isDegraded()
+		if err := fn(c); err != nil {
+			c.setDegraded("failed to " + op + ": " + err.Error())
+			if !wasDegraded && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !wasDegraded {
+			succeeded++
+		}
+	}
+	if succeeded == 0 {
+		if firstErr == nil {
+			firstErr = errors.Wrap(storage.ErrUnavailable, "no healthy mirror child")
+		}
+		return firstErr
+	}
+	m.triggerResync()
+	return nil
+}
+
+func (m *Mirror) List(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var lastErr error = errors.Wrap(storage.ErrUnavailable, "no healthy mirror child")
+	for _, c := range m.healthy() {
+		names, err := c.store.List(ctx)
+		if err == nil {
+			return names, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (m *Mirror) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stat(ctx, name)
+}
+
+func (m *Mirror) stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	var lastErr error = errors.Wrap(storage.ErrUnavailable, "no healthy mirror child")
+	for _, c := range m.healthy() {
+		fi, err := c.store.Stat(ctx, name)
+		if err == nil {
+			if fi.Size >= headerSize {
+				fi.Size -= headerSize
+			}
+			return fi, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (m *Mirror) Rename(ctx context.Context, old, new string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(ctx, old); err != nil {
+		return err
+	}
+	if _, err := m.stat(ctx, new); err == nil {
+		return &storage.AlreadyExistsError{Name: new}
+	}
+	return m.apply("rename "+old, func(c *child) error {
+		err := c.store.Rename(ctx, old, new)
+		if err != nil && c.isDegraded() && errors.Is(err, &storage.NotFoundError{Name: old}) {
+			// resynchronized later
+			return nil
+		}
+		return err
+	})
+}
+
+func (m *Mirror) Remove(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(ctx, name); err != nil {
+		return err
+	}
+	return m.apply("remove "+name, func(c *child) error {
+		if _, err := c.store.Stat(ctx, name); err != nil && c.isDegraded() {
+			// resynchronized later
+			return nil
+		}
+		return c.store.Remove(ctx, name)
+	})
+}
+
+// SetMetadata sets the metadata of name on every child, the children have to
+// implement storage.MetadataSetter.
+func (m *Mirror) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(ctx, name); err != nil {
+		return err
+	}
+	for _, c := range m.children {
+		if _, ok := c.store.(storage.MetadataSetter); !ok {
+			return storage.ErrMetadataUnsupported
+		}
+	}
+	return m.apply("set metadata of "+name, func(c *child) error {
+		err := storage.SetMetadata(ctx, c.store, name, metadata)
+		if err != nil && c.isDegraded() && errors.Is(err, &storage.NotFoundError{Name: name}) {
+			// resynchronized later
+			return nil
+		}
+		return err
+	})
+}
+
+// Capacity reports the capacity of the smallest child.
+func (m *Mirror) Capacity(ctx context.Context) (*storage.Capacity, error) {
+	var result *storage.Capacity
+	for _, c := range m.children {
+		reporter, ok := c.store.(storage.CapacityReporter)
+		if !ok {
+			continue
+		}
+		capacity, err := reporter.Capacity(ctx)
+		if err != nil {
+			continue
+		}
+		if result == nil || capacity.Total < result.Total {
+			result = capacity
+		}
+	}
+	if result == nil {
+		return nil, errors.New("no child reports its capacity")
+	}
+	return result, nil
+}
+
+// Close stops the background resynchronization and closes the children.
+func (m *Mirror) Close() error {
+	m.cancel()
+	m.wg.Wait()
+
+	var firstErr error
+	for _, c := range m.children {
+		if err := c.store.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func encode(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	raw := make([]byte, 0, headerSize+len(data))
+	raw = append(raw, magic...)
+	raw = append(raw, sum[:]...)
+	return append(raw, data...)
+}
+
+func decode(raw []byte) ([]byte, error) {
+	if len(raw) < headerSize || !bytes.Equal(raw[:len(magic)], magic) {
+		return nil, errors.New("copy has no mirror header")
+	}
+	data := raw[headerSize:]
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], raw[len(magic):headerSize]) {
+		return nil, errors.New("copy doesn't match its checksum")
+	}
+	return data, nil
+}
@@ -0,0 +1,181 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+// failing fails all writes while fail is set, like a full or failed disk.
+type failing struct {
+	storage.Storage
+	fail atomic.Bool
+}
+
+func (f *failing) Write(ctx context.Context, name string, data []byte) error {
+	if f.fail.Load() {
+		return errors.New("disk failed")
+	}
+	return f.Storage.Write(ctx, name, data)
+}
+
+func (f *failing) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	return storage.SetMetadata(ctx, f.Storage, name, metadata)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newMirror(t *testing.T, children ...storage.Storage) *Mirror {
+	t.Helper()
+	m, err := New(children, WithResyncInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func healthy(m *Mirror) bool {
+	for _, st := range m.Status() {
+		if st.Degraded {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadFailover(t *testing.T) {
+	ctx := context.Background()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	m := newMirror(t, local.New(dirs[0]), local.New(dirs[1]))
+	data := []byte("content")
+	if err := m.Write(ctx, "file", data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, damage := range []struct {
+		name string
+		fn   func(path string) error
+	}{
+		{"corrupt", func(path string) error {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			raw[len(raw)-1] ^= 0xff
+			return os.WriteFile(path, raw, 0600)
+		}},
+		{"missing", os.Remove},
+	} {
+		path := filepath.Join(dirs[0], "file")
+		if err := damage.fn(path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := m.Read(ctx, "file")
+		if err != nil {
+			t.Fatalf("%s copy: read failed: %v", damage.name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s copy: read returned different content", damage.name)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s copy: not repaired: %v", damage.name, err)
+		}
+		if repaired, err := decode(raw); err != nil || !bytes.Equal(repaired, data) {
+			t.Fatalf("%s copy: not repaired", damage.name)
+		}
+	}
+}
+
+func TestDegradedChild(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	second := &failing{Storage: local.New(dir)}
+	m := newMirror(t, local.New(t.TempDir()), second)
+
+	second.fail.Store(true)
+	if err := m.Write(ctx, "file", []byte("content")); err != nil {
+		t.Fatalf("write failed with a healthy child left: %v", err)
+	}
+	st := m.Status()
+	if st[0].Degraded || !st[1].Degraded || st[1].Reason == "" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if got, err := m.Read(ctx, "file"); err != nil || string(got) != "content" {
+		t.Fatalf("read from the healthy child returned %q, %v", got, err)
+	}
+
+	second.fail.Store(false)
+	waitFor(t, "the child to be resynchronized", func() bool { return healthy(m) })
+	raw, err := os.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := decode(raw); err != nil || string(data) != "content" {
+		t.Fatal("the resynchronized copy differs")
+	}
+}
+
+func TestResyncReplacedChild(t *testing.T) {
+	ctx := context.Background()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	m, err := New([]storage.Storage{local.New(dirs[0]), local.New(dirs[1])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"first", "second"} {
+		if err := m.Write(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetMetadata(ctx, "first", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	// replace the disk of the second child
+	if err := os.RemoveAll(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dirs[1], 0700); err != nil {
+		t.Fatal(err)
+	}
+	replaced := local.New(dirs[1])
+	m = newMirror(t, local.New(dirs[0]), replaced)
+
+	waitFor(t, "the replaced child to be resynchronized", func() bool { return healthy(m) })
+	for _, name := range []string{"first", "second"} {
+		raw, err := replaced.Read(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := decode(raw); err != nil || string(data) != name {
+			t.Fatalf("the resynchronized copy of %s differs", name)
+		}
+	}
+	fi, err := replaced.Stat(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Metadata["owner"] != "ops" {
+		t.Fatalf("the metadata wasn't resynchronized, got %v", fi.Metadata)
+	}
+}
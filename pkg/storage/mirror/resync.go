@@ -0,0 +1,272 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// triggerResync schedules a resynchronization of the degraded children.
+func (m *Mirror) triggerResync() {
+	select {
+	case m.resyncc <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Mirror) resyncLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.resyncInterval)
+	defer ticker.Stop()
+
+	m.triggerResync()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.resyncc:
+		}
+		for _, c := range m.children {
+			if !c.isDegraded() {
+				continue
+			}
+			if _, err := m.resync(m.ctx, c, false); err != nil {
+				if m.ctx.Err() == nil {
+					log.WithField("child", c.id).Errorf("Failed to resynchronize mirror child (%s)", err)
+				}
+				continue
+			}
+			c.setHealthy()
+		}
+	}
+}
+
+// resync copies the files of the healthy children which are missing on c
+// and removes the files the healthy children don't have. If verify is set,
+// the existing copies of c are checked as well, otherwise only their size is
+// compared. It returns the number of repaired files.
+func (m *Mirror) resync(ctx context.Context, c *child, verify bool) (int, error) {
+	m.mu.RLock()
+	want, err := m.listHealthy(ctx, c)
+	m.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	have, err := c.store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var repaired int
+	for name := range want {
+		if err := ctx.Err(); err != nil {
+			return repaired, err
+		}
+		fixed, err := m.resyncFile(ctx, c, name, verify)
+		if err != nil {
+			return repaired, errors.Wrapf(err, "failed to resynchronize %s", name)
+		}
+		if fixed {
+			repaired++
+		}
+	}
+	for _, name := range have {
+		if _, ok := want[name]; ok {
+			continue
+		}
+		fixed, err := m.resyncFile(ctx, c, name, verify)
+		if err != nil {
+			return repaired, errors.Wrapf(err, "failed to resynchronize %s", name)
+		}
+		if fixed {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+// listHealthy returns the files of the healthy children other than c.
+func (m *Mirror) listHealthy(ctx context.Context, c *child) (map[string]struct{}, error) {
+	for _, other := range m.healthy() {
+		if other == c {
+			continue
+		}
+		names, err := other.store.List(ctx)
+		if err != nil {
+			continue
+		}
+		result := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			result[name] = struct{}{}
+		}
+		return result, nil
+	}
+	return nil, errors.Wrap(storage.ErrUnavailable, "no other healthy mirror child")
+}
+
+// resyncFile makes the copy of name on c match the healthy children.
+func (m *Mirror) resyncFile(ctx context.Context, c *child, name string, verify bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sources []*child
+	for _, other := range m.healthy() {
+		if other != c {
+			sources = append(sources, other)
+		}
+	}
+	data, _, err := m.read(ctx, name, sources)
+	if errors.Is(err, &storage.NotFoundError{Name: name}) {
+		if _, err := c.store.Stat(ctx, name); err != nil {
+			return false, nil
+		}
+		if err := c.store.Remove(ctx, name); err != nil {
+			return false, err
+		}
+		resyncedFilesTotal.Inc()
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	metadata := m.sourceMetadata(ctx, name, sources)
+	if fi, err := c.store.Stat(ctx, name); err == nil {
+		intact := fi.Size == int64(headerSize+len(data)) && !verify
+		if verify {
+			if raw, err := c.store.Read(ctx, name); err == nil {
+				if current, err := decode(raw); err == nil && bytes.Equal(current, data) {
+					intact = true
+				}
+			}
+		}
+		if intact {
+			if reflect.DeepEqual(fi.Metadata, metadata) || (len(fi.Metadata) == 0 && len(metadata) == 0) {
+				return false, nil
+			}
+			if err := storage.SetMetadata(ctx, c.store, name, metadata); err != nil {
+				return false, err
+			}
+			resyncedFilesTotal.Inc()
+			return true, nil
+		}
+		if err := c.store.Remove(ctx, name); err != nil {
+			return false, err
+		}
+	}
+	if err := c.store.Write(ctx, name, encode(data)); err != nil {
+		return false, err
+	}
+	if len(metadata) > 0 {
+		if err := storage.SetMetadata(ctx, c.store, name, metadata); err != nil {
+			return false, err
+		}
+	}
+	resyncedFilesTotal.Inc()
+	return true, nil
+}
+
+// sourceMetadata returns the metadata of name on the first of sources which
+// has the file.
+func (m *Mirror) sourceMetadata(ctx context.Context, name string, sources []*child) map[string]string {
+	for _, source := range sources {
+		if fi, err := source.store.Stat(ctx, name); err == nil {
+			return fi.Metadata
+		}
+	}
+	return nil
+}
+
+// Heal resynchronizes the degraded children and verifies every copy of the
+// healthy ones, repairing the corrupt copies. Rebuilt counts the repaired
+// copies, files without an intact copy are reported as unrecoverable.
+func (m *Mirror) Heal(ctx context.Context) (*storage.HealReport, error) {
+	names, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.HealReport{}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Checked++
+
+		m.mu.RLock()
+		var bad []*child
+		var data []byte
+		var found, corrupt bool
+		for _, c := range m.healthy() {
+			raw, err := c.store.Read(ctx, name)
+			var copy []byte
+			if err == nil {
+				copy, err = decode(raw)
+			}
+			if err == nil && found && !bytes.Equal(copy, data) {
+				err = errors.New("copy differs from the other children")
+			}
+			if err != nil {
+				if !errors.Is(err, &storage.NotFoundError{Name: name}) {
+					corrupt = true
+				}
+				bad = append(bad, c)
+				continue
+			}
+			if !found {
+				data, found = copy, true
+			}
+		}
+		m.mu.RUnlock()
+
+		if !found {
+			// without corrupt copies the file has been removed since it was
+			// listed
+			if corrupt {
+				log.WithField("name", name).Error("Mirror has no intact copy of file")
+				report.Unrecoverable = append(report.Unrecoverable, name)
+			}
+			continue
+		}
+		if len(bad) > 0 {
+			m.repair(ctx, name, data, bad)
+			report.Healed++
+			report.Rebuilt += len(bad)
+		}
+	}
+
+	for _, c := range m.children {
+		if !c.isDegraded() {
+			continue
+		}
+		repaired, err := m.resync(ctx, c, true)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to resynchronize mirror child %s", c.id)
+		}
+		c.setHealthy()
+		if repaired > 0 {
+			report.Healed++
+			report.Rebuilt += repaired
+		}
+	}
+	return report, nil
+}
+
+// Scrub verifies every copy, see Heal.
+func (m *Mirror) Scrub(ctx context.Context) (*storage.ScrubReport, error) {
+	report, err := m.Heal(ctx)
+	if report == nil {
+		return nil, err
+	}
+	return &storage.ScrubReport{
+		Checked:  report.Checked,
+		Repaired: report.Healed,
+		Corrupt:  report.Unrecoverable,
+	}, err
+}
@@ -0,0 +1,125 @@
+package readonly
+
+import (
+	"context"
+	"sync"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// New wraps store so that writes, renames, removes and metadata changes can be
+// rejected with a storage.ReadOnlyError. The wrapper starts out writable.
+func New(store storage.Storage) *ReadOnly {
+	return &ReadOnly{
+		store: store,
+	}
+}
+
+type ReadOnly struct {
+	store storage.Storage
+
+	mu       sync.RWMutex
+	readOnly bool
+	reason   string
+}
+
+// Enable rejects all mutating calls, reason is reported to the callers.
+func (r *ReadOnly) Enable(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readOnly = true
+	r.reason = reason
+}
+
+func (r *ReadOnly) Disable() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readOnly = false
+	r.reason = ""
+}
+
+// Enabled reports whether the storage is read-only and why.
+func (r *ReadOnly) Enabled() (bool, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.readOnly, r.reason
+}
+
+func (r *ReadOnly) check() error {
+	if readOnly, reason := r.Enabled(); readOnly {
+		return &storage.ReadOnlyError{Reason: reason}
+	}
+	return nil
+}
+
+func (r *ReadOnly) Read(ctx context.Context, name string) ([]byte, error) {
+	return r.store.Read(ctx, name)
+}
+
+func (r *ReadOnly) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return storage.ReadRange(ctx, r.store, name, offset, length)
+}
+
+func (r *ReadOnly) Write(ctx context.Context, name string, data []byte) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.store.Write(ctx, name, data)
+}
+
+func (r *ReadOnly) List(ctx context.Context) ([]string, error) {
+	return r.store.List(ctx)
+}
+
+func (r *ReadOnly) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	return r.store.Stat(ctx, name)
+}
+
+func (r *ReadOnly) Rename(ctx context.Context, old, new string) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.store.Rename(ctx, old, new)
+}
+
+func (r *ReadOnly) Remove(ctx context.Context, name string) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return r.store.Remove(ctx, name)
+}
+
+func (r *ReadOnly) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	if err := r.check(); err != nil {
+		return 0, err
+	}
+	return storage.Append(ctx, r.store, name, data, offset)
+}
+
+func (r *ReadOnly) Copy(ctx context.Context, src, dst string) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return storage.Copy(ctx, r.store, src, dst)
+}
+
+func (r *ReadOnly) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return storage.SetMetadata(ctx, r.store, name, metadata)
+}
+
+func (r *ReadOnly) SetTier(ctx context.Context, name, tier string) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	return storage.SetTier(ctx, r.store, name, tier)
+}
+
+func (r *ReadOnly) Close() error {
+	return r.store.Close()
+}
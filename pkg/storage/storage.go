@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -10,6 +11,22 @@ var (
 	ErrInternal     = fmt.Errorf("internal error")
 	ErrAccessDenied = fmt.Errorf("access denied")
 	ErrInvalidName  = fmt.Errorf("name is invalid")
+
+	// ErrUnavailable is returned by backends which temporarily can't serve a
+	// request, clients may retry.
+	ErrUnavailable = fmt.Errorf("storage is unavailable")
+
+	// ErrMetadataUnsupported is returned when metadata is set on a backend
+	// which can't store it.
+	ErrMetadataUnsupported = fmt.Errorf("storage doesn't support metadata")
+
+	// ErrTieringUnsupported is returned when a file is moved to another tier
+	// of a backend which has only one.
+	ErrTieringUnsupported = fmt.Errorf("storage doesn't support tiering")
+
+	// ErrAppendUnsupported is returned when a file is appended to on a
+	// backend which can only write whole files.
+	ErrAppendUnsupported = fmt.Errorf("storage doesn't support appending")
 )
 
 type NotFoundError struct {
@@ -44,6 +61,23 @@ func (e *AlreadyExistsError) Is(target error) bool {
 	return e.Name == t.Name
 }
 
+// ReadOnlyError is returned for mutating calls on a read-only storage.
+type ReadOnlyError struct {
+	Reason string
+}
+
+func (e *ReadOnlyError) Error() string {
+	if e.Reason == "" {
+		return "Storage is read-only"
+	}
+	return fmt.Sprintf("Storage is read-only (%s)", e.Reason)
+}
+
+func (e *ReadOnlyError) Is(target error) bool {
+	_, ok := target.(*ReadOnlyError)
+	return ok
+}
+
 type Storage interface {
 	Read(ctx context.Context, name string) ([]byte, error)
 	Write(ctx context.Context, name string, data []byte) error
@@ -60,4 +94,369 @@ type FileInfo struct {
 	Mode    uint32    `json:"mode"`
 	ModTime time.Time `json:"mod_time"`
 	Dir     bool      `json:"dir"`
+
+	// StoredSize is the space the file takes up in the backend if it
+	// differs from Size, e.g. because it is compressed with Compression.
+	StoredSize  int64  `json:"stored_size,omitempty"`
+	Compression string `json:"compression,omitempty"`
+
+	// Metadata are the user defined key/value pairs of the file.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Tier is the storage tier of the file if the backend has several.
+	Tier string `json:"tier,omitempty"`
+}
+
+// RangeReader is implemented by backends which can read a part of a file
+// without reading all of it.
+type RangeReader interface {
+	// ReadRange returns up to length bytes of name starting at offset, less
+	// if the file ends before. Neither offset nor length may be negative.
+	ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
+}
+
+// ReadRange reads a part of name from store, the whole file is read if store
+// doesn't implement RangeReader.
+func ReadRange(ctx context.Context, store Storage, name string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", offset, length)
+	}
+	if reader, ok := store.(RangeReader); ok {
+		return reader.ReadRange(ctx, name, offset, length)
+	}
+	data, err := store.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= int64(len(data)) {
+		return []byte{}, nil
+	}
+	data = data[offset:]
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+	return data, nil
+}
+
+// Appender is implemented by backends which can append to a file in place.
+type Appender interface {
+	// Append appends data to name, which is created if it doesn't exist, and
+	// returns its new size. Unless offset is negative the append fails with an
+	// OffsetMismatchError if name doesn't have offset bytes, which lets
+	// concurrent appenders detect each other.
+	Append(ctx context.Context, name string, data []byte, offset int64) (int64, error)
+}
+
+// Append appends data to name in store, ErrAppendUnsupported is returned if
+// store doesn't implement Appender.
+func Append(ctx context.Context, store Storage, name string, data []byte, offset int64) (int64, error) {
+	if appender, ok := store.(Appender); ok {
+		return appender.Append(ctx, name, data, offset)
+	}
+	return 0, ErrAppendUnsupported
+}
+
+// OffsetMismatchError is returned by an append whose expected offset differs
+// from the size of the file.
+type OffsetMismatchError struct {
+	Name     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("File %s has %d bytes instead of the expected %d", e.Name, e.Actual, e.Expected)
+}
+
+func (e *OffsetMismatchError) Is(target error) bool {
+	_, ok := target.(*OffsetMismatchError)
+	return ok
+}
+
+// Copier is implemented by backends which can copy a file without passing its
+// content through the caller, e.g. by sharing its blocks.
+type Copier interface {
+	// Copy copies src with its metadata to dst, which must not exist.
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// Copy copies src with its metadata to dst in store. The content is read and
+// written again if store doesn't implement Copier.
+func Copy(ctx context.Context, store Storage, src, dst string) error {
+	if copier, ok := store.(Copier); ok {
+		return copier.Copy(ctx, src, dst)
+	}
+	fi, err := store.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	data, err := store.Read(ctx, src)
+	if err != nil {
+		return err
+	}
+	if err := store.Write(ctx, dst, data); err != nil {
+		return err
+	}
+	if len(fi.Metadata) > 0 {
+		if err := SetMetadata(ctx, store, dst, fi.Metadata); err != nil {
+			// don't leave the copy behind without its metadata
+			_ = store.Remove(ctx, dst)
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// MaxMetadataKeys is the maximum number of metadata pairs of a file.
+	MaxMetadataKeys = 64
+	// MaxMetadataSize is the maximum size of all keys and values of a file.
+	MaxMetadataSize = 2048
+
+	// ReservedMetadataPrefix prefixes the metadata keys used by argon itself.
+	ReservedMetadataPrefix = "argon."
+	// MetadataExpires holds the RFC 3339 time after which a file expires.
+	MetadataExpires = ReservedMetadataPrefix + "expires"
+	// MetadataRetentionMode holds the mode of the retention lock of a file.
+	MetadataRetentionMode = ReservedMetadataPrefix + "retention-mode"
+	// MetadataRetainUntil holds the RFC 3339 time until which a file is
+	// locked.
+	MetadataRetainUntil = ReservedMetadataPrefix + "retain-until"
+	// MetadataLegalHold is "true" while a file is under legal hold.
+	MetadataLegalHold = ReservedMetadataPrefix + "legal-hold"
+)
+
+// MetadataSetter is implemented by backends which store user defined metadata
+// of files, it is returned in FileInfo.Metadata by Stat.
+type MetadataSetter interface {
+	// SetMetadata replaces the metadata of name, empty metadata removes it.
+	SetMetadata(ctx context.Context, name string, metadata map[string]string) error
+}
+
+// SetMetadata sets the metadata of name in store, ErrMetadataUnsupported is
+// returned if store doesn't implement MetadataSetter.
+func SetMetadata(ctx context.Context, store Storage, name string, metadata map[string]string) error {
+	if setter, ok := store.(MetadataSetter); ok {
+		return setter.SetMetadata(ctx, name, metadata)
+	}
+	return ErrMetadataUnsupported
+}
+
+// ValidateMetadata checks that metadata is within the limits every backend
+// can store.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("metadata has more than %d keys", MaxMetadataKeys)
+	}
+	size := 0
+	for key, value := range metadata {
+		if key == "" {
+			return fmt.Errorf("metadata key is empty")
+		}
+		if strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("metadata key %q contains an invalid character", key)
+		}
+		if strings.HasPrefix(key, ReservedMetadataPrefix) {
+			if key != MetadataExpires {
+				return fmt.Errorf("metadata key %q is reserved", key)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+				return fmt.Errorf("invalid expiry time %q", value)
+			}
+		}
+		size += len(key) + len(value)
+	}
+	if size > MaxMetadataSize {
+		return fmt.Errorf("metadata exceeds the limit of %d bytes", MaxMetadataSize)
+	}
+	return nil
+}
+
+// ExpiresAt returns the expiry time recorded in metadata.
+func ExpiresAt(metadata map[string]string) (time.Time, bool) {
+	value, ok := metadata[MetadataExpires]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Expired reports whether the file has an expiry time before now. Locked
+// files don't expire before their lock is released.
+func (fi *FileInfo) Expired(now time.Time) bool {
+	t, ok := ExpiresAt(fi.Metadata)
+	return ok && !t.After(now) && !fi.Locked(now)
+}
+
+// Locked reports whether the file can't be overwritten, renamed or removed at
+// now.
+func (fi *FileInfo) Locked(now time.Time) bool {
+	return RetentionOf(fi.Metadata).Locked(now)
+}
+
+const (
+	// RetentionGovernance locks can be shortened and removed by privileged
+	// identities.
+	RetentionGovernance = "governance"
+	// RetentionCompliance locks can only be extended, by nobody shortened or
+	// removed.
+	RetentionCompliance = "compliance"
+)
+
+// Retention is the write once read many (WORM) state of a file. A file is
+// locked until the retention date passes and while it is under legal hold.
+type Retention struct {
+	Mode      string    `json:"mode,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	LegalHold bool      `json:"legal_hold,omitempty"`
+}
+
+// RetentionOf returns the retention recorded in metadata.
+func RetentionOf(metadata map[string]string) Retention {
+	var r Retention
+	if until, err := time.Parse(time.RFC3339Nano, metadata[MetadataRetainUntil]); err == nil {
+		r.Mode = metadata[MetadataRetentionMode]
+		r.Until = until
+	}
+	r.LegalHold = metadata[MetadataLegalHold] == "true"
+	return r
+}
+
+// Locked reports whether r prevents changes of the file at now.
+func (r Retention) Locked(now time.Time) bool {
+	return r.LegalHold || now.Before(r.Until)
+}
+
+// Permits reports why r can't be replaced by next at now, compliance locks
+// can't be shortened, removed or changed to governance until they passed.
+func (r Retention) Permits(next Retention, now time.Time) error {
+	if r.Mode != RetentionCompliance || !now.Before(r.Until) {
+		return nil
+	}
+	if next.Mode != RetentionCompliance || next.Until.Before(r.Until) {
+		return fmt.Errorf("compliance retention until %s can only be extended", r.Until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Apply returns a copy of metadata recording r.
+func (r Retention) Apply(metadata map[string]string) map[string]string {
+	applied := make(map[string]string, len(metadata)+3)
+	for key, value := range metadata {
+		applied[key] = value
+	}
+	delete(applied, MetadataRetentionMode)
+	delete(applied, MetadataRetainUntil)
+	delete(applied, MetadataLegalHold)
+	if !r.Until.IsZero() {
+		applied[MetadataRetentionMode] = r.Mode
+		applied[MetadataRetainUntil] = r.Until.UTC().Format(time.RFC3339Nano)
+	}
+	if r.LegalHold {
+		applied[MetadataLegalHold] = "true"
+	}
+	return applied
+}
+
+// LockedError is returned when a locked file is overwritten, renamed or
+// removed, or its retention is shortened against its mode.
+type LockedError struct {
+	Name      string
+	Retention Retention
+	// Reason is set if the retention can't be changed.
+	Reason string
+}
+
+func (e *LockedError) Error() string {
+	switch {
+	case e.Reason != "":
+		return fmt.Sprintf("File %s is locked (%s)", e.Name, e.Reason)
+	case e.Retention.LegalHold:
+		return fmt.Sprintf("File %s is under legal hold", e.Name)
+	}
+	return fmt.Sprintf("File %s is locked in %s mode until %s", e.Name, e.Retention.Mode,
+		e.Retention.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	_, ok := target.(*LockedError)
+	return ok
+}
+
+const (
+	// TierHot holds the frequently accessed files.
+	TierHot = "hot"
+	// TierCold holds the rarely accessed files on cheaper storage.
+	TierCold = "cold"
+)
+
+// Tierer is implemented by backends storing files on several tiers, the tier
+// of a file is returned in FileInfo.Tier by Stat.
+type Tierer interface {
+	// SetTier moves name to tier, it is a no-op if name is already there.
+	SetTier(ctx context.Context, name, tier string) error
+}
+
+// SetTier moves name to tier in store, ErrTieringUnsupported is returned if
+// store doesn't implement Tierer.
+func SetTier(ctx context.Context, store Storage, name, tier string) error {
+	if tierer, ok := store.(Tierer); ok {
+		return tierer.SetTier(ctx, name, tier)
+	}
+	return ErrTieringUnsupported
+}
+
+// Capacity describes the space of a backend in bytes.
+type Capacity struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+	Used  uint64 `json:"used"`
+}
+
+// CapacityReporter is implemented by backends which can report their capacity.
+type CapacityReporter interface {
+	Capacity(ctx context.Context) (*Capacity, error)
+}
+
+// ScrubReport summarizes a scrub run.
+type ScrubReport struct {
+	Checked  int      `json:"checked"`
+	Repaired int      `json:"repaired"`
+	Corrupt  []string `json:"corrupt,omitempty"`
+}
+
+// Scrubber is implemented by backends which can verify, and possibly repair,
+// the integrity of the stored files.
+type Scrubber interface {
+	Scrub(ctx context.Context) (*ScrubReport, error)
+}
+
+// GCReport summarizes a garbage collection run.
+type GCReport struct {
+	Removed    int   `json:"removed"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// GarbageCollector is implemented by backends which accumulate unreferenced
+// data that has to be removed periodically.
+type GarbageCollector interface {
+	GC(ctx context.Context) (*GCReport, error)
+}
+
+// HealReport summarizes a heal run.
+type HealReport struct {
+	Checked       int      `json:"checked"`
+	Healed        int      `json:"healed"`
+	Rebuilt       int      `json:"rebuilt"`
+	Unrecoverable []string `json:"unrecoverable,omitempty"`
+}
+
+// Healer is implemented by backends storing redundant data, which can rebuild
+// lost redundancy, e.g. after a failed disk has been replaced.
+type Healer interface {
+	Heal(ctx context.Context) (*HealReport, error)
 }
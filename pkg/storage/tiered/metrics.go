@@ -0,0 +1,48 @@
+package tiered
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "tiered")
+
+var (
+	tierFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "tiered",
+		Name:      "files",
+	}, []string{"tier"})
+	tierBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "argon",
+		Subsystem: "tiered",
+		Name:      "bytes",
+	}, []string{"tier"})
+	readsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "tiered",
+		Name:      "reads_total",
+	}, []string{"tier"})
+	promotionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "tiered",
+		Name:      "promotions_total",
+	})
+	demotionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "tiered",
+		Name:      "demotions_total",
+	}, []string{"reason"})
+)
+
+// Collectors returns the tiering metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		tierFiles,
+		tierBytes,
+		readsTotal,
+		promotionsTotal,
+		demotionsTotal,
+	}
+}
@@ -0,0 +1,716 @@
+// Package tiered implements a storage which keeps the recently used files on
+// a fast hot tier and moves the others to a large cold tier.
+package tiered
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	placementFile = "placement.json"
+
+	defaultPermissions = os.FileMode(0600)
+)
+
+// Policy decides when files move between the tiers, zero values disable the
+// moves.
+type Policy struct {
+	// PromoteReads is the number of reads after which a cold file is moved
+	// back to the hot tier.
+	PromoteReads int
+	// DemoteAfter moves the hot files which weren't read or written for the
+	// duration to the cold tier.
+	DemoteAfter time.Duration
+	// HotMaxBytes bounds the size of the hot files, the least recently used
+	// ones are moved to the cold tier when it is exceeded.
+	HotMaxBytes int64
+}
+
+// Validate checks that no value of p is negative.
+func (p Policy) Validate() error {
+	if p.PromoteReads < 0 {
+		return errors.New("promote reads must not be negative")
+	}
+	if p.DemoteAfter < 0 {
+		return errors.New("demote after must not be negative")
+	}
+	if p.HotMaxBytes < 0 {
+		return errors.New("hot max bytes must not be negative")
+	}
+	return nil
+}
+
+// placement records the tier of a file. The modification time is kept here,
+// since moving a file rewrites it.
+type placement struct {
+	Tier     string    `json:"tier"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Accessed time.Time `json:"accessed"`
+	// Reads counts the reads since the file was demoted.
+	Reads int `json:"reads,omitempty"`
+}
+
+// New returns a storage presenting the files of hot and cold as a single
+// namespace. New files are written to hot, policy moves them between the
+// tiers. The placement of the files is kept in dir.
+//
+// The placement is reconciled with the tiers when the storage is opened, so
+// files written before tiering was enabled are picked up. A move interrupted
+// by a crash leaves a copy on both tiers, the one on the tier the placement
+// doesn't name is removed.
+func New(hot, cold storage.Storage, dir string, policy Policy) (*Tiered, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to prepare directory")
+	}
+	t := &Tiered{
+		hot:    hot,
+		cold:   cold,
+		dir:    dir,
+		policy: policy,
+		files:  make(map[string]*placement),
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	if err := t.reconcile(context.Background()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type Tiered struct {
+	hot    storage.Storage
+	cold   storage.Storage
+	dir    string
+	policy Policy
+
+	// reads share the lock, mutations and moves take it exclusively
+	mu    sync.RWMutex
+	files map[string]*placement
+	dirty bool
+	// guards the access times and read counts, which are updated by reads
+	accessMu sync.Mutex
+}
+
+func (t *Tiered) store(tier string) storage.Storage {
+	if tier == storage.TierCold {
+		return t.cold
+	}
+	return t.hot
+}
+
+func (t *Tiered) placementPath() string {
+	return filepath.Join(t.dir, placementFile)
+}
+
+func (t *Tiered) load() error {
+	b, err := os.ReadFile(t.placementPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read placement")
+	}
+	if err := json.Unmarshal(b, &t.files); err != nil {
+		return errors.Wrap(err, "failed to parse placement")
+	}
+	return nil
+}
+
+// reconcile adds the files missing from the placement, drops the ones which
+// don't exist anymore and removes the leftovers of interrupted moves.
+func (t *Tiered) reconcile(ctx context.Context) error {
+	found := make(map[string][]string)
+	for _, tier := range []string{storage.TierHot, storage.TierCold} {
+		names, err := t.store(tier).List(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the %s tier", tier)
+		}
+		for _, name := range names {
+			found[name] = append(found[name], tier)
+		}
+	}
+
+	for name := range t.files {
+		if _, ok := found[name]; !ok {
+			delete(t.files, name)
+			t.dirty = true
+		}
+	}
+	for name, tiers := range found {
+		p, ok := t.files[name]
+		if !ok {
+			fi, err := t.store(tiers[0]).Stat(ctx, name)
+			if err != nil {
+				return errors.Wrapf(err, "failed to stat %s", name)
+			}
+			p = &placement{Tier: tiers[0], Size: fi.Size, ModTime: fi.ModTime, Accessed: fi.ModTime}
+			t.files[name] = p
+			t.dirty = true
+		}
+		if len(tiers) == 1 && tiers[0] != p.Tier {
+			p.Tier = tiers[0]
+			t.dirty = true
+		}
+		if len(tiers) > 1 {
+			leftover := storage.TierCold
+			if p.Tier == storage.TierCold {
+				leftover = storage.TierHot
+			}
+			log.WithField("name", name).WithField("tier", leftover).Warn("Removing the leftover of an interrupted move")
+			if err := t.store(leftover).Remove(ctx, name); err != nil {
+				return errors.Wrapf(err, "failed to remove the leftover of %s", name)
+			}
+		}
+	}
+	t.updateMetrics()
+	return t.save()
+}
+
+// save writes the placement if it changed, the caller holds the lock.
+func (t *Tiered) save() error {
+	if !t.dirty {
+		return nil
+	}
+	t.accessMu.Lock()
+	b, err := json.Marshal(t.files)
+	t.accessMu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := t.placementPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultPermissions); err != nil {
+		return errors.Wrap(err, "failed to write placement")
+	}
+	if err := os.Rename(tmp, t.placementPath()); err != nil {
+		return errors.Wrap(err, "failed to write placement")
+	}
+	t.dirty = false
+	return nil
+}
+
+func (t *Tiered) updateMetrics() {
+	var files, bytes [2]int64
+	for _, p := range t.files {
+		i := 0
+		if p.Tier == storage.TierCold {
+			i = 1
+		}
+		files[i]++
+		bytes[i] += p.Size
+	}
+	tierFiles.WithLabelValues(storage.TierHot).Set(float64(files[0]))
+	tierFiles.WithLabelValues(storage.TierCold).Set(float64(files[1]))
+	tierBytes.WithLabelValues(storage.TierHot).Set(float64(bytes[0]))
+	tierBytes.WithLabelValues(storage.TierCold).Set(float64(bytes[1]))
+}
+
+// accessed records a read of name and reports whether it should be promoted.
+func (t *Tiered) accessed(name string) bool {
+	t.accessMu.Lock()
+	defer t.accessMu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		return false
+	}
+	p.Accessed = time.Now()
+	if p.Tier != storage.TierCold {
+		return false
+	}
+	p.Reads++
+	return t.policy.PromoteReads > 0 && p.Reads >= t.policy.PromoteReads
+}
+
+func (t *Tiered) Read(ctx context.Context, name string) ([]byte, error) {
+	t.mu.RLock()
+	p, ok := t.files[name]
+	if !ok {
+		t.mu.RUnlock()
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	tier := p.Tier
+	data, err := t.store(tier).Read(ctx, name)
+	promote := err == nil && t.accessed(name)
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	readsTotal.WithLabelValues(tier).Inc()
+
+	if promote {
+		t.promote(ctx, name, data)
+	}
+	return data, nil
+}
+
+func (t *Tiered) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	t.mu.RLock()
+	p, ok := t.files[name]
+	if !ok {
+		t.mu.RUnlock()
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	tier := p.Tier
+	data, err := storage.ReadRange(ctx, t.store(tier), name, offset, length)
+	promote := err == nil && t.accessed(name)
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	readsTotal.WithLabelValues(tier).Inc()
+
+	if promote {
+		t.promote(ctx, name, nil)
+	}
+	return data, nil
+}
+
+// promote moves name to the hot tier after a read, failures only delay the
+// promotion to the next read.
+func (t *Tiered) promote(ctx context.Context, name string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok || p.Tier == storage.TierHot {
+		return
+	}
+	if err := t.move(ctx, name, p, storage.TierHot, data); err != nil {
+		log.WithField("name", name).Warnf("Failed to promote file (%s)", err)
+		return
+	}
+	promotionsTotal.Inc()
+}
+
+// move copies name with its metadata to tier, records the new placement and
+// removes the old copy. The caller holds the lock exclusively.
+func (t *Tiered) move(ctx context.Context, name string, p *placement, tier string, data []byte) error {
+	from, to := t.store(p.Tier), t.store(tier)
+	fi, err := from.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		if data, err = from.Read(ctx, name); err != nil {
+			return err
+		}
+	}
+	if err := to.Write(ctx, name, data); err != nil {
+		if !errors.Is(err, &storage.AlreadyExistsError{Name: name}) {
+			return err
+		}
+		// the leftover of a failed move
+		if err := to.Remove(ctx, name); err != nil {
+			return err
+		}
+		if err := to.Write(ctx, name, data); err != nil {
+			return err
+		}
+	}
+	if len(fi.Metadata) > 0 {
+		if err := storage.SetMetadata(ctx, to, name, fi.Metadata); err != nil {
+			to.Remove(ctx, name)
+			return errors.Wrap(err, "failed to copy metadata")
+		}
+	}
+
+	t.accessMu.Lock()
+	oldTier := p.Tier
+	p.Tier = tier
+	p.Reads = 0
+	t.accessMu.Unlock()
+	t.dirty = true
+	// the placement is saved before the old copy is removed, so the copy on
+	// the new tier is kept after a crash
+	if err := t.save(); err != nil {
+		log.WithField("name", name).Warnf("Failed to save placement (%s)", err)
+	}
+	if err := from.Remove(ctx, name); err != nil {
+		log.WithField("name", name).WithField("tier", oldTier).Warnf("Failed to remove moved file (%s)", err)
+	}
+	t.updateMetrics()
+	log.WithField("name", name).WithField("tier", tier).Debug("Moved file")
+	return nil
+}
+
+// Write stores new files on the hot tier.
+func (t *Tiered) Write(ctx context.Context, name string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.files[name]; ok {
+		return &storage.AlreadyExistsError{Name: name}
+	}
+	if err := t.hot.Write(ctx, name, data); err != nil {
+		if !errors.Is(err, &storage.AlreadyExistsError{Name: name}) {
+			return err
+		}
+		// the leftover of a move whose old copy couldn't be removed
+		if err := t.hot.Remove(ctx, name); err != nil {
+			return err
+		}
+		if err := t.hot.Write(ctx, name, data); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	t.files[name] = &placement{Tier: storage.TierHot, Size: int64(len(data)), ModTime: now, Accessed: now}
+	t.dirty = true
+	t.updateMetrics()
+	return nil
+}
+
+// List returns the files of both tiers.
+func (t *Tiered) List(_ context.Context) ([]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	names := make([]string, 0, len(t.files))
+	for name := range t.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Stat reports the tier of name along with the file info of its tier.
+func (t *Tiered) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	fi, err := t.store(p.Tier).Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	fi.ModTime = p.ModTime
+	fi.Tier = p.Tier
+	return fi, nil
+}
+
+func (t *Tiered) Rename(ctx context.Context, old, new string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[old]
+	if !ok {
+		return &storage.NotFoundError{Name: old}
+	}
+	if _, ok := t.files[new]; ok {
+		return &storage.AlreadyExistsError{Name: new}
+	}
+	if err := t.store(p.Tier).Rename(ctx, old, new); err != nil {
+		return err
+	}
+	delete(t.files, old)
+	t.files[new] = p
+	t.dirty = true
+	return nil
+}
+
+// Append appends to name on its tier, new files are created on the hot tier.
+func (t *Tiered) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		// the leftover of a move whose old copy couldn't be removed
+		if _, err := t.hot.Stat(ctx, name); err == nil {
+			if err := t.hot.Remove(ctx, name); err != nil {
+				return 0, err
+			}
+		}
+		p = &placement{Tier: storage.TierHot}
+	}
+	size, err := storage.Append(ctx, t.store(p.Tier), name, data, offset)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	p.Size, p.ModTime, p.Accessed = size, now, now
+	t.files[name] = p
+	t.dirty = true
+	t.updateMetrics()
+	return size, nil
+}
+
+// Copy copies src within its tier.
+func (t *Tiered) Copy(ctx context.Context, src, dst string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[src]
+	if !ok {
+		return &storage.NotFoundError{Name: src}
+	}
+	if _, ok := t.files[dst]; ok {
+		return &storage.AlreadyExistsError{Name: dst}
+	}
+	if err := storage.Copy(ctx, t.store(p.Tier), src, dst); err != nil {
+		return err
+	}
+	now := time.Now()
+	t.files[dst] = &placement{Tier: p.Tier, Size: p.Size, ModTime: now, Accessed: now}
+	t.dirty = true
+	t.updateMetrics()
+	return nil
+}
+
+func (t *Tiered) Remove(ctx context.Context, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		return &storage.NotFoundError{Name: name}
+	}
+	if err := t.store(p.Tier).Remove(ctx, name); err != nil {
+		return err
+	}
+	delete(t.files, name)
+	t.dirty = true
+	t.updateMetrics()
+	return nil
+}
+
+func (t *Tiered) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		return &storage.NotFoundError{Name: name}
+	}
+	return storage.SetMetadata(ctx, t.store(p.Tier), name, metadata)
+}
+
+// SetTier moves name to tier.
+func (t *Tiered) SetTier(ctx context.Context, name, tier string) error {
+	if tier != storage.TierHot && tier != storage.TierCold {
+		return errors.Errorf("unknown tier %q", tier)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.files[name]
+	if !ok {
+		return &storage.NotFoundError{Name: name}
+	}
+	if p.Tier == tier {
+		return nil
+	}
+	if err := t.move(ctx, name, p, tier, nil); err != nil {
+		return err
+	}
+	if tier == storage.TierHot {
+		promotionsTotal.Inc()
+	} else {
+		demotionsTotal.WithLabelValues("request").Inc()
+	}
+	return nil
+}
+
+// DemoteReport summarizes a demotion run.
+type DemoteReport struct {
+	Demoted int      `json:"demoted"`
+	Bytes   int64    `json:"bytes"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// Demote moves the hot files which weren't used for the demotion age of the
+// policy to the cold tier. If the hot files exceed the size limit of the
+// policy afterwards, the least recently used ones are moved as well. The
+// placement is saved, so the recorded access times survive restarts.
+func (t *Tiered) Demote(ctx context.Context) (*DemoteReport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type candidate struct {
+		name     string
+		p        *placement
+		accessed time.Time
+	}
+	var hot []candidate
+	var hotBytes int64
+	t.accessMu.Lock()
+	for name, p := range t.files {
+		if p.Tier == storage.TierHot {
+			hot = append(hot, candidate{name: name, p: p, accessed: p.Accessed})
+			hotBytes += p.Size
+		}
+	}
+	t.accessMu.Unlock()
+	sort.Slice(hot, func(i, j int) bool {
+		return hot[i].accessed.Before(hot[j].accessed)
+	})
+
+	// the access times changed by reads are saved with the placement
+	t.dirty = true
+	report := &DemoteReport{}
+	now := time.Now()
+	for _, c := range hot {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		reason := ""
+		switch {
+		case t.policy.DemoteAfter > 0 && now.Sub(c.accessed) >= t.policy.DemoteAfter:
+			reason = "age"
+		case t.policy.HotMaxBytes > 0 && hotBytes > t.policy.HotMaxBytes:
+			reason = "capacity"
+		default:
+			// the candidates are ordered by access time, so no later one
+			// qualifies either
+			return report, t.save()
+		}
+		if err := t.move(ctx, c.name, c.p, storage.TierCold, nil); err != nil {
+			log.WithField("name", c.name).Errorf("Failed to demote file (%s)", err)
+			report.Failed = append(report.Failed, c.name)
+			continue
+		}
+		demotionsTotal.WithLabelValues(reason).Inc()
+		hotBytes -= c.p.Size
+		report.Demoted++
+		report.Bytes += c.p.Size
+	}
+	return report, t.save()
+}
+
+// Capacity sums the capacity of the tiers which report theirs.
+func (t *Tiered) Capacity(ctx context.Context) (*storage.Capacity, error) {
+	capacity := &storage.Capacity{}
+	for _, store := range []storage.Storage{t.hot, t.cold} {
+		reporter, ok := store.(storage.CapacityReporter)
+		if !ok {
+			continue
+		}
+		c, err := reporter.Capacity(ctx)
+		if err != nil {
+			return nil, err
+		}
+		capacity.Total += c.Total
+		capacity.Free += c.Free
+		capacity.Used += c.Used
+	}
+	return capacity, nil
+}
+
+// Scrub verifies both tiers, tiers which can't scrub themselves are read back.
+func (t *Tiered) Scrub(ctx context.Context) (*storage.ScrubReport, error) {
+	report := &storage.ScrubReport{}
+	for _, store := range []storage.Storage{t.hot, t.cold} {
+		var r *storage.ScrubReport
+		var err error
+		if scrubber, ok := store.(storage.Scrubber); ok {
+			r, err = scrubber.Scrub(ctx)
+		} else {
+			r, err = readBack(ctx, store)
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Checked += r.Checked
+		report.Repaired += r.Repaired
+		report.Corrupt = append(report.Corrupt, r.Corrupt...)
+	}
+	return report, nil
+}
+
+func readBack(ctx context.Context, store storage.Storage) (*storage.ScrubReport, error) {
+	names, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.ScrubReport{}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := store.Read(ctx, name); err != nil {
+			report.Corrupt = append(report.Corrupt, name)
+		}
+		report.Checked++
+	}
+	return report, nil
+}
+
+// GC collects the garbage of the tiers which accumulate it.
+func (t *Tiered) GC(ctx context.Context) (*storage.GCReport, error) {
+	report := &storage.GCReport{}
+	var collected bool
+	for _, store := range []storage.Storage{t.hot, t.cold} {
+		collector, ok := store.(storage.GarbageCollector)
+		if !ok {
+			continue
+		}
+		r, err := collector.GC(ctx)
+		if err != nil {
+			return nil, err
+		}
+		collected = true
+		report.Removed += r.Removed
+		report.FreedBytes += r.FreedBytes
+	}
+	if !collected {
+		return nil, errors.New("no tier needs garbage collection")
+	}
+	return report, nil
+}
+
+// Heal heals the tiers storing redundant data.
+func (t *Tiered) Heal(ctx context.Context) (*storage.HealReport, error) {
+	report := &storage.HealReport{}
+	var healed bool
+	for _, store := range []storage.Storage{t.hot, t.cold} {
+		healer, ok := store.(storage.Healer)
+		if !ok {
+			continue
+		}
+		r, err := healer.Heal(ctx)
+		if err != nil {
+			return nil, err
+		}
+		healed = true
+		report.Checked += r.Checked
+		report.Healed += r.Healed
+		report.Rebuilt += r.Rebuilt
+		report.Unrecoverable = append(report.Unrecoverable, r.Unrecoverable...)
+	}
+	if !healed {
+		return nil, errors.New("no tier has redundancy to heal")
+	}
+	return report, nil
+}
+
+// Close saves the placement and closes both tiers.
+func (t *Tiered) Close() error {
+	t.mu.Lock()
+	err := t.save()
+	t.mu.Unlock()
+	if err != nil {
+		log.Errorf("Failed to save placement (%s)", err)
+	}
+	if err := t.hot.Close(); err != nil {
+		return err
+	}
+	return t.cold.Close()
+}
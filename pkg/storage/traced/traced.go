@@ -0,0 +1,152 @@
+package traced
+
+import (
+	"context"
+
+	"github.com/peertechde/argon/pkg/storage"
+	"github.com/peertechde/argon/pkg/tracing"
+)
+
+// New wraps store so that every call is recorded as a span.
+func New(store storage.Storage) storage.Storage {
+	return &Traced{
+		store: store,
+	}
+}
+
+type Traced struct {
+	store storage.Storage
+}
+
+func (t *Traced) Read(ctx context.Context, name string) ([]byte, error) {
+	ctx, span := start(ctx, "storage.Read", tracing.String("argon.name", name))
+	defer span.End()
+
+	data, err := t.store.Read(ctx, name)
+	span.SetAttributes(tracing.Int64("argon.size", int64(len(data))))
+	span.RecordError(err)
+	return data, err
+}
+
+func (t *Traced) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	ctx, span := start(ctx, "storage.ReadRange",
+		tracing.String("argon.name", name),
+		tracing.Int64("argon.offset", offset),
+		tracing.Int64("argon.length", length),
+	)
+	defer span.End()
+
+	data, err := storage.ReadRange(ctx, t.store, name, offset, length)
+	span.SetAttributes(tracing.Int64("argon.size", int64(len(data))))
+	span.RecordError(err)
+	return data, err
+}
+
+func (t *Traced) Write(ctx context.Context, name string, data []byte) error {
+	ctx, span := start(ctx, "storage.Write",
+		tracing.String("argon.name", name),
+		tracing.Int64("argon.size", int64(len(data))),
+	)
+	defer span.End()
+
+	err := t.store.Write(ctx, name, data)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) List(ctx context.Context) ([]string, error) {
+	ctx, span := start(ctx, "storage.List")
+	defer span.End()
+
+	files, err := t.store.List(ctx)
+	span.SetAttributes(tracing.Int64("argon.files", int64(len(files))))
+	span.RecordError(err)
+	return files, err
+}
+
+func (t *Traced) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	ctx, span := start(ctx, "storage.Stat", tracing.String("argon.name", name))
+	defer span.End()
+
+	fi, err := t.store.Stat(ctx, name)
+	span.RecordError(err)
+	return fi, err
+}
+
+func (t *Traced) Rename(ctx context.Context, old, new string) error {
+	ctx, span := start(ctx, "storage.Rename",
+		tracing.String("argon.old", old),
+		tracing.String("argon.new", new),
+	)
+	defer span.End()
+
+	err := t.store.Rename(ctx, old, new)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) Remove(ctx context.Context, name string) error {
+	ctx, span := start(ctx, "storage.Remove", tracing.String("argon.name", name))
+	defer span.End()
+
+	err := t.store.Remove(ctx, name)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	ctx, span := start(ctx, "storage.SetMetadata",
+		tracing.String("argon.name", name),
+		tracing.Int64("argon.keys", int64(len(metadata))),
+	)
+	defer span.End()
+
+	err := storage.SetMetadata(ctx, t.store, name, metadata)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	ctx, span := start(ctx, "storage.Append",
+		tracing.String("argon.name", name),
+		tracing.Int64("argon.size", int64(len(data))),
+		tracing.Int64("argon.offset", offset),
+	)
+	defer span.End()
+
+	size, err := storage.Append(ctx, t.store, name, data, offset)
+	span.RecordError(err)
+	return size, err
+}
+
+func (t *Traced) Copy(ctx context.Context, src, dst string) error {
+	ctx, span := start(ctx, "storage.Copy",
+		tracing.String("argon.src", src),
+		tracing.String("argon.dst", dst),
+	)
+	defer span.End()
+
+	err := storage.Copy(ctx, t.store, src, dst)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) SetTier(ctx context.Context, name, tier string) error {
+	ctx, span := start(ctx, "storage.SetTier",
+		tracing.String("argon.name", name),
+		tracing.String("argon.tier", tier),
+	)
+	defer span.End()
+
+	err := storage.SetTier(ctx, t.store, name, tier)
+	span.RecordError(err)
+	return err
+}
+
+func (t *Traced) Close() error {
+	return t.store.Close()
+}
+
+func start(ctx context.Context, name string, attributes ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, name, tracing.WithAttributes(attributes...))
+}
@@ -0,0 +1,36 @@
+package trash
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peertechde/argon/pkg/logging"
+)
+
+var log = logging.Logger.WithField(logging.Subsys, "trash")
+
+var (
+	trashedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "trash",
+		Name:      "trashed_total",
+	})
+	restoredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "trash",
+		Name:      "restored_total",
+	})
+	purgedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "trash",
+		Name:      "purged_total",
+	}, []string{"reason"})
+)
+
+// Collectors returns the trash metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		trashedTotal,
+		restoredTotal,
+		purgedTotal,
+	}
+}
@@ -0,0 +1,406 @@
+// Package trash implements a storage which moves removed files into a hidden
+// trash area, from where they can be restored until they are purged.
+package trash
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+const (
+	// Prefix is the prefix of the names of the trashed files in the wrapped
+	// storage. The names of the files of clients must not start with it.
+	Prefix = ".trash."
+
+	// MetadataName holds the original name of a trashed file.
+	MetadataName = storage.ReservedMetadataPrefix + "trash-name"
+	// MetadataDeleter holds the identity which removed a trashed file.
+	MetadataDeleter = storage.ReservedMetadataPrefix + "trash-deleter"
+)
+
+// Entry is a trashed file.
+type Entry struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	// Deleter is the identity which removed the file, it is empty if it
+	// isn't known.
+	Deleter string `json:"deleter,omitempty"`
+	Size    int64  `json:"size"`
+}
+
+type deleterKey struct{}
+
+// WithDeleter returns a context recording deleter as the identity removing
+// files.
+func WithDeleter(ctx context.Context, deleter string) context.Context {
+	return context.WithValue(ctx, deleterKey{}, deleter)
+}
+
+func deleterFromContext(ctx context.Context) string {
+	deleter, _ := ctx.Value(deleterKey{}).(string)
+	return deleter
+}
+
+// New wraps store so that removed files are renamed to a hidden name instead,
+// which only consists of the deletion time, so it fits every name length.
+// The original name and the deleter are kept in the metadata of the trashed
+// file. Since trashing and restoring are renames and metadata updates of
+// store, they take the same path as the other mutations, e.g. they are
+// replicated. Files are purged after retention, zero keeps them until they
+// are purged explicitly.
+func New(store storage.Storage, retention time.Duration) (*Trash, error) {
+	if retention < 0 {
+		return nil, errors.New("retention must not be negative")
+	}
+	return &Trash{
+		store:     store,
+		retention: retention,
+	}, nil
+}
+
+type Trash struct {
+	store     storage.Storage
+	retention time.Duration
+}
+
+// Retention returns the duration after which trashed files are purged.
+func (t *Trash) Retention() time.Duration {
+	return t.retention
+}
+
+func hidden(name string) bool {
+	return strings.HasPrefix(name, Prefix)
+}
+
+// trashName returns the hidden name of the file trashed as id. The id is the
+// deletion time in nanoseconds, so it sorts by deletion time.
+func trashName(id string) string {
+	return Prefix + id
+}
+
+// parseID returns the deletion time of the file trashed as id.
+func parseID(id string) (time.Time, bool) {
+	if id == "" || strings.ToLower(id) != id {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(id, 36, 64)
+	if err != nil || nanos < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// parseTrashName returns the entry of the hidden name, without the parts kept
+// in its metadata.
+func parseTrashName(hiddenName string) (Entry, bool) {
+	if !hidden(hiddenName) {
+		return Entry{}, false
+	}
+	id := strings.TrimPrefix(hiddenName, Prefix)
+	deleted, ok := parseID(id)
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{ID: id, Deleted: deleted}, true
+}
+
+// withoutTrash returns metadata without the keys of the trash.
+func withoutTrash(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if key != MetadataName && key != MetadataDeleter {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func (t *Trash) Read(ctx context.Context, name string) ([]byte, error) {
+	if hidden(name) {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	return t.store.Read(ctx, name)
+}
+
+func (t *Trash) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	if hidden(name) {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	return storage.ReadRange(ctx, t.store, name, offset, length)
+}
+
+func (t *Trash) Write(ctx context.Context, name string, data []byte) error {
+	if hidden(name) {
+		return storage.ErrInvalidName
+	}
+	return t.store.Write(ctx, name, data)
+}
+
+// List returns the files which aren't trashed.
+func (t *Trash) List(ctx context.Context) ([]string, error) {
+	names, err := t.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if !hidden(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+func (t *Trash) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	if hidden(name) {
+		return nil, &storage.NotFoundError{Name: name}
+	}
+	return t.store.Stat(ctx, name)
+}
+
+func (t *Trash) Rename(ctx context.Context, old, new string) error {
+	if hidden(old) {
+		return &storage.NotFoundError{Name: old}
+	}
+	if hidden(new) {
+		return storage.ErrInvalidName
+	}
+	return t.store.Rename(ctx, old, new)
+}
+
+// Remove moves name into the trash, the deleter is taken from ctx.
+func (t *Trash) Remove(ctx context.Context, name string) error {
+	if hidden(name) {
+		return &storage.NotFoundError{Name: name}
+	}
+	fi, err := t.store.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	deleted := time.Now()
+	var id string
+	for {
+		id = strconv.FormatInt(deleted.UnixNano(), 36)
+		err := t.store.Rename(ctx, name, trashName(id))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, &storage.AlreadyExistsError{Name: trashName(id)}) {
+			return err
+		}
+		// removed twice within the same nanosecond
+		deleted = deleted.Add(time.Nanosecond)
+	}
+
+	metadata := withoutTrash(fi.Metadata)
+	metadata[MetadataName] = name
+	if deleter := deleterFromContext(ctx); deleter != "" {
+		metadata[MetadataDeleter] = deleter
+	}
+	if err := storage.SetMetadata(ctx, t.store, trashName(id), metadata); err != nil {
+		// without its name the file couldn't be restored
+		if rerr := t.store.Rename(ctx, trashName(id), name); rerr != nil {
+			log.WithField("name", name).WithField("id", id).
+				Errorf("Failed to move the file out of the trash again (%s)", rerr)
+		}
+		return errors.Wrap(err, "failed to record the trashed file")
+	}
+	trashedTotal.Inc()
+	log.WithField("name", name).WithField("id", id).Debug("Moved file into the trash")
+	return nil
+}
+
+func (t *Trash) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	if hidden(name) {
+		return 0, storage.ErrInvalidName
+	}
+	return storage.Append(ctx, t.store, name, data, offset)
+}
+
+func (t *Trash) Copy(ctx context.Context, src, dst string) error {
+	if hidden(src) {
+		return &storage.NotFoundError{Name: src}
+	}
+	if hidden(dst) {
+		return storage.ErrInvalidName
+	}
+	return storage.Copy(ctx, t.store, src, dst)
+}
+
+func (t *Trash) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	if hidden(name) {
+		return &storage.NotFoundError{Name: name}
+	}
+	return storage.SetMetadata(ctx, t.store, name, metadata)
+}
+
+func (t *Trash) SetTier(ctx context.Context, name, tier string) error {
+	if hidden(name) {
+		return &storage.NotFoundError{Name: name}
+	}
+	return storage.SetTier(ctx, t.store, name, tier)
+}
+
+func (t *Trash) Close() error {
+	return t.store.Close()
+}
+
+// Entries returns the trashed files, the most recently deleted first.
+func (t *Trash) Entries(ctx context.Context) ([]Entry, error) {
+	names, err := t.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, name := range names {
+		if !hidden(name) {
+			continue
+		}
+		id := strings.TrimPrefix(name, Prefix)
+		entry, _, err := t.entry(ctx, id)
+		if err != nil {
+			if errors.Is(err, &NotFoundError{ID: id}) {
+				// purged or restored meanwhile
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to stat %s", name)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Deleted.After(entries[j].Deleted)
+	})
+	return entries, nil
+}
+
+// entry returns the file trashed as id and its metadata. Its name is empty if
+// the trash failed to record it.
+func (t *Trash) entry(ctx context.Context, id string) (Entry, map[string]string, error) {
+	entry, ok := parseTrashName(trashName(id))
+	if !ok {
+		return Entry{}, nil, &NotFoundError{ID: id}
+	}
+	fi, err := t.store.Stat(ctx, trashName(id))
+	if err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: trashName(id)}) {
+			return Entry{}, nil, &NotFoundError{ID: id}
+		}
+		return Entry{}, nil, err
+	}
+	entry.Name = fi.Metadata[MetadataName]
+	entry.Deleter = fi.Metadata[MetadataDeleter]
+	entry.Size = fi.Size
+	return entry, fi.Metadata, nil
+}
+
+// Restore moves the trashed file id back to its original name, or to name if
+// it isn't empty, and returns the name it was restored to.
+func (t *Trash) Restore(ctx context.Context, id, name string) (string, error) {
+	if hidden(name) {
+		return "", storage.ErrInvalidName
+	}
+	entry, metadata, err := t.entry(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = entry.Name
+	}
+	if name == "" {
+		return "", storage.ErrInvalidName
+	}
+	if err := t.store.Rename(ctx, trashName(id), name); err != nil {
+		return "", err
+	}
+	if err := storage.SetMetadata(ctx, t.store, name, withoutTrash(metadata)); err != nil {
+		log.WithField("name", name).WithField("id", id).
+			Errorf("Failed to remove the trash metadata of the restored file (%s)", err)
+	}
+	restoredTotal.Inc()
+	return name, nil
+}
+
+// Purge removes the trashed file id permanently.
+func (t *Trash) Purge(ctx context.Context, id string) error {
+	entry, _, err := t.entry(ctx, id)
+	if err != nil {
+		return err
+	}
+	return t.purge(ctx, entry, "request")
+}
+
+// PurgeAll removes all trashed files permanently and returns their number.
+func (t *Trash) PurgeAll(ctx context.Context) (int, error) {
+	return t.purgeIf(ctx, "request", func(Entry) bool { return true })
+}
+
+// PurgeExpired removes the files trashed longer than the retention before now
+// and returns their number.
+func (t *Trash) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	if t.retention == 0 {
+		return 0, nil
+	}
+	return t.purgeIf(ctx, "retention", func(entry Entry) bool {
+		return now.Sub(entry.Deleted) >= t.retention
+	})
+}
+
+func (t *Trash) purgeIf(ctx context.Context, reason string, match func(Entry) bool) (int, error) {
+	entries, err := t.Entries(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var purged int
+	for _, entry := range entries {
+		if !match(entry) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		if err := t.purge(ctx, entry, reason); err != nil {
+			if errors.Is(err, &storage.NotFoundError{Name: trashName(entry.ID)}) {
+				// purged or restored meanwhile
+				continue
+			}
+			return purged, errors.Wrapf(err, "failed to purge %s", entry.ID)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (t *Trash) purge(ctx context.Context, entry Entry, reason string) error {
+	if err := t.store.Remove(ctx, trashName(entry.ID)); err != nil {
+		return err
+	}
+	purgedTotal.WithLabelValues(reason).Inc()
+	log.WithField("name", entry.Name).WithField("id", entry.ID).WithField("reason", reason).
+		Debug("Purged file from the trash")
+	return nil
+}
+
+// NotFoundError is returned for an unknown trash id.
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return "Unable to find trashed file " + e.ID
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	t, ok := target.(*NotFoundError)
+	if !ok {
+		return false
+	}
+	return e.ID == t.ID
+}
@@ -0,0 +1,58 @@
+package trash
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/peertechde/argon/pkg/storage/local"
+)
+
+func TestRemoveRestoreLongName(t *testing.T) {
+	ctx := context.Background()
+	store := local.New(t.TempDir())
+	tr, err := New(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := strings.Repeat("a", 255)
+	if err := tr.Write(ctx, name, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Remove(WithDeleter(ctx, "alice"), name); err != nil {
+		t.Fatalf("failed to trash a file with a long name: %v", err)
+	}
+
+	entries, err := tr.Entries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Name != name || entry.Deleter != "alice" || entry.Size != int64(len("content")) {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	restored, err := tr.Restore(ctx, entry.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != name {
+		t.Fatalf("restored to %q, want %q", restored, name)
+	}
+	fi, err := tr.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range fi.Metadata {
+		if key == MetadataName || key == MetadataDeleter {
+			t.Fatalf("restored file still has the trash metadata %s", key)
+		}
+	}
+	if entries, err := tr.Entries(ctx); err != nil || len(entries) != 0 {
+		t.Fatalf("got entries %v (%v) after the restore, want none", entries, err)
+	}
+}
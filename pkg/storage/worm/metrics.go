@@ -0,0 +1,20 @@
+package worm
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "argon",
+		Subsystem: "worm",
+		Name:      "rejected_total",
+	})
+)
+
+// Collectors returns the WORM metrics for registration.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		rejectedTotal,
+	}
+}
@@ -0,0 +1,44 @@
+package worm
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// Rule locks the new files whose name starts with Prefix for Period in Mode,
+// the prefix takes the place of a bucket.
+type Rule struct {
+	Prefix string        `json:"prefix"`
+	Mode   string        `json:"mode"`
+	Period time.Duration `json:"period"`
+}
+
+// Validate checks that r has a known mode and a positive period.
+func (r Rule) Validate() error {
+	if r.Mode != storage.RetentionGovernance && r.Mode != storage.RetentionCompliance {
+		return errors.Errorf("unknown retention mode %q", r.Mode)
+	}
+	if r.Period <= 0 {
+		return errors.Errorf("retention period of prefix %q must be positive", r.Prefix)
+	}
+	return nil
+}
+
+// DefaultRetention returns the retention of the new file name written at now
+// according to the rule with the longest matching prefix.
+func DefaultRetention(rules []Rule, name string, now time.Time) (storage.Retention, bool) {
+	var match *Rule
+	for i, rule := range rules {
+		if strings.HasPrefix(name, rule.Prefix) && (match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = &rules[i]
+		}
+	}
+	if match == nil {
+		return storage.Retention{}, false
+	}
+	return storage.Retention{Mode: match.Mode, Until: now.Add(match.Period)}, true
+}
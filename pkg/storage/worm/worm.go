@@ -0,0 +1,130 @@
+// Package worm implements a storage which keeps locked files write once read
+// many (WORM).
+package worm
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peertechde/argon/pkg/storage"
+)
+
+// New wraps store so that files locked by their retention or a legal hold,
+// which are recorded in their metadata, can't be overwritten, renamed or
+// removed. A storage.LockedError is returned instead. Compliance locks can't
+// be shortened or removed through SetMetadata either.
+func New(store storage.Storage) *WORM {
+	return &WORM{
+		store: store,
+	}
+}
+
+type WORM struct {
+	store storage.Storage
+}
+
+// check returns a storage.LockedError if name exists and is locked.
+func (w *WORM) check(ctx context.Context, name string) error {
+	fi, err := w.store.Stat(ctx, name)
+	if err != nil {
+		if errors.Is(err, &storage.NotFoundError{Name: name}) {
+			return nil
+		}
+		return err
+	}
+	if retention := storage.RetentionOf(fi.Metadata); retention.Locked(time.Now()) {
+		rejectedTotal.Inc()
+		return &storage.LockedError{Name: name, Retention: retention}
+	}
+	return nil
+}
+
+func (w *WORM) Read(ctx context.Context, name string) ([]byte, error) {
+	return w.store.Read(ctx, name)
+}
+
+func (w *WORM) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return storage.ReadRange(ctx, w.store, name, offset, length)
+}
+
+func (w *WORM) Write(ctx context.Context, name string, data []byte) error {
+	if err := w.check(ctx, name); err != nil {
+		return err
+	}
+	return w.store.Write(ctx, name, data)
+}
+
+func (w *WORM) List(ctx context.Context) ([]string, error) {
+	return w.store.List(ctx)
+}
+
+func (w *WORM) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	return w.store.Stat(ctx, name)
+}
+
+func (w *WORM) Rename(ctx context.Context, old, new string) error {
+	if err := w.check(ctx, old); err != nil {
+		return err
+	}
+	if err := w.check(ctx, new); err != nil {
+		return err
+	}
+	return w.store.Rename(ctx, old, new)
+}
+
+func (w *WORM) Remove(ctx context.Context, name string) error {
+	if err := w.check(ctx, name); err != nil {
+		return err
+	}
+	return w.store.Remove(ctx, name)
+}
+
+func (w *WORM) Append(ctx context.Context, name string, data []byte, offset int64) (int64, error) {
+	if err := w.check(ctx, name); err != nil {
+		return 0, err
+	}
+	return storage.Append(ctx, w.store, name, data, offset)
+}
+
+// Copy doesn't carry the lock of src over to dst, the retention of the copy is
+// decided by its writer.
+func (w *WORM) Copy(ctx context.Context, src, dst string) error {
+	if err := w.check(ctx, dst); err != nil {
+		return err
+	}
+	if err := storage.Copy(ctx, w.store, src, dst); err != nil {
+		return err
+	}
+	fi, err := w.store.Stat(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if storage.RetentionOf(fi.Metadata) == (storage.Retention{}) {
+		return nil
+	}
+	return storage.SetMetadata(ctx, w.store, dst, storage.Retention{}.Apply(fi.Metadata))
+}
+
+// SetMetadata rejects metadata which shortens or removes a compliance lock.
+func (w *WORM) SetMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	fi, err := w.store.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	current := storage.RetentionOf(fi.Metadata)
+	if err := current.Permits(storage.RetentionOf(metadata), time.Now()); err != nil {
+		rejectedTotal.Inc()
+		return &storage.LockedError{Name: name, Retention: current, Reason: err.Error()}
+	}
+	return storage.SetMetadata(ctx, w.store, name, metadata)
+}
+
+func (w *WORM) SetTier(ctx context.Context, name, tier string) error {
+	return storage.SetTier(ctx, w.store, name, tier)
+}
+
+func (w *WORM) Close() error {
+	return w.store.Close()
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exporter ships finished spans to a backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// NewExporter creates an exporter by kind. The endpoint is the file path for
+// the "file" and the collector URL for the "otlp" exporter.
+func NewExporter(kind, endpoint string) (Exporter, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case "file":
+		if endpoint == "" {
+			return nil, fmt.Errorf("file exporter requires a path")
+		}
+		return NewFileExporter(endpoint)
+	case "otlp":
+		if endpoint == "" {
+			return nil, fmt.Errorf("otlp exporter requires an endpoint")
+		}
+		return NewOTLPExporter(endpoint), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", kind)
+	}
+}
+
+// NewWriterExporter writes every span as a single JSON line to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{
+		w: w,
+	}
+}
+
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewFileExporter appends spans as JSON lines to the file at path.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{
+		w:      fd,
+		closer: fd,
+	}, nil
+}
+
+type jsonSpan struct {
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Duration      string                 `json:"duration"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Status        string                 `json:"status,omitempty"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+func (e *WriterExporter) ExportSpans(_ context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, span := range spans {
+		js := jsonSpan{
+			TraceID:       span.SpanContext.TraceID.String(),
+			SpanID:        span.SpanContext.SpanID.String(),
+			Name:          span.Name,
+			Kind:          span.Kind.String(),
+			Start:         span.Start,
+			End:           span.End,
+			Duration:      span.End.Sub(span.Start).String(),
+			StatusMessage: span.StatusMessage,
+		}
+		if span.Parent.IsValid() {
+			js.ParentSpanID = span.Parent.String()
+		}
+		if len(span.Attributes) > 0 {
+			js.Attributes = make(map[string]interface{}, len(span.Attributes))
+			for _, a := range span.Attributes {
+				js.Attributes[a.Key] = a.Value
+			}
+		}
+		switch span.Status {
+		case StatusOK:
+			js.Status = "ok"
+		case StatusError:
+			js.Status = "error"
+		}
+		if err := enc.Encode(&js); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(_ context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
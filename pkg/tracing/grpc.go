@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// UnaryServerInterceptor starts a server span for every unary RPC, continuing
+// the trace propagated by the caller.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startServerSpan(ctx, info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		endRPCSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor starts a server span for every streaming RPC,
+// continuing the trace propagated by the caller.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(stream.Context(), info.FullMethod)
+		defer span.End()
+
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+
+		err := handler(srv, wrapped)
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor starts a client span for every unary RPC and
+// propagates it to the server.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startClientSpan(ctx, method, cc.Target())
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span for every streaming RPC and
+// propagates it to the server. The span ends once the stream is finished.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(ctx, method, cc.Target())
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endRPCSpan(span, err)
+			span.End()
+			return nil, err
+		}
+		return &tracedClientStream{
+			ClientStream:  stream,
+			span:          span,
+			serverStreams: desc.ServerStreams,
+		}, nil
+	}
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+
+	span          *Span
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.serverStreams:
+		// a unary response closes a client streaming RPC
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *tracedClientStream) finish(err error) {
+	s.once.Do(func() {
+		endRPCSpan(s.span, err)
+		s.span.End()
+	})
+}
+
+func startServerSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		ctx = Extract(ctx, metadataCarrier(md))
+	}
+
+	attributes := rpcAttributes(fullMethod)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		attributes = append(attributes, String("net.peer.addr", p.Addr.String()))
+	}
+	return Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		WithSpanKind(SpanKindServer),
+		WithAttributes(attributes...),
+	)
+}
+
+func startClientSpan(ctx context.Context, fullMethod, target string) (context.Context, *Span) {
+	attributes := append(rpcAttributes(fullMethod), String("net.peer.addr", target))
+	ctx, span := Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		WithSpanKind(SpanKindClient),
+		WithAttributes(attributes...),
+	)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func rpcAttributes(fullMethod string) []Attribute {
+	attributes := []Attribute{String("rpc.system", "grpc")}
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		attributes = append(attributes,
+			String("rpc.service", name[:i]),
+			String("rpc.method", name[i+1:]),
+		)
+	}
+	return attributes
+}
+
+func endRPCSpan(span *Span, err error) {
+	s := status.Convert(err)
+	span.SetAttributes(Int64("rpc.grpc.status_code", int64(s.Code())))
+	if err != nil {
+		span.SetStatus(StatusError, s.Message())
+	}
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// W3C trace context header names, see https://www.w3.org/TR/trace-context/
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const (
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// Carrier is the transport specific storage of propagated fields.
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// Inject writes the span context of ctx into carrier.
+func Inject(ctx context.Context, carrier Carrier) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	carrier.Set(TraceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		carrier.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract returns a context carrying the remote span context found in carrier.
+// Invalid or missing headers leave ctx untouched.
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	sc, err := ParseTraceparent(carrier.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = carrier.Get(TracestateHeader)
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" {
+		return sc, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	// future versions may append fields, version 00 must not
+	if version == traceparentVersion && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if err := decodeHex(sc.TraceID[:], traceID); err != nil {
+		return sc, err
+	}
+	if err := decodeHex(sc.SpanID[:], spanID); err != nil {
+		return sc, err
+	}
+	var f [1]byte
+	if err := decodeHex(f[:], flags); err != nil {
+		return sc, err
+	}
+	sc.Sampled = f[0]&flagSampled != 0
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("invalid traceparent field %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
@@ -0,0 +1,134 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peertechde/argon/pkg/client"
+	"github.com/peertechde/argon/pkg/server"
+	"github.com/peertechde/argon/pkg/tracing"
+)
+
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+// collector is an OTLP/HTTP collector accepting the JSON encoding.
+type collector struct {
+	mu       sync.Mutex
+	requests int
+	spans    []collectedSpan
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []collectedSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	w.Write([]byte("{}"))
+}
+
+func (c *collector) span(t *testing.T, name string, kind tracing.SpanKind) collectedSpan {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name && span.Kind == int(kind) {
+			return span
+		}
+	}
+	t.Fatalf("no %s span %s in %+v", kind, name, c.spans)
+	return collectedSpan{}
+}
+
+func TestReadTrace(t *testing.T) {
+	c := &collector{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	tracer := tracing.NewTracer(tracing.NewOTLPExporter(ts.URL))
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.NewTracer(nil))
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "report"), []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(server.WithAddr("127.0.0.1"), server.WithStoragePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	defer srv.Stop()
+	for srv.Addr() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx := context.Background()
+	cl := client.New()
+	if err := cl.DialContext(ctx, srv.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Read(ctx, "report", filepath.Join(t.TempDir(), "report")); err != nil {
+		t.Fatal(err)
+	}
+	// the spans are exported in batches, shutting down flushes the last one
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	read := c.span(t, "client.Read", tracing.SpanKindInternal)
+	clientRPC := c.span(t, "compute.Storage/Read", tracing.SpanKindClient)
+	serverRPC := c.span(t, "compute.Storage/Read", tracing.SpanKindServer)
+	storage := c.span(t, "storage.Read", tracing.SpanKindInternal)
+	c.mu.Lock()
+	requests := c.requests
+	c.mu.Unlock()
+	if requests != 1 {
+		t.Errorf("got %d export requests, want one batch", requests)
+	}
+	for _, span := range []collectedSpan{clientRPC, serverRPC, storage} {
+		if span.TraceID != read.TraceID {
+			t.Errorf("span %s has trace %s, want %s", span.Name, span.TraceID, read.TraceID)
+		}
+	}
+	if clientRPC.ParentSpanID != read.SpanID {
+		t.Errorf("client RPC span isn't a child of client.Read")
+	}
+	if serverRPC.ParentSpanID != clientRPC.SpanID {
+		t.Errorf("server span isn't a child of the propagated client span")
+	}
+	if storage.ParentSpanID != serverRPC.SpanID {
+		t.Errorf("storage span isn't a child of the server span")
+	}
+}
@@ -0,0 +1,35 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time via -ldflags "-X github.com/peertechde/argon/pkg/version.GitCommit=..."
+var (
+	Version   = "dev"
+	GitCommit = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok && info.Version == "dev" && buildInfo.Main.Version != "" {
+		info.Version = buildInfo.Main.Version
+	}
+	return info
+}